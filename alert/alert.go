@@ -0,0 +1,468 @@
+// Package alert evaluates the alert rules stored in the database, common.AlertRule, against the live market data.
+//
+// An Engine takes the records of the exchangers as a sink.Sink: the last price, the spread and the 24h change of
+// each market are checked by the rules of the market as they come, the markets without data every second.
+// A rule alerts when its condition becomes met, a price rule when the price crosses its threshold, not while
+// it stays met, and at most once every cooldown. The alerts are delivered by the Sender of the channel of the rule
+// in the background, then stored with their delivery result. The rules are reloaded periodically, they are
+// managed through the REST API and dbman while the engine runs.
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/shopspring/decimal"
+)
+
+// the metrics of the rules
+const (
+	MetricPrice  = "price"   // the last price of the tickers and trades
+	MetricSpread = "spread"  // (ask - bid) / mid of the tickers and books
+	MetricChange = "change"  // last / previous close - 1 of the tickers
+	MetricNoData = "no_data" // the seconds since the last record of the market
+)
+
+// Metrics are all the metrics
+var Metrics = []string{MetricPrice, MetricSpread, MetricChange, MetricNoData}
+
+// the comparisons of the rules, no_data rules are always above
+const (
+	OpAbove = "above"
+	OpBelow = "below"
+)
+
+// the channels of the rules
+const (
+	ChannelWebhook = "webhook" // a JSON POST to the URL of the target
+	ChannelEmail   = "email"   // a mail to the address of the target
+)
+
+const (
+	// DefaultReloadInterval is the period of the reload of the rules
+	DefaultReloadInterval = 30 * time.Second
+	// CheckInterval is the period of the check of the markets without data
+	CheckInterval = time.Second
+	// QueueSize is the number of the alerts waiting for their delivery, the alerts beyond are lost
+	QueueSize = 256
+)
+
+// CheckRule returns an error if the rule r is not valid, its market is not checked.
+// The op of a no_data rule is set to above. A webhook to localhost or to a private address is refused,
+// the names resolving to one are refused by the Webhook when it sends.
+func CheckRule(r *common.AlertRule) error {
+	switch r.Metric {
+	case MetricPrice, MetricSpread, MetricChange:
+		if r.Op != OpAbove && r.Op != OpBelow {
+			return fmt.Errorf("bad op %q, above or below", r.Op)
+		}
+	case MetricNoData:
+		if !r.Threshold.IsPositive() {
+			return errors.New("no_data threshold must be positive seconds")
+		}
+		r.Op = OpAbove
+	default:
+		return fmt.Errorf("bad metric %q, one of price, spread, change or no_data", r.Metric)
+	}
+	switch r.Channel {
+	case ChannelWebhook:
+		u, err := url.Parse(r.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("bad webhook url %q", r.Target)
+		}
+		if ip := net.ParseIP(u.Hostname()); strings.EqualFold(u.Hostname(), "localhost") || (ip != nil && !PublicIP(ip)) {
+			return fmt.Errorf("webhook url %q of a private address", r.Target)
+		}
+	case ChannelEmail:
+		if _, err := mail.ParseAddress(r.Target); err != nil {
+			return fmt.Errorf("bad email address %q", r.Target)
+		}
+	default:
+		return fmt.Errorf("bad channel %q, webhook or email", r.Channel)
+	}
+	if r.Cooldown < 0 {
+		return errors.New("negative cooldown")
+	}
+	return nil
+}
+
+// Store keeps the rules and their alerts, database.DataStore is one
+type Store interface {
+	AlertRules(enabled bool) ([]*common.AlertRule, error)
+	LastAlerts() ([]*common.Alert, error)
+	InsertAlert(a *common.Alert) error
+}
+
+// Sender delivers the alerts of a channel
+type Sender interface {
+	Send(r *common.AlertRule, a *common.Alert) error
+}
+
+// Engine is a sink.Sink evaluating the enabled rules of the store against the records
+type Engine struct {
+	Senders map[string]Sender // by channel, set before the first record
+	Retries int               // the delivery attempts after the first one
+
+	store Store
+
+	mu      sync.Mutex
+	rules   map[string][]*rule // by exchanger:market
+	markets map[string]*market // by exchanger:market
+	loaded  bool
+
+	queue chan *delivery
+	now   func() time.Time
+	stop  chan struct{}
+	done  chan struct{}
+	sent  chan struct{}
+}
+
+// rule is an enabled rule and the state of its condition
+type rule struct {
+	*common.AlertRule
+	known bool      // the condition was evaluated
+	met   bool      // the last evaluation
+	since time.Time // first loaded, the start of a market without data
+	last  time.Time // of the last alert
+}
+
+// market is the time of the last record of a market
+type market struct {
+	seen time.Time
+}
+
+type delivery struct {
+	rule  *common.AlertRule
+	alert *common.Alert
+}
+
+// NewEngine returns the engine of the rules of store reloaded every reloadInterval, DefaultReloadInterval if 0.
+// A negative reloadInterval leaves the loads and the checks of the markets without data to Load and Check.
+// store belongs to the caller.
+func NewEngine(store Store, reloadInterval time.Duration) *Engine {
+	if reloadInterval == 0 {
+		reloadInterval = DefaultReloadInterval
+	}
+	e := &Engine{
+		Senders: make(map[string]Sender),
+		Retries: 2,
+		store:   store,
+		rules:   make(map[string][]*rule),
+		markets: make(map[string]*market),
+		queue:   make(chan *delivery, QueueSize),
+		now:     time.Now,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		sent:    make(chan struct{}),
+	}
+	go e.deliver()
+	if reloadInterval < 0 {
+		close(e.done)
+		return e
+	}
+	go e.run(reloadInterval)
+	return e
+}
+
+func (e *Engine) run(reload time.Duration) {
+	defer close(e.done)
+	if err := e.Load(); err != nil {
+		log.Println("alert rules load error:", err)
+	}
+	check := time.NewTicker(CheckInterval)
+	defer check.Stop()
+	load := time.NewTicker(reload)
+	defer load.Stop()
+	for {
+		select {
+		case <-check.C:
+			e.Check()
+		case <-load.C:
+			if err := e.Load(); err != nil {
+				log.Println("alert rules load error:", err)
+			}
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+func key(m *common.Market) string {
+	if m == nil || m.Exchanger == nil {
+		return ""
+	}
+	return m.Exchanger.Name + ":" + m.Name
+}
+
+// Load reads the enabled rules from the store. A rule keeps the state of its condition unless it changed,
+// the last alerts of the store start the cooldowns.
+func (e *Engine) Load() error {
+	c, err := e.store.AlertRules(true)
+	if err != nil {
+		return err
+	}
+	var last []*common.Alert
+	e.mu.Lock()
+	loaded := e.loaded
+	e.mu.Unlock()
+	if !loaded {
+		if last, err = e.store.LastAlerts(); err != nil {
+			return err
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	old := make(map[uint]*rule)
+	for _, rules := range e.rules {
+		for _, r := range rules {
+			old[r.ID] = r
+		}
+	}
+	now := e.now()
+	e.rules = make(map[string][]*rule)
+	byID := make(map[uint]*rule)
+	for _, ar := range c {
+		k := key(ar.Market)
+		if k == "" {
+			continue
+		}
+		if err := CheckRule(ar); err != nil {
+			log.Println("alert rule", ar.ID, "ignored:", err)
+			continue
+		}
+		r := &rule{AlertRule: ar, since: now}
+		if o := old[ar.ID]; o != nil {
+			r.since, r.last = o.since, o.last
+			if o.MarketRef == ar.MarketRef && o.Metric == ar.Metric && o.Op == ar.Op && o.Threshold.Equal(ar.Threshold) {
+				r.known, r.met = o.known, o.met
+			}
+		}
+		e.rules[k] = append(e.rules[k], r)
+		byID[r.ID] = r
+	}
+	for _, a := range last {
+		if r := byID[a.RuleRef]; r != nil && a.Time.After(r.last) {
+			r.last = a.Time
+		}
+	}
+	e.loaded = true
+	return nil
+}
+
+// seen records a record of the market m and returns its key, the lock is held
+func (e *Engine) seen(m *common.Market) string {
+	k := key(m)
+	if k == "" {
+		return ""
+	}
+	mk := e.markets[k]
+	if mk == nil {
+		mk = &market{}
+		e.markets[k] = mk
+	}
+	mk.seen = e.now()
+	return k
+}
+
+// spread returns (ask - bid) / mid, false without both sides
+func spread(bid, ask decimal.Decimal) (decimal.Decimal, bool) {
+	if !bid.IsPositive() || !ask.IsPositive() {
+		return decimal.Zero, false
+	}
+	mid := bid.Add(ask).Div(decimal.New(2, 0))
+	return ask.Sub(bid).Div(mid), true
+}
+
+func (e *Engine) WriteTickers(c []*common.Ticker) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, t := range c {
+		k := e.seen(t.Market)
+		if k == "" || len(e.rules[k]) == 0 {
+			continue
+		}
+		if t.Last.IsPositive() {
+			e.evaluate(k, MetricPrice, t.Last)
+		}
+		if s, ok := spread(t.Bid, t.Ask); ok {
+			e.evaluate(k, MetricSpread, s)
+		}
+		if t.Last.IsPositive() && t.PreviousClose.IsPositive() {
+			e.evaluate(k, MetricChange, t.Last.Div(t.PreviousClose).Sub(decimal.New(1, 0)))
+		} else if !t.Percentage.IsZero() {
+			e.evaluate(k, MetricChange, t.Percentage)
+		}
+	}
+	return nil
+}
+
+// WriteTrades checks the price of the last trade of each market
+func (e *Engine) WriteTrades(c []*common.Trade) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	last := make(map[string]*common.Trade)
+	for _, t := range c {
+		k := e.seen(t.Market)
+		if k == "" || !t.Price.IsPositive() {
+			continue
+		}
+		if l := last[k]; l == nil || !t.Time.Before(l.Time) {
+			last[k] = t
+		}
+	}
+	for k, t := range last {
+		e.evaluate(k, MetricPrice, t.Price)
+	}
+	return nil
+}
+
+func (e *Engine) WriteOrderBooks(c []*common.OrderBook) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, ob := range c {
+		k := e.seen(ob.Market)
+		if k == "" || len(ob.Bids) == 0 || len(ob.Asks) == 0 {
+			continue
+		}
+		if s, ok := spread(ob.Bids[0].Price, ob.Asks[0].Price); ok {
+			e.evaluate(k, MetricSpread, s)
+		}
+	}
+	return nil
+}
+
+// WriteBookDeltas records the data of m, see sink.DeltaWriter
+func (e *Engine) WriteBookDeltas(m *common.Market, c []*common.BookDelta) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(c) > 0 {
+		e.seen(m)
+	}
+	return nil
+}
+
+func (e *Engine) WriteCandles(c []*common.Candle) error { return nil }
+
+// evaluate updates the rules of metric of the market k with the value v, the lock is held
+func (e *Engine) evaluate(k, metric string, v decimal.Decimal) {
+	for _, r := range e.rules[k] {
+		if r.Metric != metric {
+			continue
+		}
+		met := v.GreaterThan(r.Threshold)
+		if r.Op == OpBelow {
+			met = v.LessThan(r.Threshold)
+		}
+		e.update(r, k, met, v)
+	}
+}
+
+// update sets the condition of r and alerts if it becomes met, the lock is held.
+// The first price only places the price on a side of the threshold.
+func (e *Engine) update(r *rule, k string, met bool, v decimal.Decimal) {
+	known, was := r.known, r.met
+	r.known, r.met = true, met
+	if !met || (known && was) || (!known && r.Metric == MetricPrice) {
+		return
+	}
+	now := e.now()
+	if !r.last.IsZero() && now.Sub(r.last) < time.Duration(r.Cooldown)*time.Second {
+		log.Println("alert rule", r.ID, "in cooldown:", message(r, k, v))
+		return
+	}
+	r.last = now
+	a := &common.Alert{Time: now, RuleRef: r.ID, Value: v, Message: message(r, k, v)}
+	select {
+	case e.queue <- &delivery{rule: r.AlertRule, alert: a}:
+	default:
+		log.Println("alert queue full, alert lost:", a.Message)
+	}
+}
+
+// message describes the alert of r on the market k for the value v
+func message(r *rule, k string, v decimal.Decimal) string {
+	var s string
+	switch r.Metric {
+	case MetricNoData:
+		s = fmt.Sprintf("%s no data for %ss", k, v.StringFixed(0))
+	case MetricPrice:
+		s = fmt.Sprintf("%s price %s crossed %s %s", k, v, r.Op, r.Threshold)
+	default:
+		s = fmt.Sprintf("%s %s %s %s %s", k, r.Metric, v.StringFixed(6), r.Op, r.Threshold)
+	}
+	if r.Name != "" {
+		s = r.Name + ": " + s
+	}
+	return s
+}
+
+// Check evaluates the no_data rules against the time of the last record of their market
+func (e *Engine) Check() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.now()
+	for k, rules := range e.rules {
+		for _, r := range rules {
+			if r.Metric != MetricNoData {
+				continue
+			}
+			seen := r.since
+			if m := e.markets[k]; m != nil && m.seen.After(seen) {
+				seen = m.seen
+			}
+			idle := decimal.New(int64(now.Sub(seen)/time.Second), 0)
+			e.update(r, k, idle.GreaterThan(r.Threshold), idle)
+		}
+	}
+}
+
+// deliver sends the queued alerts with their sender and stores them
+func (e *Engine) deliver() {
+	defer close(e.sent)
+	for d := range e.queue {
+		var err error
+		s := e.Senders[d.rule.Channel]
+		if s == nil {
+			err = fmt.Errorf("no sender of channel %s", d.rule.Channel)
+		}
+		for k := 0; s != nil && k <= e.Retries; k++ {
+			if k > 0 {
+				time.Sleep(time.Duration(k) * time.Second)
+			}
+			if err = s.Send(d.rule, d.alert); err == nil {
+				break
+			}
+		}
+		d.alert.Delivered = err == nil
+		if err != nil {
+			d.alert.Error = err.Error()
+			log.Println("alert not delivered:", d.alert.Message, err)
+		}
+		if err = e.store.InsertAlert(d.alert); err != nil {
+			log.Println("alert store error:", err)
+		}
+	}
+}
+
+// Close stops the reloads and the checks, and waits for the delivery of the queued alerts.
+// The engine must not be written to anymore.
+func (e *Engine) Close() error {
+	select {
+	case <-e.done:
+	default:
+		close(e.stop)
+		<-e.done
+	}
+	close(e.queue)
+	<-e.sent
+	return nil
+}
@@ -0,0 +1,322 @@
+package alert
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/database"
+	"github.com/shopspring/decimal"
+)
+
+var dec = decimal.RequireFromString
+
+var start = time.Date(2018, 11, 26, 0, 0, 0, 0, time.UTC)
+
+type ruleStore struct {
+	rules  []*common.AlertRule
+	last   []*common.Alert
+	alerts []*common.Alert
+}
+
+func (s *ruleStore) AlertRules(enabled bool) ([]*common.AlertRule, error) { return s.rules, nil }
+func (s *ruleStore) LastAlerts() ([]*common.Alert, error)                 { return s.last, nil }
+func (s *ruleStore) InsertAlert(a *common.Alert) error {
+	s.alerts = append(s.alerts, a)
+	return nil
+}
+
+type sender struct {
+	mu   sync.Mutex
+	sent []string
+	err  error
+}
+
+func (s *sender) Send(r *common.AlertRule, a *common.Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, a.Message)
+	return s.err
+}
+
+func TestCheckRule(t *testing.T) {
+	r := &common.AlertRule{Metric: MetricNoData, Threshold: dec("300"), Channel: ChannelEmail, Target: "ops@example.com"}
+	if err := CheckRule(r); err != nil || r.Op != OpAbove {
+		t.Fatal("no data rule", r.Op, err)
+	}
+	for _, bad := range []*common.AlertRule{
+		{Metric: "volume", Op: OpAbove, Channel: ChannelWebhook, Target: "http://example.com"},
+		{Metric: MetricPrice, Op: "crosses", Channel: ChannelWebhook, Target: "http://example.com"},
+		{Metric: MetricNoData, Channel: ChannelWebhook, Target: "http://example.com"},
+		{Metric: MetricSpread, Op: OpAbove, Channel: ChannelWebhook, Target: "example.com/hook"},
+		{Metric: MetricSpread, Op: OpAbove, Channel: ChannelWebhook, Target: "http://localhost:8080/hook"},
+		{Metric: MetricSpread, Op: OpAbove, Channel: ChannelWebhook, Target: "http://169.254.169.254/latest/meta-data"},
+		{Metric: MetricSpread, Op: OpAbove, Channel: ChannelWebhook, Target: "https://[::1]/hook"},
+		{Metric: MetricSpread, Op: OpAbove, Channel: ChannelWebhook, Target: "http://10.0.0.2/hook"},
+		{Metric: MetricSpread, Op: OpAbove, Channel: ChannelWebhook, Target: "http://100.100.100.200/latest/meta-data"},
+		{Metric: MetricSpread, Op: OpAbove, Channel: ChannelWebhook, Target: "http://[::ffff:198.18.0.1]/hook"},
+		{Metric: MetricSpread, Op: OpAbove, Channel: ChannelEmail, Target: "ops"},
+		{Metric: MetricSpread, Op: OpAbove, Channel: "sms", Target: "ops"},
+		{Metric: MetricChange, Op: OpBelow, Channel: ChannelEmail, Target: "ops@example.com", Cooldown: -1},
+	} {
+		if CheckRule(bad) == nil {
+			t.Error("bad rule accepted", bad)
+		}
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	for _, ip := range []string{"93.184.216.34", "100.63.255.255", "100.128.0.0", "198.17.255.255", "198.20.0.0", "2606:2800:220:1::"} {
+		if !PublicIP(net.ParseIP(ip)) {
+			t.Error("public address refused", ip)
+		}
+	}
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254",
+		"100.64.0.1", "100.127.255.254", "198.18.0.1", "198.19.255.255", "0.0.0.0", "224.0.0.1",
+		"::ffff:10.0.0.1", "::ffff:100.64.0.1", "::ffff:198.19.0.1", "::1", "fd00::1", "fe80::1"} {
+		if PublicIP(net.ParseIP(ip)) {
+			t.Error("internal address accepted", ip)
+		}
+	}
+}
+
+func TestEngine(t *testing.T) {
+	m := &common.Market{ID: 1, Name: "BTC-LTC", Exchanger: &common.Exchanger{Name: "bittrex"}}
+	hook := func(id uint, metric, op, threshold string, cooldown int64) *common.AlertRule {
+		return &common.AlertRule{ID: id, MarketRef: 1, Market: m, Metric: metric, Op: op, Threshold: dec(threshold),
+			Channel: ChannelWebhook, Target: "http://example.com/hook", Cooldown: cooldown, Enabled: true}
+	}
+	store := &ruleStore{
+		rules: []*common.AlertRule{
+			hook(1, MetricPrice, OpAbove, "0.01", 60),
+			hook(2, MetricSpread, OpAbove, "0.02", 0),
+			hook(3, MetricChange, OpBelow, "-0.1", 0),
+			hook(4, MetricNoData, "", "300", 0),
+		},
+		last: []*common.Alert{{RuleRef: 2, Time: start.Add(-time.Minute)}},
+	}
+	out := &sender{}
+	now := start
+	e := NewEngine(store, -1)
+	e.Senders[ChannelWebhook] = out
+	e.now = func() time.Time { return now }
+	if err := e.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	ticker := func(last, bid, ask, prev string) {
+		e.WriteTickers([]*common.Ticker{{Time: now, Market: m, Last: dec(last), Bid: dec(bid), Ask: dec(ask), PreviousClose: dec(prev)}})
+	}
+	ticker("0.011", "0.0109", "0.0111", "0.011")   // the first price is above, not crossed
+	ticker("0.009", "0.00895", "0.00905", "0.009") // below
+	now = now.Add(10 * time.Second)
+	ticker("0.0105", "0.01", "0.011", "0.0125") // crossed above, spread 0.0952, change -0.16
+	ticker("0.0106", "0.01", "0.011", "0.0125") // still met
+	e.WriteTrades([]*common.Trade{{Time: now, Market: m, Price: dec("0.0099")}})
+	now = now.Add(10 * time.Second)
+	e.WriteTrades([]*common.Trade{{Time: now, Market: m, Price: dec("0.0101")}}) // crossed again within the cooldown
+	e.WriteOrderBooks([]*common.OrderBook{{Time: now, Market: m,
+		Bids: []*common.PriceVol{{Price: dec("0.0101"), Volume: dec("1")}}, Asks: []*common.PriceVol{{Price: dec("0.0102"), Volume: dec("1")}}}})
+	e.WriteOrderBooks([]*common.OrderBook{{Time: now, Market: m,
+		Bids: []*common.PriceVol{{Price: dec("0.01"), Volume: dec("1")}}, Asks: []*common.PriceVol{{Price: dec("0.011"), Volume: dec("1")}}}})
+	now = now.Add(301 * time.Second)
+	e.Check()
+	e.Check()
+	e.Close()
+
+	expected := []uint{1, 2, 3, 2, 4}
+	if len(store.alerts) != len(expected) {
+		t.Fatal("alerts", len(store.alerts), out.sent)
+	}
+	for k, a := range store.alerts {
+		if a.RuleRef != expected[k] || !a.Delivered || a.Message != out.sent[k] {
+			t.Error("alert", k, a.RuleRef, a.Delivered, a.Message)
+		}
+	}
+	if msg := store.alerts[0].Message; msg != "bittrex:BTC-LTC price 0.0105 crossed above 0.01" {
+		t.Error("message", msg)
+	}
+	if msg := store.alerts[4].Message; msg != "bittrex:BTC-LTC no data for 301s" {
+		t.Error("message", msg)
+	}
+}
+
+func TestDeliveryError(t *testing.T) {
+	m := &common.Market{ID: 1, Name: "BTC-LTC", Exchanger: &common.Exchanger{Name: "bittrex"}}
+	store := &ruleStore{rules: []*common.AlertRule{{ID: 1, Market: m, Metric: MetricSpread, Op: OpAbove, Threshold: dec("0.01"),
+		Channel: ChannelEmail, Target: "ops@example.com"}}}
+	out := &sender{err: errors.New("mailbox full")}
+	e := NewEngine(store, -1)
+	e.Senders[ChannelEmail] = out
+	e.Retries = 0
+	e.Load()
+	e.WriteTickers([]*common.Ticker{{Time: start, Market: m, Bid: dec("1"), Ask: dec("2")}})
+	e.Close()
+	if len(store.alerts) != 1 || store.alerts[0].Delivered || store.alerts[0].Error != "mailbox full" {
+		t.Fatal("undelivered alert", store.alerts)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got Payload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	r := &common.AlertRule{ID: 7, Name: "ltc", Market: &common.Market{Name: "BTC-LTC", Exchanger: &common.Exchanger{Name: "bittrex"}},
+		Metric: MetricPrice, Op: OpAbove, Threshold: dec("0.01"), Channel: ChannelWebhook, Target: srv.URL}
+	a := &common.Alert{Time: start, RuleRef: 7, Value: dec("0.0105"), Message: "ltc up"}
+	if err := NewWebhook(time.Second).Send(r, a); err == nil {
+		t.Fatal("posted to a loopback address")
+	}
+	if err := NewWebhook(time.Second, "127.0.0.1").Send(r, a); err != nil {
+		t.Fatal(err)
+	}
+	if got.Rule != 7 || got.Exchanger != "bittrex" || got.Market != "BTC-LTC" || !got.Value.Equal(a.Value) || !got.Time.Equal(start) {
+		t.Fatal("payload", got)
+	}
+	srv.Config.Handler = http.NotFoundHandler()
+	if err := NewWebhook(time.Second, "127.0.0.1").Send(r, a); err == nil {
+		t.Fatal("error status accepted")
+	}
+}
+
+// fakeSMTP accepts one mail on l and sends the recipients and the header lines to c
+func fakeSMTP(l net.Listener, c chan<- []string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	var got []string
+	r := bufio.NewReader(conn)
+	conn.Write([]byte("220 fake\r\n"))
+	for data := false; ; {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case data && line == ".":
+			data = false
+			conn.Write([]byte("250 queued\r\n"))
+		case data:
+			if strings.HasPrefix(line, "To:") {
+				got = append(got, line)
+			}
+		case strings.HasPrefix(line, "RCPT TO:"):
+			got = append(got, line)
+			conn.Write([]byte("250 ok\r\n"))
+		case line == "DATA":
+			data = true
+			conn.Write([]byte("354 go on\r\n"))
+		case line == "QUIT":
+			conn.Write([]byte("221 bye\r\n"))
+			c <- got
+			return
+		default:
+			conn.Write([]byte("250 ok\r\n"))
+		}
+	}
+	c <- got
+}
+
+func TestSMTP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	got := make(chan []string, 1)
+	go fakeSMTP(l, got)
+
+	s, err := NewSMTP(l.Addr().String(), "alerts@example.com", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &common.AlertRule{ID: 7, Market: &common.Market{Name: "BTC-LTC", Exchanger: &common.Exchanger{Name: "bittrex"}},
+		Metric: MetricPrice, Op: OpAbove, Threshold: dec("0.01"), Channel: ChannelEmail, Target: "Desk <desk@example.com>"}
+	if err = CheckRule(r); err != nil {
+		t.Fatal("named address refused", err)
+	}
+	if err = s.Send(r, &common.Alert{Time: start, RuleRef: 7, Value: dec("0.0105"), Message: "ltc up"}); err != nil {
+		t.Fatal(err)
+	}
+	if c := <-got; len(c) != 2 || c[0] != "RCPT TO:<desk@example.com>" || c[1] != `To: "Desk" <desk@example.com>` {
+		t.Fatal("recipient", c)
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edalert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ds := database.NewDataStore("sqlite3")
+	ds.Name = filepath.Join(dir, "test.db")
+	if err = ds.OpenDB(); err != nil {
+		t.Fatal("open db", err)
+	}
+	defer ds.CloseDB()
+	if err = ds.Migrate(); err != nil {
+		t.Fatal("migrate db", err)
+	}
+
+	m := &common.Market{Name: "BTC-LTC", Symbol: &common.Symbol{Base: &common.Currency{Name: "Bitcoin", Abbr: "BTC"},
+		Quote: &common.Currency{Name: "Litecoin", Abbr: "LTC"}}, Exchanger: &common.Exchanger{Name: "bittrex"}}
+	r := &common.AlertRule{Market: m, Metric: MetricSpread, Op: OpAbove, Threshold: dec("0.01"), Channel: ChannelWebhook,
+		Target: "http://example.com/hook", Cooldown: 3600, Enabled: true}
+	if err = ds.SaveAlertRule(r); err != nil || r.ID == 0 {
+		t.Fatal("save rule", r.ID, err)
+	}
+	off := &common.AlertRule{MarketRef: m.ID, Metric: MetricNoData, Threshold: dec("60"), Channel: ChannelWebhook,
+		Target: "http://example.com/hook"}
+	if err = ds.SaveAlertRule(off); err != nil {
+		t.Fatal("save rule", err)
+	}
+	out := &sender{}
+	for k := 0; k < 2; k++ { // the stored alert starts the cooldown of the next engine
+		e := NewEngine(ds, -1)
+		e.Senders[ChannelWebhook] = out
+		if err = e.Load(); err != nil {
+			t.Fatal("load", err)
+		}
+		e.WriteTickers([]*common.Ticker{{Time: start, Market: &common.Market{Name: "BTC-LTC", Exchanger: &common.Exchanger{Name: "bittrex"}},
+			Bid: dec("1"), Ask: dec("2")}})
+		e.Close()
+	}
+	if len(out.sent) != 1 {
+		t.Fatal("alerts sent", out.sent)
+	}
+	c, _, err := ds.Alerts(r.ID, time.Now().Add(-time.Hour), time.Now().Add(time.Hour), nil, 10)
+	if err != nil || len(c) != 1 || !c[0].Delivered || !c[0].Value.Equal(dec("0.6666666666666667")) {
+		t.Fatal("stored alerts", c, err)
+	}
+	if all, err := ds.AlertRules(false); err != nil || len(all) != 2 || all[0].Market.Exchanger.Name != "bittrex" {
+		t.Fatal("rules", all, err)
+	}
+	if err = ds.DeleteAlertRule(r.ID); err != nil {
+		t.Fatal("delete", err)
+	}
+	if err = ds.DeleteAlertRule(r.ID); err == nil {
+		t.Fatal("deleted twice")
+	}
+	if c, _, _ = ds.Alerts(r.ID, time.Now().Add(-time.Hour), time.Now().Add(time.Hour), nil, 10); len(c) != 0 {
+		t.Fatal("alerts of the deleted rule", c)
+	}
+}
@@ -0,0 +1,158 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/shopspring/decimal"
+)
+
+// Payload is the JSON body of the webhooks
+type Payload struct {
+	Rule      uint            `json:"rule"`
+	Name      string          `json:"name,omitempty"`
+	Exchanger string          `json:"exchanger"`
+	Market    string          `json:"market"`
+	Metric    string          `json:"metric"`
+	Op        string          `json:"op"`
+	Threshold decimal.Decimal `json:"threshold"`
+	Value     decimal.Decimal `json:"value"`
+	Message   string          `json:"message"`
+	Time      time.Time       `json:"time"`
+}
+
+// NewPayload returns the payload of the alert a of the rule r
+func NewPayload(r *common.AlertRule, a *common.Alert) *Payload {
+	p := &Payload{Rule: r.ID, Name: r.Name, Metric: r.Metric, Op: r.Op, Threshold: r.Threshold,
+		Value: a.Value, Message: a.Message, Time: a.Time.UTC()}
+	if r.Market != nil {
+		p.Market = r.Market.Name
+		if r.Market.Exchanger != nil {
+			p.Exchanger = r.Market.Exchanger.Name
+		}
+	}
+	return p
+}
+
+// Webhook posts the alerts as a JSON Payload to the URL of the target of the rules.
+// The rules are written by the API clients, the daemon must not post to the internal services:
+// the targets resolving to a loopback, link-local or private address are refused, but the hosts allowed.
+type Webhook struct {
+	Client *http.Client
+}
+
+// NewWebhook returns a webhook sender waiting for the responses up to timeout,
+// allowed are the hosts of the targets posted to even if their address is private
+func NewWebhook(timeout time.Duration, allowed ...string) *Webhook {
+	allow := make(map[string]bool)
+	for _, h := range allowed {
+		allow[strings.ToLower(strings.TrimSpace(h))] = true
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if allow[strings.ToLower(host)] {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if !PublicIP(ip.IP) {
+				return nil, fmt.Errorf("webhook host %s has the private address %s", host, ip.IP)
+			}
+		}
+		// the checked address, a second resolution could return another one
+		return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+	}
+	return &Webhook{Client: &http.Client{Timeout: timeout, Transport: &http.Transport{DialContext: dial}}}
+}
+
+// internalNets are the ranges used inside the networks besides the private ones of net.IP.IsPrivate:
+// the shared address space of carrier-grade NAT, also used in cloud VPCs, and the benchmarking networks
+var internalNets = []*net.IPNet{
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+	{IP: net.IPv4(198, 18, 0, 0), Mask: net.CIDRMask(15, 32)},
+}
+
+// PublicIP tells if ip is none of the loopback, link-local, private, shared, benchmarking, multicast
+// or unspecified addresses, an IPv4-mapped address as its IPv4 one
+func PublicIP(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, n := range internalNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return !(ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsPrivate() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// Send posts the alert a, a response status other than 2xx is an error
+func (w *Webhook) Send(r *common.AlertRule, a *common.Alert) error {
+	body, err := json.Marshal(NewPayload(r, a))
+	if err != nil {
+		return err
+	}
+	resp, err := w.Client.Post(r.Target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook status %s", resp.Status)
+	}
+	return nil
+}
+
+// SMTP mails the alerts to the address of the target of the rules through the server Addr, host:port
+type SMTP struct {
+	Addr string
+	From string
+	Auth smtp.Auth // no authentication if nil
+}
+
+// NewSMTP returns the mail sender of the server addr, with the plain authentication of user if not empty
+func NewSMTP(addr, from, user, password string) (*SMTP, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &SMTP{Addr: addr, From: from}
+	if user != "" {
+		s.Auth = smtp.PlainAuth("", user, password, host)
+	}
+	return s, nil
+}
+
+// Send mails the alert a, its message is the subject. The target may carry a name, like Name <a@b>.
+func (s *SMTP) Send(r *common.AlertRule, a *common.Alert) error {
+	to, err := mail.ParseAddress(r.Target)
+	if err != nil {
+		return err
+	}
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(a.Message)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\nTo: %s\r\nSubject: [exchangedata] %s\r\nDate: %s\r\n", s.From, to.String(), subject,
+		a.Time.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	p := NewPayload(r, a)
+	fmt.Fprintf(&b, "%s\r\n\r\nrule %d %s %s %s %s\r\nvalue %s at %s\r\n", a.Message, p.Rule, p.Exchanger+":"+p.Market,
+		p.Metric, p.Op, p.Threshold, p.Value, p.Time.Format(time.RFC3339))
+	return smtp.SendMail(s.Addr, s.Auth, s.From, []string{to.Address}, b.Bytes())
+}
@@ -0,0 +1,371 @@
+// Package arbitrage finds the arbitrage opportunities in the order books of the exchangers: a symbol bought
+// on an exchanger and sold on another, and the triangular cycles of three markets of an exchanger, like
+// BTC→ETH→USDT→BTC on Bittrex.
+//
+// Like on Bittrex, the book of the market BTC-LTC, Symbol BTC_LTC, prices its Symbol.Quote LTC in its Symbol.Base BTC,
+// its volumes are of LTC. The orders of an opportunity walk the levels of the books, pay the fee of their exchanger
+// and keep within the Limitation of their market.
+package arbitrage
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/sink"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// DefaultMaxAge is the age of the books left out of the search
+	DefaultMaxAge = 30 * time.Second
+	// DefaultFlushInterval is the period of the searches in the changed books
+	DefaultFlushInterval = time.Second
+)
+
+// DefaultFee is the taker fee rate of the exchangers without their own, the one of Bittrex
+var DefaultFee = decimal.RequireFromString("0.0025")
+
+// precision is the number of decimals of the amounts of the opportunities
+const precision = 8
+
+// searchSteps is the number of halvings of the amount range of an opportunity
+const searchSteps = 48
+
+var one = decimal.New(1, 0)
+
+// Detector takes the order books of the exchangers as a sink.Sink and a sink.DeltaWriter, and writes the
+// opportunities of the changed books to out. The opportunities are logged.
+type Detector struct {
+	Fees    map[string]decimal.Decimal // taker fee rates by exchanger, DefaultFee for the others
+	MinEdge decimal.Decimal            // the opportunities with a lower edge are left out, 0 by default
+	MaxAge  time.Duration
+
+	out sink.OpportunityWriter
+
+	mu      sync.Mutex
+	books   map[string]*book   // by exchanger:market
+	symbols map[string][]*book // by symbol name
+	venues  map[string]*venue  // by exchanger
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// book is the last order book of a market
+type book struct {
+	m               *common.Market
+	exchanger       string
+	traded, pricing string // currency abbreviations
+	ob              *common.OrderBook
+	changed         bool
+}
+
+// venue is the books of an exchanger and their triangular cycles, nil until searched
+type venue struct {
+	books   []*book
+	cycles  [][3]step
+	changed bool
+}
+
+// NewDetector searches the changed books every flushInterval, DefaultFlushInterval if 0.
+// A negative flushInterval leaves the searches to Flush. out belongs to the caller.
+func NewDetector(out sink.OpportunityWriter, flushInterval time.Duration) *Detector {
+	if flushInterval == 0 {
+		flushInterval = DefaultFlushInterval
+	}
+	d := &Detector{
+		Fees:    make(map[string]decimal.Decimal),
+		MaxAge:  DefaultMaxAge,
+		out:     out,
+		books:   make(map[string]*book),
+		symbols: make(map[string][]*book),
+		venues:  make(map[string]*venue),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if flushInterval < 0 {
+		close(d.done)
+		return d
+	}
+	go d.run(flushInterval)
+	return d
+}
+
+// ParseFees parses the fee rates of exchangers, like bittrex=0.0025,binance=0.001
+func ParseFees(s string) (map[string]decimal.Decimal, error) {
+	fees := make(map[string]decimal.Decimal)
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad fee %s, exchanger=rate expected", f)
+		}
+		rate, err := decimal.NewFromString(strings.TrimSpace(kv[1]))
+		if err != nil || rate.IsNegative() || !rate.LessThan(one) {
+			return nil, fmt.Errorf("bad fee rate %s", kv[1])
+		}
+		fees[strings.ToLower(strings.TrimSpace(kv[0]))] = rate
+	}
+	return fees, nil
+}
+
+func (d *Detector) run(interval time.Duration) {
+	defer close(d.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := d.Flush(now); err != nil {
+				log.Println("arbitrage write error:", err)
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+func (d *Detector) fee(exchanger string) decimal.Decimal {
+	if f, ok := d.Fees[exchanger]; ok {
+		return f
+	}
+	return DefaultFee
+}
+
+// book returns the book of the market m, nil if its symbol is unknown
+func (d *Detector) book(m *common.Market) *book {
+	if m == nil || m.Exchanger == nil || m.Symbol == nil || m.Symbol.Base == nil || m.Symbol.Quote == nil {
+		return nil
+	}
+	key := m.Exchanger.Name + ":" + m.Name
+	b := d.books[key]
+	if b == nil {
+		b = &book{m: m, exchanger: m.Exchanger.Name,
+			traded: strings.ToUpper(m.Symbol.Quote.Abbr), pricing: strings.ToUpper(m.Symbol.Base.Abbr)}
+		d.books[key] = b
+		sym := b.pricing + "_" + b.traded
+		d.symbols[sym] = append(d.symbols[sym], b)
+		v := d.venues[b.exchanger]
+		if v == nil {
+			v = &venue{}
+			d.venues[b.exchanger] = v
+		}
+		v.books = append(v.books, b)
+		v.cycles = nil
+	}
+	return b
+}
+
+func (d *Detector) WriteOrderBooks(r []*common.OrderBook) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, ob := range r {
+		b := d.book(ob.Market)
+		if b == nil || (b.ob != nil && ob.Time.Before(b.ob.Time)) {
+			continue
+		}
+		b.ob = ob.Clone()
+		d.changed(b)
+	}
+	return nil
+}
+
+// WriteBookDeltas changes the book of m, see sink.DeltaWriter
+func (d *Detector) WriteBookDeltas(m *common.Market, c []*common.BookDelta) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b := d.book(m)
+	if b == nil || b.ob == nil || len(c) == 0 { // no book to change yet
+		return nil
+	}
+	b.ob.Apply(c)
+	d.changed(b)
+	return nil
+}
+
+func (d *Detector) changed(b *book) {
+	b.changed = true
+	d.venues[b.exchanger].changed = true
+}
+
+func (d *Detector) WriteTickers(r []*common.Ticker) error { return nil }
+func (d *Detector) WriteTrades(r []*common.Trade) error   { return nil }
+func (d *Detector) WriteCandles(r []*common.Candle) error { return nil }
+
+// Flush searches the opportunities at now using the books changed since the last search, and writes them
+// from the highest edge. The books older than MaxAge at now are left out.
+func (d *Detector) Flush(now time.Time) error {
+	d.mu.Lock()
+	var found []*common.Opportunity
+	add := func(kind string, steps ...step) {
+		for _, s := range steps {
+			if s.b.changed {
+				if o := d.search(kind, now, steps...); o != nil {
+					found = append(found, o)
+				}
+				return
+			}
+		}
+	}
+	for _, books := range d.symbols {
+		for _, a := range books {
+			for _, b := range books {
+				if a.exchanger != b.exchanger {
+					add(common.ArbitrageCross, step{b: a, buy: true}, step{b: b})
+				}
+			}
+		}
+	}
+	for _, v := range d.venues {
+		if !v.changed {
+			continue
+		}
+		v.changed = false
+		if v.cycles == nil {
+			v.cycles = cycles(v.books)
+		}
+		for _, c := range v.cycles {
+			add(common.ArbitrageTriangular, c[:]...)
+		}
+	}
+	for _, b := range d.books {
+		b.changed = false
+	}
+	d.mu.Unlock()
+
+	if len(found) == 0 {
+		return nil
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Edge.GreaterThan(found[j].Edge) })
+	for _, o := range found {
+		log.Println("arbitrage", describe(o))
+	}
+	return d.out.WriteOpportunities(found)
+}
+
+// cycles returns the triangular cycles of the books, each once from its lowest currency
+func cycles(books []*book) [][3]step {
+	next := make(map[string]map[string]step) // the orders from a currency to another
+	link := func(from, to string, s step) {
+		if next[from] == nil {
+			next[from] = make(map[string]step)
+		}
+		if _, ok := next[from][to]; !ok {
+			next[from][to] = s
+		}
+	}
+	for _, b := range books {
+		link(b.pricing, b.traded, step{b: b, buy: true})
+		link(b.traded, b.pricing, step{b: b})
+	}
+	var c [][3]step
+	for c0, to := range next {
+		for c1, s0 := range to {
+			if c1 <= c0 {
+				continue
+			}
+			for c2, s1 := range next[c1] {
+				if c2 <= c0 {
+					continue
+				}
+				if s2, ok := next[c2][c0]; ok {
+					c = append(c, [3]step{s0, s1, s2})
+				}
+			}
+		}
+	}
+	return c
+}
+
+// search returns the opportunity of the largest amount of the cycle of steps with an edge of MinEdge at the margin,
+// nil if there is none
+func (d *Detector) search(kind string, now time.Time, steps ...step) *common.Opportunity {
+	oldest := now
+	for k := range steps {
+		s := &steps[k]
+		if s.b.ob == nil || now.Sub(s.b.ob.Time) > d.MaxAge {
+			return nil
+		}
+		if s.b.ob.Time.Before(oldest) {
+			oldest = s.b.ob.Time
+		}
+		s.fee = d.fee(s.b.exchanger)
+	}
+	target := one.Add(d.MinEdge)
+	profitable := func(in decimal.Decimal) bool {
+		rate := one
+		for _, s := range steps {
+			f := s.fill(in)
+			if !f.filled || (s.b.m.Limitation.Max.IsPositive() && f.amount.GreaterThan(s.b.m.Limitation.Max)) {
+				return false
+			}
+			rate = rate.Mul(f.rate)
+			in = f.out
+		}
+		return !rate.LessThan(target)
+	}
+	if !profitable(decimal.Zero) {
+		return nil
+	}
+	lo, hi := decimal.Zero, steps[0].capacity()
+	for k := 0; k < searchSteps; k++ {
+		mid := lo.Add(hi).Div(decimal.New(2, 0))
+		if profitable(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	start := lo.Truncate(precision)
+	if !start.IsPositive() {
+		return nil
+	}
+
+	o := &common.Opportunity{Kind: kind, Time: oldest, Start: start, Currency: steps[0].from()}
+	in := start
+	for _, s := range steps {
+		f := s.fill(in)
+		if f.amount.LessThan(s.b.m.Limitation.Min) {
+			return nil
+		}
+		side := common.SideSell
+		if s.buy {
+			side = common.SideBuy
+		}
+		o.Legs = append(o.Legs, &common.Leg{Market: s.b.m, Side: side, Price: f.total.DivRound(f.amount, 18),
+			Amount: f.amount, Fee: s.fee})
+		in = f.out
+	}
+	o.Profit = in.Sub(start)
+	o.Edge = o.Profit.DivRound(start, 8)
+	if !o.Profit.IsPositive() || o.Edge.LessThan(d.MinEdge) {
+		return nil
+	}
+	return o
+}
+
+func describe(o *common.Opportunity) string {
+	s := fmt.Sprintf("%s edge %s profit %s %s of %s:", o.Kind, o.Edge, o.Profit, o.Currency, o.Start)
+	for _, l := range o.Legs {
+		s += fmt.Sprintf(" %s %s %s:%s at %s", l.Side, l.Amount, l.Market.Exchanger.Name, l.Market.Name, l.Price)
+	}
+	return s
+}
+
+// Close stops the periodic searches and searches the changed books
+func (d *Detector) Close() error {
+	select {
+	case <-d.done:
+	default:
+		close(d.stop)
+		<-d.done
+	}
+	return d.Flush(time.Now())
+}
@@ -0,0 +1,144 @@
+package arbitrage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/shopspring/decimal"
+)
+
+var dec = decimal.RequireFromString
+
+type opportunitySink struct {
+	found []*common.Opportunity
+}
+
+func (s *opportunitySink) WriteOpportunities(c []*common.Opportunity) error {
+	s.found = append(s.found, c...)
+	return nil
+}
+
+var start = time.Date(2018, 11, 26, 0, 0, 0, 0, time.UTC)
+
+var currencies = map[string]*common.Currency{}
+
+func market(ex, base, quote string) *common.Market {
+	cur := func(abbr string) *common.Currency {
+		if currencies[abbr] == nil {
+			currencies[abbr] = &common.Currency{Abbr: abbr}
+		}
+		return currencies[abbr]
+	}
+	return &common.Market{Name: base + "-" + quote, Exchanger: &common.Exchanger{Name: ex},
+		Symbol: &common.Symbol{Base: cur(base), Quote: cur(quote)}}
+}
+
+func levels(pv ...string) []*common.PriceVol {
+	l := []*common.PriceVol{}
+	for k := 0; k < len(pv); k += 2 {
+		l = append(l, &common.PriceVol{Price: dec(pv[k]), Volume: dec(pv[k+1])})
+	}
+	return l
+}
+
+func near(a, b decimal.Decimal) bool {
+	return a.Sub(b).Abs().LessThan(dec("0.000001"))
+}
+
+func TestCross(t *testing.T) {
+	bittrex, binance := market("bittrex", "USDT", "BTC"), market("binance", "USDT", "BTC")
+	out := &opportunitySink{}
+	d := NewDetector(out, -1)
+	d.Fees = map[string]decimal.Decimal{"bittrex": decimal.Zero, "binance": decimal.Zero}
+
+	d.WriteOrderBooks([]*common.OrderBook{
+		{Time: start, Market: bittrex, Bids: levels("99", "1"), Asks: levels("100", "1", "101", "1")},
+		{Time: start, Market: binance, Bids: levels("103", "0.5", "102", "2"), Asks: levels("104", "1")},
+	})
+	d.Flush(start)
+	if len(out.found) != 1 {
+		t.Fatal("wrong opportunities", out.found)
+	}
+	o := out.found[0]
+	// both bittrex asks are bought, sold on binance
+	if o.Kind != common.ArbitrageCross || o.Currency != "USDT" || !near(o.Start, dec("201")) || !near(o.Profit, dec("3.5")) ||
+		len(o.Legs) != 2 || o.Legs[0].Market != bittrex || o.Legs[0].Side != common.SideBuy || !near(o.Legs[0].Amount, dec("2")) ||
+		!near(o.Legs[1].Price, dec("102.25")) {
+		t.Fatal("wrong opportunity", describe(o))
+	}
+
+	// the fees take the edge of the second ask, unchanged books are not searched again
+	out.found = nil
+	d.Flush(start)
+	d.Fees["binance"] = dec("0.01")
+	d.WriteBookDeltas(binance, []*common.BookDelta{{Time: start, Side: common.BookAsk, Price: dec("105"), Volume: dec("1")}})
+	d.Flush(start)
+	if len(out.found) != 1 || !near(out.found[0].Start, dec("100")) || !near(out.found[0].Legs[1].Amount, dec("1")) {
+		t.Fatal("wrong opportunity with fees", out.found)
+	}
+
+	// the limitations of the markets
+	out.found = nil
+	d.Fees["binance"] = decimal.Zero
+	binance.Limitation.Max = dec("0.5")
+	d.WriteOrderBooks([]*common.OrderBook{{Time: start, Market: binance, Bids: levels("103", "0.5", "102", "2")}})
+	d.Flush(start)
+	if len(out.found) != 1 || !near(out.found[0].Start, dec("50")) || !near(out.found[0].Profit, dec("1.5")) {
+		t.Fatal("max limitation not kept", out.found)
+	}
+	out.found = nil
+	bittrex.Limitation.Min = dec("1")
+	d.WriteOrderBooks([]*common.OrderBook{{Time: start, Market: binance, Bids: levels("103", "0.5", "102", "2")}})
+	d.Flush(start)
+	if len(out.found) != 0 {
+		t.Fatal("min limitation not kept", describe(out.found[0]))
+	}
+
+	// stale books are left out
+	bittrex.Limitation, binance.Limitation = common.Limitation{}, common.Limitation{}
+	d.WriteOrderBooks([]*common.OrderBook{{Time: start, Market: binance, Bids: levels("103", "0.5", "102", "2")}})
+	d.Flush(start.Add(time.Minute))
+	if len(out.found) != 0 {
+		t.Fatal("stale books searched", out.found)
+	}
+	d.Close()
+}
+
+func TestTriangular(t *testing.T) {
+	out := &opportunitySink{}
+	d := NewDetector(out, -1)
+	d.WriteOrderBooks([]*common.OrderBook{
+		{Time: start, Market: market("bittrex", "USDT", "BTC"), Bids: levels("99", "1"), Asks: levels("100", "0.5")},
+		{Time: start, Market: market("bittrex", "BTC", "ETH"), Bids: levels("0.04", "100"), Asks: levels("0.05", "100")},
+		{Time: start, Market: market("bittrex", "USDT", "ETH"), Bids: levels("5.5", "10"), Asks: levels("6", "10")},
+	})
+	d.Flush(start)
+	if len(out.found) != 1 {
+		t.Fatal("wrong opportunities", out.found)
+	}
+	o := out.found[0]
+	// BTC → 20 ETH → 110 USDT → 1.1 BTC, the BTC asks are the limit: 0.5 BTC for 55 USDT of 10 ETH of 0.5 BTC
+	keep := one.Sub(DefaultFee)
+	edge := dec("1.1").Mul(keep).Mul(keep).Mul(keep).Sub(one)
+	if o.Kind != common.ArbitrageTriangular || o.Currency != "BTC" || len(o.Legs) != 3 ||
+		o.Legs[0].Side != common.SideBuy || o.Legs[1].Side != common.SideSell || o.Legs[2].Side != common.SideBuy ||
+		!near(o.Edge, edge) || !near(o.Legs[2].Amount, dec("0.5")) {
+		t.Fatal("wrong opportunity", describe(o), edge)
+	}
+	if o.Legs[0].Amount.GreaterThan(dec("10")) || o.Legs[1].Amount.GreaterThan(dec("10")) {
+		t.Fatal("ETH bids exceeded", describe(o))
+	}
+}
+
+func TestParseFees(t *testing.T) {
+	fees, err := ParseFees("bittrex=0.0025, Binance=0.001")
+	if err != nil || len(fees) != 2 || !fees["binance"].Equal(dec("0.001")) {
+		t.Fatal("fees", fees, err)
+	}
+	for _, bad := range []string{"bittrex", "bittrex=x", "bittrex=-0.1", "bittrex=1"} {
+		if _, err := ParseFees(bad); err == nil {
+			t.Error("bad fees accepted", bad)
+		}
+	}
+}
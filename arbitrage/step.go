@@ -0,0 +1,93 @@
+package arbitrage
+
+import (
+	"github.com/exchangedata/common"
+	"github.com/shopspring/decimal"
+)
+
+// step is an order of a cycle: buy spends the pricing currency of the book for its traded currency on the asks,
+// sell the traded currency for the pricing currency on the bids
+type step struct {
+	b   *book
+	buy bool
+	fee decimal.Decimal
+}
+
+// from returns the currency spent by s
+func (s step) from() string {
+	if s.buy {
+		return s.b.pricing
+	}
+	return s.b.traded
+}
+
+func (s step) levels() []*common.PriceVol {
+	if s.buy {
+		return s.b.ob.Asks
+	}
+	return s.b.ob.Bids
+}
+
+// capacity returns the amount spent by s taking all the levels of its side
+func (s step) capacity() decimal.Decimal {
+	c := decimal.Zero
+	for _, l := range s.levels() {
+		if s.buy {
+			c = c.Add(l.Price.Mul(l.Volume))
+		} else {
+			c = c.Add(l.Volume)
+		}
+	}
+	return c
+}
+
+// fill is an order of a step: amount of the traded currency for total of the pricing currency,
+// out is what the order returns after the fee and rate is what the next unit spent would return
+type fill struct {
+	amount, total, out, rate decimal.Decimal
+	filled                   bool
+}
+
+// fill spends in on the levels of s from the best price
+func (s step) fill(in decimal.Decimal) fill {
+	f := fill{}
+	left := in
+	var price decimal.Decimal
+	for _, l := range s.levels() {
+		if !l.Price.IsPositive() || !l.Volume.IsPositive() {
+			continue
+		}
+		price = l.Price
+		cost := l.Volume // what taking the level spends
+		if s.buy {
+			cost = l.Price.Mul(l.Volume)
+		}
+		if left.LessThan(cost) {
+			if s.buy {
+				f.amount = f.amount.Add(left.DivRound(l.Price, 18))
+				f.total = f.total.Add(left)
+			} else {
+				f.amount = f.amount.Add(left)
+				f.total = f.total.Add(left.Mul(l.Price))
+			}
+			left = decimal.Zero
+			break
+		}
+		f.amount = f.amount.Add(l.Volume)
+		f.total = f.total.Add(l.Price.Mul(l.Volume))
+		left = left.Sub(cost)
+	}
+	f.filled = !left.IsPositive() && price.IsPositive()
+	if !f.filled {
+		return f
+	}
+	keep := one.Sub(s.fee)
+	if s.buy {
+		f.out = f.amount.Mul(keep)
+		f.rate = keep.DivRound(price, 18)
+	} else {
+		f.out = f.total.Mul(keep)
+		f.rate = price.Mul(keep)
+	}
+	return f
+}
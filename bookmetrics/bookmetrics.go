@@ -0,0 +1,245 @@
+// Package bookmetrics measures the order books: the depth within a band around the mid price, the imbalance
+// of the two sides, the microprice and the slippage of the market orders of a notional, see common.BookMetrics.
+//
+// An Analyzer takes the live books as a sink.Sink and writes the metrics of the changed ones periodically,
+// the streamed books change with every delta and are sampled. Rebuild computes the metrics of the stored snapshots.
+package bookmetrics
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/database"
+	"github.com/exchangedata/sink"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// DefaultFlushInterval is the period of the metrics of the changed books
+	DefaultFlushInterval = 10 * time.Second
+)
+
+var (
+	// DefaultBand is the band around the mid price of the depths, 1%
+	DefaultBand = decimal.New(1, -2)
+	// DefaultNotional is the amount in the pricing currency of the slippages, 1 BTC on the BTC markets
+	DefaultNotional = decimal.New(1, 0)
+
+	one = decimal.New(1, 0)
+	two = decimal.New(2, 0)
+)
+
+// Compute returns the metrics of the book ob, nil if a side is empty. band is the rate of the mid price
+// of the depths, notional the amount of the orders of the slippages in the pricing currency.
+func Compute(ob *common.OrderBook, band, notional decimal.Decimal) *common.BookMetrics {
+	if len(ob.Bids) == 0 || len(ob.Asks) == 0 {
+		return nil
+	}
+	bid, ask := ob.Bids[0], ob.Asks[0]
+	mid := bid.Price.Add(ask.Price).Div(two)
+	m := &common.BookMetrics{Time: ob.Time, MarketRef: ob.MarketRef, Market: ob.Market,
+		Mid: mid, Spread: ask.Price.Sub(bid.Price), Microprice: mid, Band: band, Notional: notional}
+	if top := bid.Volume.Add(ask.Volume); top.IsPositive() {
+		m.Microprice = bid.Price.Mul(ask.Volume).Add(ask.Price.Mul(bid.Volume)).Div(top)
+	}
+
+	low, high := mid.Mul(one.Sub(band)), mid.Mul(one.Add(band))
+	for _, l := range ob.Bids {
+		if l.Price.LessThan(low) {
+			break
+		}
+		m.BidDepth = m.BidDepth.Add(l.Volume)
+	}
+	for _, l := range ob.Asks {
+		if l.Price.GreaterThan(high) {
+			break
+		}
+		m.AskDepth = m.AskDepth.Add(l.Volume)
+	}
+	if depth := m.BidDepth.Add(m.AskDepth); depth.IsPositive() {
+		m.Imbalance = m.BidDepth.Sub(m.AskDepth).Div(depth)
+	}
+
+	if mid.IsPositive() && notional.IsPositive() {
+		if avg, ok := average(ob.Asks, notional); ok {
+			m.BuySlippage = decimal.NullDecimal{Decimal: avg.Sub(mid).Div(mid), Valid: true}
+		}
+		if avg, ok := average(ob.Bids, notional); ok {
+			m.SellSlippage = decimal.NullDecimal{Decimal: mid.Sub(avg).Div(mid), Valid: true}
+		}
+	}
+	return m
+}
+
+// average returns the average price of an order of notional filled by the levels from the best one,
+// false if they are not enough
+func average(levels []*common.PriceVol, notional decimal.Decimal) (decimal.Decimal, bool) {
+	left, amount := notional, decimal.Zero
+	for _, l := range levels {
+		if !l.Price.IsPositive() {
+			continue
+		}
+		total := l.Price.Mul(l.Volume)
+		if total.GreaterThanOrEqual(left) {
+			amount = amount.Add(left.Div(l.Price))
+			return notional.Div(amount), true
+		}
+		left = left.Sub(total)
+		amount = amount.Add(l.Volume)
+	}
+	return decimal.Zero, false
+}
+
+// Analyzer takes the order books and their deltas as a sink.Sink and writes the metrics of the changed books to out
+type Analyzer struct {
+	Band     decimal.Decimal // set before the first book
+	Notional decimal.Decimal // set before the first book
+
+	out sink.BookMetricsWriter
+
+	mu    sync.Mutex
+	books map[string]*book // by exchanger:market
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type book struct {
+	ob      *common.OrderBook
+	changed bool
+}
+
+// NewAnalyzer writes the metrics of the changed books to out every flushInterval, DefaultFlushInterval if 0.
+// A negative flushInterval leaves the writes to Flush. out belongs to the caller.
+func NewAnalyzer(out sink.BookMetricsWriter, flushInterval time.Duration) *Analyzer {
+	if flushInterval == 0 {
+		flushInterval = DefaultFlushInterval
+	}
+	a := &Analyzer{
+		Band:     DefaultBand,
+		Notional: DefaultNotional,
+		out:      out,
+		books:    make(map[string]*book),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if flushInterval < 0 {
+		close(a.done)
+		return a
+	}
+	go a.run(flushInterval)
+	return a
+}
+
+func (a *Analyzer) run(interval time.Duration) {
+	defer close(a.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.Flush(); err != nil {
+				log.Println("book metrics write error:", err)
+			}
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+func key(m *common.Market) string {
+	if m == nil || m.Exchanger == nil {
+		return ""
+	}
+	return m.Exchanger.Name + ":" + m.Name
+}
+
+func (a *Analyzer) WriteOrderBooks(r []*common.OrderBook) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, ob := range r {
+		k := key(ob.Market)
+		if k == "" {
+			continue
+		}
+		b := a.books[k]
+		if b == nil {
+			b = &book{}
+			a.books[k] = b
+		} else if ob.Time.Before(b.ob.Time) {
+			continue
+		}
+		b.ob, b.changed = ob.Clone(), true
+	}
+	return nil
+}
+
+// WriteBookDeltas changes the book of m, see sink.DeltaWriter
+func (a *Analyzer) WriteBookDeltas(m *common.Market, c []*common.BookDelta) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b := a.books[key(m)]
+	if b == nil || len(c) == 0 { // no book to change yet
+		return nil
+	}
+	b.ob.Apply(c)
+	b.changed = true
+	return nil
+}
+
+func (a *Analyzer) WriteTickers(r []*common.Ticker) error { return nil }
+func (a *Analyzer) WriteTrades(r []*common.Trade) error   { return nil }
+func (a *Analyzer) WriteCandles(r []*common.Candle) error { return nil }
+
+// Flush writes the metrics of the books changed since the last flush
+func (a *Analyzer) Flush() error {
+	a.mu.Lock()
+	var c []*common.BookMetrics
+	for _, b := range a.books {
+		if !b.changed {
+			continue
+		}
+		b.changed = false
+		if m := Compute(b.ob, a.Band, a.Notional); m != nil {
+			c = append(c, m)
+		}
+	}
+	a.mu.Unlock()
+	if len(c) == 0 {
+		return nil
+	}
+	return a.out.WriteBookMetrics(c)
+}
+
+// Close stops the periodic writes and writes the metrics of the changed books
+func (a *Analyzer) Close() error {
+	select {
+	case <-a.done:
+	default:
+		close(a.stop)
+		<-a.done
+	}
+	return a.Flush()
+}
+
+// Rebuild computes the metrics of the order book snapshots stored in [from, to) and stores them in place of
+// the stored ones. It returns the number of metrics stored.
+func Rebuild(ds *database.DataStore, from, to time.Time, band, notional decimal.Decimal) (int, error) {
+	if err := ds.DeleteBookMetrics(from, to); err != nil {
+		return 0, err
+	}
+	n := 0
+	err := ds.ScanOrderBooks(from, to, 0, func(page []*common.OrderBook) error {
+		c := make([]*common.BookMetrics, 0, len(page))
+		for _, ob := range page {
+			if m := Compute(ob, band, notional); m != nil {
+				c = append(c, m)
+			}
+		}
+		n += len(c)
+		return ds.InsertBookMetrics(c)
+	})
+	return n, err
+}
@@ -0,0 +1,127 @@
+package bookmetrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/database"
+	"github.com/shopspring/decimal"
+)
+
+var dec = decimal.RequireFromString
+
+var start = time.Date(2018, 11, 26, 0, 0, 0, 0, time.UTC)
+
+func levels(pv ...string) []*common.PriceVol {
+	l := []*common.PriceVol{}
+	for k := 0; k < len(pv); k += 2 {
+		l = append(l, &common.PriceVol{Price: dec(pv[k]), Volume: dec(pv[k+1])})
+	}
+	return l
+}
+
+type metricsSink struct {
+	metrics []*common.BookMetrics
+}
+
+func (s *metricsSink) WriteBookMetrics(c []*common.BookMetrics) error {
+	s.metrics = append(s.metrics, c...)
+	return nil
+}
+
+func TestCompute(t *testing.T) {
+	ob := &common.OrderBook{Time: start, Bids: levels("99", "3", "97", "1"), Asks: levels("101", "1", "103", "1")}
+	m := Compute(ob, dec("0.02"), dec("204"))
+	for name, v := range map[string][2]decimal.Decimal{
+		"mid":        {m.Mid, dec("100")},
+		"spread":     {m.Spread, dec("2")},
+		"microprice": {m.Microprice, dec("100.5")},
+		"bid depth":  {m.BidDepth, dec("3")},
+		"ask depth":  {m.AskDepth, dec("1")},
+		"imbalance":  {m.Imbalance, dec("0.5")},
+		"buy":        {m.BuySlippage.Decimal, dec("0.02")},
+		"sell":       {m.SellSlippage.Decimal, dec("0.01")},
+	} {
+		if !v[0].Equal(v[1]) {
+			t.Error(name, v[0], "expected", v[1])
+		}
+	}
+	if !m.BuySlippage.Valid || !m.SellSlippage.Valid {
+		t.Error("slippages not set")
+	}
+	if m = Compute(ob, dec("0.02"), dec("1000")); m.BuySlippage.Valid || m.SellSlippage.Valid {
+		t.Error("slippage beyond the book", m.BuySlippage, m.SellSlippage)
+	}
+	if Compute(&common.OrderBook{Bids: levels("99", "1")}, DefaultBand, DefaultNotional) != nil {
+		t.Error("metrics of a one sided book")
+	}
+}
+
+func TestAnalyzer(t *testing.T) {
+	out := &metricsSink{}
+	a := NewAnalyzer(out, -1)
+	m := &common.Market{ID: 1, Name: "USDT-BTC", Exchanger: &common.Exchanger{Name: "bittrex"}}
+	a.WriteBookDeltas(m, []*common.BookDelta{{Time: start, Side: common.BookBid, Price: dec("99"), Volume: dec("1")}})
+	a.Flush()
+	if len(out.metrics) != 0 {
+		t.Fatal("metrics without book", out.metrics)
+	}
+	a.WriteOrderBooks([]*common.OrderBook{{Time: start, Market: m, Bids: levels("99", "1"), Asks: levels("101", "1")}})
+	a.WriteBookDeltas(m, []*common.BookDelta{{Time: start.Add(time.Second), Side: common.BookAsk, Price: dec("100"), Volume: dec("3")}})
+	a.Flush()
+	if len(out.metrics) != 1 || !out.metrics[0].Mid.Equal(dec("99.5")) || !out.metrics[0].Time.Equal(start.Add(time.Second)) {
+		t.Fatal("metrics of the changed book", out.metrics)
+	}
+	a.Close() // unchanged since the flush
+	if len(out.metrics) != 1 {
+		t.Fatal("metrics of an unchanged book", len(out.metrics))
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edbookmetrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ds := database.NewDataStore("sqlite3")
+	ds.Name = filepath.Join(dir, "test.db")
+	if err = ds.OpenDB(); err != nil {
+		t.Fatal("open db", err)
+	}
+	defer ds.CloseDB()
+	if err = ds.Migrate(); err != nil {
+		t.Fatal("migrate db", err)
+	}
+
+	m := &common.Market{Name: "USDT-BTC", Symbol: &common.Symbol{Base: &common.Currency{Name: "Tether", Abbr: "USDT"},
+		Quote: &common.Currency{Name: "Bitcoin", Abbr: "BTC"}}, Exchanger: &common.Exchanger{Name: "bittrex"}}
+	if err = ds.UpdateMarket(m).Error; err != nil {
+		t.Fatal("save market", err)
+	}
+	w := ds.NewBatchWriter(0, 0)
+	for k := 0; k < 3; k++ {
+		w.AddOrderBook(&common.OrderBook{Time: start.Add(time.Duration(k) * time.Minute), Market: m,
+			Bids: levels("99", "3", "97", "1"), Asks: levels("101", "1", "103", "1")})
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal("flush", err)
+	}
+	for k := 0; k < 2; k++ { // rebuilt in place
+		n, err := Rebuild(ds, start, start.Add(time.Hour), dec("0.02"), dec("300"))
+		if err != nil || n != 3 {
+			t.Fatal("rebuild", n, err)
+		}
+	}
+	c, _, err := ds.BookMetrics(m.ID, start, start.Add(time.Hour), nil, 10)
+	if err != nil || len(c) != 3 {
+		t.Fatal("book metrics", c, err)
+	}
+	if !c[0].Imbalance.Equal(dec("0.5")) || c[0].BuySlippage.Valid || !c[0].SellSlippage.Valid || c[0].Market.Exchanger.Name != "bittrex" {
+		t.Fatal("wrong stored metrics", c[0])
+	}
+}
@@ -0,0 +1,228 @@
+// Package bus is the in-process event bus between the market data producers, the exchangers,
+// and its consumers: storage, streaming APIs and analytics.
+//
+// Each subscriber has its own buffer and Policy, a slow consumer only delays or loses its own events.
+package bus
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/exchangedata/common"
+)
+
+// Topic is the kind of the records of an event
+type Topic uint8
+
+const (
+	Tickers Topic = iota
+	Trades
+	OrderBooks
+	BookDeltas
+	Candles
+	Consolidated
+	Opportunities
+	Indicators
+	BookMetrics
+)
+
+var topicNames = []string{"tickers", "trades", "orderbooks", "bookdeltas", "candles", "consolidated", "opportunities", "indicators", "bookmetrics"}
+
+func (t Topic) String() string {
+	if int(t) < len(topicNames) {
+		return topicNames[t]
+	}
+	return "unknown"
+}
+
+// Event carries the records of its Topic, the other record fields are nil.
+// Market is set for BookDeltas, the other records refer to their own market.
+// The records are shared by all the subscribers and must not be modified.
+type Event struct {
+	Topic      Topic
+	Time       time.Time // when the event was published
+	Market     *common.Market
+	Tickers    []*common.Ticker
+	Trades     []*common.Trade
+	OrderBooks []*common.OrderBook
+	Deltas     []*common.BookDelta
+	Candles    []*common.Candle
+
+	Consolidated  []*common.ConsolidatedTicker
+	Opportunities []*common.Opportunity
+	Indicators    []*common.IndicatorValue
+	BookMetrics   []*common.BookMetrics
+}
+
+// Policy is what happens to an event published to a subscriber with a full buffer
+type Policy int
+
+const (
+	DropNewest Policy = iota // the event is dropped
+	DropOldest               // the oldest buffered event is dropped to make room
+	Block                    // the publisher waits for room
+)
+
+// Bus delivers the published events to the subscribers of their topic
+type Bus struct {
+	mu     sync.RWMutex
+	subs   []*Subscriber
+	closed bool
+}
+
+func New() *Bus {
+	return &Bus{}
+}
+
+// Subscriber receives the events of its topics on C, in the order they were published.
+// C is closed by Unsubscribe and by the Close of the bus.
+type Subscriber struct {
+	Name string
+	C    <-chan *Event
+
+	b      *Bus
+	ch     chan *Event
+	policy Policy
+	topics uint32 // bit set of Topic
+	done   chan struct{}
+	once   sync.Once
+
+	delivered uint64
+	dropped   uint64
+}
+
+// Subscribe adds a subscriber with a buffer of size events to the topics, all of them if none is given
+func (b *Bus) Subscribe(name string, size int, policy Policy, topics ...Topic) *Subscriber {
+	ch := make(chan *Event, size)
+	s := &Subscriber{Name: name, C: ch, b: b, ch: ch, policy: policy, done: make(chan struct{})}
+	if len(topics) == 0 {
+		s.topics = ^uint32(0)
+	}
+	for _, t := range topics {
+		s.topics |= 1 << t
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.done)
+		close(s.ch)
+		return s
+	}
+	b.subs = append(b.subs, s)
+	return s
+}
+
+// Publish delivers e to the subscribers of its topic according to their policy.
+// It only waits for the subscribers with the Block policy.
+func (b *Bus) Publish(e *Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, s := range b.subs {
+		if s.topics&(1<<e.Topic) != 0 {
+			s.deliver(e)
+		}
+	}
+}
+
+// Close closes the channels of all the subscribers, the events buffered can still be received.
+// Publishing after Close does nothing.
+func (b *Bus) Close() {
+	b.mu.RLock()
+	subs := append([]*Subscriber{}, b.subs...)
+	b.mu.RUnlock()
+	for _, s := range subs {
+		s.Unsubscribe()
+	}
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+}
+
+// SubscriberStats are the counters of a subscriber
+type SubscriberStats struct {
+	Name      string
+	Delivered uint64
+	Dropped   uint64
+	Buffered  int
+}
+
+// Stats returns the counters of the subscribers
+func (b *Bus) Stats() []SubscriberStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	stats := make([]SubscriberStats, len(b.subs))
+	for k, s := range b.subs {
+		stats[k] = SubscriberStats{Name: s.Name, Delivered: s.Delivered(), Dropped: s.Dropped(), Buffered: len(s.ch)}
+	}
+	return stats
+}
+
+// deliver puts e in the buffer of s, the bus read lock is held so ch is not closed meanwhile
+func (s *Subscriber) deliver(e *Event) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	switch s.policy {
+	case Block:
+		select {
+		case s.ch <- e:
+		case <-s.done:
+			return
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- e:
+				atomic.AddUint64(&s.delivered, 1)
+				return
+			default:
+			}
+			select {
+			case <-s.ch:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+	default:
+		select {
+		case s.ch <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+			return
+		}
+	}
+	atomic.AddUint64(&s.delivered, 1)
+}
+
+// Delivered is the number of events put in the buffer of s
+func (s *Subscriber) Delivered() uint64 {
+	return atomic.LoadUint64(&s.delivered)
+}
+
+// Dropped is the number of events s lost to a full buffer
+func (s *Subscriber) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Unsubscribe stops the deliveries to s and closes C
+func (s *Subscriber) Unsubscribe() {
+	s.once.Do(func() {
+		close(s.done) // releases the publishers blocked on s
+		s.b.mu.Lock()
+		for k, t := range s.b.subs {
+			if t == s {
+				s.b.subs = append(s.b.subs[:k:k], s.b.subs[k+1:]...)
+				break
+			}
+		}
+		close(s.ch)
+		s.b.mu.Unlock()
+	})
+}
@@ -0,0 +1,222 @@
+package bus
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/database"
+	"github.com/exchangedata/mq"
+	"github.com/exchangedata/sink"
+	"github.com/shopspring/decimal"
+)
+
+func tradeEvent(id string) *Event {
+	return &Event{Topic: Trades, Trades: []*common.Trade{{OrderID: id}}}
+}
+
+func TestPolicies(t *testing.T) {
+	b := New()
+	newest := b.Subscribe("newest", 2, DropNewest)
+	oldest := b.Subscribe("oldest", 2, DropOldest, Trades)
+	books := b.Subscribe("books", 2, DropNewest, OrderBooks)
+	for _, id := range []string{"1", "2", "3", "4"} {
+		b.Publish(tradeEvent(id))
+	}
+
+	check := func(s *Subscriber, want ...string) {
+		t.Helper()
+		for _, id := range want {
+			e := <-s.C
+			if e.Trades[0].OrderID != id {
+				t.Errorf("%s got trade %s, want %s", s.Name, e.Trades[0].OrderID, id)
+			}
+		}
+		if len(s.C) != 0 {
+			t.Errorf("%s has %d events left", s.Name, len(s.C))
+		}
+	}
+	check(newest, "1", "2")
+	check(oldest, "3", "4")
+	check(books)
+	if newest.Dropped() != 2 || oldest.Dropped() != 2 || books.Dropped() != 0 {
+		t.Error("wrong drop counts", newest.Dropped(), oldest.Dropped(), books.Dropped())
+	}
+	if newest.Delivered() != 2 || oldest.Delivered() != 4 {
+		t.Error("wrong delivered counts", newest.Delivered(), oldest.Delivered())
+	}
+	if st := b.Stats(); len(st) != 3 || st[0].Name != "newest" || st[0].Dropped != 2 {
+		t.Error("wrong stats", st)
+	}
+
+	b.Close()
+	if _, ok := <-newest.C; ok {
+		t.Error("channel open after close")
+	}
+	b.Publish(tradeEvent("5"))
+	if s := b.Subscribe("late", 1, Block); s.Delivered() != 0 {
+		t.Error("delivery after close")
+	} else if _, ok := <-s.C; ok {
+		t.Error("late subscription open")
+	}
+}
+
+func TestBlock(t *testing.T) {
+	b := New()
+	s := b.Subscribe("block", 1, Block)
+	b.Publish(tradeEvent("1"))
+
+	done := make(chan bool)
+	go func() {
+		b.Publish(tradeEvent("2"))
+		done <- true
+	}()
+	select {
+	case <-done:
+		t.Fatal("publish did not wait for room")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if e := <-s.C; e.Trades[0].OrderID != "1" {
+		t.Error("wrong first event", e.Trades[0].OrderID)
+	}
+	<-done
+	if e := <-s.C; e.Trades[0].OrderID != "2" {
+		t.Error("wrong second event", e.Trades[0].OrderID)
+	}
+
+	// a subscriber leaving releases the blocked publishers
+	b.Publish(tradeEvent("3"))
+	go func() {
+		b.Publish(tradeEvent("4"))
+		done <- true
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.Unsubscribe()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish still blocked after unsubscribe")
+	}
+	if s.Dropped() != 0 || len(b.Stats()) != 0 {
+		t.Error("wrong counters after unsubscribe")
+	}
+}
+
+type countSink struct {
+	tickers, trades, books, deltas, candles int
+	closed                                  bool
+}
+
+func (c *countSink) WriteTickers(l []*common.Ticker) error       { c.tickers += len(l); return nil }
+func (c *countSink) WriteTrades(l []*common.Trade) error         { c.trades += len(l); return nil }
+func (c *countSink) WriteOrderBooks(l []*common.OrderBook) error { c.books += len(l); return nil }
+func (c *countSink) WriteCandles(l []*common.Candle) error       { c.candles += len(l); return nil }
+func (c *countSink) Close() error                                { c.closed = true; return nil }
+func (c *countSink) WriteBookDeltas(m *common.Market, l []*common.BookDelta) error {
+	c.deltas += len(l)
+	return nil
+}
+
+func TestForward(t *testing.T) {
+	b := New()
+	var dst countSink
+	done := make(chan bool)
+	sub := b.Subscribe("count", 16, Block)
+	go func() {
+		Forward(sub, &dst, nil)
+		done <- true
+	}()
+
+	var src sink.Sink = NewSink(b)
+	src.WriteTickers([]*common.Ticker{{}, {}})
+	src.WriteTrades([]*common.Trade{{}})
+	src.WriteTrades(nil)
+	src.WriteOrderBooks([]*common.OrderBook{{}})
+	src.(sink.DeltaWriter).WriteBookDeltas(&common.Market{}, []*common.BookDelta{{}, {}, {}})
+	src.WriteCandles([]*common.Candle{{}})
+	src.Close()
+	b.Close()
+	<-done
+
+	if dst.tickers != 2 || dst.trades != 1 || dst.books != 1 || dst.deltas != 3 || dst.candles != 1 || dst.closed {
+		t.Error("wrong forwarded records", dst)
+	}
+	if sub.Delivered() != 5 {
+		t.Error("empty writes published", sub.Delivered())
+	}
+}
+
+// TestSharedRecords forwards the same records to the database and a broker, the database must not
+// modify them: run with -race
+func TestSharedRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ds := database.NewDataStore("sqlite3")
+	ds.Name = filepath.Join(dir, "test.db")
+	if err = ds.OpenDB(); err != nil {
+		t.Fatal("open db", err)
+	}
+	defer ds.CloseDB()
+	if err = ds.Migrate(); err != nil {
+		t.Fatal("migrate db", err)
+	}
+
+	broker := mq.NewLocal()
+	var mu sync.Mutex
+	var sequences []uint64
+	broker.Subscribe("md.>", func(subject string, data []byte) {
+		var e struct{ Data mq.BookData }
+		json.Unmarshal(data, &e)
+		mu.Lock()
+		sequences = append(sequences, e.Data.Sequence)
+		mu.Unlock()
+	})
+
+	b := New()
+	wg := &sync.WaitGroup{}
+	for _, dst := range []sink.Sink{sink.NewGorm(ds, 0, 0), mq.NewPublisher(broker)} {
+		sub := b.Subscribe("shared", 16, Block)
+		wg.Add(1)
+		go func(dst sink.Sink) {
+			defer wg.Done()
+			Forward(sub, dst, func(err error) { t.Error(err) })
+		}(dst)
+	}
+
+	m := &common.Market{Name: "BTC-LTC", Exchanger: &common.Exchanger{Name: "bittrex"},
+		Symbol: &common.Symbol{Base: &common.Currency{Name: "Bitcoin", Abbr: "BTC"}, Quote: &common.Currency{Name: "Litecoin", Abbr: "LTC"}}}
+	src := NewSink(b)
+	start := time.Date(2018, 11, 26, 0, 0, 0, 0, time.UTC)
+	var books []*common.OrderBook
+	for k := 0; k < 20; k++ {
+		ob := &common.OrderBook{Time: start.Add(time.Duration(k) * time.Second), Market: m,
+			Bids: []*common.PriceVol{{Price: decimal.New(int64(100+k), -2), Volume: decimal.New(1, 0)}}}
+		books = append(books, ob)
+		src.WriteOrderBooks([]*common.OrderBook{ob})
+		src.WriteTickers([]*common.Ticker{{Time: ob.Time, Market: m}})
+	}
+	b.Close()
+	wg.Wait()
+
+	for _, ob := range books {
+		if ob.Sequence != 0 || ob.MarketRef != 0 || ob.ID != 0 {
+			t.Fatal("shared order book modified", ob.Sequence, ob.MarketRef, ob.ID)
+		}
+	}
+	if m.ID != 0 || m.Exchanger.ID != 0 {
+		t.Fatal("shared market modified", m.ID)
+	}
+	for _, seq := range sequences {
+		if seq != 0 {
+			t.Fatal("published the sequence of the database", sequences)
+		}
+	}
+}
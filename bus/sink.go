@@ -0,0 +1,134 @@
+package bus
+
+import (
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/sink"
+)
+
+// Sink is a sink.Sink publishing the records to a bus, the producers write to it like to any other sink.
+// It does not own the bus, Close does nothing.
+type Sink struct {
+	b *Bus
+}
+
+func NewSink(b *Bus) *Sink {
+	return &Sink{b: b}
+}
+
+func (s *Sink) WriteTickers(c []*common.Ticker) error {
+	if len(c) > 0 {
+		s.b.Publish(&Event{Topic: Tickers, Tickers: c})
+	}
+	return nil
+}
+
+func (s *Sink) WriteTrades(c []*common.Trade) error {
+	if len(c) > 0 {
+		s.b.Publish(&Event{Topic: Trades, Trades: c})
+	}
+	return nil
+}
+
+func (s *Sink) WriteOrderBooks(c []*common.OrderBook) error {
+	if len(c) > 0 {
+		s.b.Publish(&Event{Topic: OrderBooks, OrderBooks: c})
+	}
+	return nil
+}
+
+// WriteBookDeltas publishes the changes c of the order book of m, see sink.DeltaWriter
+func (s *Sink) WriteBookDeltas(m *common.Market, c []*common.BookDelta) error {
+	if len(c) > 0 {
+		s.b.Publish(&Event{Topic: BookDeltas, Market: m, Deltas: c})
+	}
+	return nil
+}
+
+func (s *Sink) WriteCandles(c []*common.Candle) error {
+	if len(c) > 0 {
+		s.b.Publish(&Event{Topic: Candles, Candles: c})
+	}
+	return nil
+}
+
+// WriteConsolidated publishes the consolidated tickers c, see sink.ConsolidatedWriter
+func (s *Sink) WriteConsolidated(c []*common.ConsolidatedTicker) error {
+	if len(c) > 0 {
+		s.b.Publish(&Event{Topic: Consolidated, Consolidated: c})
+	}
+	return nil
+}
+
+// WriteOpportunities publishes the arbitrage opportunities c, see sink.OpportunityWriter
+func (s *Sink) WriteOpportunities(c []*common.Opportunity) error {
+	if len(c) > 0 {
+		s.b.Publish(&Event{Topic: Opportunities, Opportunities: c})
+	}
+	return nil
+}
+
+// WriteIndicators publishes the indicator values c, see sink.IndicatorWriter
+func (s *Sink) WriteIndicators(c []*common.IndicatorValue) error {
+	if len(c) > 0 {
+		s.b.Publish(&Event{Topic: Indicators, Indicators: c})
+	}
+	return nil
+}
+
+// WriteBookMetrics publishes the order book metrics c, see sink.BookMetricsWriter
+func (s *Sink) WriteBookMetrics(c []*common.BookMetrics) error {
+	if len(c) > 0 {
+		s.b.Publish(&Event{Topic: BookMetrics, BookMetrics: c})
+	}
+	return nil
+}
+
+func (s *Sink) Close() error {
+	return nil
+}
+
+// Forward writes the events received by sub to dst until its channel is closed.
+// The write errors are passed to onErr when not nil. dst is not closed.
+func Forward(sub *Subscriber, dst sink.Sink, onErr func(error)) {
+	deltaW, _ := dst.(sink.DeltaWriter)
+	consW, _ := dst.(sink.ConsolidatedWriter)
+	oppW, _ := dst.(sink.OpportunityWriter)
+	indW, _ := dst.(sink.IndicatorWriter)
+	metW, _ := dst.(sink.BookMetricsWriter)
+	for e := range sub.C {
+		var err error
+		switch e.Topic {
+		case Tickers:
+			err = dst.WriteTickers(e.Tickers)
+		case Trades:
+			err = dst.WriteTrades(e.Trades)
+		case OrderBooks:
+			err = dst.WriteOrderBooks(e.OrderBooks)
+		case BookDeltas:
+			if deltaW != nil {
+				err = deltaW.WriteBookDeltas(e.Market, e.Deltas)
+			}
+		case Candles:
+			err = dst.WriteCandles(e.Candles)
+		case Consolidated:
+			if consW != nil {
+				err = consW.WriteConsolidated(e.Consolidated)
+			}
+		case Opportunities:
+			if oppW != nil {
+				err = oppW.WriteOpportunities(e.Opportunities)
+			}
+		case Indicators:
+			if indW != nil {
+				err = indW.WriteIndicators(e.Indicators)
+			}
+		case BookMetrics:
+			if metW != nil {
+				err = metW.WriteBookMetrics(e.BookMetrics)
+			}
+		}
+		if err != nil && onErr != nil {
+			onErr(err)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+// Package candle builds OHLCV candles from trades, for the exchangers without a candle API
+// and to get the same candles from all of them.
+//
+// An Aggregator takes the live trades as a sink.Sink and writes the candles of its intervals to another sink,
+// the open candles as they change and the closed ones corrected by the trades arriving late.
+// Rebuild computes the candles of a time range from the stored trades.
+package candle
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/sink"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// DefaultLateness is how long a closed candle is kept to add the trades arriving late
+	DefaultLateness = 5 * time.Minute
+	// DefaultFlushInterval is the period of the writes of the changed candles
+	DefaultFlushInterval = time.Second
+)
+
+// Aggregator builds the candles of intervals from the trades written to it and writes them to out.
+// The trades are identified by their market and OrderID, a trade written again is counted once.
+// A trade older than the candles kept is dropped, Rebuild corrects its candles from the stored trades.
+// So are the trades before From: the candles open at the start only count the trades from then, unless Seed
+// added the stored ones.
+type Aggregator struct {
+	Lateness time.Duration // set before the first trade
+	From     time.Time     // the creation time by default, set before the first trade
+
+	out       sink.Sink
+	loc       *time.Location
+	intervals []Interval
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*bucket
+	horizon map[string]time.Time // by market, the trades before are dropped
+	dropped uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type bucketKey struct {
+	market   string
+	interval Interval
+	start    int64
+}
+
+// bucket is a candle and the trades counted in it
+type bucket struct {
+	c           *common.Candle
+	end         time.Time
+	first, last time.Time // of the trades of the open and close prices
+	orders      map[string]bool
+	dirty       bool // changed since the last write
+}
+
+// NewAggregator builds the candles of intervals in the time zone loc, UTC if nil, and writes the changed ones
+// to out every flushInterval, DefaultFlushInterval if 0. A negative flushInterval leaves the writes to Flush.
+// out belongs to the caller, it is not closed by Close.
+func NewAggregator(out sink.Sink, loc *time.Location, flushInterval time.Duration, intervals ...Interval) *Aggregator {
+	if loc == nil {
+		loc = time.UTC
+	}
+	if flushInterval == 0 {
+		flushInterval = DefaultFlushInterval
+	}
+	a := &Aggregator{
+		Lateness:  DefaultLateness,
+		From:      time.Now(),
+		out:       out,
+		loc:       loc,
+		intervals: intervals,
+		buckets:   make(map[bucketKey]*bucket),
+		horizon:   make(map[string]time.Time),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if flushInterval < 0 {
+		close(a.done)
+		return a
+	}
+	go a.run(flushInterval)
+	return a
+}
+
+func (a *Aggregator) run(interval time.Duration) {
+	defer close(a.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.Flush(time.Now()); err != nil {
+				log.Println("candle write error:", err)
+			}
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// WriteTrades adds the trades to their candles
+func (a *Aggregator) WriteTrades(c []*common.Trade) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, t := range c {
+		key := marketKey(t.MarketRef, t.Market)
+		if key == "" {
+			continue
+		}
+		if t.Time.Before(a.From) || t.Time.Before(a.horizon[key]) {
+			a.dropped++
+			continue
+		}
+		for _, i := range a.intervals {
+			a.add(key, i, t)
+		}
+	}
+	return nil
+}
+
+func (a *Aggregator) add(key string, i Interval, t *common.Trade) {
+	start := i.Start(t.Time, a.loc)
+	k := bucketKey{key, i, start.UnixNano()}
+	b := a.buckets[k]
+	if b == nil {
+		b = &bucket{
+			c: &common.Candle{Time: start, MarketRef: t.MarketRef, Market: t.Market, Interval: i.String(),
+				Open: t.Price, High: t.Price, Low: t.Price, Close: t.Price},
+			end:    i.End(start, a.loc),
+			first:  t.Time,
+			last:   t.Time,
+			orders: make(map[string]bool),
+		}
+		a.buckets[k] = b
+	}
+	if t.OrderID != "" {
+		if b.orders[t.OrderID] {
+			return
+		}
+		b.orders[t.OrderID] = true
+	}
+	c := b.c
+	if c.Trades > 0 {
+		if t.Time.Before(b.first) {
+			c.Open, b.first = t.Price, t.Time
+		}
+		if !t.Time.Before(b.last) {
+			c.Close, b.last = t.Price, t.Time
+		}
+		if t.Price.GreaterThan(c.High) {
+			c.High = t.Price
+		}
+		if t.Price.LessThan(c.Low) {
+			c.Low = t.Price
+		}
+	}
+	c.Trades++
+	c.Volume = c.Volume.Add(t.Amount)
+	c.QuoteVolume = c.QuoteVolume.Add(quoteAmount(t))
+	b.dirty = true
+}
+
+// quoteAmount is the total of t, its price times its amount if the exchanger has not set it
+func quoteAmount(t *common.Trade) decimal.Decimal {
+	if !t.Total.IsZero() {
+		return t.Total
+	}
+	return t.Price.Mul(t.Amount)
+}
+
+// Flush writes the candles changed since the last write, then forgets the candles closed for longer than Lateness
+// at now. A zero now keeps all the candles.
+func (a *Aggregator) Flush(now time.Time) error {
+	a.mu.Lock()
+	var changed []*common.Candle
+	for k, b := range a.buckets {
+		if b.dirty {
+			b.dirty = false
+			c := *b.c
+			changed = append(changed, &c)
+		}
+		if !now.IsZero() && now.Sub(b.end) > a.Lateness {
+			delete(a.buckets, k)
+			if b.end.After(a.horizon[k.market]) {
+				a.horizon[k.market] = b.end
+			}
+		}
+	}
+	a.mu.Unlock()
+	if len(changed) == 0 {
+		return nil
+	}
+	return a.out.WriteCandles(changed)
+}
+
+// Dropped returns the number of trades dropped for arriving after their candles were forgotten
+func (a *Aggregator) Dropped() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.dropped
+}
+
+// Close stops the periodic writes and writes the changed candles
+func (a *Aggregator) Close() error {
+	select {
+	case <-a.done:
+	default:
+		close(a.stop)
+		<-a.done
+	}
+	return a.Flush(time.Time{})
+}
+
+func (a *Aggregator) WriteTickers(c []*common.Ticker) error       { return nil }
+func (a *Aggregator) WriteOrderBooks(c []*common.OrderBook) error { return nil }
+
+// WriteCandles ignores the candles of the exchangers, the aggregator builds its own
+func (a *Aggregator) WriteCandles(c []*common.Candle) error { return nil }
+
+// marketKey identifies the market of a record by its exchanger and name, which stay the same once the market
+// is stored, or by its id without Market
+func marketKey(ref uint, m *common.Market) string {
+	switch {
+	case m != nil && m.Exchanger != nil:
+		return m.Exchanger.Name + ":" + m.Name
+	case m != nil && m.ID != 0:
+		ref = m.ID
+	}
+	if ref == 0 {
+		return ""
+	}
+	return "#" + strconv.FormatUint(uint64(ref), 10)
+}
@@ -0,0 +1,197 @@
+package candle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/database"
+	"github.com/shopspring/decimal"
+)
+
+var dec = decimal.RequireFromString
+
+func TestInterval(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no tz database", err)
+	}
+	kolkata := time.FixedZone("IST", 5*3600+1800)
+	at := time.Date(2018, 11, 26, 3, 47, 12, 0, time.UTC) // a monday
+	for _, c := range []struct {
+		interval string
+		loc      *time.Location
+		start    time.Time
+		end      time.Time
+	}{
+		{"1s", time.UTC, at, at.Add(time.Second)},
+		{"15m", time.UTC, time.Date(2018, 11, 26, 3, 45, 0, 0, time.UTC), time.Date(2018, 11, 26, 4, 0, 0, 0, time.UTC)},
+		{"4h", time.UTC, time.Date(2018, 11, 26, 0, 0, 0, 0, time.UTC), time.Date(2018, 11, 26, 4, 0, 0, 0, time.UTC)},
+		{"1h", kolkata, time.Date(2018, 11, 26, 9, 0, 0, 0, kolkata), time.Date(2018, 11, 26, 10, 0, 0, 0, kolkata)},
+		{"1d", ny, time.Date(2018, 11, 25, 0, 0, 0, 0, ny), time.Date(2018, 11, 26, 0, 0, 0, 0, ny)},
+		{"1w", time.UTC, at.Truncate(24 * time.Hour), time.Date(2018, 12, 3, 0, 0, 0, 0, time.UTC)},
+		{"1w", ny, time.Date(2018, 11, 19, 0, 0, 0, 0, ny), time.Date(2018, 11, 26, 0, 0, 0, 0, ny)},
+		{"7m", time.UTC, time.Date(2018, 11, 26, 3, 44, 0, 0, time.UTC), time.Date(2018, 11, 26, 3, 51, 0, 0, time.UTC)},
+	} {
+		i, err := ParseInterval(c.interval)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := i.Start(at, c.loc)
+		if !start.Equal(c.start) || !i.End(start, c.loc).Equal(c.end) {
+			t.Error(c.interval, c.loc, "start", start, "end", i.End(start, c.loc), "expected", c.start, c.end)
+		}
+	}
+
+	// the day of the daylight saving change lasts 25 hours
+	d, _ := ParseInterval("1d")
+	start := d.Start(time.Date(2018, 11, 4, 12, 0, 0, 0, ny), ny)
+	if l := d.End(start, ny).Sub(start); l != 25*time.Hour {
+		t.Error("dst day of", l)
+	}
+	// an intraday interval not dividing the day restarts at midnight
+	m, _ := ParseInterval("7m")
+	if end := m.End(time.Date(2018, 11, 26, 23, 55, 0, 0, time.UTC), time.UTC); !end.Equal(time.Date(2018, 11, 27, 0, 0, 0, 0, time.UTC)) {
+		t.Error("last bucket of the day ends", end)
+	}
+
+	for _, bad := range []string{"", "m", "0m", "-1h", "1y", "1.5h"} {
+		if _, err := ParseInterval(bad); err == nil {
+			t.Error("bad interval accepted", bad)
+		}
+	}
+}
+
+type candleSink struct {
+	candles []*common.Candle
+}
+
+func (s *candleSink) WriteTickers(c []*common.Ticker) error       { return nil }
+func (s *candleSink) WriteTrades(c []*common.Trade) error         { return nil }
+func (s *candleSink) WriteOrderBooks(c []*common.OrderBook) error { return nil }
+func (s *candleSink) Close() error                                { return nil }
+func (s *candleSink) WriteCandles(c []*common.Candle) error {
+	s.candles = append(s.candles, c...)
+	return nil
+}
+
+var testMarket = &common.Market{Name: "USDT-BTC", Exchanger: &common.Exchanger{Name: "bittrex"}}
+
+var start = time.Date(2018, 11, 26, 0, 0, 0, 0, time.UTC)
+
+func trade(id string, sec int, price, amount string) *common.Trade {
+	return &common.Trade{Time: start.Add(time.Duration(sec) * time.Second), Market: testMarket, OrderID: id,
+		Price: dec(price), Amount: dec(amount)}
+}
+
+func TestAggregator(t *testing.T) {
+	out := &candleSink{}
+	m, _ := ParseInterval("1m")
+	a := NewAggregator(out, nil, -1, m)
+	a.From = start
+	a.Lateness = time.Minute
+
+	a.WriteTrades([]*common.Trade{trade("1", 10, "100", "1"), trade("2", 30, "102", "2"), trade("3", 20, "99", "1")})
+	a.WriteTrades([]*common.Trade{trade("2", 30, "102", "2")}) // polled again
+	a.WriteTrades([]*common.Trade{trade("4", 65, "103", "1")})
+	a.Flush(start.Add(90 * time.Second))
+	if len(out.candles) != 2 {
+		t.Fatal("wrong candles", len(out.candles))
+	}
+	c := out.candles[0]
+	if c.Interval == "1m" && c.Time.Equal(start.Add(time.Minute)) {
+		c = out.candles[1]
+	}
+	if !c.Open.Equal(dec("100")) || !c.High.Equal(dec("102")) || !c.Low.Equal(dec("99")) || !c.Close.Equal(dec("102")) ||
+		!c.Volume.Equal(dec("4")) || !c.QuoteVolume.Equal(dec("403")) || c.Trades != 3 {
+		t.Fatal("wrong candle", c)
+	}
+
+	// a late trade corrects the closed candle, only the changed candles are written
+	out.candles = nil
+	a.WriteTrades([]*common.Trade{trade("5", 5, "98", "1")})
+	a.Flush(start.Add(100 * time.Second))
+	if len(out.candles) != 1 || !out.candles[0].Open.Equal(dec("98")) || !out.candles[0].Low.Equal(dec("98")) || out.candles[0].Trades != 4 {
+		t.Fatal("not corrected", out.candles)
+	}
+
+	// after the lateness the candle is forgotten, its late trades are dropped
+	a.Flush(start.Add(3 * time.Minute))
+	out.candles = nil
+	a.WriteTrades([]*common.Trade{trade("6", 15, "1", "1"), trade("7", -5, "1", "1")})
+	a.Flush(start.Add(3 * time.Minute))
+	if len(out.candles) != 0 || a.Dropped() != 2 {
+		t.Fatal("late trades not dropped", out.candles, a.Dropped())
+	}
+	a.Close()
+}
+
+func TestRebuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edcandle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ds := database.NewDataStore("sqlite3")
+	ds.Name = filepath.Join(dir, "test.db")
+	if err = ds.OpenDB(); err != nil {
+		t.Fatal("open db", err)
+	}
+	defer ds.CloseDB()
+	if err = ds.Migrate(); err != nil {
+		t.Fatal("migrate db", err)
+	}
+
+	m := &common.Market{Name: "USDT-BTC", Exchanger: &common.Exchanger{Name: "bittrex"}}
+	if err = ds.UpdateMarket(m).Error; err != nil {
+		t.Fatal("save market", err)
+	}
+	w := ds.NewBatchWriter(0, 0)
+	// a partial candle stored by the live aggregator, replaced by the rebuild
+	w.AddCandle(&common.Candle{Time: start, Market: m, Interval: "1m", Open: dec("1"), High: dec("1"), Low: dec("1"), Close: dec("1"), Trades: 1})
+	for k, p := range []string{"100", "101", "99", "102"} {
+		w.AddTrade(&common.Trade{Time: start.Add(time.Duration(k*20) * time.Second), Market: m, OrderID: p, Price: dec(p), Amount: dec("1")})
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal("flush", err)
+	}
+
+	intervals, _ := ParseIntervals("1m,1h")
+	n, err := Rebuild(ds, nil, start.Add(30*time.Second), start.Add(40*time.Second), intervals...)
+	if err != nil || n != 3 {
+		t.Fatal("rebuild", n, err)
+	}
+	c, _, err := ds.Candles(m.ID, "1m", start, start.Add(time.Hour), nil, 10)
+	if err != nil || len(c) != 2 {
+		t.Fatal("candles", c, err)
+	}
+	if !c[0].Open.Equal(dec("100")) || !c[0].Close.Equal(dec("99")) || c[0].Trades != 3 || !c[1].Close.Equal(dec("102")) {
+		t.Fatal("wrong rebuilt candles", c[0], c[1])
+	}
+	h, _, err := ds.Candles(m.ID, "1h", start, start.Add(time.Hour), nil, 10)
+	if err != nil || len(h) != 1 || h[0].Trades != 4 || !h[0].High.Equal(dec("102")) {
+		t.Fatal("wrong hour candle", h, err)
+	}
+	// an aggregator started within the candles counts their stored trades
+	out := &candleSink{}
+	a := NewAggregator(out, nil, -1, intervals...)
+	a.From = start.Add(65 * time.Second)
+	if err = a.Seed(ds); err != nil {
+		t.Fatal("seed", err)
+	}
+	a.WriteTrades([]*common.Trade{{Time: start.Add(70 * time.Second), Market: m, OrderID: "5", Price: dec("103"), Amount: dec("1")},
+		{Time: start.Add(10 * time.Second), Market: m, OrderID: "6", Price: dec("90"), Amount: dec("1")}})
+	a.Close()
+	if len(out.candles) != 2 {
+		t.Fatal("seeded candles", out.candles)
+	}
+	for _, c := range out.candles {
+		if (c.Interval == "1m" && (!c.Time.Equal(start.Add(time.Minute)) || c.Trades != 2 || !c.Open.Equal(dec("102")))) ||
+			(c.Interval == "1h" && (c.Trades != 5 || !c.Open.Equal(dec("100")) || !c.Close.Equal(dec("103")))) {
+			t.Fatal("wrong seeded candle", c)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package candle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Interval is the length of a candle: N seconds, minutes, hours, days or weeks
+type Interval struct {
+	N    int
+	Unit byte // s, m, h, d or w
+}
+
+// ParseInterval parses an interval like 1s, 15m, 4h, 1d or 1w
+func ParseInterval(s string) (Interval, error) {
+	if len(s) < 2 || len(s) > 8 || !strings.ContainsRune("smhdw", rune(s[len(s)-1])) {
+		return Interval{}, fmt.Errorf("bad interval %s, like 1s, 15m, 1h, 1d or 1w expected", s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return Interval{}, fmt.Errorf("bad interval %s, like 1s, 15m, 1h, 1d or 1w expected", s)
+	}
+	return Interval{N: n, Unit: s[len(s)-1]}, nil
+}
+
+// ParseIntervals parses a comma separated list of intervals
+func ParseIntervals(s string) ([]Interval, error) {
+	var c []Interval
+	for _, p := range strings.Split(s, ",") {
+		i, err := ParseInterval(strings.TrimSpace(p))
+		if err != nil {
+			return nil, err
+		}
+		c = append(c, i)
+	}
+	return c, nil
+}
+
+// String is the name of the interval, the Interval field of its candles
+func (i Interval) String() string {
+	return strconv.Itoa(i.N) + string(i.Unit)
+}
+
+// Duration is the nominal length of the interval, a day is 24h
+func (i Interval) Duration() time.Duration {
+	switch i.Unit {
+	case 's':
+		return time.Duration(i.N) * time.Second
+	case 'm':
+		return time.Duration(i.N) * time.Minute
+	case 'h':
+		return time.Duration(i.N) * time.Hour
+	case 'd':
+		return time.Duration(i.N) * 24 * time.Hour
+	}
+	return time.Duration(i.N) * 7 * 24 * time.Hour
+}
+
+// Start returns the start of the candle of t, the buckets follow the wall clock of loc:
+// the intraday ones restart at each midnight, the days start at midnight and the weeks on monday.
+// Multiples of days and weeks are counted from 1970-01-01, 1970-01-05 for the weeks.
+func (i Interval) Start(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	y, m, d := t.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	switch i.Unit {
+	case 'd':
+		return dayTime(floorMul(dayNumber(y, m, d), i.N), loc)
+	case 'w':
+		const monday = 4 // 1970-01-05
+		return dayTime(monday+floorMul(dayNumber(y, m, d)-monday, 7*i.N), loc)
+	}
+	since := t.Sub(midnight)
+	return midnight.Add(since - since%i.Duration())
+}
+
+// End returns the end of the candle starting at start, excluded
+func (i Interval) End(start time.Time, loc *time.Location) time.Time {
+	start = start.In(loc)
+	y, m, d := start.Date()
+	switch i.Unit {
+	case 'd':
+		return time.Date(y, m, d+i.N, 0, 0, 0, 0, loc)
+	case 'w':
+		return time.Date(y, m, d+7*i.N, 0, 0, 0, 0, loc)
+	}
+	end := start.Add(i.Duration())
+	if next := time.Date(y, m, d+1, 0, 0, 0, 0, loc); end.After(next) {
+		return next
+	}
+	return end
+}
+
+// dayNumber is the number of days from 1970-01-01 to the date
+func dayNumber(y int, m time.Month, d int) int {
+	return int(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+func dayTime(n int, loc *time.Location) time.Time {
+	return time.Date(1970, 1, 1+n, 0, 0, 0, 0, loc)
+}
+
+// floorMul rounds n down to a multiple of k
+func floorMul(n, k int) int {
+	r := n % k
+	if r < 0 {
+		r += k
+	}
+	return n - r
+}
@@ -0,0 +1,74 @@
+package candle
+
+import (
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/database"
+	"github.com/exchangedata/sink"
+)
+
+// Rebuild computes the candles of intervals in the time zone loc, UTC if nil, from the trades stored in [from, to)
+// widened to whole candles, and stores them in place of the stored ones. It returns the number of candles stored.
+func Rebuild(ds *database.DataStore, loc *time.Location, from, to time.Time, intervals ...Interval) (int, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	for _, i := range intervals {
+		if s := i.Start(from, loc); s.Before(from) {
+			from = s
+		}
+		if e := i.End(i.Start(to.Add(-time.Nanosecond), loc), loc); e.After(to) {
+			to = e
+		}
+	}
+	out := &counter{Sink: sink.NewGorm(ds, 0, 0)}
+	a := NewAggregator(out, loc, -1, intervals...)
+	a.From = time.Time{}
+	err := ds.ScanTrades(from, to, 0, a.WriteTrades)
+	if err == nil {
+		err = a.Close()
+	}
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	return out.n, err
+}
+
+// counter counts the candles written to its sink
+type counter struct {
+	sink.Sink
+	n int
+}
+
+func (c *counter) WriteCandles(r []*common.Candle) error {
+	c.n += len(r)
+	return c.Sink.WriteCandles(r)
+}
+
+// Seed adds the trades stored before From to the candles open at From, so that their first writes do not replace
+// the stored candles with those of the trades since the start. Call it before the first trade.
+func (a *Aggregator) Seed(ds *database.DataStore) error {
+	from := a.From
+	for _, i := range a.intervals {
+		if s := i.Start(a.From, a.loc); s.Before(from) {
+			from = s
+		}
+	}
+	return ds.ScanTrades(from, a.From, 0, func(c []*common.Trade) error {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		for _, t := range c {
+			key := marketKey(t.MarketRef, t.Market)
+			if key == "" {
+				continue
+			}
+			for _, i := range a.intervals {
+				if !t.Time.Before(i.Start(a.From, a.loc)) { // of the open candle
+					a.add(key, i, t)
+				}
+			}
+		}
+		return nil
+	})
+}
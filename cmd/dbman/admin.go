@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/exchangedata/database"
+	"github.com/urfave/cli"
+)
+
+// migrateUp applies the pending migrations up to --to
+func migrateUp(c *cli.Context) error {
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	applied, err := ds.MigrateUp(c.Int64("to"))
+	log.Printf("%d migrations applied", len(applied))
+	return err
+}
+
+// migrateDown reverts the last --steps migrations
+func migrateDown(c *cli.Context) error {
+	if c.Int("steps") < 1 {
+		return fmt.Errorf("bad --steps: %d", c.Int("steps"))
+	}
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	reverted, err := ds.MigrateDown(c.Int("steps"))
+	log.Printf("%d migrations reverted", len(reverted))
+	return err
+}
+
+// migrationStatus prints the migrations in version order
+func migrationStatus(c *cli.Context) error {
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	states, err := ds.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	w := newTable()
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+	for _, s := range states {
+		status := "pending"
+		switch {
+		case s.Unknown:
+			status = "applied " + s.AppliedAt.Format(time.RFC3339) + ", unknown to this build"
+		case s.Applied():
+			status = "applied " + s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, status)
+	}
+	return w.Flush()
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+func listExchangers(c *cli.Context) error {
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	exchangers, err := ds.Exchangers()
+	if err != nil {
+		return err
+	}
+	w := newTable()
+	fmt.Fprintln(w, "ID\tNAME\tINFO")
+	for _, e := range exchangers {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", e.ID, e.Name, e.Info)
+	}
+	return w.Flush()
+}
+
+func listMarkets(c *cli.Context) error {
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	markets, err := ds.Markets(database.MarketFilter{Exchanger: c.String("exchanger"), Currency: c.String("currency"),
+		Active: c.Bool("active")})
+	if err != nil {
+		return err
+	}
+	w := newTable()
+	fmt.Fprintln(w, "ID\tEXCHANGER\tNAME\tBASE\tQUOTE\tACTIVE\tPRECISION\tMIN\tMAX\tSTEP")
+	for _, m := range markets {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%t\t%d\t%s\t%s\t%s\n", m.ID, m.Exchanger.Name, m.Name, m.Symbol.Base.Abbr,
+			m.Symbol.Quote.Abbr, m.Active, m.Precision, m.Limitation.Min, m.Limitation.Max, m.MinStep)
+	}
+	return w.Flush()
+}
+
+func listCurrencies(c *cli.Context) error {
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	currencies, err := ds.Currencies()
+	if err != nil {
+		return err
+	}
+	w := newTable()
+	fmt.Fprintln(w, "ID\tABBR\tNAME\tINFO")
+	for _, cu := range currencies {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", cu.ID, cu.Abbr, cu.Name, cu.Info)
+	}
+	return w.Flush()
+}
+
+// showTicker prints the last ticker of the market of the argument
+func showTicker(c *cli.Context) error {
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	m, err := findMarket(ds, c.Args().First(), c.String("exchanger"))
+	if err != nil {
+		return err
+	}
+	t, err := ds.LatestTicker(m.ID)
+	if err != nil {
+		return fmt.Errorf("no ticker of %s:%s: %v", m.Exchanger.Name, m.Name, err)
+	}
+	w := newTable()
+	fmt.Fprintf(w, "market\t%s:%s\n", m.Exchanger.Name, m.Name)
+	fmt.Fprintf(w, "time\t%s\n", t.Time.Format(time.RFC3339))
+	for _, f := range []struct {
+		name  string
+		value fmt.Stringer
+	}{
+		{"last", t.Last}, {"bid", t.Bid}, {"ask", t.Ask}, {"high", t.High}, {"low", t.Low},
+		{"base volume", t.BaseVolume}, {"quote volume", t.QuoteVolume}, {"previous close", t.PreviousClose},
+	} {
+		fmt.Fprintf(w, "%s\t%s\n", f.name, f.value)
+	}
+	return w.Flush()
+}
+
+// purge deletes the rows of the tables before --before, or counts them
+func purge(c *cli.Context) error {
+	if c.String("before") == "" {
+		return errors.New("--before missing")
+	}
+	before, err := parseTime(c.String("before"))
+	if err != nil {
+		return fmt.Errorf("bad --before: %v", err)
+	}
+	var tables []string
+	if c.String("tables") != "" {
+		for _, t := range strings.Split(c.String("tables"), ",") {
+			tables = append(tables, strings.TrimSpace(t))
+		}
+	}
+
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	purged, err := ds.Purge(before, c.Bool("dry-run"), tables...)
+	verb := "deleted"
+	if c.Bool("dry-run") {
+		verb = "to delete"
+	}
+	for _, p := range purged {
+		fmt.Printf("%s %d rows %s\n", p.Table, p.Rows, verb)
+	}
+	return err
+}
+
+// stats prints the rows and sizes of the tables
+func stats(c *cli.Context) error {
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	tables, err := ds.Stats()
+	if err != nil {
+		return err
+	}
+	w := newTable()
+	fmt.Fprintln(w, "TABLE\tROWS\tSIZE")
+	var rows, size int64
+	for _, t := range tables {
+		rows += t.Rows
+		fmt.Fprintf(w, "%s\t%d\t%s\n", t.Table, t.Rows, byteSize(t.Size))
+		if t.Size > 0 {
+			size += t.Size
+		}
+	}
+	if ds.Dialect == "sqlite3" {
+		if fi, err := os.Stat(ds.Name); err == nil {
+			size = fi.Size()
+		}
+	}
+	fmt.Fprintf(w, "total\t%d\t%s\n", rows, byteSize(size))
+	return w.Flush()
+}
+
+// byteSize formats n bytes in binary units, ? if n is unknown
+func byteSize(n int64) string {
+	if n < 0 {
+		return "?"
+	}
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// check prints the integrity problems, an error makes dbman exit with a failure if there are any
+func check(c *cli.Context) error {
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	problems, err := ds.Check()
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		if p.Table == "" {
+			fmt.Println(p.Check)
+			continue
+		}
+		fmt.Printf("%s: %d rows of %s\n", p.Check, p.Rows, p.Table)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d integrity problems", len(problems))
+	}
+	log.Println("no integrity problem")
+	return nil
+}
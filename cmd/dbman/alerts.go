@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/exchangedata/alert"
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/database"
+	"github.com/shopspring/decimal"
+	"github.com/urfave/cli"
+)
+
+// ruleID returns the rule id of the first argument
+func ruleID(c *cli.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Args().First(), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad rule id %q", c.Args().First())
+	}
+	return uint(id), nil
+}
+
+// listAlertRules prints the rules in id order
+func listAlertRules(c *cli.Context) error {
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	rules, err := ds.AlertRules(false)
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		state := "enabled"
+		if !r.Enabled {
+			state = "disabled"
+		}
+		market := ""
+		if r.Market != nil && r.Market.Exchanger != nil {
+			market = r.Market.Exchanger.Name + ":" + r.Market.Name
+		}
+		fmt.Printf("%d %s %s %s %s %s %s %s cooldown %s %s\n", r.ID, r.Name, market, r.Metric, r.Op, r.Threshold,
+			r.Channel, r.Target, time.Duration(r.Cooldown)*time.Second, state)
+	}
+	return nil
+}
+
+// addAlertRule stores the rule of the flags
+func addAlertRule(c *cli.Context) error {
+	threshold, err := decimal.NewFromString(c.String("threshold"))
+	if err != nil {
+		return fmt.Errorf("bad --threshold: %s", c.String("threshold"))
+	}
+	r := &common.AlertRule{Name: c.String("name"), Metric: c.String("metric"), Op: c.String("op"), Threshold: threshold,
+		Channel: c.String("channel"), Target: c.String("target"), Cooldown: int64(c.Duration("cooldown") / time.Second),
+		Enabled: !c.Bool("disabled")}
+	if err = alert.CheckRule(r); err != nil {
+		return err
+	}
+
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	if r.Market, err = findMarket(ds, c.String("market"), c.String("exchanger")); err != nil {
+		return err
+	}
+	if err = ds.SaveAlertRule(r); err != nil {
+		return err
+	}
+	log.Printf("alert rule %d stored", r.ID)
+	return nil
+}
+
+// enableAlertRule enables or disables the rule of the argument
+func enableAlertRule(c *cli.Context, enabled bool) error {
+	id, err := ruleID(c)
+	if err != nil {
+		return err
+	}
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	r, err := ds.AlertRule(id)
+	if err != nil {
+		return err
+	}
+	r.Enabled = enabled
+	return ds.SaveAlertRule(r)
+}
+
+// deleteAlertRule deletes the rule of the argument
+func deleteAlertRule(c *cli.Context) error {
+	id, err := ruleID(c)
+	if err != nil {
+		return err
+	}
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	return ds.DeleteAlertRule(id)
+}
+
+// alertHistory prints the alerts of the rule of the argument in the range
+func alertHistory(c *cli.Context) error {
+	id, err := ruleID(c)
+	if err != nil {
+		return err
+	}
+	to := time.Now()
+	if c.String("to") != "" {
+		if to, err = parseTime(c.String("to")); err != nil {
+			return fmt.Errorf("bad --to: %v", err)
+		}
+	}
+	from := to.Add(-7 * 24 * time.Hour)
+	if c.String("from") != "" {
+		if from, err = parseTime(c.String("from")); err != nil {
+			return fmt.Errorf("bad --from: %v", err)
+		}
+	}
+
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	var after *database.Cursor
+	for {
+		page, next, err := ds.Alerts(id, from, to, after, 0)
+		if err != nil {
+			return err
+		}
+		for _, a := range page {
+			state := "delivered"
+			if !a.Delivered {
+				state = "failed: " + a.Error
+			}
+			fmt.Printf("%s %s %s\n", a.Time.Format(time.RFC3339), a.Message, state)
+		}
+		if next == nil {
+			return nil
+		}
+		after = next
+	}
+}
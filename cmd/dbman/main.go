@@ -1,178 +1,467 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 
+	"github.com/exchangedata/alert"
+	"github.com/exchangedata/bookmetrics"
+	"github.com/exchangedata/candle"
 	"github.com/exchangedata/common"
+	"github.com/exchangedata/completeness"
 	"github.com/exchangedata/database"
+	"github.com/exchangedata/sink"
+	"github.com/shopspring/decimal"
+	"github.com/urfave/cli"
 )
 
-var testCurrencies = []common.Currency{
-	common.Currency{Name: "bitcoin", Abbr: "BTC"},
-	common.Currency{Name: "Litecoin", Abbr: "LTC"},
-	common.Currency{Name: "bitcoin cash", Abbr: "BCH"},
-	common.Currency{Name: "Dogecoin", Abbr: "DOGE"},
-}
-
-var testExchangers = []common.Exchanger{
-	common.Exchanger{Name: "Poloniex"},
-	common.Exchanger{Name: "bittrex"},
-	common.Exchanger{Name: "okcoin", Info: "Okex is located in japan"},
+func main() {
+	app := cli.NewApp()
+	app.Name = "dbman"
+	app.Usage = "manage the exchangedata database"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{Name: "config", Usage: "JSON database config, of " + database.EnvConfig + " if empty"},
+		cli.StringFlag{Name: "dialect", Usage: "mysql, postgres or sqlite3, overrides the config"},
+		cli.StringFlag{Name: "host", Usage: "database host, overrides the config"},
+		cli.StringFlag{Name: "db", Usage: "database name, the file for sqlite3, overrides the config"},
+		cli.StringFlag{Name: "user", Usage: "database user, overrides the config"},
+		cli.StringFlag{Name: "password", Usage: "database password, overrides the config"},
+	}
+	app.Commands = []cli.Command{
+		{
+			Name:  "migrate",
+			Usage: "apply or revert the versioned schema migrations",
+			Subcommands: []cli.Command{
+				{
+					Name:  "up",
+					Usage: "apply the pending migrations",
+					Flags: []cli.Flag{
+						cli.Int64Flag{Name: "to", Usage: "last version to apply, all if 0"},
+					},
+					Action: migrateUp,
+				},
+				{
+					Name:  "down",
+					Usage: "revert the last applied migrations, the initial schema drops all the tables",
+					Flags: []cli.Flag{
+						cli.IntFlag{Name: "steps", Value: 1, Usage: "number of migrations to revert"},
+					},
+					Action: migrateDown,
+				},
+				{
+					Name:   "status",
+					Usage:  "print the migrations, applied or pending",
+					Action: migrationStatus,
+				},
+			},
+		},
+		{
+			Name:  "seed",
+			Usage: "store the currencies, exchangers and markets of a seed file, updating the stored ones",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "file", Usage: "JSON seed file, see seed.example.json"},
+			},
+			Action: seed,
+		},
+		{
+			Name:  "list",
+			Usage: "print the reference data",
+			Subcommands: []cli.Command{
+				{
+					Name:   "exchangers",
+					Usage:  "print the exchangers",
+					Action: listExchangers,
+				},
+				{
+					Name:  "markets",
+					Usage: "print the markets",
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "exchanger", Usage: "only the markets of the exchanger"},
+						cli.StringFlag{Name: "currency", Usage: "only the markets trading the currency, name or abbreviation"},
+						cli.BoolFlag{Name: "active", Usage: "only the active markets"},
+					},
+					Action: listMarkets,
+				},
+				{
+					Name:   "currencies",
+					Usage:  "print the currencies",
+					Action: listCurrencies,
+				},
+			},
+		},
+		{
+			Name:  "show",
+			Usage: "print the stored market data",
+			Subcommands: []cli.Command{
+				{
+					Name:      "ticker",
+					Usage:     "print the last ticker of the market",
+					ArgsUsage: "market",
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "exchanger", Usage: "exchanger of the market"},
+					},
+					Action: showTicker,
+				},
+			},
+		},
+		{
+			Name:  "purge",
+			Usage: "delete the market data before a time",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "before", Usage: "2006-01-02 or RFC3339, the rows before it are deleted"},
+				cli.StringFlag{Name: "tables", Usage: "tables to purge, " + strings.Join(database.PurgeTables(), ",") + " if empty"},
+				cli.BoolFlag{Name: "dry-run", Usage: "only count the rows"},
+			},
+			Action: purge,
+		},
+		{
+			Name:   "stats",
+			Usage:  "print the row count and size of each table",
+			Action: stats,
+		},
+		{
+			Name:   "check",
+			Usage:  "check the integrity of the database, fails on a problem",
+			Action: check,
+		},
+		{
+			Name:  "export",
+			Usage: "export the market data of a time range to files",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "from", Usage: "start of the range, 2006-01-02 or RFC3339"},
+				cli.StringFlag{Name: "to", Usage: "end of the range, excluded, now if empty"},
+				cli.StringFlag{Name: "dir", Value: "export", Usage: "output directory"},
+				cli.StringFlag{Name: "kinds", Value: "tickers,trades,candles,orderbooks", Usage: "records to export"},
+				cli.StringFlag{Name: "format", Value: sink.FormatParquet, Usage: "parquet, jsonl or csv"},
+				cli.StringFlag{Name: "compression", Usage: "gzip or zstd, snappy for parquet if empty"},
+				cli.Int64Flag{Name: "row-group", Value: sink.DefaultRowGroupSize >> 20, Usage: "parquet row group size in MB, each open market file keeps one in memory"},
+			},
+			Action: export,
+		},
+		{
+			Name:  "candles",
+			Usage: "build the candles of a time range from the stored trades, replacing the stored ones",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "from", Usage: "start of the range, 2006-01-02 or RFC3339"},
+				cli.StringFlag{Name: "to", Usage: "end of the range, excluded, now if empty"},
+				cli.StringFlag{Name: "intervals", Value: "1m,5m,1h,1d", Usage: "candle intervals, like 1s, 15m, 4h, 1d or 1w"},
+				cli.StringFlag{Name: "tz", Value: "UTC", Usage: "time zone of the candle boundaries, like America/New_York"},
+			},
+			Action: rebuildCandles,
+		},
+		{
+			Name:  "bookmetrics",
+			Usage: "compute the depth, imbalance and slippage metrics of the stored order books, replacing the stored ones",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "from", Usage: "start of the range, 2006-01-02 or RFC3339"},
+				cli.StringFlag{Name: "to", Usage: "end of the range, excluded, now if empty"},
+				cli.StringFlag{Name: "band", Value: bookmetrics.DefaultBand.String(), Usage: "band around the mid price of the depths, 0.01 for 1%"},
+				cli.StringFlag{Name: "notional", Value: bookmetrics.DefaultNotional.String(), Usage: "order amount of the slippages, in the pricing currency"},
+			},
+			Action: rebuildBookMetrics,
+		},
+		{
+			Name:  "gaps",
+			Usage: "report the gaps and completeness of the market data by market and day",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "from", Usage: "start of the range, 2006-01-02 or RFC3339"},
+				cli.StringFlag{Name: "to", Usage: "end of the range, excluded, now if empty"},
+				cli.StringFlag{Name: "exchanger", Usage: "only the markets of the exchanger"},
+				cli.StringFlag{Name: "market", Usage: "only the market of this name"},
+				cli.DurationFlag{Name: "tickers", Value: time.Minute, Usage: "longest time between two tickers, 0 not to check them"},
+				cli.DurationFlag{Name: "trades", Value: time.Hour, Usage: "longest time between two trades, 0 not to check them"},
+				cli.StringFlag{Name: "candles", Usage: "candle intervals to check, like 1m,1h"},
+				cli.StringFlag{Name: "tz", Value: "UTC", Usage: "time zone of the days and candle boundaries"},
+				cli.BoolFlag{Name: "backfill", Usage: "rebuild the missing candles from the stored trades"},
+			},
+			Action: gaps,
+		},
+		{
+			Name:  "alerts",
+			Usage: "manage the alert rules evaluated by the daemon",
+			Subcommands: []cli.Command{
+				{
+					Name:   "list",
+					Usage:  "print the alert rules",
+					Action: listAlertRules,
+				},
+				{
+					Name:  "add",
+					Usage: "store a new alert rule",
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "name", Usage: "name of the rule in its alerts"},
+						cli.StringFlag{Name: "exchanger", Usage: "exchanger of the market"},
+						cli.StringFlag{Name: "market", Usage: "market name, or exchanger:name"},
+						cli.StringFlag{Name: "metric", Usage: "price, spread, change or no_data"},
+						cli.StringFlag{Name: "op", Usage: "above or below, above for no_data"},
+						cli.StringFlag{Name: "threshold", Usage: "price, spread or 24h change rate like 0.02 or -0.1, seconds for no_data"},
+						cli.StringFlag{Name: "channel", Value: alert.ChannelWebhook, Usage: "webhook or email"},
+						cli.StringFlag{Name: "target", Usage: "webhook url or email address"},
+						cli.DurationFlag{Name: "cooldown", Value: time.Hour, Usage: "shortest time between two alerts of the rule"},
+						cli.BoolFlag{Name: "disabled", Usage: "store the rule disabled"},
+					},
+					Action: addAlertRule,
+				},
+				{
+					Name:      "enable",
+					Usage:     "enable the alert rule",
+					ArgsUsage: "id",
+					Action:    func(c *cli.Context) error { return enableAlertRule(c, true) },
+				},
+				{
+					Name:      "disable",
+					Usage:     "disable the alert rule",
+					ArgsUsage: "id",
+					Action:    func(c *cli.Context) error { return enableAlertRule(c, false) },
+				},
+				{
+					Name:      "delete",
+					Usage:     "delete the alert rule and its alerts",
+					ArgsUsage: "id",
+					Action:    deleteAlertRule,
+				},
+				{
+					Name:      "history",
+					Usage:     "print the alerts of the rule",
+					ArgsUsage: "id",
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "from", Usage: "start of the range, 2006-01-02 or RFC3339, a week before to if empty"},
+						cli.StringFlag{Name: "to", Usage: "end of the range, excluded, now if empty"},
+					},
+					Action: alertHistory,
+				},
+			},
+		},
+	}
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
 }
 
-var testMarkets = []*common.Market{
-	&common.Market{Name: "LTC-BTC",
-		Symbol: &common.Symbol{Base: &common.Currency{Name: "Litecoin", Abbr: "LTC"},
-			Quote: &common.Currency{Name: "bitcoin", Abbr: "BTC"}},
-		Active:     true,
-		Info:       "common market test",
-		Precision:  5,
-		Limitation: common.Limitation{Min: 0.01, Max: 10000},
-		MinStep:    0.000001,
-		Exchanger:  &testExchangers[1]},
-	&common.Market{Name: "DOGE-BTC",
-		Symbol: &common.Symbol{
-			Base:  &testCurrencies[3],
-			Quote: &testCurrencies[0]},
-		Active:     true,
-		Info:       "extiguish test",
-		Precision:  8,
-		Limitation: common.Limitation{Min: 100, Max: 10000000},
-		MinStep:    0.00000000001,
-		Exchanger:  &testExchangers[1]},
-	&common.Market{Name: "DOGE-BTC",
-		Symbol: &common.Symbol{
-			Base:  &testCurrencies[3],
-			Quote: &testCurrencies[0]},
-		Active:     true,
-		Info:       "extiguish test",
-		Precision:  8,
-		Limitation: common.Limitation{Min: 100, Max: 10000000},
-		MinStep:    0.00000000001,
-		Exchanger:  &testExchangers[0]},
+// openStore opens the database of the config, overridden by the global flags set
+func openStore(c *cli.Context) (*database.DataStore, error) {
+	conf, err := database.LoadConfig(c.GlobalString("config"))
+	if err != nil {
+		return nil, err
+	}
+	for flag, f := range map[string]*string{"dialect": &conf.Dialect, "host": &conf.Host, "db": &conf.Name,
+		"user": &conf.User, "password": &conf.Password} {
+		if v := c.GlobalString(flag); v != "" {
+			*f = v
+		}
+	}
+	ds := conf.DataStore()
+	if err = ds.OpenDB(); err != nil {
+		return nil, err
+	}
+	return ds, nil
 }
 
-var testTickers = []*common.Ticker{
-	&common.Ticker{
-		Time:   time.Date(2018, 11, 22, 3, 4, 5, 6, time.Local),
-		Market: testMarkets[1], Last: 382.98901522, Ask: 381.99755898, Bid: 379.41296309, High: 412.25844455,
-		Percentage: -0.04312950, Low: 364.56122072, BaseVolume: 14969820.94951828, QuoteVolume: 38859.58435407,
-	}, // poloniex model
-	&common.Ticker{
-		Time:   time.Date(2018, 11, 22, 3, 4, 5, 6, time.Local),
-		Market: testMarkets[0], Last: 3.35579531, Bid: 2.05670368, Ask: 3.35579531,
-	}, // bittrex model
-	&common.Ticker{
-		Time:   time.Date(2018, 11, 22, 3, 4, 5, 6, time.Local),
-		Market: testMarkets[2], High: 0.00846390, BaseVolume: 1135176.4290665, Last: 0.00809068, Low: 0.00801497, Bid: 0.00808481, Ask: 0.00809001,
-	}, //okex model
+// parseTime parses a date or an RFC3339 time, a date is in UTC
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
 }
 
-var testTrades = []*common.Trade{
-	&common.Trade{
-		Time:    time.Now(),
-		OrderID: "4688134",
-		Type:    "fill",
-		Side:    "buy",
-		Price:   3304.51,
-		Amount:  23.1000008,
-		Market:  testMarkets[1],
-	},
-	&common.Trade{
-		Time:    time.Date(2018, 11, 12, 23, 54, 33, 00, time.UTC),
-		OrderID: "1832441212123",
-		Side:    "buy",
-		Price:   33.3,
-		Amount:  2008,
-		Market:  testMarkets[2],
-	},
+// findMarket returns the market of the name on the exchanger, the name may be exchanger:name
+func findMarket(ds *database.DataStore, name, exchanger string) (*common.Market, error) {
+	f := database.MarketFilter{Exchanger: exchanger, Name: name}
+	if k := strings.IndexByte(f.Name, ':'); k >= 0 {
+		f.Exchanger, f.Name = f.Name[:k], f.Name[k+1:]
+	}
+	if f.Name == "" {
+		return nil, errors.New("market missing")
+	}
+	markets, err := ds.Markets(f)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(markets) == 0:
+		return nil, fmt.Errorf("unknown market %s", name)
+	case len(markets) > 1:
+		return nil, fmt.Errorf("market %s on several exchangers, set --exchanger", f.Name)
+	}
+	return markets[0], nil
 }
 
-var testOrderBooks = []*common.OrderBook{
-	&common.OrderBook{
-		Market: testMarkets[0],
-		Time:   time.Now(),
-		Asks: []*common.PriceVol{
-			&common.PriceVol{Price: 0.00001853, Volume: 2537.5637},
-			&common.PriceVol{Price: 0.00001854, Volume: 1567238.172367},
-		},
-		Bids: []*common.PriceVol{
-			&common.PriceVol{Price: 0.00001841, Volume: 3645.3647},
-			&common.PriceVol{Price: 0.00001840, Volume: 1637.3647},
-		},
-	},
-}
+// export writes the records of the range with a sink.File, partitioned by exchanger, market and date
+func export(c *cli.Context) error {
+	from, err := parseTime(c.String("from"))
+	if err != nil {
+		return fmt.Errorf("bad --from: %v", err)
+	}
+	to := time.Now()
+	if c.String("to") != "" {
+		if to, err = parseTime(c.String("to")); err != nil {
+			return fmt.Errorf("bad --to: %v", err)
+		}
+	}
 
-var (
-	Currencs  map[string]*common.Currency
-	Exchanges map[string]*common.Exchanger
-	Markes    map[uint]*common.Market
-)
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	out, err := sink.NewFile(sink.FileConfig{
+		Dir:          c.String("dir"),
+		Format:       c.String("format"),
+		Compression:  c.String("compression"),
+		RowGroupSize: c.Int64("row-group") << 20,
+	})
+	if err != nil {
+		return err
+	}
 
-func init() {
-	Currencs = make(map[string]*common.Currency)
-	Exchanges = make(map[string]*common.Exchanger)
-	Markes = make(map[uint]*common.Market)
-	for k, m := range testMarkets {
-		if Currencs[m.Symbol.Base.Name] == nil || Currencs[m.Symbol.Base.Name].Name != m.Symbol.Base.Name {
-			Currencs[m.Symbol.Base.Name] = m.Symbol.Base
-		} else {
-			m.Symbol.Base = Currencs[m.Symbol.Base.Name]
-		}
-		if Currencs[m.Symbol.Quote.Name] == nil || Currencs[m.Symbol.Quote.Name].Name != m.Symbol.Quote.Name {
-			Currencs[m.Symbol.Quote.Name] = m.Symbol.Quote
-		} else {
-			m.Symbol.Quote = Currencs[m.Symbol.Quote.Name]
+	for _, kind := range strings.Split(c.String("kinds"), ",") {
+		var n int
+		switch strings.TrimSpace(kind) {
+		case "tickers":
+			err = ds.ScanTickers(from, to, 0, func(r []*common.Ticker) error { n += len(r); return out.WriteTickers(r) })
+		case "trades":
+			err = ds.ScanTrades(from, to, 0, func(r []*common.Trade) error { n += len(r); return out.WriteTrades(r) })
+		case "candles":
+			err = ds.ScanCandles(from, to, 0, func(r []*common.Candle) error { n += len(r); return out.WriteCandles(r) })
+		case "orderbooks":
+			err = ds.ScanOrderBooks(from, to, 0, func(r []*common.OrderBook) error { n += len(r); return out.WriteOrderBooks(r) })
+		default:
+			err = fmt.Errorf("unknown kind %s", kind)
 		}
-		if Exchanges[m.Exchanger.Name] == nil || Exchanges[m.Exchanger.Name].Name != m.Exchanger.Name {
-			Exchanges[m.Exchanger.Name] = m.Exchanger
-		} else {
-			m.Exchanger = Exchanges[m.Exchanger.Name]
+		if err != nil {
+			out.Close()
+			return err
 		}
-		Markes[uint(k)] = m
+		log.Printf("%d %s exported", n, kind)
 	}
+	return out.Close()
 }
 
-func main() {
-	var err error
-	ds := database.NewDataStore("mysql")
-	if err = ds.OpenDB(); err != nil {
-		panic("open db failed")
+// rebuildCandles stores the candles of the range built from the trades, widened to whole candles
+func rebuildCandles(c *cli.Context) error {
+	from, err := parseTime(c.String("from"))
+	if err != nil {
+		return fmt.Errorf("bad --from: %v", err)
+	}
+	to := time.Now()
+	if c.String("to") != "" {
+		if to, err = parseTime(c.String("to")); err != nil {
+			return fmt.Errorf("bad --to: %v", err)
+		}
+	}
+	intervals, err := candle.ParseIntervals(c.String("intervals"))
+	if err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(c.String("tz"))
+	if err != nil {
+		return err
+	}
+
+	ds, err := openStore(c)
+	if err != nil {
+		return err
 	}
 	defer ds.CloseDB()
+	n, err := candle.Rebuild(ds, loc, from, to, intervals...)
+	if err != nil {
+		return err
+	}
+	log.Printf("%d candles stored", n)
+	return nil
+}
 
-	ds.AutoMigrate()
-	for _, c := range Currencs {
-		if ds.UpdateCurrency(c).Error != nil {
-			log.Println("error save currency", ds.GetDB().Error)
-		}
+// rebuildBookMetrics stores the metrics of the order books of the range
+func rebuildBookMetrics(c *cli.Context) error {
+	from, err := parseTime(c.String("from"))
+	if err != nil {
+		return fmt.Errorf("bad --from: %v", err)
 	}
-	for _, c := range Exchanges {
-		if ds.UpdateExchanger(c).Error != nil {
-			log.Println("error save exchanger", ds.GetDB().Error)
+	to := time.Now()
+	if c.String("to") != "" {
+		if to, err = parseTime(c.String("to")); err != nil {
+			return fmt.Errorf("bad --to: %v", err)
 		}
 	}
-	for _, c := range Markes {
-		if ds.UpdateMarket(c).Error != nil {
-			log.Println("error save market", ds.GetDB().Error)
+	band, err := decimal.NewFromString(c.String("band"))
+	if err != nil || band.IsNegative() {
+		return fmt.Errorf("bad --band: %s", c.String("band"))
+	}
+	notional, err := decimal.NewFromString(c.String("notional"))
+	if err != nil || notional.IsNegative() {
+		return fmt.Errorf("bad --notional: %s", c.String("notional"))
+	}
+
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	n, err := bookmetrics.Rebuild(ds, from, to, band, notional)
+	if err != nil {
+		return err
+	}
+	log.Printf("%d book metrics stored", n)
+	return nil
+}
+
+// gaps prints the completeness of the days and the gaps of the range, and rebuilds the fillable candles
+func gaps(c *cli.Context) error {
+	from, err := parseTime(c.String("from"))
+	if err != nil {
+		return fmt.Errorf("bad --from: %v", err)
+	}
+	to := time.Now()
+	if c.String("to") != "" {
+		if to, err = parseTime(c.String("to")); err != nil {
+			return fmt.Errorf("bad --to: %v", err)
 		}
 	}
-	for _, c := range testTickers {
-		if ds.UpdateTicker(c).Error != nil {
-			log.Println("error save ticker", ds.GetDB().Error)
+	o := completeness.Options{From: from, To: to, Exchanger: c.String("exchanger"), Market: c.String("market"),
+		Tickers: c.Duration("tickers"), Trades: c.Duration("trades")}
+	if c.String("candles") != "" {
+		if o.Candles, err = candle.ParseIntervals(c.String("candles")); err != nil {
+			return err
 		}
 	}
-	for _, c := range testTrades {
-		if ds.UpdateTrade(c).Error != nil {
-			log.Println("error save trade", ds.GetDB().Error)
+	if o.Loc, err = time.LoadLocation(c.String("tz")); err != nil {
+		return err
+	}
+
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	r, err := completeness.Scan(ds, o)
+	if err != nil {
+		return err
+	}
+	for _, d := range r.Days {
+		fmt.Printf("%s %s:%s %s records %d gaps %d missing %s complete %.2f%%\n", d.Day.Format("2006-01-02"),
+			d.Exchanger, d.Market, d.Kind, d.Records, d.Gaps, d.Missing, d.Completeness*100)
+	}
+	for _, g := range r.Gaps {
+		fillable := ""
+		if g.Fillable {
+			fillable = " fillable"
 		}
+		fmt.Printf("gap %s:%s %s %s - %s %s %s%s\n", g.Exchanger, g.Market, g.Kind, g.From.In(o.Loc).Format(time.RFC3339),
+			g.To.In(o.Loc).Format(time.RFC3339), g.To.Sub(g.From), strings.Join(g.Causes, ","), fillable)
 	}
-	for _, c := range testOrderBooks {
-		if ds.UpdateOrderBook(c).Error != nil {
-			log.Println("error save orderbook", ds.GetDB().Error)
+	if c.Bool("backfill") {
+		n, err := completeness.Backfill(ds, r, o.Loc)
+		if err != nil {
+			return err
 		}
+		log.Printf("%d candles stored", n)
 	}
+	return nil
 }
 
 /*
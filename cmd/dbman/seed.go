@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/exchangedata/common"
+	"github.com/shopspring/decimal"
+	"github.com/urfave/cli"
+)
+
+// seedFile is the reference data of a seed file, see seed.example.json
+type seedFile struct {
+	Currencies []*seedCurrency  `json:"currencies"`
+	Exchangers []*seedExchanger `json:"exchangers"`
+	Markets    []*seedMarket    `json:"markets"`
+}
+
+type seedCurrency struct {
+	Name      string `json:"name"` // matches the stored name in any case
+	Abbr      string `json:"abbr"` // stored uppercase
+	AbbrFinal bool   `json:"abbr_final"`
+	Info      string `json:"info"`
+}
+
+type seedExchanger struct {
+	Name string `json:"name"` // stored lowercase
+	Info string `json:"info"`
+}
+
+// seedMarket refers to its exchanger by name and to its currencies by abbreviation or name,
+// from the file or already stored
+type seedMarket struct {
+	Exchanger string          `json:"exchanger"`
+	Name      string          `json:"name"`
+	Base      string          `json:"base"`
+	Quote     string          `json:"quote"`
+	Active    *bool           `json:"active"`    // true if missing
+	Precision uint            `json:"precision"` // 8 if 0
+	MinAmount decimal.Decimal `json:"min_amount"`
+	MaxAmount decimal.Decimal `json:"max_amount"`
+	MinStep   decimal.Decimal `json:"min_step"`
+	Info      string          `json:"info"`
+}
+
+// seed stores the reference data of the file, the stored rows of the same names are updated
+func seed(c *cli.Context) error {
+	if c.String("file") == "" {
+		return errors.New("--file missing")
+	}
+	b, err := ioutil.ReadFile(c.String("file"))
+	if err != nil {
+		return err
+	}
+	f := &seedFile{}
+	if err = json.Unmarshal(b, f); err != nil {
+		return fmt.Errorf("bad seed file: %v", err)
+	}
+
+	ds, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+	stored, err := ds.Currencies()
+	if err != nil {
+		return err
+	}
+	currencies := make(map[string]*common.Currency)
+	byName := make(map[string]*common.Currency)
+	for _, cu := range stored {
+		byName[strings.ToLower(cu.Name)] = cu
+		currencies[strings.ToUpper(cu.Abbr)] = cu
+	}
+	for _, sc := range f.Currencies {
+		cu := &common.Currency{Name: strings.TrimSpace(sc.Name), Abbr: strings.ToUpper(sc.Abbr), AbbrFinal: sc.AbbrFinal, Info: sc.Info}
+		if cu.Name == "" || cu.Abbr == "" {
+			return fmt.Errorf("currency without name or abbreviation: %+v", sc)
+		}
+		if t := byName[strings.ToLower(cu.Name)]; t != nil {
+			cu.ID = t.ID
+		}
+		// the file wins over the stored abbreviation, unlike UpdateCurrency
+		if err = ds.GetDB().Set("gorm:save_associations", false).Save(cu).Error; err != nil {
+			return fmt.Errorf("store currency %s: %v", cu.Name, err)
+		}
+		byName[strings.ToLower(cu.Name)] = cu
+		currencies[cu.Abbr] = cu
+	}
+	currency := func(s string) *common.Currency {
+		if cu := currencies[strings.ToUpper(s)]; cu != nil {
+			return cu
+		}
+		return byName[strings.ToLower(s)]
+	}
+
+	exchangers := make(map[string]*common.Exchanger)
+	for _, se := range f.Exchangers {
+		e := &common.Exchanger{Name: strings.ToLower(se.Name)}
+		if err = ds.UpdateExchanger(e).Error; err != nil {
+			return fmt.Errorf("store exchanger %s: %v", e.Name, err)
+		}
+		if e.Info != se.Info {
+			if err = ds.GetDB().Model(e).Update("info", se.Info).Error; err != nil {
+				return fmt.Errorf("store exchanger %s: %v", e.Name, err)
+			}
+		}
+		exchangers[e.Name] = e
+	}
+
+	for _, sm := range f.Markets {
+		e := exchangers[strings.ToLower(sm.Exchanger)]
+		if e == nil {
+			e = &common.Exchanger{Name: strings.ToLower(sm.Exchanger)}
+			if err = ds.UpdateExchanger(e).Error; err != nil {
+				return fmt.Errorf("store exchanger %s: %v", e.Name, err)
+			}
+			exchangers[e.Name] = e
+		}
+		base, quote := currency(sm.Base), currency(sm.Quote)
+		if base == nil || quote == nil {
+			return fmt.Errorf("market %s:%s: unknown currency %s or %s", sm.Exchanger, sm.Name, sm.Base, sm.Quote)
+		}
+		m := &common.Market{Name: sm.Name, Exchanger: e, Symbol: &common.Symbol{Base: base, Quote: quote},
+			Active: sm.Active == nil || *sm.Active, Precision: sm.Precision, Info: sm.Info, MinStep: sm.MinStep,
+			Limitation: common.Limitation{Min: sm.MinAmount, Max: sm.MaxAmount}}
+		if m.Precision == 0 {
+			m.Precision = 8
+		}
+		if err = ds.UpdateMarket(m).Error; err != nil {
+			return fmt.Errorf("store market %s:%s: %v", e.Name, m.Name, err)
+		}
+		if !m.Active { // a new market takes the default of the column
+			if err = ds.GetDB().Model(m).Update("active", false).Error; err != nil {
+				return fmt.Errorf("store market %s:%s: %v", e.Name, m.Name, err)
+			}
+		}
+	}
+	log.Printf("%d currencies, %d exchangers, %d markets stored", len(f.Currencies), len(exchangers), len(f.Markets))
+	return nil
+}
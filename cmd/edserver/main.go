@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/exchangedata/database"
+	"github.com/exchangedata/server"
+	"github.com/urfave/cli"
+)
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "edserver"
+	app.Usage = "serve the exchangedata database as JSON over HTTP"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{Name: "config", Usage: "JSON database config, of " + database.EnvConfig + " if empty"},
+		cli.StringFlag{Name: "dialect", Usage: "mysql, postgres or sqlite3, overrides the config"},
+		cli.StringFlag{Name: "db", Usage: "database name, the file for sqlite3, overrides the config"},
+		cli.StringFlag{Name: "addr", Value: ":8080", Usage: "listen address"},
+		cli.StringFlag{Name: "allow-origin", Usage: "CORS allowed origin like https://example.com or *, none if empty"},
+		cli.StringFlag{Name: "token", EnvVar: "EXDATA_API_TOKEN", Usage: "bearer token of the alert rule writes, refused if empty"},
+	}
+	app.Action = serve
+
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serve(c *cli.Context) error {
+	conf, err := database.LoadConfig(c.String("config"))
+	if err != nil {
+		return err
+	}
+	if dialect := c.String("dialect"); dialect != "" {
+		conf.Dialect = dialect
+	}
+	if name := c.String("db"); name != "" {
+		conf.Name = name
+	}
+	ds := conf.DataStore()
+	if err = ds.OpenDB(); err != nil {
+		return err
+	}
+	defer ds.CloseDB()
+
+	api := server.New(ds)
+	api.AllowOrigin = c.String("allow-origin")
+	api.Token = c.String("token")
+	srv := &http.Server{Addr: c.String("addr"), Handler: api, ReadTimeout: 10 * time.Second}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	log.Println("edserver listening on", srv.Addr)
+	select {
+	case err := <-errCh:
+		return err
+	case <-interrupt:
+	}
+	log.Println("interrupted! Exiting...")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
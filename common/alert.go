@@ -0,0 +1,37 @@
+package common
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// AlertRule is a condition on the live market data of a market: its Metric, price, spread, change or no_data,
+// going Op, above or below, Threshold. The spread and the 24h change are rates, no_data is in seconds without
+// records. The alerts are sent on Channel, webhook or email, to Target, its URL or address, at most once
+// every Cooldown seconds.
+type AlertRule struct {
+	ID        uint            `gorm:"primary_key"`
+	Name      string          `gorm:"size:64"`
+	MarketRef uint            `gorm:"index;not null"`
+	Metric    string          `gorm:"size:16;not null"`
+	Op        string          `gorm:"size:8"`
+	Threshold decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Channel   string          `gorm:"size:16;not null"`
+	Target    string          `gorm:"not null"`
+	Cooldown  int64
+	Enabled   bool
+	Market    *Market `gorm:"foreignkey:MarketRef;association_save_reference:false"`
+}
+
+// Alert is a condition of an AlertRule met at Time by Value, Error is the last delivery error if not Delivered
+type Alert struct {
+	ID        uint            `gorm:"primary_key"`
+	Time      time.Time       `gorm:"index:idx_rule_alert;not null"`
+	RuleRef   uint            `gorm:"index:idx_rule_alert;not null"`
+	Value     decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Message   string
+	Delivered bool
+	Error     string
+	Rule      *AlertRule `gorm:"foreignkey:RuleRef;association_save_reference:false"`
+}
@@ -0,0 +1,42 @@
+package common
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// kinds of arbitrage opportunities
+const (
+	ArbitrageCross      = "cross"      // buy a symbol on an exchanger, sell it on another
+	ArbitrageTriangular = "triangular" // three markets of an exchanger back to the first currency
+)
+
+// Opportunity is a cycle of trades ending with more of Currency than it starts with, after the fees.
+// Start is the amount of Currency spent by the first leg, Profit what the last leg returns above it,
+// Edge is Profit / Start.
+type Opportunity struct {
+	Kind     string
+	Time     time.Time // of the oldest book used
+	Currency string    // abbreviation
+	Start    decimal.Decimal
+	Profit   decimal.Decimal
+	Edge     decimal.Decimal
+	Legs     []*Leg
+}
+
+// Leg is an order of an Opportunity filled by the levels of the book of Market, Amount is of the currency traded
+// in the market, Symbol.Quote like on Bittrex, and Price is the average price of the levels in Symbol.Base
+type Leg struct {
+	Market *Market
+	Side   string // buy or sell
+	Price  decimal.Decimal
+	Amount decimal.Decimal
+	Fee    decimal.Decimal // rate
+}
+
+// order sides of the legs
+const (
+	SideBuy  = "buy"
+	SideSell = "sell"
+)
@@ -0,0 +1,14 @@
+package common
+
+import "time"
+
+// IndicatorValue is the value of a technical indicator of the candles of a market and interval,
+// after the candle of Time. The values are floats, the indicators are estimates, not amounts.
+type IndicatorValue struct {
+	Time      time.Time
+	Market    *Market
+	Interval  string
+	Indicator string    // like sma(20) or macd(12,26,9)
+	Fields    []string  // the names of Values, like macd, signal and histogram
+	Values    []float64 // by field
+}
@@ -0,0 +1,134 @@
+package common
+
+import "sort"
+
+// Levels returns the level rows of ob for storage
+func (ob *OrderBook) Levels() []*BookLevel {
+	levels := make([]*BookLevel, 0, len(ob.Bids)+len(ob.Asks))
+	for k, p := range ob.Bids {
+		levels = append(levels, &BookLevel{BookRef: ob.ID, Side: BookBid, Level: uint(k), Price: p.Price, Volume: p.Volume})
+	}
+	for k, p := range ob.Asks {
+		levels = append(levels, &BookLevel{BookRef: ob.ID, Side: BookAsk, Level: uint(k), Price: p.Price, Volume: p.Volume})
+	}
+	return levels
+}
+
+// SetLevels rebuilds the bids and asks of ob from stored level rows
+func (ob *OrderBook) SetLevels(levels []*BookLevel) {
+	ob.Bids, ob.Asks = []*PriceVol{}, []*PriceVol{}
+	for _, l := range levels {
+		p := &PriceVol{Price: l.Price, Volume: l.Volume}
+		if l.Side == BookBid {
+			ob.Bids = append(ob.Bids, p)
+		} else {
+			ob.Asks = append(ob.Asks, p)
+		}
+	}
+	ob.sort()
+}
+
+// Clone copies ob and its price levels
+func (ob *OrderBook) Clone() *OrderBook {
+	c := *ob
+	c.Bids = make([]*PriceVol, len(ob.Bids))
+	for k, p := range ob.Bids {
+		v := *p
+		c.Bids[k] = &v
+	}
+	c.Asks = make([]*PriceVol, len(ob.Asks))
+	for k, p := range ob.Asks {
+		v := *p
+		c.Asks[k] = &v
+	}
+	return &c
+}
+
+// Apply changes the levels of ob by deltas, the sides stay sorted from the best price
+func (ob *OrderBook) Apply(deltas []*BookDelta) {
+	if len(deltas) == 0 {
+		return
+	}
+	bids, asks := volumes(ob.Bids), volumes(ob.Asks)
+	for _, d := range deltas {
+		side := bids
+		if d.Side == BookAsk {
+			side = asks
+		}
+		if d.Volume.IsZero() {
+			delete(side, d.Price.String())
+		} else {
+			side[d.Price.String()] = &PriceVol{Price: d.Price, Volume: d.Volume}
+		}
+		if d.Sequence > ob.Sequence {
+			ob.Sequence = d.Sequence
+		}
+		if d.Time.After(ob.Time) {
+			ob.Time = d.Time
+		}
+	}
+	ob.Bids, ob.Asks = levels(bids), levels(asks)
+	ob.sort()
+}
+
+// DiffOrderBook returns the deltas turning the book prev into next, Sequence is left to the caller
+func DiffOrderBook(prev, next *OrderBook) []*BookDelta {
+	deltas := []*BookDelta{}
+	diff := func(side uint8, from, to []*PriceVol) {
+		old := volumes(from)
+		for _, p := range to {
+			if v, ok := old[p.Price.String()]; !ok || !v.Volume.Equal(p.Volume) {
+				deltas = append(deltas, &BookDelta{Side: side, Price: p.Price, Volume: p.Volume})
+			}
+		}
+		now := volumes(to)
+		for _, p := range from {
+			if _, ok := now[p.Price.String()]; !ok {
+				deltas = append(deltas, &BookDelta{Side: side, Price: p.Price})
+			}
+		}
+	}
+	diff(BookBid, prev.Bids, next.Bids)
+	diff(BookAsk, prev.Asks, next.Asks)
+	for _, d := range deltas {
+		d.Time = next.Time
+		d.MarketRef = next.MarketRef
+	}
+	return deltas
+}
+
+// SameLevels reports whether the price levels a and b are equal in value
+func SameLevels(a, b []*PriceVol) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if !a[k].Price.Equal(b[k].Price) || !a[k].Volume.Equal(b[k].Volume) {
+			return false
+		}
+	}
+	return true
+}
+
+// volumes indexes the levels of a side by price, the decimal string is the key as equal decimals may differ in exponent
+func volumes(side []*PriceVol) map[string]*PriceVol {
+	m := make(map[string]*PriceVol, len(side))
+	for _, p := range side {
+		m[p.Price.String()] = p
+	}
+	return m
+}
+
+func levels(m map[string]*PriceVol) []*PriceVol {
+	side := make([]*PriceVol, 0, len(m))
+	for _, p := range m {
+		v := *p
+		side = append(side, &v)
+	}
+	return side
+}
+
+func (ob *OrderBook) sort() {
+	sort.Slice(ob.Bids, func(i, j int) bool { return ob.Bids[i].Price.GreaterThan(ob.Bids[j].Price) })
+	sort.Slice(ob.Asks, func(i, j int) bool { return ob.Asks[i].Price.LessThan(ob.Asks[j].Price) })
+}
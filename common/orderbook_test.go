@@ -0,0 +1,55 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var dec = decimal.RequireFromString
+
+var testBook = &OrderBook{
+	Time: time.Date(2018, 11, 22, 3, 4, 5, 0, time.UTC),
+	Bids: []*PriceVol{{Price: dec("0.00001841"), Volume: dec("3645.3647")}, {Price: dec("0.00001840"), Volume: dec("1637.3647")}},
+	Asks: []*PriceVol{{Price: dec("0.00001853"), Volume: dec("2537.5637")}, {Price: dec("0.00001854"), Volume: dec("1567238.172367")}},
+}
+
+func TestOrderBookLevels(t *testing.T) {
+	ob := &OrderBook{}
+	ob.SetLevels(testBook.Levels())
+	if !SameLevels(ob.Bids, testBook.Bids) || !SameLevels(ob.Asks, testBook.Asks) {
+		t.Fatal("book rebuilt from levels differs")
+	}
+}
+
+func TestOrderBookDiff(t *testing.T) {
+	next := testBook.Clone()
+	next.Time = next.Time.Add(5 * time.Second)
+	next.Bids[0].Volume = dec("100")                                                           // changed
+	next.Bids = next.Bids[:1]                                                                  // removed
+	next.Asks = append([]*PriceVol{{Price: dec("0.0000185"), Volume: dec("1")}}, next.Asks...) // added
+
+	deltas := DiffOrderBook(testBook, next)
+	if len(deltas) != 3 {
+		t.Fatalf("want 3 deltas, got %d", len(deltas))
+	}
+	for k, d := range deltas {
+		d.Sequence = uint64(k + 1)
+	}
+
+	ob := testBook.Clone()
+	ob.Apply(deltas)
+	if !SameLevels(ob.Bids, next.Bids) || !SameLevels(ob.Asks, next.Asks) {
+		t.Fatal("applying the deltas does not give the next book")
+	}
+	if !ob.Time.Equal(next.Time) || ob.Sequence != 3 {
+		t.Fatalf("time or sequence not moved, %s %d", ob.Time, ob.Sequence)
+	}
+	if testBook.Bids[0].Volume.Equal(dec("100")) {
+		t.Fatal("clone shares the levels")
+	}
+	if len(DiffOrderBook(next, next)) != 0 {
+		t.Fatal("same books should have no delta")
+	}
+}
@@ -3,8 +3,11 @@ package common
 import (
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/shopspring/decimal"
 )
 
 // @Dev: all the Name should be lowercase, Abbr should be uppercase, the leading and tail space should be trimmed
@@ -35,15 +38,15 @@ type Market struct {
 	SymRef     uint    `gorm:"unique_index:idx_sym_ex"`
 	Active     bool    `gorm:"default:true"`
 	Info       string
-	Precision  uint       `gorm:"default:8"`
-	Limitation Limitation `gorm:"embedded;embedded_prefix:amount_"`
-	MinStep    float64
-	ExRef      uint       `gorm:"unique_index:idx_sym_ex"`
-	Exchanger  *Exchanger `gorm:"foreignkey:ExRef"`
+	Precision  uint            `gorm:"default:8"`
+	Limitation Limitation      `gorm:"embedded;embedded_prefix:amount_"`
+	MinStep    decimal.Decimal `gorm:"type:decimal(36,18)"`
+	ExRef      uint            `gorm:"unique_index:idx_sym_ex"`
+	Exchanger  *Exchanger      `gorm:"foreignkey:ExRef"`
 }
 
 type Limitation struct {
-	Min, Max float64
+	Min, Max decimal.Decimal `gorm:"type:decimal(36,18)"`
 }
 
 type CommunicationAPI struct {
@@ -75,25 +78,25 @@ type Symbol struct {
 }
 
 type Ticker struct {
-	ID            uint      `gorm:"primary_key"`
-	Time          time.Time `gorm:"unique_index:idx_time_market;not null"`
-	MarketRef     uint      `gorm:"unique_index:idx_time_market;not null"`
-	High          float64
-	Low           float64
-	Bid           float64
-	BidVolume     float64
-	Ask           float64
-	AskVolume     float64
-	Last          float64
-	PreviousClose float64
-	Change        float64
-	Percentage    float64
-	Average       float64
-	BaseVolume    float64
-	QuoteVolume   float64
-	Open          float64
-	Close         float64
-	Market        *Market `gorm:"foreignkey:MarketRef;association_save_reference:false"`
+	ID            uint            `gorm:"primary_key"`
+	Time          time.Time       `gorm:"unique_index:idx_time_market;not null"`
+	MarketRef     uint            `gorm:"unique_index:idx_time_market;not null"`
+	High          decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Low           decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Bid           decimal.Decimal `gorm:"type:decimal(36,18)"`
+	BidVolume     decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Ask           decimal.Decimal `gorm:"type:decimal(36,18)"`
+	AskVolume     decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Last          decimal.Decimal `gorm:"type:decimal(36,18)"`
+	PreviousClose decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Change        decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Percentage    decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Average       decimal.Decimal `gorm:"type:decimal(36,18)"`
+	BaseVolume    decimal.Decimal `gorm:"type:decimal(36,18)"`
+	QuoteVolume   decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Open          decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Close         decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Market        *Market         `gorm:"foreignkey:MarketRef;association_save_reference:false"`
 }
 
 type Trade struct {
@@ -103,26 +106,179 @@ type Trade struct {
 	OrderID   string    `gorm:"unique_index:idx_market_trade;not null"`
 	Type      string
 	Side      string
-	Price     float64
-	Amount    float64
-	Total     float64
-	Market    *Market `gorm:"foreignkey:MarketRef;association_save_reference:false"`
+	Price     decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Amount    decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Total     decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Market    *Market         `gorm:"foreignkey:MarketRef;association_save_reference:false"`
 }
 
+// OrderBook is a snapshot of a market order book, the price levels are stored as BookLevel rows
 type OrderBook struct {
 	ID        uint        `gorm:"primary_key"`
 	Time      time.Time   `gorm:"unique_index:idx_market_orderbook;not null"`
 	MarketRef uint        `gorm:"unique_index:idx_market_orderbook;not null"`
-	Bids      []*PriceVol `gorm:"many2many:bid_pricevols"`
-	Asks      []*PriceVol `gorm:"many2many:ask_pricevols"`
+	Sequence  uint64      // position of the snapshot among the deltas of the market
+	Bids      []*PriceVol `gorm:"-"` // from the highest price
+	Asks      []*PriceVol `gorm:"-"` // from the lowest price
 	Market    *Market     `gorm:"foreignkey:MarketRef;association_save_reference:false"`
 }
 
+// Candle is the OHLCV summary of the trades of a market in the bucket of Interval starting at Time
+type Candle struct {
+	ID          uint            `gorm:"primary_key"`
+	Time        time.Time       `gorm:"unique_index:idx_market_candle;not null"`
+	MarketRef   uint            `gorm:"unique_index:idx_market_candle;not null"`
+	Interval    string          `gorm:"unique_index:idx_market_candle;size:8;not null"` // ex: 1m, 1h, 1d
+	Open        decimal.Decimal `gorm:"type:decimal(36,18)"`
+	High        decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Low         decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Close       decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Volume      decimal.Decimal `gorm:"type:decimal(36,18)"`
+	QuoteVolume decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Trades      uint
+	Market      *Market `gorm:"foreignkey:MarketRef;association_save_reference:false"`
+}
+
 type PriceVol struct {
-	ID     uint         `gorm:"primary_key"`
-	Price  float64      `gorm:"unique_index:idx_price_volume"`
-	Volume float64      `gorm:"unique_index:idx_price_volume"`
-	Occurs []*OrderBook `gorm:"many2many:bid_pricevols;many2many:ask_pricevols"`
+	Price  decimal.Decimal
+	Volume decimal.Decimal
+}
+
+// sides of the order book levels and deltas
+const (
+	BookBid uint8 = iota
+	BookAsk
+)
+
+// BookLevel is one price level of a stored order book snapshot, Level 0 is the best price of the side
+type BookLevel struct {
+	BookRef uint            `gorm:"primary_key;auto_increment:false"`
+	Side    uint8           `gorm:"primary_key;auto_increment:false"`
+	Level   uint            `gorm:"primary_key;auto_increment:false"`
+	Price   decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Volume  decimal.Decimal `gorm:"type:decimal(36,18)"`
+}
+
+// BookDelta is a change of one price level of a market order book after the snapshot BookRef.
+// Volume is the new volume of the level, zero removes it. The deltas are applied in Sequence order.
+type BookDelta struct {
+	ID        uint      `gorm:"primary_key"`
+	Time      time.Time `gorm:"index:idx_market_bookdelta;not null"`
+	MarketRef uint      `gorm:"index:idx_market_bookdelta;not null"`
+	BookRef   uint      `gorm:"index;not null"`
+	Sequence  uint64
+	Side      uint8
+	Price     decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Volume    decimal.Decimal `gorm:"type:decimal(36,18)"`
+}
+
+// ConsolidatedTicker is a symbol across the exchangers trading it: the best bid and ask of all of them,
+// and VWAP, the volume weighted average price of their trades of the last minutes, zero without trades.
+// Venues are the quotes it is made of.
+type ConsolidatedTicker struct {
+	ID           uint            `gorm:"primary_key"`
+	Time         time.Time       `gorm:"unique_index:idx_symbol_consolidated;not null"`
+	SymRef       uint            `gorm:"unique_index:idx_symbol_consolidated;not null"`
+	Bid          decimal.Decimal `gorm:"type:decimal(36,18)"`
+	BidVolume    decimal.Decimal `gorm:"type:decimal(36,18)"`
+	BidExchanger string          `gorm:"size:64"`
+	Ask          decimal.Decimal `gorm:"type:decimal(36,18)"`
+	AskVolume    decimal.Decimal `gorm:"type:decimal(36,18)"`
+	AskExchanger string          `gorm:"size:64"`
+	VWAP         decimal.Decimal `gorm:"column:vwap;type:decimal(36,18)"`
+	Volume       decimal.Decimal `gorm:"type:decimal(36,18)"` // base volume of the exchangers
+	Symbol       *Symbol         `gorm:"foreignkey:SymRef;association_save_reference:false"`
+	Venues       []*VenueQuote   `gorm:"foreignkey:TickerRef"`
+}
+
+// VenueQuote is the quote of one exchanger in a ConsolidatedTicker, Spread is Ask - Bid
+type VenueQuote struct {
+	ID        uint            `gorm:"primary_key"`
+	TickerRef uint            `gorm:"index;not null"`
+	MarketRef uint            `gorm:"not null"`
+	Exchanger string          `gorm:"size:64"`
+	Time      time.Time       // of the quote
+	Bid       decimal.Decimal `gorm:"type:decimal(36,18)"`
+	BidVolume decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Ask       decimal.Decimal `gorm:"type:decimal(36,18)"`
+	AskVolume decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Spread    decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Last      decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Volume    decimal.Decimal `gorm:"type:decimal(36,18)"`
+}
+
+// QualityIssue is a record of an exchanger flagged by a validation Rule before storage. Action is what was done
+// with it: rejected, quarantined, only kept here with its JSON in Data, or annotated, stored with its issue.
+type QualityIssue struct {
+	ID        uint      `gorm:"primary_key"`
+	Time      time.Time `gorm:"index:idx_market_issue;not null"` // of the record
+	MarketRef uint      `gorm:"index:idx_market_issue;not null"`
+	Kind      string    `gorm:"size:16"` // ticker, trade or book
+	Rule      string    `gorm:"size:32;index"`
+	Action    string    `gorm:"size:16"`
+	Detail    string
+	Data      string  `gorm:"type:text"`
+	Market    *Market `gorm:"foreignkey:MarketRef;association_save_reference:false"`
+}
+
+// SourceEvent is a change of state of the source of the market data of an exchanger, or of one of its markets:
+// started or stopped, a market failing to be fetched then recovered, a stream disconnected then reconnected.
+// MarketRef is 0 for the whole exchanger.
+type SourceEvent struct {
+	ID        uint      `gorm:"primary_key"`
+	Time      time.Time `gorm:"not null"`
+	Exchanger string    `gorm:"size:64;not null"`
+	MarketRef uint
+	Kind      string `gorm:"size:32"`
+	Detail    string
+}
+
+// BookMetrics are the measures of an order book snapshot of a market. The depths are the volumes of the levels
+// within Band, a rate, of Mid, Imbalance is (BidDepth - AskDepth) / (BidDepth + AskDepth), from -1 to 1.
+// Microprice is the best bid and ask weighted by the volume on the other side. The slippages are the costs of the
+// market orders buying and selling Notional, in the pricing currency, against Mid as a rate,
+// null when the book is not deep enough.
+type BookMetrics struct {
+	ID           uint                `gorm:"primary_key"`
+	Time         time.Time           `gorm:"unique_index:idx_market_bookmetrics;not null"`
+	MarketRef    uint                `gorm:"unique_index:idx_market_bookmetrics;not null"`
+	Mid          decimal.Decimal     `gorm:"type:decimal(36,18)"`
+	Spread       decimal.Decimal     `gorm:"type:decimal(36,18)"`
+	Microprice   decimal.Decimal     `gorm:"type:decimal(36,18)"`
+	Band         decimal.Decimal     `gorm:"type:decimal(36,18)"`
+	BidDepth     decimal.Decimal     `gorm:"type:decimal(36,18)"`
+	AskDepth     decimal.Decimal     `gorm:"type:decimal(36,18)"`
+	Imbalance    decimal.Decimal     `gorm:"type:decimal(36,18)"`
+	Notional     decimal.Decimal     `gorm:"type:decimal(36,18)"`
+	BuySlippage  decimal.NullDecimal `gorm:"type:decimal(36,18)"`
+	SellSlippage decimal.NullDecimal `gorm:"type:decimal(36,18)"`
+	Market       *Market             `gorm:"foreignkey:MarketRef;association_save_reference:false"`
+}
+
+// the kinds of the source events
+const (
+	EventStarted        = "started"
+	EventStopped        = "stopped"
+	EventFetchFailed    = "fetch_failed"
+	EventFetchRecovered = "fetch_recovered"
+	EventDisconnected   = "disconnected"
+	EventReconnected    = "reconnected"
+)
+
+// MarketNames returns the exchanger and market names of a record of the market m or of the id ref,
+// unknown and market-<ref> when m does not tell them
+func MarketNames(ref uint, m *Market) (ex, market string) {
+	ex, market = "unknown", "market-"+strconv.FormatUint(uint64(ref), 10)
+	if m == nil {
+		return
+	}
+	if m.Exchanger != nil && m.Exchanger.Name != "" {
+		ex = m.Exchanger.Name
+	}
+	if m.Name != "" {
+		market = m.Name
+	}
+	return
 }
 
 func (s *Symbol) ParseString(str string) error {
@@ -0,0 +1,352 @@
+// Package completeness finds the gaps of the stored market data: the periods without any record of a kind
+// longer than its cadence, the tickers of the fetch cycles, the trades and the candles of an interval.
+// The gaps are matched with the source events of their exchanger, their causes, and summed up by market and day.
+//
+// The candles missing while the trades are stored can be rebuilt, see Backfill. The exchanger APIs only give
+// the latest tickers and trades, their gaps are not fillable.
+package completeness
+
+import (
+	"sort"
+	"time"
+
+	"github.com/exchangedata/candle"
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/database"
+)
+
+// the causes of the gaps besides the failures of the source events
+const (
+	CauseStopped = "stopped" // the exchanger was not running
+)
+
+// lookback is how far before the range the source events are read, for the failures started before
+const lookback = 24 * time.Hour
+
+// Options select the markets, range and cadences of a Scan
+type Options struct {
+	From, To  time.Time
+	Exchanger string            // all the exchangers if empty
+	Market    string            // all the markets if empty
+	Loc       *time.Location    // of the days, UTC if nil
+	Tickers   time.Duration     // longest time between two tickers, the tickers are not checked if 0
+	Trades    time.Duration     // longest time between two trades, the trades are not checked if 0
+	Candles   []candle.Interval // the candles of each interval are checked
+}
+
+// DefaultOptions checks the tickers of the fetch cycles and the trades of the range [from, to)
+func DefaultOptions(from, to time.Time) Options {
+	return Options{From: from, To: to, Tickers: time.Minute, Trades: time.Hour}
+}
+
+// Gap is a period without records of Kind, ticker, trade or candle:<interval>, in a market
+type Gap struct {
+	Exchanger string
+	Market    string
+	MarketRef uint
+	Kind      string
+	From, To  time.Time
+	Causes    []string // the failures during the gap, like fetch_failed, disconnected or stopped
+	Fillable  bool     // candles missing while the trades are stored
+}
+
+// Day is the completeness of the records of Kind of a market during a day
+type Day struct {
+	Exchanger    string
+	Market       string
+	MarketRef    uint
+	Kind         string
+	Day          time.Time // midnight in the time zone of the scan
+	Records      int
+	Gaps         int
+	Missing      time.Duration
+	Completeness float64 // share of the day, within the range, with records
+}
+
+// Report is the result of a Scan
+type Report struct {
+	From, To time.Time
+	Days     []*Day
+	Gaps     []*Gap
+}
+
+// check is a kind of record and its cadence
+type check struct {
+	kind      string // of the gaps
+	record    string // database.KindTicker, KindTrade or KindCandle
+	interval  *candle.Interval
+	threshold time.Duration
+}
+
+// Scan reports the gaps of the markets of o in the range [o.From, o.To)
+func Scan(ds *database.DataStore, o Options) (*Report, error) {
+	loc := o.Loc
+	if loc == nil {
+		loc = time.UTC
+	}
+	var checks []check
+	if o.Tickers > 0 {
+		checks = append(checks, check{kind: database.KindTicker, record: database.KindTicker, threshold: o.Tickers})
+	}
+	if o.Trades > 0 {
+		checks = append(checks, check{kind: database.KindTrade, record: database.KindTrade, threshold: o.Trades})
+	}
+	for k := range o.Candles {
+		i := o.Candles[k]
+		checks = append(checks, check{kind: database.KindCandle + ":" + i.String(), record: database.KindCandle, interval: &i})
+	}
+	markets, err := ds.Markets(database.MarketFilter{Exchanger: o.Exchanger, Name: o.Market})
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{From: o.From, To: o.To}
+	events := make(map[string]*history)
+	for _, m := range markets {
+		ex := ""
+		if m.Exchanger != nil {
+			ex = m.Exchanger.Name
+		}
+		h := events[ex]
+		if h == nil {
+			c, err := ds.SourceEvents(ex, o.From.Add(-lookback), o.To)
+			if err != nil {
+				return nil, err
+			}
+			h = newHistory(c, o.To)
+			events[ex] = h
+		}
+		for _, c := range checks {
+			days := newDays(ex, m, c.kind, o.From, o.To, loc)
+			gaps, err := scanGaps(ds, m, c, o.From, o.To, loc, days)
+			if err != nil {
+				return nil, err
+			}
+			for _, g := range gaps {
+				g.Exchanger, g.Market, g.MarketRef = ex, m.Name, m.ID
+				g.Causes = h.causes(m.ID, g.From, g.To)
+				if c.interval != nil {
+					n, err := ds.CountRecords(database.KindTrade, m.ID, "", g.From, g.To)
+					if err != nil {
+						return nil, err
+					}
+					g.Fillable = n > 0
+				}
+				for _, d := range days {
+					if missing := overlap(d.Day.Day, d.end, g.From, g.To); missing > 0 {
+						d.Gaps++
+						d.Missing += missing
+					}
+				}
+			}
+			for _, d := range days {
+				if l := overlap(d.Day.Day, d.end, o.From, o.To); l > 0 {
+					d.Completeness = 1 - float64(d.Missing)/float64(l)
+				}
+				r.Days = append(r.Days, &d.Day)
+			}
+			r.Gaps = append(r.Gaps, gaps...)
+		}
+	}
+	return r, nil
+}
+
+// day is a Day and its end
+type day struct {
+	Day
+	end time.Time
+}
+
+func newDays(ex string, m *common.Market, kind string, from, to time.Time, loc *time.Location) []*day {
+	var days []*day
+	start := from.In(loc)
+	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+	for start.Before(to) {
+		end := start.AddDate(0, 0, 1)
+		days = append(days, &day{Day: Day{Exchanger: ex, Market: m.Name, MarketRef: m.ID, Kind: kind, Day: start, Completeness: 1}, end: end})
+		start = end
+	}
+	return days
+}
+
+// scanGaps returns the gaps of the records of c of the market m, and counts the records of the days
+func scanGaps(ds *database.DataStore, m *common.Market, c check, from, to time.Time, loc *time.Location, days []*day) ([]*Gap, error) {
+	var gaps []*Gap
+	// since is the end of the covered time, due when a record is late
+	since, due := from, from.Add(c.threshold)
+	if c.interval != nil {
+		since = c.interval.Start(from, loc)
+		if since.Before(from) {
+			since = c.interval.End(since, loc)
+		}
+		due = since
+	}
+	k := 0
+	err := ds.ScanTimes(c.record, m.ID, intervalName(c), from, to, func(t time.Time) error {
+		if t.After(due) {
+			gaps = append(gaps, &Gap{Kind: c.kind, From: since, To: t})
+		}
+		for k < len(days)-1 && !t.Before(days[k].end) {
+			k++
+		}
+		days[k].Records++
+		if c.interval != nil {
+			since = c.interval.End(t, loc)
+			due = since
+		} else {
+			since, due = t, t.Add(c.threshold)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	end := due
+	if c.interval != nil {
+		end = c.interval.End(due, loc) // the last bucket is complete
+	}
+	if !end.After(to) && since.Before(to) {
+		gaps = append(gaps, &Gap{Kind: c.kind, From: since, To: to})
+	}
+	return gaps, nil
+}
+
+func intervalName(c check) string {
+	if c.interval == nil {
+		return ""
+	}
+	return c.interval.String()
+}
+
+// overlap returns the length of the intersection of [a0, a1) and [b0, b1)
+func overlap(a0, a1, b0, b1 time.Time) time.Duration {
+	if b0.After(a0) {
+		a0 = b0
+	}
+	if b1.Before(a1) {
+		a1 = b1
+	}
+	if !a1.After(a0) {
+		return 0
+	}
+	return a1.Sub(a0)
+}
+
+// history is the periods of failure of an exchanger from its source events
+type history struct {
+	periods []*period
+}
+
+type period struct {
+	cause     string
+	marketRef uint // 0 for all the markets
+	from, to  time.Time
+}
+
+// slack is how late a start may be after a gap caused by the stop
+const slack = time.Minute
+
+func newHistory(c []*common.SourceEvent, end time.Time) *history {
+	h := &history{}
+	type key struct {
+		kind      string
+		marketRef uint
+	}
+	open := make(map[key]*period)
+	var stopped *period
+	var last time.Time // of the last event of the exchanger running
+	for _, e := range c {
+		switch e.Kind {
+		case common.EventFetchFailed, common.EventDisconnected:
+			if open[key{e.Kind, e.MarketRef}] == nil {
+				p := &period{cause: e.Kind, marketRef: e.MarketRef, from: e.Time, to: end}
+				open[key{e.Kind, e.MarketRef}] = p
+				h.periods = append(h.periods, p)
+			}
+		case common.EventFetchRecovered, common.EventReconnected:
+			failed := common.EventFetchFailed
+			if e.Kind == common.EventReconnected {
+				failed = common.EventDisconnected
+			}
+			if p := open[key{failed, e.MarketRef}]; p != nil {
+				p.to = e.Time
+				delete(open, key{failed, e.MarketRef})
+			}
+		case common.EventStopped:
+			stopped = &period{cause: CauseStopped, from: e.Time, to: end}
+			h.periods = append(h.periods, stopped)
+		case common.EventStarted:
+			if stopped != nil {
+				stopped.to = e.Time
+				stopped = nil
+			} else if !last.IsZero() { // stopped without its event, from the last sign of life
+				h.periods = append(h.periods, &period{cause: CauseStopped, from: last, to: e.Time})
+			}
+			// the failures are followed again after a start
+			for k, p := range open {
+				p.to = e.Time
+				delete(open, k)
+			}
+		}
+		last = e.Time
+	}
+	return h
+}
+
+// causes returns the causes of the failures of the market marketRef during [from, to)
+func (h *history) causes(marketRef uint, from, to time.Time) []string {
+	found := make(map[string]bool)
+	for _, p := range h.periods {
+		if p.marketRef != 0 && p.marketRef != marketRef {
+			continue
+		}
+		if p.from.Before(to.Add(slack)) && p.to.After(from) {
+			found[p.cause] = true
+		}
+	}
+	c := make([]string, 0, len(found))
+	for cause := range found {
+		c = append(c, cause)
+	}
+	sort.Strings(c)
+	return c
+}
+
+// Backfill rebuilds from the stored trades the candles of the fillable gaps of r, it returns the number of candles
+// written
+func Backfill(ds *database.DataStore, r *Report, loc *time.Location) (int, error) {
+	ranges := make(map[string][]*Gap) // by interval
+	for _, g := range r.Gaps {
+		if g.Fillable {
+			ranges[g.Kind] = append(ranges[g.Kind], g)
+		}
+	}
+	n := 0
+	for kind, gaps := range ranges {
+		i, err := candle.ParseInterval(kind[len(database.KindCandle)+1:])
+		if err != nil {
+			return n, err
+		}
+		sort.Slice(gaps, func(a, b int) bool { return gaps[a].From.Before(gaps[b].From) })
+		// the gaps of all the markets are merged, Rebuild covers the markets of the range
+		from, to := gaps[0].From, gaps[0].To
+		for _, g := range gaps[1:] {
+			if g.From.After(to) {
+				c, err := candle.Rebuild(ds, loc, from, to, i)
+				n += c
+				if err != nil {
+					return n, err
+				}
+				from = g.From
+			}
+			if g.To.After(to) {
+				to = g.To
+			}
+		}
+		c, err := candle.Rebuild(ds, loc, from, to, i)
+		n += c
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
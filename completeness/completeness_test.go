@@ -0,0 +1,105 @@
+package completeness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/exchangedata/candle"
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/database"
+	"github.com/shopspring/decimal"
+)
+
+var dec = decimal.RequireFromString
+
+func TestScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edcompleteness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ds := database.NewDataStore("sqlite3")
+	ds.Name = filepath.Join(dir, "test.db")
+	if err = ds.OpenDB(); err != nil {
+		t.Fatal("open db", err)
+	}
+	defer ds.CloseDB()
+	if err = ds.Migrate(); err != nil {
+		t.Fatal("migrate db", err)
+	}
+
+	// two hours over midnight, the tickers missing from 23:30 to 00:30
+	start := time.Date(2020, 3, 1, 23, 0, 0, 0, time.UTC)
+	at := func(min float64) time.Time { return start.Add(time.Duration(min * float64(time.Minute))) }
+	m := &common.Market{Name: "USDT-BTC", Symbol: &common.Symbol{Base: &common.Currency{Name: "Tether", Abbr: "USDT"},
+		Quote: &common.Currency{Name: "Bitcoin", Abbr: "BTC"}}, Exchanger: &common.Exchanger{Name: "bittrex"}}
+	w := ds.NewBatchWriter(0, 0)
+	for k := 0.0; k < 120; k += 0.5 {
+		if k <= 30 || k >= 90 {
+			w.AddTicker(&common.Ticker{Time: at(k), Market: m, Last: dec("100"), Bid: dec("99"), Ask: dec("101")})
+		}
+	}
+	w.AddTrade(&common.Trade{Time: at(40), Market: m, OrderID: "1", Price: dec("100"), Amount: dec("1")})
+	w.AddTrade(&common.Trade{Time: at(50), Market: m, OrderID: "2", Price: dec("101"), Amount: dec("1")})
+	if err = w.Close(); err != nil {
+		t.Fatal("flush", err)
+	}
+	err = ds.InsertSourceEvents([]*common.SourceEvent{
+		{Time: at(-60), Exchanger: "bittrex", Kind: common.EventStarted},
+		{Time: at(31), Exchanger: "bittrex", MarketRef: m.ID, Kind: common.EventFetchFailed, Detail: "timeout"},
+		{Time: at(89), Exchanger: "bittrex", MarketRef: m.ID, Kind: common.EventFetchRecovered},
+		{Time: at(115), Exchanger: "bittrex", Kind: common.EventStopped},
+	})
+	if err != nil {
+		t.Fatal("insert events", err)
+	}
+
+	o := DefaultOptions(start, at(120))
+	o.Candles, _ = candle.ParseIntervals("1h")
+	r, err := Scan(ds, o)
+	if err != nil {
+		t.Fatal("scan", err)
+	}
+	if len(r.Gaps) != 3 {
+		t.Fatal("wrong gaps", len(r.Gaps))
+	}
+	g := r.Gaps[0]
+	if g.Kind != database.KindTicker || !g.From.Equal(at(30)) || !g.To.Equal(at(90)) ||
+		!reflect.DeepEqual(g.Causes, []string{common.EventFetchFailed}) || g.Fillable {
+		t.Error("wrong ticker gap", g)
+	}
+	g = r.Gaps[1]
+	if g.Kind != database.KindTrade || !g.From.Equal(at(50)) || !g.To.Equal(at(120)) ||
+		!reflect.DeepEqual(g.Causes, []string{common.EventFetchFailed, CauseStopped}) {
+		t.Error("wrong trade gap", g)
+	}
+	g = r.Gaps[2]
+	if g.Kind != "candle:1h" || !g.From.Equal(start) || !g.To.Equal(at(120)) || !g.Fillable {
+		t.Error("wrong candle gap", g)
+	}
+
+	if len(r.Days) != 6 {
+		t.Fatal("wrong days", len(r.Days))
+	}
+	for k, records := range []int{61, 60} {
+		d := r.Days[k]
+		if d.Kind != database.KindTicker || d.Records != records || d.Gaps != 1 || d.Missing != 30*time.Minute || d.Completeness != 0.5 {
+			t.Error("wrong ticker day", k, d)
+		}
+	}
+	if !r.Days[1].Day.Equal(time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Error("wrong second day", r.Days[1].Day)
+	}
+
+	n, err := Backfill(ds, r, nil)
+	if err != nil || n != 1 {
+		t.Fatal("backfill", n, err)
+	}
+	if r, err = Scan(ds, o); err != nil || len(r.Gaps) != 3 || !r.Gaps[2].From.Equal(at(60)) || r.Gaps[2].Fillable {
+		t.Fatal("candle gap after the backfill", r.Gaps[2], err)
+	}
+}
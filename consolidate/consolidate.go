@@ -0,0 +1,282 @@
+// Package consolidate combines the quotes of the symbols traded on several exchangers: the markets
+// of the same common.Symbol, the idx_sym_ex index of common.Market, make one ConsolidatedTicker with the global
+// best bid and ask, the volume weighted average price of the recent trades and the spread of each exchanger.
+package consolidate
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/sink"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// DefaultMaxAge is the age of the quotes left out of the consolidation
+	DefaultMaxAge = time.Minute
+	// DefaultFlushInterval is the period of the writes of the changed symbols
+	DefaultFlushInterval = time.Second
+)
+
+// Consolidator takes the tickers, trades and order books of the exchangers as a sink.Sink, and writes the consolidated
+// tickers of the changed symbols to out. The order books give the best prices, the tickers the last prices and
+// volumes, and the best prices of the markets without book, the trades of the last MaxAge the VWAP.
+type Consolidator struct {
+	MaxAge    time.Duration // set before the first record
+	MinVenues int           // the symbols quoted by fewer exchangers are left out, 2 by default
+
+	out sink.ConsolidatedWriter
+
+	mu      sync.Mutex
+	symbols map[string]*symbolState // by name, BASE_QUOTE
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type symbolState struct {
+	ref    uint
+	symbol *common.Symbol
+	venues map[uint]*venue // by market id
+	dirty  bool
+}
+
+// venue is the last quote of a market
+type venue struct {
+	exchanger string
+	time      time.Time // of the best prices
+	bid, bidVolume,
+	ask, askVolume decimal.Decimal
+	tickerTime   time.Time // of the last price and volume
+	last, volume decimal.Decimal
+	trades       []*common.Trade // of the last MaxAge
+	orders       map[string]bool // the OrderIDs of trades, a trade written again is counted once
+	from         time.Time       // the trades before are dropped, they are out of the last consolidation
+}
+
+// NewConsolidator writes the changed symbols to out every flushInterval, DefaultFlushInterval if 0.
+// A negative flushInterval leaves the writes to Flush. out belongs to the caller.
+func NewConsolidator(out sink.ConsolidatedWriter, flushInterval time.Duration) *Consolidator {
+	if flushInterval == 0 {
+		flushInterval = DefaultFlushInterval
+	}
+	c := &Consolidator{
+		MaxAge:    DefaultMaxAge,
+		MinVenues: 2,
+		out:       out,
+		symbols:   make(map[string]*symbolState),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if flushInterval < 0 {
+		close(c.done)
+		return c
+	}
+	go c.run(flushInterval)
+	return c
+}
+
+func (c *Consolidator) run(interval time.Duration) {
+	defer close(c.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := c.Flush(now); err != nil {
+				log.Println("consolidated write error:", err)
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// venue returns the quote of the market m, nil if the symbol of m is unknown
+func (c *Consolidator) venue(m *common.Market) (*symbolState, *venue) {
+	if m == nil || m.ID == 0 || m.Symbol == nil || m.Symbol.Base == nil || m.Symbol.Quote == nil {
+		return nil, nil
+	}
+	name := m.Symbol.String()
+	s := c.symbols[name]
+	if s == nil {
+		s = &symbolState{symbol: m.Symbol, venues: make(map[uint]*venue)}
+		c.symbols[name] = s
+	}
+	if s.ref == 0 {
+		if s.ref = m.SymRef; s.ref == 0 {
+			s.ref = m.Symbol.ID
+		}
+	}
+	v := s.venues[m.ID]
+	if v == nil {
+		v = &venue{orders: make(map[string]bool)}
+		if m.Exchanger != nil {
+			v.exchanger = m.Exchanger.Name
+		}
+		s.venues[m.ID] = v
+	}
+	return s, v
+}
+
+func (c *Consolidator) WriteTickers(r []*common.Ticker) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range r {
+		s, v := c.venue(t.Market)
+		if v == nil || t.Time.Before(v.tickerTime) {
+			continue
+		}
+		v.tickerTime, v.last, v.volume = t.Time, t.Last, t.BaseVolume
+		if (!t.Bid.IsZero() || !t.Ask.IsZero()) && !t.Time.Before(v.time) {
+			v.time, v.bid, v.bidVolume, v.ask, v.askVolume = t.Time, t.Bid, t.BidVolume, t.Ask, t.AskVolume
+		}
+		s.dirty = true
+	}
+	return nil
+}
+
+func (c *Consolidator) WriteOrderBooks(r []*common.OrderBook) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, b := range r {
+		s, v := c.venue(b.Market)
+		if v == nil || b.Time.Before(v.time) {
+			continue
+		}
+		v.time = b.Time
+		v.bid, v.bidVolume, v.ask, v.askVolume = decimal.Zero, decimal.Zero, decimal.Zero, decimal.Zero
+		if len(b.Bids) > 0 {
+			v.bid, v.bidVolume = b.Bids[0].Price, b.Bids[0].Volume
+		}
+		if len(b.Asks) > 0 {
+			v.ask, v.askVolume = b.Asks[0].Price, b.Asks[0].Volume
+		}
+		s.dirty = true
+	}
+	return nil
+}
+
+func (c *Consolidator) WriteTrades(r []*common.Trade) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range r {
+		s, v := c.venue(t.Market)
+		if v == nil || !t.Price.IsPositive() || !t.Amount.IsPositive() || t.Time.Before(v.from) {
+			continue
+		}
+		if t.OrderID != "" {
+			if v.orders[t.OrderID] {
+				continue
+			}
+			v.orders[t.OrderID] = true
+		}
+		v.trades = append(v.trades, t)
+		s.dirty = true
+	}
+	return nil
+}
+
+func (c *Consolidator) WriteCandles(r []*common.Candle) error { return nil }
+
+// Flush writes the consolidated tickers at now of the symbols changed since the last write.
+// The quotes older than MaxAge at now are left out.
+func (c *Consolidator) Flush(now time.Time) error {
+	c.mu.Lock()
+	var out []*common.ConsolidatedTicker
+	for _, s := range c.symbols {
+		if !s.dirty {
+			continue
+		}
+		s.dirty = false
+		if t := c.consolidate(s, now); t != nil {
+			out = append(out, t)
+		}
+	}
+	c.mu.Unlock()
+	if len(out) == 0 {
+		return nil
+	}
+	return c.out.WriteConsolidated(out)
+}
+
+// consolidate returns the ticker of s at now, nil if too few exchangers quote it.
+// The best prices and the last prices are left out once older than MaxAge, each on its own.
+func (c *Consolidator) consolidate(s *symbolState, now time.Time) *common.ConsolidatedTicker {
+	t := &common.ConsolidatedTicker{Time: now, SymRef: s.ref, Symbol: s.symbol}
+	var traded, amount decimal.Decimal
+	for ref, v := range s.venues {
+		v.dropTrades(now.Add(-c.MaxAge))
+		for _, tr := range v.trades {
+			traded, amount = traded.Add(tr.Price.Mul(tr.Amount)), amount.Add(tr.Amount)
+		}
+		quoted, ticked := now.Sub(v.time) <= c.MaxAge, now.Sub(v.tickerTime) <= c.MaxAge
+		if !quoted && !ticked {
+			continue
+		}
+		q := &common.VenueQuote{MarketRef: ref, Exchanger: v.exchanger}
+		if quoted {
+			q.Time, q.Bid, q.BidVolume, q.Ask, q.AskVolume = v.time, v.bid, v.bidVolume, v.ask, v.askVolume
+		}
+		if ticked {
+			q.Last, q.Volume = v.last, v.volume
+			if v.tickerTime.After(q.Time) {
+				q.Time = v.tickerTime
+			}
+		}
+		if q.Bid.IsPositive() && q.Ask.IsPositive() {
+			q.Spread = q.Ask.Sub(q.Bid)
+		}
+		t.Venues = append(t.Venues, q)
+		if q.Bid.IsPositive() && (t.Bid.IsZero() || q.Bid.GreaterThan(t.Bid)) {
+			t.Bid, t.BidVolume, t.BidExchanger = q.Bid, q.BidVolume, v.exchanger
+		}
+		if q.Ask.IsPositive() && (t.Ask.IsZero() || q.Ask.LessThan(t.Ask)) {
+			t.Ask, t.AskVolume, t.AskExchanger = q.Ask, q.AskVolume, v.exchanger
+		}
+		if q.Volume.IsPositive() {
+			t.Volume = t.Volume.Add(q.Volume)
+		}
+	}
+	if len(t.Venues) < c.MinVenues {
+		return nil
+	}
+	if amount.IsPositive() {
+		t.VWAP = traded.DivRound(amount, 18)
+	}
+	sort.Slice(t.Venues, func(i, j int) bool { return t.Venues[i].Exchanger < t.Venues[j].Exchanger })
+	return t
+}
+
+// dropTrades drops the trades of v before from with their OrderIDs, the later writes of them are skipped
+func (v *venue) dropTrades(from time.Time) {
+	if from.After(v.from) {
+		v.from = from
+	}
+	kept := v.trades[:0]
+	for _, t := range v.trades {
+		if !t.Time.Before(from) {
+			kept = append(kept, t)
+		} else {
+			delete(v.orders, t.OrderID)
+		}
+	}
+	for k := len(kept); k < len(v.trades); k++ {
+		v.trades[k] = nil
+	}
+	v.trades = kept
+}
+
+// Close stops the periodic writes and writes the changed symbols
+func (c *Consolidator) Close() error {
+	select {
+	case <-c.done:
+	default:
+		close(c.stop)
+		<-c.done
+	}
+	return c.Flush(time.Now())
+}
@@ -0,0 +1,179 @@
+package consolidate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/database"
+	"github.com/shopspring/decimal"
+)
+
+var dec = decimal.RequireFromString
+
+type consolidatedSink struct {
+	tickers []*common.ConsolidatedTicker
+}
+
+func (s *consolidatedSink) WriteConsolidated(c []*common.ConsolidatedTicker) error {
+	s.tickers = append(s.tickers, c...)
+	return nil
+}
+
+var start = time.Date(2018, 11, 26, 0, 0, 0, 0, time.UTC)
+
+func market(id uint, ex string, sym *common.Symbol) *common.Market {
+	return &common.Market{ID: id, Name: sym.String(), Symbol: sym, SymRef: sym.ID, Exchanger: &common.Exchanger{Name: ex}}
+}
+
+func TestConsolidator(t *testing.T) {
+	sym := &common.Symbol{ID: 1, Base: &common.Currency{Abbr: "BTC"}, Quote: &common.Currency{Abbr: "USDT"}}
+	bittrex, binance, poloniex := market(1, "bittrex", sym), market(2, "binance", sym), market(3, "poloniex", sym)
+	out := &consolidatedSink{}
+	c := NewConsolidator(out, -1)
+
+	c.WriteTickers([]*common.Ticker{{Time: start, Market: bittrex, Bid: dec("99"), Ask: dec("101"), Last: dec("100"), BaseVolume: dec("1")}})
+	c.Flush(start.Add(time.Second))
+	if len(out.tickers) != 0 {
+		t.Fatal("one exchanger consolidated", out.tickers)
+	}
+
+	c.WriteOrderBooks([]*common.OrderBook{{Time: start, Market: binance,
+		Bids: []*common.PriceVol{{Price: dec("100"), Volume: dec("2")}}, Asks: []*common.PriceVol{{Price: dec("102"), Volume: dec("3")}}}})
+	c.WriteTickers([]*common.Ticker{{Time: start, Market: binance, Last: dec("103"), BaseVolume: dec("2")}})
+	c.WriteTrades([]*common.Trade{{Time: start.Add(-2 * time.Minute), Market: bittrex, Price: dec("90"), Amount: dec("5")},
+		{Time: start, Market: bittrex, Price: dec("100"), Amount: dec("1")}, {Time: start, Market: binance, Price: dec("104"), Amount: dec("3")}})
+	c.Flush(start.Add(time.Second))
+	if len(out.tickers) != 1 {
+		t.Fatal("not consolidated", out.tickers)
+	}
+	ct := out.tickers[0]
+	if !ct.Bid.Equal(dec("100")) || ct.BidExchanger != "binance" || !ct.Ask.Equal(dec("101")) || ct.AskExchanger != "bittrex" ||
+		!ct.VWAP.Equal(dec("103")) || !ct.Volume.Equal(dec("3")) || ct.SymRef != 1 {
+		t.Fatal("wrong consolidated ticker", ct)
+	}
+	if len(ct.Venues) != 2 || ct.Venues[0].Exchanger != "binance" || !ct.Venues[0].Spread.Equal(dec("2")) || !ct.Venues[1].Spread.Equal(dec("2")) {
+		t.Fatal("wrong venues", ct.Venues)
+	}
+
+	// unchanged symbols are not written, stale quotes are left out
+	out.tickers = nil
+	c.Flush(start.Add(2 * time.Second))
+	c.WriteTickers([]*common.Ticker{{Time: start.Add(2 * time.Minute), Market: poloniex, Bid: dec("98"), Ask: dec("99")}})
+	c.Flush(start.Add(2 * time.Minute))
+	if len(out.tickers) != 0 {
+		t.Fatal("stale quotes consolidated", out.tickers)
+	}
+	c.WriteTickers([]*common.Ticker{{Time: start.Add(2 * time.Minute), Market: bittrex, Bid: dec("97"), Ask: dec("100")}})
+	c.Flush(start.Add(2 * time.Minute))
+	if len(out.tickers) != 1 || !out.tickers[0].Bid.Equal(dec("98")) || out.tickers[0].AskExchanger != "poloniex" {
+		t.Fatal("wrong consolidated ticker", out.tickers)
+	}
+	c.Close()
+}
+
+func TestRepeatedTrades(t *testing.T) {
+	sym := &common.Symbol{ID: 1, Base: &common.Currency{Abbr: "BTC"}, Quote: &common.Currency{Abbr: "USDT"}}
+	bittrex, binance := market(1, "bittrex", sym), market(2, "binance", sym)
+	out := &consolidatedSink{}
+	c := NewConsolidator(out, -1)
+
+	trades := []*common.Trade{{Time: start, Market: bittrex, OrderID: "1", Price: dec("100"), Amount: dec("1")},
+		{Time: start.Add(time.Second), Market: bittrex, OrderID: "2", Price: dec("103"), Amount: dec("2")},
+		{Time: start.Add(2 * time.Second), Market: bittrex, OrderID: "3", Price: dec("106"), Amount: dec("1")}}
+	// the market history of each fetch cycle repeats the trades of the previous ones
+	for k, batch := range [][]*common.Trade{trades[:2], trades[:2], trades} {
+		c.WriteTickers([]*common.Ticker{{Time: start, Market: bittrex, Bid: dec("99"), Ask: dec("101")},
+			{Time: start, Market: binance, Bid: dec("98"), Ask: dec("102")}})
+		c.WriteTrades(batch)
+		c.Flush(start.Add(time.Duration(k+2) * time.Second))
+	}
+	if len(out.tickers) != 3 || !out.tickers[0].VWAP.Equal(dec("102")) || !out.tickers[1].VWAP.Equal(dec("102")) ||
+		!out.tickers[2].VWAP.Equal(dec("103")) || len(c.symbols["BTC_USDT"].venues[1].trades) != 3 {
+		t.Fatal("repeated trades counted again", out.tickers)
+	}
+	// nor once out of the window
+	c.Flush(start.Add(2 * time.Minute))
+	c.WriteTrades(trades)
+	c.WriteTickers([]*common.Ticker{{Time: start.Add(2 * time.Minute), Market: bittrex, Bid: dec("99"), Ask: dec("101")},
+		{Time: start.Add(2 * time.Minute), Market: binance, Bid: dec("98"), Ask: dec("102")}})
+	c.Flush(start.Add(2 * time.Minute))
+	if ct := out.tickers[len(out.tickers)-1]; !ct.VWAP.IsZero() || len(c.symbols["BTC_USDT"].venues[1].trades) != 0 {
+		t.Fatal("old trades written again counted", ct)
+	}
+}
+
+func TestStaleTicker(t *testing.T) {
+	sym := &common.Symbol{ID: 1, Base: &common.Currency{Abbr: "BTC"}, Quote: &common.Currency{Abbr: "USDT"}}
+	bittrex, binance := market(1, "bittrex", sym), market(2, "binance", sym)
+	out := &consolidatedSink{}
+	c := NewConsolidator(out, -1)
+
+	c.WriteTickers([]*common.Ticker{{Time: start, Market: bittrex, Bid: dec("99"), Ask: dec("101"), Last: dec("100"), BaseVolume: dec("1")},
+		{Time: start, Market: binance, Bid: dec("98"), Ask: dec("102"), Last: dec("100"), BaseVolume: dec("2")}})
+	// the books of binance go on, its ticker and the trades get older than MaxAge
+	later := start.Add(2 * time.Minute)
+	c.WriteOrderBooks([]*common.OrderBook{{Time: later, Market: binance,
+		Bids: []*common.PriceVol{{Price: dec("100"), Volume: dec("2")}}, Asks: []*common.PriceVol{{Price: dec("103"), Volume: dec("3")}}}})
+	c.WriteTickers([]*common.Ticker{{Time: later, Market: bittrex, Last: dec("101"), BaseVolume: dec("1")}})
+	c.WriteTrades([]*common.Trade{{Time: start, Market: binance, Price: dec("100"), Amount: dec("1")}})
+	c.Flush(later)
+	if len(out.tickers) != 1 {
+		t.Fatal("not consolidated", out.tickers)
+	}
+	ct := out.tickers[0]
+	if !ct.Volume.Equal(dec("1")) || !ct.VWAP.IsZero() || !ct.Bid.Equal(dec("100")) || !ct.Ask.Equal(dec("103")) {
+		t.Fatal("stale ticker consolidated", ct)
+	}
+	for _, q := range ct.Venues {
+		if q.Exchanger == "binance" && (!q.Last.IsZero() || !q.Volume.IsZero() || !q.Time.Equal(later)) {
+			t.Fatal("stale last price of binance", q)
+		}
+		if q.Exchanger == "bittrex" && (!q.Bid.IsZero() || !q.Last.Equal(dec("101"))) {
+			t.Fatal("stale best prices of bittrex", q)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edconsolidate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ds := database.NewDataStore("sqlite3")
+	ds.Name = filepath.Join(dir, "test.db")
+	if err = ds.OpenDB(); err != nil {
+		t.Fatal("open db", err)
+	}
+	defer ds.CloseDB()
+	if err = ds.Migrate(); err != nil {
+		t.Fatal("migrate db", err)
+	}
+
+	sym := &common.Symbol{ID: 1, Base: &common.Currency{Name: "bitcoin", Abbr: "BTC"}, Quote: &common.Currency{Name: "tether", Abbr: "USDT"}}
+	if err = ds.GetDB().Create(sym).Error; err != nil {
+		t.Fatal("symbol", err)
+	}
+	ct := &common.ConsolidatedTicker{Time: start, Symbol: sym, Bid: dec("100"), Ask: dec("101"), Venues: []*common.VenueQuote{
+		{MarketRef: 1, Exchanger: "binance", Bid: dec("100"), Ask: dec("102"), Spread: dec("2")},
+		{MarketRef: 2, Exchanger: "bittrex", Bid: dec("99"), Ask: dec("101"), Spread: dec("2")},
+	}}
+	if err = ds.InsertConsolidated([]*common.ConsolidatedTicker{ct}); err != nil {
+		t.Fatal("insert", err)
+	}
+	// written again by a restarted consolidator
+	if err = ds.InsertConsolidated([]*common.ConsolidatedTicker{{Time: start, SymRef: sym.ID, Venues: ct.Venues}}); err != nil {
+		t.Fatal("insert again", err)
+	}
+	c, _, err := ds.ConsolidatedTickers(sym.ID, start, start.Add(time.Hour), nil, 10)
+	if err != nil || len(c) != 1 {
+		t.Fatal("consolidated tickers", c, err)
+	}
+	if !c[0].Bid.Equal(dec("100")) || len(c[0].Venues) != 2 || c[0].Symbol == nil || c[0].Symbol.String() != "BTC_USDT" {
+		t.Fatal("wrong consolidated ticker", c[0])
+	}
+}
@@ -0,0 +1,245 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TableStats is the number of rows of a table and its size in bytes with its indexes, -1 if the dialect does not tell
+type TableStats struct {
+	Table string
+	Rows  int64
+	Size  int64
+}
+
+// Tables returns the names of the tables of the store, the join tables included
+func (d *DataStore) Tables() []string {
+	var c []string
+	for _, m := range models {
+		c = append(c, d.db.NewScope(m).TableName())
+	}
+	return append(c, "currency_exchangers")
+}
+
+// Stats returns the stats of the tables of the store
+func (d *DataStore) Stats() ([]*TableStats, error) {
+	sizes, err := d.tableSizes()
+	if err != nil {
+		return nil, err
+	}
+	var c []*TableStats
+	for _, t := range d.Tables() {
+		s := &TableStats{Table: t, Size: -1}
+		if err := d.db.Table(t).Count(&s.Rows).Error; err != nil {
+			return nil, fmt.Errorf("count %s: %v", t, err)
+		}
+		if n, ok := sizes[t]; ok {
+			s.Size = n
+		}
+		c = append(c, s)
+	}
+	return c, nil
+}
+
+// tableSizes returns the sizes of the tables the dialect tells, those of the hypertables include their chunks.
+// sqlite tells them only when built with the dbstat table.
+func (d *DataStore) tableSizes() (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	var q string
+	switch d.Dialect {
+	case "mysql":
+		q = "SELECT table_name, data_length + index_length FROM information_schema.tables WHERE table_schema = DATABASE()"
+	case "postgres":
+		q = "SELECT tablename, pg_total_relation_size(quote_ident(tablename)) FROM pg_tables WHERE schemaname = current_schema()"
+	case "sqlite3":
+		q = "SELECT tbl_name, sum(pgsize) FROM dbstat JOIN sqlite_master ON dbstat.name = sqlite_master.name GROUP BY tbl_name"
+	default:
+		return sizes, nil
+	}
+	rows, err := d.db.Raw(q).Rows()
+	if err != nil {
+		if d.Dialect == "sqlite3" { // no dbstat
+			return sizes, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t string
+		var n int64
+		if err := rows.Scan(&t, &n); err != nil {
+			return nil, err
+		}
+		sizes[t] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if d.Dialect == "postgres" {
+		var ext int
+		if err := d.db.Raw("SELECT count(*) FROM pg_extension WHERE extname = 'timescaledb'").Row().Scan(&ext); err != nil || ext == 0 {
+			return sizes, err
+		}
+		for _, h := range hypertables {
+			var n int64
+			if err := d.db.Raw("SELECT hypertable_size(?::regclass)", h.table).Row().Scan(&n); err == nil {
+				sizes[h.table] = n
+			}
+		}
+	}
+	return sizes, nil
+}
+
+// Problem is a number of rows of a table failing an integrity check
+type Problem struct {
+	Check string
+	Table string
+	Rows  int64
+}
+
+// integrityChecks are the rows of table matching where, the references to missing rows mostly
+var integrityChecks = []struct {
+	check string
+	table string
+	where string
+}{
+	{"market without exchanger", "markets", "NOT EXISTS (SELECT 1 FROM exchangers x WHERE x.id = markets.ex_ref)"},
+	{"market without symbol", "markets", "NOT EXISTS (SELECT 1 FROM symbols x WHERE x.id = markets.sym_ref)"},
+	{"symbol without currency", "symbols", "NOT EXISTS (SELECT 1 FROM currencies x WHERE x.id = symbols.base_id) OR " +
+		"NOT EXISTS (SELECT 1 FROM currencies x WHERE x.id = symbols.quote_id)"},
+	{"ticker without market", "tickers", "NOT EXISTS (SELECT 1 FROM markets x WHERE x.id = tickers.market_ref)"},
+	{"trade without market", "trades", "NOT EXISTS (SELECT 1 FROM markets x WHERE x.id = trades.market_ref)"},
+	{"order book without market", "order_books", "NOT EXISTS (SELECT 1 FROM markets x WHERE x.id = order_books.market_ref)"},
+	{"book level without book", "book_levels", "NOT EXISTS (SELECT 1 FROM order_books x WHERE x.id = book_levels.book_ref)"},
+	{"book delta without book", "book_delta", "NOT EXISTS (SELECT 1 FROM order_books x WHERE x.id = book_delta.book_ref)"},
+	{"candle without market", "candles", "NOT EXISTS (SELECT 1 FROM markets x WHERE x.id = candles.market_ref)"},
+	{"candle high below low", "candles", "high < low"},
+	{"book metrics without market", "book_metrics", "NOT EXISTS (SELECT 1 FROM markets x WHERE x.id = book_metrics.market_ref)"},
+	{"consolidated ticker without symbol", "consolidated_tickers",
+		"NOT EXISTS (SELECT 1 FROM symbols x WHERE x.id = consolidated_tickers.sym_ref)"},
+	{"venue quote without ticker", "venue_quotes",
+		"NOT EXISTS (SELECT 1 FROM consolidated_tickers x WHERE x.id = venue_quotes.ticker_ref)"},
+	{"issue without market", "quality_issues", "NOT EXISTS (SELECT 1 FROM markets x WHERE x.id = quality_issues.market_ref)"},
+	{"alert rule without market", "alert_rules", "NOT EXISTS (SELECT 1 FROM markets x WHERE x.id = alert_rules.market_ref)"},
+	{"alert without rule", "alerts", "NOT EXISTS (SELECT 1 FROM alert_rules x WHERE x.id = alerts.rule_ref)"},
+}
+
+// Check returns the integrity problems of the store: the rows referring to missing rows, the candles with their
+// high below their low, and on sqlite the corruptions of the database file
+func (d *DataStore) Check() ([]*Problem, error) {
+	var c []*Problem
+	for _, ic := range integrityChecks {
+		var n int64
+		if err := d.db.Table(ic.table).Where(ic.where).Count(&n).Error; err != nil {
+			return nil, fmt.Errorf("check %s: %v", ic.check, err)
+		}
+		if n > 0 {
+			c = append(c, &Problem{Check: ic.check, Table: ic.table, Rows: n})
+		}
+	}
+	if d.Dialect == "sqlite3" {
+		var res string
+		if err := d.db.Raw("PRAGMA quick_check").Row().Scan(&res); err != nil {
+			return nil, err
+		}
+		if res != "ok" {
+			c = append(c, &Problem{Check: "sqlite quick_check: " + res})
+		}
+	}
+	return c, nil
+}
+
+// RowCount is a number of rows of a table
+type RowCount struct {
+	Table string
+	Rows  int64
+}
+
+// step deletes the rows of table matching where, each ? of where is the purge time
+type step struct {
+	table string
+	where string
+}
+
+// purged are the time series purged by Purge in order, after the rows of the other tables depending on them
+var purged = []struct {
+	table string
+	deps  []step
+}{
+	{"tickers", nil},
+	{"trades", nil},
+	{"order_books", []step{
+		{"book_levels", "book_ref IN (SELECT id FROM order_books WHERE time < ?)"},
+		// the deltas after the purge time of the snapshots before it go with them
+		{"book_delta", "time < ? OR book_ref IN (SELECT id FROM order_books WHERE time < ?)"},
+	}},
+	{"book_delta", nil},
+	{"candles", nil},
+	{"book_metrics", nil},
+	{"consolidated_tickers", []step{
+		{"venue_quotes", "ticker_ref IN (SELECT id FROM consolidated_tickers WHERE time < ?)"},
+	}},
+	{"quality_issues", nil},
+	{"source_events", nil},
+	{"alerts", nil},
+}
+
+// PurgeTables are the tables purged by Purge by default
+func PurgeTables() []string {
+	c := make([]string, len(purged))
+	for k, p := range purged {
+		c[k] = p.table
+	}
+	return c
+}
+
+// Purge deletes the rows of the tables, PurgeTables if none, before the time before and returns the numbers
+// of rows deleted by table. The rows depending on them are deleted first: the levels and deltas of the order books,
+// the venue quotes of the consolidated tickers. dryRun only counts them.
+func (d *DataStore) Purge(before time.Time, dryRun bool, tables ...string) ([]*RowCount, error) {
+	known := make(map[string]bool)
+	for _, p := range purged {
+		known[p.table] = true
+	}
+	selected := make(map[string]bool)
+	for _, t := range tables {
+		if !known[t] {
+			return nil, fmt.Errorf("table %s is not purged", t)
+		}
+		selected[t] = true
+	}
+
+	before = before.UTC()
+	var c []*RowCount
+	done := make(map[string]bool)
+	for _, p := range purged {
+		if len(tables) > 0 && !selected[p.table] {
+			continue
+		}
+		for _, s := range append(append([]step{}, p.deps...), step{p.table, "time < ?"}) {
+			if done[s.table] { // by a wider step
+				continue
+			}
+			done[s.table] = true
+			args := make([]interface{}, strings.Count(s.where, "?"))
+			for k := range args {
+				args[k] = before
+			}
+			var n int64
+			if dryRun {
+				if err := d.db.Table(s.table).Where(s.where, args...).Count(&n).Error; err != nil {
+					return c, fmt.Errorf("count %s: %v", s.table, err)
+				}
+			} else {
+				db := d.db.Exec("DELETE FROM "+s.table+" WHERE "+s.where, args...)
+				if db.Error != nil {
+					return c, fmt.Errorf("purge %s: %v", s.table, db.Error)
+				}
+				n = db.RowsAffected
+			}
+			c = append(c, &RowCount{Table: s.table, Rows: n})
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/exchangedata/common"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exdataconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "db.json")
+	if err = ioutil.WriteFile(path, []byte(`{"dialect": "postgres", "host": "db:5432", "name": "md"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(EnvName, "exdata_test")
+	defer os.Unsetenv(EnvName)
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := c.DataStore()
+	if ds.Dialect != "postgres" || ds.Host != "db:5432" || ds.Name != "exdata_test" || ds.User != "postgres" {
+		t.Fatal("wrong config", ds)
+	}
+	if c, err = LoadConfig(""); err != nil || c.Dialect != "mysql" {
+		t.Fatal("default config", c, err)
+	}
+	if _, err = LoadConfig(filepath.Join(dir, "missing.json")); err == nil {
+		t.Fatal("missing file accepted")
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	ds := openTestStore(t)
+	defer ds.CloseDB()
+
+	m := Markes[1]
+	if ds.UpdateMarket(m).Error != nil {
+		t.Fatal("error save market", ds.GetDB().Error)
+	}
+	// before the records of the other tests
+	start := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := ds.NewBatchWriter(0, 0)
+	w.SnapshotInterval = time.Hour
+	book := func(bid string) *common.OrderBook {
+		return &common.OrderBook{Market: m, Bids: []*common.PriceVol{{Price: dec(bid), Volume: dec("1")}},
+			Asks: []*common.PriceVol{{Price: dec("2"), Volume: dec("1")}}}
+	}
+	// the snapshots before before and after it, a delta after before of the last snapshot before it
+	for k, at := range []time.Time{start, before.Add(-time.Minute), before.Add(time.Second), before.Add(2 * time.Hour)} {
+		ob := book("1")
+		if k == 2 {
+			ob = book("1.5")
+		}
+		ob.Time = at
+		w.AddOrderBook(ob)
+	}
+	for _, at := range []time.Time{start, before.Add(-time.Minute), before} {
+		w.AddTicker(&common.Ticker{Time: at, Market: m, Last: dec("1")})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("flush", err)
+	}
+
+	stats, err := ds.Stats()
+	if err != nil {
+		t.Fatal("stats", err)
+	}
+	rows := make(map[string]int64)
+	for _, s := range stats {
+		rows[s.Table] = s.Rows
+	}
+	if len(stats) != len(models)+1 || rows["tickers"] < 3 || rows["book_levels"] < 6 {
+		t.Fatal("wrong stats", rows)
+	}
+
+	expected := map[string]int64{"tickers": 2, "order_books": 2, "book_levels": 4, "book_delta": 2}
+	for _, dryRun := range []bool{true, false} {
+		purged, err := ds.Purge(before, dryRun, "order_books", "tickers")
+		if err != nil {
+			t.Fatal("purge", err)
+		}
+		if len(purged) != len(expected) {
+			t.Fatal("wrong purged tables", len(purged))
+		}
+		for _, p := range purged {
+			if p.Rows != expected[p.Table] {
+				t.Error("purged", dryRun, p.Table, p.Rows)
+			}
+		}
+	}
+	if purged, _ := ds.Purge(before, true); len(purged) != len(PurgeTables())+2 || purged[0].Rows != 0 {
+		t.Fatal("purged again", purged)
+	}
+	if _, err = ds.Purge(before, true, "markets"); err == nil {
+		t.Fatal("markets purged")
+	}
+
+	if problems, err := ds.Check(); err != nil || len(problems) != 0 {
+		t.Fatal("problems", problems, err)
+	}
+	ds.GetDB().Exec("INSERT INTO tickers (time, market_ref) VALUES (?, ?)", start, 999999)
+	problems, err := ds.Check()
+	if err != nil || len(problems) != 1 || problems[0].Check != "ticker without market" || problems[0].Rows != 1 {
+		t.Fatal("orphan ticker", problems, err)
+	}
+	// the records of the test are left to none of the other tests
+	if _, err = ds.Purge(before.Add(3*time.Hour), false); err != nil {
+		t.Fatal("purge", err)
+	}
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/jinzhu/gorm"
+)
+
+// AlertRules returns the alert rules with their market and exchanger in id order, only the enabled ones if enabled
+func (d *DataStore) AlertRules(enabled bool) ([]*common.AlertRule, error) {
+	c := []*common.AlertRule{}
+	q := d.db.Preload("Market.Exchanger")
+	if enabled {
+		q = q.Where("enabled = ?", true)
+	}
+	if err := q.Order("id").Find(&c).Error; err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// AlertRule returns the alert rule id, gorm.ErrRecordNotFound if there is none
+func (d *DataStore) AlertRule(id uint) (*common.AlertRule, error) {
+	r := &common.AlertRule{}
+	if err := d.db.Preload("Market.Exchanger").First(r, id).Error; err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SaveAlertRule stores the rule r, a new one without id, its market is referred to by MarketRef or Market
+func (d *DataStore) SaveAlertRule(r *common.AlertRule) error {
+	ref, err := d.marketRef(r.MarketRef, r.Market)
+	if err != nil {
+		return err
+	}
+	r.MarketRef = ref
+	return d.db.Set("gorm:save_associations", false).Save(r).Error
+}
+
+// DeleteAlertRule deletes the alert rule id and its alerts, gorm.ErrRecordNotFound if there is none
+func (d *DataStore) DeleteAlertRule(id uint) error {
+	tx := d.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	db := tx.Delete(&common.AlertRule{ID: id})
+	if db.Error == nil && db.RowsAffected == 0 {
+		db.Error = gorm.ErrRecordNotFound
+	}
+	if db.Error == nil {
+		db = tx.Where("rule_ref = ?", id).Delete(&common.Alert{})
+	}
+	if db.Error != nil {
+		tx.Rollback()
+		return db.Error
+	}
+	return tx.Commit().Error
+}
+
+// InsertAlert stores the alert a of its rule RuleRef
+func (d *DataStore) InsertAlert(a *common.Alert) error {
+	return d.insertRows(d.db, []interface{}{a})
+}
+
+// Alerts returns a page of the alerts of the rule ruleRef in the time range [from, to), like Tickers
+func (d *DataStore) Alerts(ruleRef uint, from, to time.Time, after *Cursor, limit int) ([]*common.Alert, *Cursor, error) {
+	c := []*common.Alert{}
+	next, err := d.page(d.db, &c, "rule_ref", ruleRef, from, to, after, limit)
+	return c, next, err
+}
+
+// LastAlerts returns the last alert of each rule
+func (d *DataStore) LastAlerts() ([]*common.Alert, error) {
+	c := []*common.Alert{}
+	err := d.db.Select("alerts.*").
+		Joins("JOIN (SELECT rule_ref, max(time) latest FROM alerts GROUP BY rule_ref) l ON l.rule_ref = alerts.rule_ref AND l.latest = alerts.time").
+		Order("alerts.rule_ref").Find(&c).Error
+	return c, err
+}
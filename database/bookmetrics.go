@@ -0,0 +1,45 @@
+package database
+
+import (
+	"time"
+
+	"github.com/exchangedata/common"
+)
+
+// InsertBookMetrics stores the order book metrics c in one transaction
+func (d *DataStore) InsertBookMetrics(c []*common.BookMetrics) error {
+	if len(c) == 0 {
+		return nil
+	}
+	rows := make([]interface{}, 0, len(c))
+	for _, m := range c {
+		ref, err := d.marketRef(m.MarketRef, m.Market)
+		if err != nil {
+			return err
+		}
+		m.MarketRef = ref
+		rows = append(rows, m)
+	}
+	tx := d.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := d.insertRows(tx, rows); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// BookMetrics returns a page of the order book metrics of the market marketRef in the time range [from, to),
+// like Tickers
+func (d *DataStore) BookMetrics(marketRef uint, from, to time.Time, after *Cursor, limit int) ([]*common.BookMetrics, *Cursor, error) {
+	c := []*common.BookMetrics{}
+	next, err := d.page(d.db.Preload("Market.Exchanger"), &c, "market_ref", marketRef, from, to, after, limit)
+	return c, next, err
+}
+
+// DeleteBookMetrics deletes the order book metrics of the time range [from, to), of all the markets
+func (d *DataStore) DeleteBookMetrics(from, to time.Time) error {
+	return d.db.Where("time >= ? and time < ?", from.UTC(), to.UTC()).Delete(&common.BookMetrics{}).Error
+}
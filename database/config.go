@@ -0,0 +1,73 @@
+package database
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// Config is the database shared by the daemon, edserver and dbman, read by LoadConfig.
+// The empty fields keep the defaults of NewDataStore for the dialect.
+type Config struct {
+	Dialect  string `json:"dialect"` // mysql by default
+	Host     string `json:"host"`
+	Name     string `json:"name"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// the environment variables of LoadConfig
+const (
+	EnvConfig   = "EXDATA_DB_CONFIG" // the JSON file of the config
+	EnvDialect  = "EXDATA_DB_DIALECT"
+	EnvHost     = "EXDATA_DB_HOST"
+	EnvName     = "EXDATA_DB_NAME"
+	EnvUser     = "EXDATA_DB_USER"
+	EnvPassword = "EXDATA_DB_PASSWORD"
+)
+
+// LoadConfig reads the JSON file path, or the file of EXDATA_DB_CONFIG if path is empty, none if both are.
+// The EXDATA_DB_* variables set override the file.
+func LoadConfig(path string) (*Config, error) {
+	c := &Config{}
+	if path == "" {
+		path = os.Getenv(EnvConfig)
+	}
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(b, c); err != nil {
+			return nil, err
+		}
+	}
+	for env, f := range map[string]*string{EnvDialect: &c.Dialect, EnvHost: &c.Host, EnvName: &c.Name,
+		EnvUser: &c.User, EnvPassword: &c.Password} {
+		if v, ok := os.LookupEnv(env); ok {
+			*f = v
+		}
+	}
+	if c.Dialect == "" {
+		c.Dialect = "mysql"
+	}
+	return c, nil
+}
+
+// DataStore returns the unopened data store of the config
+func (c *Config) DataStore() *DataStore {
+	d := NewDataStore(c.Dialect)
+	if c.Host != "" {
+		d.Host = c.Host
+	}
+	if c.Name != "" {
+		d.Name = c.Name
+	}
+	if c.User != "" {
+		d.User = c.User
+	}
+	if c.Password != "" {
+		d.Password = c.Password
+	}
+	return d
+}
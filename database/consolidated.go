@@ -0,0 +1,58 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/exchangedata/common"
+)
+
+// InsertConsolidated stores the consolidated tickers and their venue quotes in one transaction.
+// A ticker of a symbol and time stored already is skipped with its venues.
+func (d *DataStore) InsertConsolidated(c []*common.ConsolidatedTicker) error {
+	if len(c) == 0 {
+		return nil
+	}
+	tx := d.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	venues := []interface{}{}
+	for _, t := range c {
+		if t.SymRef == 0 && t.Symbol != nil {
+			t.SymRef = t.Symbol.ID
+		}
+		if t.SymRef == 0 {
+			tx.Rollback()
+			return errors.New("consolidated ticker of a symbol not stored")
+		}
+		table, cols, vals := insertColumns(tx, t)
+		id, err := d.insertID(tx, table, cols, vals)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if id == 0 {
+			continue
+		}
+		t.ID = id
+		for _, v := range t.Venues {
+			v.TickerRef = id
+			venues = append(venues, v)
+		}
+	}
+	if err := d.insertRows(tx, venues); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// ConsolidatedTickers returns a page of the consolidated tickers of the symbol symRef in the time range [from, to)
+// with their venues, like Tickers
+func (d *DataStore) ConsolidatedTickers(symRef uint, from, to time.Time, after *Cursor, limit int) ([]*common.ConsolidatedTicker, *Cursor, error) {
+	c := []*common.ConsolidatedTicker{}
+	q := d.db.Preload("Symbol.Base").Preload("Symbol.Quote").Preload("Venues")
+	next, err := d.page(q, &c, "sym_ref", symRef, from, to, after, limit)
+	return c, next, err
+}
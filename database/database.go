@@ -1,16 +1,27 @@
 package database
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/exchangedata/common"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
 )
 
 // DataStore ...
+// Dialect is "mysql", "postgres" or "sqlite3", for sqlite3 Name is the path of the database file.
+// Host is host[:port] of the server, empty for the local default.
 type DataStore struct {
 	Dialect  string
+	Host     string
 	Name     string
 	User     string
 	Password string
@@ -19,6 +30,15 @@ type DataStore struct {
 }
 
 func NewDataStore(Dialet string) *DataStore {
+	if Dialet == "sqlite3" {
+		return &DataStore{Dialect: Dialet, Name: "exdata.db"}
+	}
+	if Dialet == "postgres" { // the password comes from the config, or PGPASSWORD and .pgpass if empty
+		return &DataStore{Dialect: Dialet,
+			Host: "localhost",
+			Name: "exdata",
+			User: "postgres"}
+	}
 	return &DataStore{Dialect: Dialet,
 		Name:     "exdata",
 		User:     "root",
@@ -26,18 +46,54 @@ func NewDataStore(Dialet string) *DataStore {
 }
 
 func (d *DataStore) OpenDB() error {
-	//	user+":"+passwd+"@tcp(127.0.0.1:3306)/"+name+"?charset=utf8&parseTime=True&loc=Local"
-	uri := d.User + ":" + d.Password + "@/" + d.Name + "?charset=utf8&parseTime=True&loc=Local"
+	var uri string
+	switch d.Dialect {
+	case "mysql":
+		//	user+":"+passwd+"@tcp(127.0.0.1:3306)/"+name+"?charset=utf8&parseTime=True&loc=Local"
+		uri = d.User + ":" + d.Password + "@/" + d.Name + "?charset=utf8&parseTime=True&loc=Local"
+		if d.Host != "" {
+			uri = d.User + ":" + d.Password + "@tcp(" + d.Host + ")/" + d.Name + "?charset=utf8&parseTime=True&loc=Local"
+		}
+	case "postgres":
+		host, port := d.Host, "5432"
+		if h, p, err := net.SplitHostPort(d.Host); err == nil {
+			host, port = h, p
+		}
+		uri = fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable", host, port, d.User, d.Name)
+		if d.Password != "" {
+			uri += " password='" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(d.Password) + "'"
+		}
+	case "sqlite3":
+		uri = d.Name
+	default:
+		return fmt.Errorf("not supported database dialect %s", d.Dialect)
+	}
 	db, err := gorm.Open(d.Dialect, uri)
 	if err != nil {
 		log.Println("DB open with", err)
 		return err
 	}
+	if d.Dialect == "sqlite3" {
+		// sqlite has a single writer, sharing one connection avoids the busy errors of concurrent writes
+		db.DB().SetMaxOpenConns(1)
+		db.Callback().Create().Before("gorm:create").Register("exdata:utc_times", utcTimes)
+		db.Callback().Update().Before("gorm:update").Register("exdata:utc_times", utcTimes)
+	}
 	d.db = db
 
 	return nil
 }
 
+// utcTimes sets the times of a model to UTC before it is saved.
+// sqlite compares the times as text, which only works when they are in the same zone.
+func utcTimes(scope *gorm.Scope) {
+	for _, f := range scope.Fields() {
+		if t, ok := f.Field.Interface().(time.Time); ok && f.Field.CanSet() {
+			f.Field.Set(reflect.ValueOf(t.UTC()))
+		}
+	}
+}
+
 func (d *DataStore) CloseDB() {
 	d.db.Close()
 }
@@ -46,11 +102,12 @@ func (d *DataStore) GetDB() *gorm.DB {
 	return d.db
 }
 
-func (d *DataStore) AutoMigrate() *gorm.DB {
-	return d.db.AutoMigrate(&common.Exchanger{}, &common.Market{}, &common.Currency{},
-		&common.CommunicationAPI{}, &common.AccessSecret{}, &common.Symbol{},
-		&common.Ticker{}, &common.Trade{}, &common.OrderBook{}, &common.PriceVol{})
-}
+// models are the tables of the store
+var models = []interface{}{&common.Exchanger{}, &common.Market{}, &common.Currency{},
+	&common.CommunicationAPI{}, &common.AccessSecret{}, &common.Symbol{},
+	&common.Ticker{}, &common.Trade{}, &common.OrderBook{}, &common.BookLevel{}, &common.BookDelta{}, &common.Candle{},
+	&common.ConsolidatedTicker{}, &common.VenueQuote{}, &common.QualityIssue{}, &common.SourceEvent{},
+	&common.BookMetrics{}, &common.AlertRule{}, &common.Alert{}}
 
 func (d *DataStore) UpdateCurrency(c *common.Currency) *gorm.DB {
 	t := &common.Currency{}
@@ -65,6 +122,11 @@ func (d *DataStore) UpdateCurrency(c *common.Currency) *gorm.DB {
 }
 
 func (d *DataStore) UpdateExchanger(c *common.Exchanger) *gorm.DB {
+	if c.Name == "" {
+		db := d.db.New()
+		db.AddError(errors.New("exchanger without name"))
+		return db
+	}
 	return d.db.Where("name = ?", c.Name).FirstOrCreate(c)
 }
 
@@ -102,6 +164,22 @@ func (d *DataStore) UpdateMarket(c *common.Market) *gorm.DB {
 	return d.db.Save(c)
 }
 
+// marketRef returns the market id of a record referring to ref or m, the market m is stored if it has no id yet
+func (d *DataStore) marketRef(ref uint, m *common.Market) (uint, error) {
+	if ref != 0 {
+		return ref, nil
+	}
+	if m == nil {
+		return 0, errors.New("record without market")
+	}
+	if m.ID == 0 {
+		if err := d.UpdateMarket(m).Error; err != nil {
+			return 0, err
+		}
+	}
+	return m.ID, nil
+}
+
 func (d *DataStore) UpdateTicker(c *common.Ticker) *gorm.DB {
 	if c.MarketRef == 0 && c.Market == nil {
 		return nil
@@ -115,7 +193,7 @@ func (d *DataStore) UpdateTicker(c *common.Ticker) *gorm.DB {
 		d.db.First(c.Market, c.MarketRef)
 	}
 
-	return d.db.Where("time = ? and market_ref = ?", c.Time, c.MarketRef).FirstOrCreate(c)
+	return d.db.Where("time = ? and market_ref = ?", c.Time.UTC(), c.MarketRef).FirstOrCreate(c)
 }
 
 func (d *DataStore) UpdateTrade(c *common.Trade) *gorm.DB {
@@ -147,21 +225,25 @@ func (d *DataStore) UpdateOrderBook(c *common.OrderBook) *gorm.DB {
 		d.db.First(c.Market, c.MarketRef)
 	}
 
-	for _, p := range c.Asks {
-		if d.UpdatePriceVol(p).Error != nil {
-			log.Println("error update pricevol:", p, d.db.Error)
-		}
+	t := &common.OrderBook{}
+	if db := d.db.Where("time = ? and market_ref = ?", c.Time.UTC(), c.MarketRef).First(t); !db.RecordNotFound() {
+		c.ID = t.ID
+		return db
 	}
 
-	for _, p := range c.Bids {
-		if d.UpdatePriceVol(p).Error != nil {
-			log.Println("error update pricevol:", p, d.db.Error)
-		}
+	tx := d.db.Begin()
+	if db := tx.Create(c); db.Error != nil {
+		tx.Rollback()
+		return db
 	}
-
-	return d.db.Where("time = ? and market_ref = ?", c.Time, c.MarketRef).FirstOrCreate(c)
-}
-
-func (d *DataStore) UpdatePriceVol(c *common.PriceVol) *gorm.DB {
-	return d.db.Where("price = ? and volume = ?", c.Price, c.Volume).FirstOrCreate(c)
+	levels := []interface{}{}
+	for _, l := range c.Levels() {
+		levels = append(levels, l)
+	}
+	if err := d.insertRows(tx, levels); err != nil {
+		tx.Rollback()
+		tx.AddError(err)
+		return tx
+	}
+	return tx.Commit()
 }
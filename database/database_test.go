@@ -1,13 +1,49 @@
 package database
 
 import (
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/exchangedata/common"
+	"github.com/shopspring/decimal"
 )
 
+var dec = decimal.RequireFromString
+
+// testDB is the sqlite file shared by the tests of the package.
+// Set EXDATA_TEST_DIALECT=mysql or postgres to run them on the local exdata database instead.
+var testDB string
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "exdata")
+	if err != nil {
+		log.Fatal(err)
+	}
+	testDB = filepath.Join(dir, "test.db")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func openTestStore(t *testing.T) *DataStore {
+	ds := NewDataStore("sqlite3")
+	ds.Name = testDB
+	if dialect := os.Getenv("EXDATA_TEST_DIALECT"); dialect == "mysql" || dialect == "postgres" {
+		ds = NewDataStore(dialect)
+	}
+	if err := ds.OpenDB(); err != nil {
+		t.Fatal("open db failed")
+	}
+	if err := ds.Migrate(); err != nil {
+		t.Fatal("migrate db failed", err)
+	}
+	return ds
+}
+
 var testCurrencies = []common.Currency{
 	common.Currency{Name: "bitcoin", Abbr: "BTC"},
 	common.Currency{Name: "Litecoin", Abbr: "LTC"},
@@ -28,8 +64,8 @@ var testMarkets = []*common.Market{
 		Active:     true,
 		Info:       "common market test",
 		Precision:  5,
-		Limitation: common.Limitation{Min: 0.01, Max: 10000},
-		MinStep:    0.000001,
+		Limitation: common.Limitation{Min: dec("0.01"), Max: dec("10000")},
+		MinStep:    dec("0.000001"),
 		Exchanger:  &testExchangers[1]},
 	&common.Market{Name: "DOGE-BTC",
 		Symbol: &common.Symbol{
@@ -38,8 +74,8 @@ var testMarkets = []*common.Market{
 		Active:     true,
 		Info:       "extiguish test",
 		Precision:  8,
-		Limitation: common.Limitation{Min: 100, Max: 10000000},
-		MinStep:    0.00000000001,
+		Limitation: common.Limitation{Min: dec("100"), Max: dec("10000000")},
+		MinStep:    dec("0.00000000001"),
 		Exchanger:  &testExchangers[1]},
 	&common.Market{Name: "DOGE-BTC",
 		Symbol: &common.Symbol{
@@ -48,24 +84,24 @@ var testMarkets = []*common.Market{
 		Active:     true,
 		Info:       "extiguish test",
 		Precision:  8,
-		Limitation: common.Limitation{Min: 100, Max: 10000000},
-		MinStep:    0.00000000001,
+		Limitation: common.Limitation{Min: dec("100"), Max: dec("10000000")},
+		MinStep:    dec("0.00000000001"),
 		Exchanger:  &testExchangers[0]},
 }
 
 var testTickers = []*common.Ticker{
 	&common.Ticker{
 		Time:   time.Date(2018, 11, 22, 3, 4, 5, 6, time.Local),
-		Market: testMarkets[1], Last: 382.98901522, Ask: 381.99755898, Bid: 379.41296309, High: 412.25844455,
-		Percentage: -0.04312950, Low: 364.56122072, BaseVolume: 14969820.94951828, QuoteVolume: 38859.58435407,
+		Market: testMarkets[1], Last: dec("382.98901522"), Ask: dec("381.99755898"), Bid: dec("379.41296309"), High: dec("412.25844455"),
+		Percentage: dec("-0.04312950"), Low: dec("364.56122072"), BaseVolume: dec("14969820.94951828"), QuoteVolume: dec("38859.58435407"),
 	}, // poloniex model
 	&common.Ticker{
 		Time:   time.Date(2018, 11, 22, 3, 4, 5, 6, time.Local),
-		Market: testMarkets[0], Last: 3.35579531, Bid: 2.05670368, Ask: 3.35579531,
+		Market: testMarkets[0], Last: dec("3.35579531"), Bid: dec("2.05670368"), Ask: dec("3.35579531"),
 	}, // bittrex model
 	&common.Ticker{
 		Time:   time.Date(2018, 11, 22, 3, 4, 5, 6, time.Local),
-		Market: testMarkets[2], High: 0.00846390, BaseVolume: 1135176.4290665, Last: 0.00809068, Low: 0.00801497, Bid: 0.00808481, Ask: 0.00809001,
+		Market: testMarkets[2], High: dec("0.00846390"), BaseVolume: dec("1135176.4290665"), Last: dec("0.00809068"), Low: dec("0.00801497"), Bid: dec("0.00808481"), Ask: dec("0.00809001"),
 	}, //okex model
 }
 
@@ -75,16 +111,16 @@ var testTrades = []*common.Trade{
 		OrderID: "4688134",
 		Type:    "fill",
 		Side:    "buy",
-		Price:   3304.51,
-		Amount:  23.1000008,
+		Price:   dec("3304.51"),
+		Amount:  dec("23.1000008"),
 		Market:  testMarkets[1],
 	},
 	&common.Trade{
 		Time:    time.Date(2018, 11, 12, 23, 54, 33, 00, time.UTC),
 		OrderID: "1832441212123",
 		Side:    "buy",
-		Price:   33.3,
-		Amount:  2008,
+		Price:   dec("33.3"),
+		Amount:  dec("2008"),
 		Market:  testMarkets[2],
 	},
 }
@@ -94,16 +130,26 @@ var testOrderBooks = []*common.OrderBook{
 		Market: testMarkets[0],
 		Time:   time.Now(),
 		Asks: []*common.PriceVol{
-			&common.PriceVol{Price: 0.00001853, Volume: 2537.5637},
-			&common.PriceVol{Price: 0.00001854, Volume: 1567238.172367},
+			&common.PriceVol{Price: dec("0.00001853"), Volume: dec("2537.5637")},
+			&common.PriceVol{Price: dec("0.00001854"), Volume: dec("1567238.172367")},
 		},
 		Bids: []*common.PriceVol{
-			&common.PriceVol{Price: 0.00001841, Volume: 3645.3647},
-			&common.PriceVol{Price: 0.00001840, Volume: 1637.3647},
+			&common.PriceVol{Price: dec("0.00001841"), Volume: dec("3645.3647")},
+			&common.PriceVol{Price: dec("0.00001840"), Volume: dec("1637.3647")},
 		},
 	},
 }
 
+var testCandles = []*common.Candle{
+	&common.Candle{
+		Time:     time.Date(2018, 11, 22, 3, 4, 0, 0, time.UTC),
+		Interval: "1m",
+		Market:   testMarkets[1],
+		Open:     dec("3304.51"), High: dec("3310"), Low: dec("3301.2"), Close: dec("3305"),
+		Volume: dec("23.1000008"), QuoteVolume: dec("76335.5036"), Trades: 3,
+	},
+}
+
 type currencyTest struct {
 	in   common.Currency
 	want bool
@@ -119,13 +165,9 @@ var tc = []currencyTest{
 }
 
 func TestSaveCurrency(t *testing.T) {
-	ds := NewDataStore("mysql")
-	if err := ds.OpenDB(); err != nil {
-		t.Fatal("open db failed")
-	}
+	ds := openTestStore(t)
 	defer ds.CloseDB()
 
-	ds.AutoMigrate()
 	for _, m := range tc {
 		result := true
 		if ds.UpdateCurrency(&m.in).Error != nil {
@@ -153,16 +195,12 @@ var te = []exchangerTest{
 }
 
 func TestSaveExchager(t *testing.T) {
-	ds := NewDataStore("mysql")
-	if err := ds.OpenDB(); err != nil {
-		t.Fatal("open db failed")
-	}
+	ds := openTestStore(t)
 	defer ds.CloseDB()
 
-	ds.AutoMigrate()
 	for _, m := range te {
 		result := true
-		err := ds.UpdateExchanger(&m.in)
+		err := ds.UpdateExchanger(&m.in).Error
 		if err != nil {
 			log.Println("Error ", err)
 			result = false
@@ -204,14 +242,9 @@ func init() {
 }
 
 func TestUpdateTicker(t *testing.T) {
-	var err error
-	ds := NewDataStore("mysql")
-	if err = ds.OpenDB(); err != nil {
-		panic("open db failed")
-	}
+	ds := openTestStore(t)
 	defer ds.CloseDB()
 
-	ds.AutoMigrate()
 	for _, c := range Currencs {
 		if ds.UpdateCurrency(c).Error != nil {
 			log.Println("error save currency", ds.GetDB().Error)
@@ -244,4 +277,32 @@ func TestUpdateTicker(t *testing.T) {
 	}
 }
 
-// drop table access_secrets,communication_apis,currencies,currency_exchangers,exchangers,markets,symbols,tickers,ask_pricevols,bid_pricevols,order_books,price_vols,trades;
+func TestDecimalPrecision(t *testing.T) {
+	ds := openTestStore(t)
+	defer ds.CloseDB()
+
+	m := Markes[1]
+	if ds.UpdateMarket(m).Error != nil {
+		t.Fatal("error save market", ds.GetDB().Error)
+	}
+	c := &common.Trade{
+		Time:    time.Date(2018, 11, 23, 1, 2, 3, 0, time.UTC),
+		OrderID: "precision",
+		Price:   dec("0.00000123456789"),
+		Amount:  dec("123456789.123456"),
+		Market:  m,
+	}
+	if ds.UpdateTrade(c).Error != nil {
+		t.Fatal("error save trade", ds.GetDB().Error)
+	}
+
+	sm := &common.Market{}
+	st := &common.Trade{}
+	ds.GetDB().First(sm, m.ID)
+	ds.GetDB().Where("order_id = ?", c.OrderID).First(st)
+	if !sm.MinStep.Equal(m.MinStep) || !st.Price.Equal(c.Price) || !st.Amount.Equal(c.Amount) {
+		t.Fatalf("values changed by storage, %s %s %s", sm.MinStep, st.Price, st.Amount)
+	}
+}
+
+// drop table access_secrets,communication_apis,currencies,currency_exchangers,exchangers,markets,symbols,tickers,order_books,book_levels,book_deltas,trades,candles;
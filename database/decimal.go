@@ -0,0 +1,47 @@
+package database
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// DecimalType is the column type of the prices and volumes, wide enough for satoshi scale values
+const DecimalType = "decimal(36,18)"
+
+// migrateDecimalColumns changes the price and volume columns of the tables created
+// when they were floating point into DecimalType. AutoMigrate does not change existing columns.
+func (d *DataStore) migrateDecimalColumns() error {
+	schema := "DATABASE()"
+	switch d.Dialect {
+	case "mysql":
+	case "postgres":
+		schema = "CURRENT_SCHEMA()"
+	default:
+		return nil // sqlite columns have no fixed type
+	}
+	decimalType := reflect.TypeOf(decimal.Decimal{})
+	for _, m := range v1DecimalTables {
+		scope := d.db.NewScope(m)
+		table := scope.TableName()
+		for _, f := range scope.GetModelStruct().StructFields {
+			if f.Struct.Type != decimalType || f.IsIgnored {
+				continue
+			}
+			var current string
+			row := d.db.Raw("SELECT data_type FROM information_schema.columns WHERE table_schema = "+schema+" AND table_name = ? AND column_name = ?",
+				table, f.DBName).Row()
+			if err := row.Scan(&current); err != nil {
+				return err
+			}
+			if current = strings.ToLower(current); current == "decimal" || current == "numeric" {
+				continue
+			}
+			if err := d.db.Model(m).ModifyColumn(f.DBName, DecimalType).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
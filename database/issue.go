@@ -0,0 +1,66 @@
+package database
+
+import (
+	"time"
+
+	"github.com/exchangedata/common"
+)
+
+// InsertIssues stores the validation issues c in one transaction
+func (d *DataStore) InsertIssues(c []*common.QualityIssue) error {
+	if len(c) == 0 {
+		return nil
+	}
+	rows := make([]interface{}, 0, len(c))
+	for _, i := range c {
+		ref, err := d.marketRef(i.MarketRef, i.Market)
+		if err != nil {
+			return err
+		}
+		i.MarketRef = ref
+		rows = append(rows, i)
+	}
+	tx := d.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := d.insertRows(tx, rows); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// Issues returns a page of the validation issues of the market marketRef in the time range [from, to), like Tickers.
+// An empty rule selects all of them.
+func (d *DataStore) Issues(marketRef uint, rule string, from, to time.Time, after *Cursor, limit int) ([]*common.QualityIssue, *Cursor, error) {
+	c := []*common.QualityIssue{}
+	q := d.db.Preload("Market.Exchanger")
+	if rule != "" {
+		q = q.Where("rule = ?", rule)
+	}
+	next, err := d.page(q, &c, "market_ref", marketRef, from, to, after, limit)
+	return c, next, err
+}
+
+// IssueCount is the number of the validation issues of a rule handled by an action
+type IssueCount struct {
+	Rule   string
+	Action string
+	Count  int
+}
+
+// IssueCounts returns the number of the issues of the records in the time range [from, to) by rule and action,
+// of the market marketRef or of all the markets if 0
+func (d *DataStore) IssueCounts(marketRef uint, from, to time.Time) ([]*IssueCount, error) {
+	q := d.db.Model(&common.QualityIssue{}).Select("rule, action, count(*) as count").
+		Where("time >= ? and time < ?", from.UTC(), to.UTC())
+	if marketRef != 0 {
+		q = q.Where("market_ref = ?", marketRef)
+	}
+	c := []*IssueCount{}
+	if err := q.Group("rule, action").Order("rule, action").Scan(&c).Error; err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,342 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Migration is a versioned change of the schema. Up applies it and Down reverts it on a DataStore whose queries
+// run in a transaction, unless NoTx is set: the continuous aggregates of TimescaleDB can not be created in one,
+// and mysql commits its DDL statements anyway.
+type Migration struct {
+	Version int64 // applied in increasing order
+	Name    string
+	Up      func(d *DataStore) error
+	Down    func(d *DataStore) error // nil if the migration can not be reverted
+	NoTx    bool
+}
+
+// SQL returns an Up or Down running the statements in order
+func SQL(statements ...string) func(d *DataStore) error {
+	return func(d *DataStore) error {
+		for _, s := range statements {
+			if err := d.db.Exec(s).Error; err != nil {
+				return fmt.Errorf("%s: %v", s, err)
+			}
+		}
+		return nil
+	}
+}
+
+// schemaMigration is a row of schema_migrations, an applied migration
+type schemaMigration struct {
+	Version   int64 `gorm:"primary_key;auto_increment:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string { return "schema_migrations" }
+
+// MigrationState is a migration with the time it was applied, zero if it is pending.
+// Unknown is set for a migration applied by a newer build, missing from this one.
+type MigrationState struct {
+	Version   int64
+	Name      string
+	AppliedAt time.Time
+	Unknown   bool
+}
+
+// Applied tells if the migration is applied
+func (s *MigrationState) Applied() bool { return !s.AppliedAt.IsZero() }
+
+const (
+	migrationLockTimeout = 5 * time.Minute
+	migrationLockPoll    = 200 * time.Millisecond
+	// migrationLockKey is the advisory lock of postgres, migrationLockName the named lock of mysql
+	migrationLockKey  = 0x65786461746100
+	migrationLockName = "exdata_migrations"
+	// staleMigrationLock is the age of a sqlite lock left by a dead process
+	staleMigrationLock = time.Hour
+)
+
+// sortedMigrations returns the migrations in version order, an error if two have the same version
+func sortedMigrations() ([]*Migration, error) {
+	c := append([]*Migration{}, migrations...)
+	sort.Slice(c, func(i, j int) bool { return c[i].Version < c[j].Version })
+	for k := 1; k < len(c); k++ {
+		if c[k].Version == c[k-1].Version {
+			return nil, fmt.Errorf("migrations %s and %s have the same version %d", c[k-1].Name, c[k].Name, c[k].Version)
+		}
+	}
+	return c, nil
+}
+
+// appliedMigrations returns the rows of schema_migrations by version, none if the table does not exist yet
+func (d *DataStore) appliedMigrations() (map[int64]*schemaMigration, error) {
+	applied := make(map[int64]*schemaMigration)
+	if !d.db.HasTable(&schemaMigration{}) {
+		return applied, nil
+	}
+	var rows []*schemaMigration
+	if err := d.db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// MigrationStatus returns the migrations in version order with the time they were applied
+func (d *DataStore) MigrationStatus() ([]*MigrationState, error) {
+	known, err := sortedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var c []*MigrationState
+	for _, m := range known {
+		s := &MigrationState{Version: m.Version, Name: m.Name}
+		if r := applied[m.Version]; r != nil {
+			s.AppliedAt = r.AppliedAt
+			delete(applied, m.Version)
+		}
+		c = append(c, s)
+	}
+	for _, r := range applied {
+		c = append(c, &MigrationState{Version: r.Version, Name: r.Name, AppliedAt: r.AppliedAt, Unknown: true})
+	}
+	sort.Slice(c, func(i, j int) bool { return c[i].Version < c[j].Version })
+	return c, nil
+}
+
+// PendingMigrations returns the migrations not applied yet, in version order
+func (d *DataStore) PendingMigrations() ([]*Migration, error) {
+	known, err := sortedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var c []*Migration
+	for _, m := range known {
+		if applied[m.Version] == nil {
+			c = append(c, m)
+		}
+	}
+	return c, nil
+}
+
+// Migrate applies all the pending migrations
+func (d *DataStore) Migrate() error {
+	_, err := d.MigrateUp(0)
+	return err
+}
+
+// MigrateUp applies the pending migrations up to the version to, all of them if to is 0, and returns those applied.
+// It fails on a database migrated by a newer build, with an applied version above the last known one.
+// The migrations of the other processes are waited for, they hold a lock of the database while they run.
+func (d *DataStore) MigrateUp(to int64) ([]*Migration, error) {
+	unlock, err := d.lockMigrations(migrationLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err = d.db.AutoMigrate(&schemaMigration{}).Error; err != nil {
+		return nil, err
+	}
+
+	known, err := sortedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	for v := range applied {
+		if len(known) == 0 || v > known[len(known)-1].Version {
+			return nil, fmt.Errorf("database schema at version %d, newer than this build", v)
+		}
+	}
+	var c []*Migration
+	for _, m := range known {
+		if to > 0 && m.Version > to {
+			break
+		}
+		if applied[m.Version] != nil {
+			continue
+		}
+		log.Println("applying migration", m.Version, m.Name)
+		if err = d.runMigration(m, true); err != nil {
+			return c, fmt.Errorf("migration %d %s: %v", m.Version, m.Name, err)
+		}
+		c = append(c, m)
+	}
+	return c, nil
+}
+
+// MigrateDown reverts the last steps applied migrations and returns them
+func (d *DataStore) MigrateDown(steps int) ([]*Migration, error) {
+	unlock, err := d.lockMigrations(migrationLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	known, err := sortedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, m := range known {
+		byVersion[m.Version] = m
+	}
+	versions := make([]int64, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+	var c []*Migration
+	for k := 0; k < steps && k < len(versions); k++ {
+		m := byVersion[versions[k]]
+		switch {
+		case m == nil:
+			return c, fmt.Errorf("migration %d unknown to this build", versions[k])
+		case m.Down == nil:
+			return c, fmt.Errorf("migration %d %s can not be reverted", m.Version, m.Name)
+		}
+		log.Println("reverting migration", m.Version, m.Name)
+		if err = d.runMigration(m, false); err != nil {
+			return c, fmt.Errorf("migration %d %s: %v", m.Version, m.Name, err)
+		}
+		c = append(c, m)
+	}
+	return c, nil
+}
+
+// runMigration applies or reverts m and records it in schema_migrations, in a transaction unless m.NoTx is set
+func (d *DataStore) runMigration(m *Migration, up bool) error {
+	step := m.Up
+	if !up {
+		step = m.Down
+	}
+	record := func(db *gorm.DB) error {
+		if up {
+			return db.Create(&schemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC()}).Error
+		}
+		return db.Where("version = ?", m.Version).Delete(&schemaMigration{}).Error
+	}
+	if m.NoTx {
+		if err := step(d); err != nil {
+			return err
+		}
+		return record(d.db)
+	}
+
+	tx := d.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	td := *d
+	td.db = tx
+	if err := step(&td); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// lockMigrations takes the migration lock of the database within timeout and returns its release.
+// mysql and postgres hold a lock of a session, released when the process dies. sqlite has a single
+// connection for the store, its lock is a row of schema_lock taken over after staleMigrationLock.
+func (d *DataStore) lockMigrations(timeout time.Duration) (func(), error) {
+	var try func() (bool, error)
+	var release func() error
+	abort := func() {} // frees the resources of a lock not taken
+	switch d.Dialect {
+	case "mysql", "postgres":
+		conn, err := d.db.DB().Conn(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		lock, unlock := "SELECT GET_LOCK(?, 0)", "SELECT RELEASE_LOCK(?)"
+		var key interface{} = migrationLockName
+		if d.Dialect == "postgres" {
+			lock, unlock = "SELECT pg_try_advisory_lock($1)::int", "SELECT pg_advisory_unlock($1)"
+			key = int64(migrationLockKey)
+		}
+		try = func() (bool, error) {
+			var ok sql.NullInt64
+			err := conn.QueryRowContext(context.Background(), lock, key).Scan(&ok)
+			return ok.Int64 == 1, err
+		}
+		release = func() error {
+			defer conn.Close()
+			_, err := conn.ExecContext(context.Background(), unlock, key)
+			return err
+		}
+		abort = func() { conn.Close() }
+	case "sqlite3":
+		if err := d.db.Exec("CREATE TABLE IF NOT EXISTS schema_lock (id integer PRIMARY KEY, locked_at datetime)").Error; err != nil {
+			return nil, err
+		}
+		var lockedAt time.Time
+		try = func() (bool, error) {
+			lockedAt = time.Now().UTC()
+			if err := d.db.Exec("DELETE FROM schema_lock WHERE locked_at < ?", lockedAt.Add(-staleMigrationLock)).Error; err != nil {
+				return false, err
+			}
+			db := d.db.Exec("INSERT OR IGNORE INTO schema_lock (id, locked_at) VALUES (1, ?)", lockedAt)
+			return db.RowsAffected == 1, db.Error
+		}
+		release = func() error { // unless taken over
+			return d.db.Exec("DELETE FROM schema_lock WHERE id = 1 AND locked_at = ?", lockedAt).Error
+		}
+	default:
+		return nil, fmt.Errorf("not supported database dialect %s", d.Dialect)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := try()
+		if err != nil {
+			abort()
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			abort()
+			return nil, errors.New("migrations locked by another process")
+		}
+		time.Sleep(migrationLockPoll)
+	}
+	return func() {
+		if err := release(); err != nil {
+			log.Println("migration lock release error:", err)
+		}
+	}, nil
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrations(t *testing.T) {
+	ds := NewDataStore("sqlite3")
+	ds.Name = filepath.Join(filepath.Dir(testDB), "migrations.db")
+	if err := ds.OpenDB(); err != nil {
+		t.Fatal("open db", err)
+	}
+	defer ds.CloseDB()
+
+	defer func(saved []*Migration) { migrations = saved }(migrations)
+	notes := &Migration{Version: 2, Name: "trade notes",
+		Up:   SQL("CREATE TABLE trade_notes (id integer PRIMARY KEY, note text)"),
+		Down: SQL("DROP TABLE trade_notes")}
+	broken := &Migration{Version: 3, Name: "broken", Up: SQL("CREATE TABLE broken (id integer)", "INSERT INTO missing VALUES (1)")}
+	migrations = append(migrations, broken, notes)
+
+	if pending, err := ds.PendingMigrations(); err != nil || len(pending) != 3 || pending[1] != notes {
+		t.Fatal("pending", pending, err)
+	}
+	applied, err := ds.MigrateUp(0)
+	if err == nil || len(applied) != 2 || applied[1] != notes {
+		t.Fatal("applied with the broken one", applied, err)
+	}
+	if !ds.GetDB().HasTable("tickers") || !ds.GetDB().HasTable("trade_notes") || ds.GetDB().HasTable("broken") {
+		t.Fatal("tables of the migrations")
+	}
+	states, err := ds.MigrationStatus()
+	if err != nil || len(states) != 3 || !states[1].Applied() || states[2].Applied() {
+		t.Fatal("status", states, err)
+	}
+
+	migrations = migrations[:len(migrations)-2]
+	migrations = append(migrations, notes)
+	if applied, err = ds.MigrateUp(0); err != nil || len(applied) != 0 {
+		t.Fatal("applied again", applied, err)
+	}
+	if applied, err = ds.MigrateDown(1); err != nil || len(applied) != 1 || ds.GetDB().HasTable("trade_notes") {
+		t.Fatal("reverted", applied, err)
+	}
+	if applied, err = ds.MigrateUp(1); err != nil || len(applied) != 0 {
+		t.Fatal("applied up to 1", applied, err)
+	}
+
+	// a version of a newer build
+	ds.GetDB().Create(&schemaMigration{Version: 99, Name: "future", AppliedAt: time.Now()})
+	if _, err = ds.MigrateUp(0); err == nil {
+		t.Fatal("migrated a newer schema")
+	}
+	if states, _ = ds.MigrationStatus(); len(states) != 3 || !states[2].Unknown {
+		t.Fatal("unknown version", states)
+	}
+	if _, err = ds.MigrateDown(1); err == nil {
+		t.Fatal("reverted an unknown version")
+	}
+	ds.GetDB().Where("version = ?", 99).Delete(&schemaMigration{})
+
+	if applied, err = ds.MigrateDown(5); err != nil || len(applied) != 1 || ds.GetDB().HasTable("tickers") {
+		t.Fatal("reverted the initial schema", applied, err)
+	}
+	if err = ds.Migrate(); err != nil || !ds.GetDB().HasTable("tickers") || !ds.GetDB().HasTable("trade_notes") {
+		t.Fatal("migrated again", err)
+	}
+}
+
+func TestMigrationLock(t *testing.T) {
+	open := func() *DataStore {
+		ds := NewDataStore("sqlite3")
+		ds.Name = filepath.Join(filepath.Dir(testDB), "lock.db")
+		if err := ds.OpenDB(); err != nil {
+			t.Fatal("open db", err)
+		}
+		return ds
+	}
+	ds, other := open(), open()
+	defer ds.CloseDB()
+	defer other.CloseDB()
+
+	unlock, err := ds.lockMigrations(time.Second)
+	if err != nil {
+		t.Fatal("lock", err)
+	}
+	if _, err = other.lockMigrations(300 * time.Millisecond); err == nil {
+		t.Fatal("locked twice")
+	}
+	unlock()
+	unlockOther, err := other.lockMigrations(time.Second)
+	if err != nil {
+		t.Fatal("lock after release", err)
+	}
+	unlockOther()
+}
+
+// TestModelsMigrated fails on a change of the models of common without its migration
+func TestModelsMigrated(t *testing.T) {
+	ds := openTestStore(t)
+	defer ds.CloseDB()
+	dialect := ds.GetDB().Dialect()
+	for _, m := range models {
+		scope := ds.GetDB().NewScope(m)
+		if !ds.GetDB().HasTable(scope.TableName()) {
+			t.Error("no migration of the table", scope.TableName())
+			continue
+		}
+		for _, f := range scope.GetModelStruct().StructFields {
+			if f.IsNormal && !f.IsIgnored && !dialect.HasColumn(scope.TableName(), f.DBName) {
+				t.Error("no migration of the column", scope.TableName(), f.DBName)
+			}
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package database
+
+// migrations are the versions of the schema. A change of the models of common needs a new migration
+// of the next version, the applied ones are never edited: they have run on the existing databases.
+var migrations = []*Migration{
+	// the frozen tables of schema_v1.go, those AutoMigrate created before the versions are updated in place
+	{Version: 1, Name: "initial schema", Up: (*DataStore).createSchemaV1, Down: (*DataStore).dropSchemaV1, NoTx: true},
+}
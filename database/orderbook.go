@@ -0,0 +1,101 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/exchangedata/common"
+)
+
+// OrderBookAt rebuilds the order book of the market marketRef as it was at the time at,
+// from the latest snapshot before at and the deltas following it.
+// It returns gorm.ErrRecordNotFound if no snapshot of the market is that old.
+func (d *DataStore) OrderBookAt(marketRef uint, at time.Time) (*common.OrderBook, error) {
+	at = at.UTC()
+	ob := &common.OrderBook{}
+	if err := d.db.Where("market_ref = ? and time <= ?", marketRef, at).Order("time desc").First(ob).Error; err != nil {
+		return nil, err
+	}
+	levels := []*common.BookLevel{}
+	if err := d.db.Where("book_ref = ?", ob.ID).Order("side, level").Find(&levels).Error; err != nil {
+		return nil, err
+	}
+	ob.SetLevels(levels)
+
+	deltas := []*common.BookDelta{}
+	if err := d.db.Where("book_ref = ? and time <= ?", ob.ID, at).Order("sequence").Find(&deltas).Error; err != nil {
+		return nil, err
+	}
+	ob.Apply(deltas)
+	return ob, nil
+}
+
+// migrateOrderBookLevels moves the levels of the order books stored with the shared price_vols
+// into book_levels, then drops the old tables.
+func (d *DataStore) migrateOrderBookLevels() error {
+	if !d.db.HasTable("bid_pricevols") {
+		return nil
+	}
+
+	tx := d.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	var last uint
+	for {
+		ids := []uint{}
+		if err := tx.Model(&v1OrderBook{}).Where("id > ?", last).Order("id").Limit(500).Pluck("id", &ids).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		last = ids[len(ids)-1]
+
+		in := make([]string, len(ids))
+		for k, id := range ids {
+			in[k] = strconv.FormatUint(uint64(id), 10)
+		}
+		levels := []interface{}{}
+		for _, side := range []struct {
+			side  uint8
+			table string
+			order string
+		}{{common.BookBid, "bid_pricevols", "p.price desc"}, {common.BookAsk, "ask_pricevols", "p.price"}} {
+			rows, err := tx.Raw("SELECT j.order_book_id, p.price, p.volume FROM " + side.table + " j JOIN price_vols p ON p.id = j.price_vol_id" +
+				" WHERE j.order_book_id IN (" + strings.Join(in, ",") + ") ORDER BY j.order_book_id, " + side.order).Rows()
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			var book, level uint
+			for rows.Next() {
+				l := &v1BookLevel{Side: side.side}
+				if err = rows.Scan(&l.BookRef, &l.Price, &l.Volume); err != nil {
+					break
+				}
+				if l.BookRef != book {
+					book, level = l.BookRef, 0
+				}
+				l.Level = level
+				level++
+				levels = append(levels, l)
+			}
+			rows.Close()
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := d.insertRows(tx, levels); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	return d.db.DropTableIfExists("bid_pricevols", "ask_pricevols", "price_vols").Error
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/jinzhu/gorm"
+)
+
+// DefaultQueryLimit is the number of records of a page of the queries when no limit is given
+const DefaultQueryLimit = 1000
+
+// Cursor is the position of the last record of a page, the next page starts after it.
+// The records are in time order, the id orders those of the same time.
+type Cursor struct {
+	Time time.Time
+	ID   uint
+}
+
+// String encodes the cursor for the clients, ParseCursor decodes it
+func (c *Cursor) String() string {
+	return strconv.FormatInt(c.Time.UnixNano(), 36) + "." + strconv.FormatUint(uint64(c.ID), 36)
+}
+
+func ParseCursor(s string) (*Cursor, error) {
+	k := strings.IndexByte(s, '.')
+	if k < 0 {
+		return nil, errors.New("invalid cursor")
+	}
+	ns, err := strconv.ParseInt(s[:k], 36, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	id, err := strconv.ParseUint(s[k+1:], 36, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &Cursor{Time: time.Unix(0, ns).UTC(), ID: uint(id)}, nil
+}
+
+// LatestTicker returns the last ticker of the market marketRef, gorm.ErrRecordNotFound if there is none
+func (d *DataStore) LatestTicker(marketRef uint) (*common.Ticker, error) {
+	t := &common.Ticker{}
+	if err := d.db.Preload("Market.Exchanger").Where("market_ref = ?", marketRef).Order("time desc").First(t).Error; err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// LatestTickers returns the last ticker of each of the markets marketRefs, of all the markets if none is given.
+// The markets without tickers are left out.
+func (d *DataStore) LatestTickers(marketRefs ...uint) ([]*common.Ticker, error) {
+	q := d.db.Preload("Market.Exchanger").Select("tickers.*").
+		Joins("JOIN (SELECT market_ref, max(time) latest FROM tickers GROUP BY market_ref) l ON l.market_ref = tickers.market_ref AND l.latest = tickers.time")
+	if len(marketRefs) > 0 {
+		q = q.Where("tickers.market_ref in (?)", marketRefs)
+	}
+	c := []*common.Ticker{}
+	if err := q.Order("tickers.market_ref").Find(&c).Error; err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Tickers returns a page of the tickers of the market marketRef in the time range [from, to), in time order.
+// The page starts after the cursor after, nil for the first one, and has at most limit tickers,
+// DefaultQueryLimit when limit is not positive.
+// The cursor of the next page is nil after the last one.
+func (d *DataStore) Tickers(marketRef uint, from, to time.Time, after *Cursor, limit int) ([]*common.Ticker, *Cursor, error) {
+	c := []*common.Ticker{}
+	next, err := d.page(d.db.Preload("Market.Exchanger"), &c, "market_ref", marketRef, from, to, after, limit)
+	return c, next, err
+}
+
+// Trades returns a page of the trades of the market marketRef in the time range [from, to), like Tickers
+func (d *DataStore) Trades(marketRef uint, from, to time.Time, after *Cursor, limit int) ([]*common.Trade, *Cursor, error) {
+	c := []*common.Trade{}
+	next, err := d.page(d.db.Preload("Market.Exchanger"), &c, "market_ref", marketRef, from, to, after, limit)
+	return c, next, err
+}
+
+// Candles returns a page of the candles of interval of the market marketRef starting in [from, to), like Tickers
+func (d *DataStore) Candles(marketRef uint, interval string, from, to time.Time, after *Cursor, limit int) ([]*common.Candle, *Cursor, error) {
+	c := []*common.Candle{}
+	q := d.db.Preload("Market.Exchanger").Where(d.db.Dialect().Quote("interval")+" = ?", interval)
+	next, err := d.page(q, &c, "market_ref", marketRef, from, to, after, limit)
+	return c, next, err
+}
+
+// page loads into out, a pointer to a slice of model pointers, the records selected by q of the market
+// or symbol ref, col is its column, following after. A record past the limit tells whether a next page exists.
+func (d *DataStore) page(q *gorm.DB, out interface{}, col string, ref uint, from, to time.Time, after *Cursor, limit int) (*Cursor, error) {
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	q = q.Where(col+" = ? and time >= ? and time < ?", ref, from.UTC(), to.UTC())
+	if after != nil {
+		at := after.Time.UTC()
+		q = q.Where("time > ? or (time = ? and id > ?)", at, at, after.ID)
+	}
+	if err := q.Order("time, id").Limit(limit + 1).Find(out).Error; err != nil {
+		return nil, err
+	}
+	page := reflect.ValueOf(out).Elem()
+	if page.Len() <= limit {
+		return nil, nil
+	}
+	page.Set(page.Slice(0, limit))
+	last := page.Index(limit - 1).Elem()
+	return &Cursor{Time: last.FieldByName("Time").Interface().(time.Time), ID: uint(last.FieldByName("ID").Uint())}, nil
+}
+
+// MarketFilter selects markets, its empty fields match all of them.
+// The names are compared ignoring the case, a currency matches its name or its abbreviation.
+type MarketFilter struct {
+	ID        uint
+	Name      string // the market names are only unique for an exchanger
+	Exchanger string
+	Currency  string // base or quote
+	Base      string
+	Quote     string
+	Active    bool // only the active markets
+}
+
+// Markets returns the markets selected by f with their exchanger and symbol, in id order
+func (d *DataStore) Markets(f MarketFilter) ([]*common.Market, error) {
+	q := d.db.Preload("Exchanger").Preload("Symbol.Base").Preload("Symbol.Quote").Select("markets.*").
+		Joins("JOIN exchangers ON exchangers.id = markets.ex_ref").
+		Joins("JOIN symbols ON symbols.id = markets.sym_ref").
+		Joins("JOIN currencies cb ON cb.id = symbols.base_id").
+		Joins("JOIN currencies cq ON cq.id = symbols.quote_id")
+	if f.ID != 0 {
+		q = q.Where("markets.id = ?", f.ID)
+	}
+	if f.Name != "" {
+		q = q.Where("upper(markets.name) = ?", strings.ToUpper(f.Name))
+	}
+	if f.Exchanger != "" {
+		q = q.Where("lower(exchangers.name) = ?", strings.ToLower(f.Exchanger))
+	}
+	currency := func(alias string) string {
+		return "(lower(" + alias + ".name) = ? or upper(" + alias + ".abbr) = ?)"
+	}
+	if f.Currency != "" {
+		q = q.Where(currency("cb")+" or "+currency("cq"),
+			strings.ToLower(f.Currency), strings.ToUpper(f.Currency), strings.ToLower(f.Currency), strings.ToUpper(f.Currency))
+	}
+	if f.Base != "" {
+		q = q.Where(currency("cb"), strings.ToLower(f.Base), strings.ToUpper(f.Base))
+	}
+	if f.Quote != "" {
+		q = q.Where(currency("cq"), strings.ToLower(f.Quote), strings.ToUpper(f.Quote))
+	}
+	if f.Active {
+		q = q.Where("markets.active = ?", true)
+	}
+	c := []*common.Market{}
+	if err := q.Order("markets.id").Find(&c).Error; err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Exchangers returns all the exchangers in id order
+func (d *DataStore) Exchangers() ([]*common.Exchanger, error) {
+	c := []*common.Exchanger{}
+	if err := d.db.Order("id").Find(&c).Error; err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Currencies returns all the currencies in id order
+func (d *DataStore) Currencies() ([]*common.Currency, error) {
+	c := []*common.Currency{}
+	if err := d.db.Order("id").Find(&c).Error; err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// MarketsByExchanger returns the markets of the exchanger name
+func (d *DataStore) MarketsByExchanger(name string) ([]*common.Market, error) {
+	return d.Markets(MarketFilter{Exchanger: name})
+}
+
+// MarketsByCurrency returns the markets trading the currency name, as base or quote
+func (d *DataStore) MarketsByCurrency(name string) ([]*common.Market, error) {
+	return d.Markets(MarketFilter{Currency: name})
+}
+
+// addQueryIndexes adds the indexes of the queries by market in time order, the unique indexes start with the time
+func (d *DataStore) addQueryIndexes() error {
+	for _, idx := range []struct {
+		model interface{}
+		name  string
+		cols  []string
+	}{
+		{&v1Ticker{}, "idx_ticker_market_time", []string{"market_ref", "time"}},
+		{&v1Trade{}, "idx_trade_market_time", []string{"market_ref", "time"}},
+		{&v1OrderBook{}, "idx_orderbook_market_time", []string{"market_ref", "time"}},
+		{&v1Candle{}, "idx_candle_market_time", []string{"market_ref", "interval", "time"}},
+		{&v1ConsolidatedTicker{}, "idx_consolidated_symbol_time", []string{"sym_ref", "time"}},
+		{&v1BookMetrics{}, "idx_bookmetrics_market_time", []string{"market_ref", "time"}},
+		{&v1SourceEvent{}, "idx_event_exchanger_time", []string{"exchanger", "time"}},
+		{&v1Market{}, "idx_market_ex", []string{"ex_ref"}},
+		{&v1Symbol{}, "idx_symbol_quote", []string{"quote_id"}},
+	} {
+		if err := d.db.Model(idx.model).AddIndex(idx.name, idx.cols...).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/jinzhu/gorm"
+)
+
+func TestQuery(t *testing.T) {
+	ds := openTestStore(t)
+	defer ds.CloseDB()
+
+	qry := &common.Currency{Name: "querycoin", Abbr: "QRY"}
+	ex := &common.Exchanger{Name: "queryex"}
+	markets := []*common.Market{
+		{Name: "QRY-BTC", Symbol: &common.Symbol{Base: qry, Quote: Currencs["bitcoin"]}, Exchanger: ex},
+		{Name: "DOGE-QRY", Symbol: &common.Symbol{Base: Currencs["Dogecoin"], Quote: qry}, Exchanger: ex},
+	}
+	for _, m := range markets {
+		if ds.UpdateMarket(m).Error != nil {
+			t.Fatal("error save market", ds.GetDB().Error)
+		}
+	}
+	if err := ds.GetDB().Model(markets[1]).Update("active", false).Error; err != nil {
+		t.Fatal("deactivate market", err)
+	}
+
+	start := time.Date(2018, 11, 25, 0, 0, 0, 0, time.UTC)
+	w := ds.NewBatchWriter(0, 0)
+	for k := 0; k < 5; k++ {
+		at := start.Add(time.Duration(k) * time.Minute)
+		for _, m := range markets {
+			if err := w.AddTicker(&common.Ticker{Time: at, Market: m, Last: dec(strconv.Itoa(k + 1))}); err != nil {
+				t.Fatal("add ticker", err)
+			}
+		}
+		// two trades a minute, the second page starts between them
+		for _, id := range []string{"a", "b"} {
+			tr := &common.Trade{Time: at, OrderID: "query" + strconv.Itoa(k) + id, Price: dec("1.5"), Amount: dec("2"), Market: markets[0]}
+			if err := w.AddTrade(tr); err != nil {
+				t.Fatal("add trade", err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("flush", err)
+	}
+
+	ticker, err := ds.LatestTicker(markets[0].ID)
+	if err != nil {
+		t.Fatal("latest ticker", err)
+	}
+	if !ticker.Time.Equal(start.Add(4*time.Minute)) || !ticker.Last.Equal(dec("5")) || ticker.Market.Exchanger.Name != ex.Name {
+		t.Fatal("wrong latest ticker", ticker.Time, ticker.Last)
+	}
+	if _, err = ds.LatestTicker(0); !gorm.IsRecordNotFoundError(err) {
+		t.Fatal("latest ticker of no market", err)
+	}
+	tickers, err := ds.LatestTickers(markets[0].ID, markets[1].ID)
+	if err != nil {
+		t.Fatal("latest tickers", err)
+	}
+	if len(tickers) != 2 || tickers[0].MarketRef != markets[0].ID || !tickers[1].Last.Equal(dec("5")) {
+		t.Fatal("wrong latest tickers", len(tickers))
+	}
+
+	tickers, next, err := ds.Tickers(markets[1].ID, start.Add(time.Minute), start.Add(4*time.Minute), nil, 0)
+	if err != nil {
+		t.Fatal("tickers", err)
+	}
+	if len(tickers) != 3 || next != nil || !tickers[0].Last.Equal(dec("2")) || !tickers[2].Last.Equal(dec("4")) {
+		t.Fatal("wrong tickers in range", len(tickers), next)
+	}
+
+	// minutes 1 to 4, 8 trades in pages of limit
+	tradePages := func(limit int) (ids []string, calls int) {
+		var next *Cursor
+		for {
+			trades, n, err := ds.Trades(markets[0].ID, start.Add(time.Minute), start.Add(5*time.Minute), next, limit)
+			if err != nil {
+				t.Fatal("trades", err)
+			}
+			calls++
+			for _, tr := range trades {
+				ids = append(ids, tr.OrderID)
+			}
+			if n == nil {
+				return
+			}
+			// the clients get the cursor as a string
+			if next, err = ParseCursor(n.String()); err != nil {
+				t.Fatal("parse cursor", err)
+			}
+		}
+	}
+	ids, calls := tradePages(3)
+	if len(ids) != 8 || calls != 3 || ids[0] != "query1a" || ids[3] != "query2b" || ids[7] != "query4b" {
+		t.Fatal("wrong trade pages", calls, ids)
+	}
+	// no empty page after a full last one
+	if ids, calls = tradePages(4); len(ids) != 8 || calls != 2 || ids[4] != "query3a" {
+		t.Fatal("wrong full trade pages", calls, ids)
+	}
+	if _, err = ParseCursor("x"); err == nil {
+		t.Fatal("invalid cursor parsed")
+	}
+
+	for _, c := range []struct {
+		f    MarketFilter
+		want []string
+	}{
+		{MarketFilter{Exchanger: "QueryEx"}, []string{"QRY-BTC", "DOGE-QRY"}},
+		{MarketFilter{Exchanger: "queryex", Active: true}, []string{"QRY-BTC"}},
+		{MarketFilter{Currency: "qry"}, []string{"QRY-BTC", "DOGE-QRY"}},
+		{MarketFilter{Currency: "QueryCoin", Quote: "btc"}, []string{"QRY-BTC"}},
+		{MarketFilter{Base: "dogecoin", Quote: "QRY"}, []string{"DOGE-QRY"}},
+		{MarketFilter{Exchanger: "nowhere"}, nil},
+	} {
+		found, err := ds.Markets(c.f)
+		if err != nil {
+			t.Fatal("markets", c.f, err)
+		}
+		if len(found) != len(c.want) {
+			t.Fatalf("%+v: %d markets, %d expected", c.f, len(found), len(c.want))
+		}
+		for k, m := range found {
+			if m.Name != c.want[k] || m.Exchanger == nil || m.Symbol == nil || m.Symbol.Base == nil || m.Symbol.Quote == nil {
+				t.Fatalf("%+v: wrong market %s", c.f, m.Name)
+			}
+		}
+	}
+	if found, err := ds.MarketsByCurrency("querycoin"); err != nil || len(found) != 2 {
+		t.Fatal("markets by currency", err)
+	}
+	if found, err := ds.MarketsByExchanger("queryex"); err != nil || len(found) != 2 {
+		t.Fatal("markets by exchanger", err)
+	}
+}
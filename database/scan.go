@@ -0,0 +1,83 @@
+package database
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/exchangedata/common"
+)
+
+// DefaultScanSize is the number of records of a page of the Scan functions
+const DefaultScanSize = 5000
+
+// ScanTickers calls f with the tickers of the time range [from, to), a page of size at a time in id order.
+// The markets are loaded with their exchangers. A zero size takes DefaultScanSize.
+func (d *DataStore) ScanTickers(from, to time.Time, size int, f func([]*common.Ticker) error) error {
+	page := []*common.Ticker{}
+	return d.scan(&page, from, to, size, func() error { return f(page) })
+}
+
+// ScanTrades calls f with the trades of the time range [from, to), like ScanTickers
+func (d *DataStore) ScanTrades(from, to time.Time, size int, f func([]*common.Trade) error) error {
+	page := []*common.Trade{}
+	return d.scan(&page, from, to, size, func() error { return f(page) })
+}
+
+// ScanCandles calls f with the candles starting in the time range [from, to), like ScanTickers
+func (d *DataStore) ScanCandles(from, to time.Time, size int, f func([]*common.Candle) error) error {
+	page := []*common.Candle{}
+	return d.scan(&page, from, to, size, func() error { return f(page) })
+}
+
+// ScanOrderBooks calls f with the order book snapshots of the time range [from, to) and their levels,
+// like ScanTickers. The deltas in between are not included.
+func (d *DataStore) ScanOrderBooks(from, to time.Time, size int, f func([]*common.OrderBook) error) error {
+	page := []*common.OrderBook{}
+	return d.scan(&page, from, to, size, func() error {
+		ids := make([]uint, len(page))
+		for k, b := range page {
+			ids[k] = b.ID
+		}
+		levels := []*common.BookLevel{}
+		if err := d.db.Where("book_ref in (?)", ids).Order("book_ref, side, level").Find(&levels).Error; err != nil {
+			return err
+		}
+		byBook := make(map[uint][]*common.BookLevel)
+		for _, l := range levels {
+			byBook[l.BookRef] = append(byBook[l.BookRef], l)
+		}
+		for _, b := range page {
+			b.SetLevels(byBook[b.ID])
+		}
+		return f(page)
+	})
+}
+
+// scan loads the pages of records of the time range into out, a pointer to a slice of model pointers,
+// and calls f after each one
+func (d *DataStore) scan(out interface{}, from, to time.Time, size int, f func() error) error {
+	if size <= 0 {
+		size = DefaultScanSize
+	}
+	page := reflect.ValueOf(out).Elem()
+	var last uint64
+	for {
+		page.Set(reflect.MakeSlice(page.Type(), 0, size))
+		err := d.db.Preload("Market.Exchanger").Where("time >= ? and time < ? and id > ?", from.UTC(), to.UTC(), last).
+			Order("id").Limit(size).Find(out).Error
+		if err != nil {
+			return err
+		}
+		n := page.Len()
+		if n == 0 {
+			return nil
+		}
+		last = page.Index(n - 1).Elem().FieldByName("ID").Uint()
+		if err = f(); err != nil {
+			return err
+		}
+		if n < size {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/exchangedata/common"
+)
+
+func TestScan(t *testing.T) {
+	ds := openTestStore(t)
+	defer ds.CloseDB()
+
+	m := Markes[2]
+	if ds.UpdateMarket(m).Error != nil {
+		t.Fatal("error save market", ds.GetDB().Error)
+	}
+
+	start := time.Date(2018, 11, 24, 0, 0, 0, 0, time.UTC)
+	w := ds.NewBatchWriter(0, 0)
+	w.SnapshotInterval = 0 // all snapshots
+	for k := 0; k < 7; k++ {
+		at := start.Add(time.Duration(k) * time.Minute)
+		if err := w.AddTrade(&common.Trade{Time: at, OrderID: "scan" + strconv.Itoa(k), Price: dec("1.5"), Amount: dec("2"), Market: m}); err != nil {
+			t.Fatal("add trade", err)
+		}
+		ob := &common.OrderBook{Time: at, Market: m,
+			Bids: []*common.PriceVol{{Price: dec("1.4"), Volume: dec("3")}},
+			Asks: []*common.PriceVol{{Price: dec("1.6"), Volume: dec("1")}, {Price: dec("1.7"), Volume: dec("5")}}}
+		if err := w.AddOrderBook(ob); err != nil {
+			t.Fatal("add orderbook", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("flush", err)
+	}
+
+	// minutes 1 to 5, in pages of 2
+	from, to := start.Add(time.Minute), start.Add(6*time.Minute)
+	var trades, pages int
+	err := ds.ScanTrades(from, to, 2, func(c []*common.Trade) error {
+		pages++
+		for _, tr := range c {
+			if tr.Market == nil || tr.Market.Exchanger == nil || tr.Market.Exchanger.Name != m.Exchanger.Name {
+				t.Fatal("market of the trade not loaded", tr.Market)
+			}
+			if tr.Time.Before(from) || !tr.Time.Before(to) {
+				t.Fatal("trade out of range", tr.Time)
+			}
+		}
+		trades += len(c)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("scan trades", err)
+	}
+	if trades != 5 || pages != 3 {
+		t.Fatalf("%d trades in %d pages, 5 in 3 expected", trades, pages)
+	}
+
+	var books int
+	err = ds.ScanOrderBooks(from, to, 0, func(c []*common.OrderBook) error {
+		for _, b := range c {
+			if b.MarketRef != m.ID {
+				continue
+			}
+			if len(b.Bids) != 1 || len(b.Asks) != 2 || !b.Asks[1].Price.Equal(dec("1.7")) {
+				t.Fatalf("levels of the order book not loaded, %d bids %d asks", len(b.Bids), len(b.Asks))
+			}
+			books++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("scan order books", err)
+	}
+	if books != 5 {
+		t.Fatalf("%d order books, 5 expected", books)
+	}
+}
@@ -0,0 +1,309 @@
+package database
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// The tables of the migration 1 as they were when the migrations replaced AutoMigrate. They are a copy of the
+// columns and indexes of the models of common at the time, frozen: the later changes of the models go to
+// new migrations, the migration 1 must create the same schema on every database.
+
+type v1Currency struct {
+	ID        uint   `gorm:"primary_key"`
+	Name      string `gorm:"unique;size:64;not null"`
+	Abbr      string `gorm:"size:16"`
+	AbbrFinal bool   `gorm:"default:false"`
+	Info      string
+}
+
+func (v1Currency) TableName() string { return "currencies" }
+
+type v1Exchanger struct {
+	ID   uint   `gorm:"primary_key"`
+	Name string `gorm:"unique;not null"`
+	Info string
+}
+
+func (v1Exchanger) TableName() string { return "exchangers" }
+
+// v1CurrencyExchanger is the join table of the many2many currencies of the exchangers
+type v1CurrencyExchanger struct {
+	ExchangerID uint `gorm:"primary_key;auto_increment:false"`
+	CurrencyID  uint `gorm:"primary_key;auto_increment:false"`
+}
+
+func (v1CurrencyExchanger) TableName() string { return "currency_exchangers" }
+
+type v1Market struct {
+	ID        uint   `gorm:"primary_key"`
+	Name      string `gorm:"not null"`
+	SymRef    uint   `gorm:"unique_index:idx_sym_ex"`
+	Active    bool   `gorm:"default:true"`
+	Info      string
+	Precision uint            `gorm:"default:8"`
+	AmountMin decimal.Decimal `gorm:"type:decimal(36,18)"`
+	AmountMax decimal.Decimal `gorm:"type:decimal(36,18)"`
+	MinStep   decimal.Decimal `gorm:"type:decimal(36,18)"`
+	ExRef     uint            `gorm:"unique_index:idx_sym_ex"`
+}
+
+func (v1Market) TableName() string { return "markets" }
+
+type v1CommunicationAPI struct {
+	ID            uint   `gorm:"primary_key"`
+	Version       string `gorm:"size:32"`
+	WebURL        string `gorm:"size:32;not null"`
+	WssURL        string
+	Enable        bool
+	ExRef         uint
+	Timeout       int
+	RateLimit     int
+	CommComAPIRef uint
+	CommFilePath  string
+	CommSalt      int
+}
+
+func (v1CommunicationAPI) TableName() string { return "communication_apis" }
+
+type v1AccessSecret struct {
+	ComAPIRef uint
+	FilePath  string
+	Salt      int
+}
+
+func (v1AccessSecret) TableName() string { return "access_secrets" }
+
+type v1Symbol struct {
+	ID      uint `gorm:"primary_key"`
+	BaseID  uint `gorm:"unique_index:idx_base_quote"`
+	QuoteID uint `gorm:"unique_index:idx_base_quote"`
+}
+
+func (v1Symbol) TableName() string { return "symbols" }
+
+type v1Ticker struct {
+	ID            uint            `gorm:"primary_key"`
+	Time          time.Time       `gorm:"unique_index:idx_time_market;not null"`
+	MarketRef     uint            `gorm:"unique_index:idx_time_market;not null"`
+	High          decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Low           decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Bid           decimal.Decimal `gorm:"type:decimal(36,18)"`
+	BidVolume     decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Ask           decimal.Decimal `gorm:"type:decimal(36,18)"`
+	AskVolume     decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Last          decimal.Decimal `gorm:"type:decimal(36,18)"`
+	PreviousClose decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Change        decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Percentage    decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Average       decimal.Decimal `gorm:"type:decimal(36,18)"`
+	BaseVolume    decimal.Decimal `gorm:"type:decimal(36,18)"`
+	QuoteVolume   decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Open          decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Close         decimal.Decimal `gorm:"type:decimal(36,18)"`
+}
+
+func (v1Ticker) TableName() string { return "tickers" }
+
+type v1Trade struct {
+	ID        uint      `gorm:"primary_key"`
+	Time      time.Time `gorm:"not null"`
+	MarketRef uint      `gorm:"unique_index:idx_market_trade;not null"`
+	OrderID   string    `gorm:"unique_index:idx_market_trade;not null"`
+	Type      string
+	Side      string
+	Price     decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Amount    decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Total     decimal.Decimal `gorm:"type:decimal(36,18)"`
+}
+
+func (v1Trade) TableName() string { return "trades" }
+
+type v1OrderBook struct {
+	ID        uint      `gorm:"primary_key"`
+	Time      time.Time `gorm:"unique_index:idx_market_orderbook;not null"`
+	MarketRef uint      `gorm:"unique_index:idx_market_orderbook;not null"`
+	Sequence  uint64
+}
+
+func (v1OrderBook) TableName() string { return "order_books" }
+
+type v1BookLevel struct {
+	BookRef uint            `gorm:"primary_key;auto_increment:false"`
+	Side    uint8           `gorm:"primary_key;auto_increment:false"`
+	Level   uint            `gorm:"primary_key;auto_increment:false"`
+	Price   decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Volume  decimal.Decimal `gorm:"type:decimal(36,18)"`
+}
+
+func (v1BookLevel) TableName() string { return "book_levels" }
+
+type v1BookDelta struct {
+	ID        uint      `gorm:"primary_key"`
+	Time      time.Time `gorm:"index:idx_market_bookdelta;not null"`
+	MarketRef uint      `gorm:"index:idx_market_bookdelta;not null"`
+	BookRef   uint      `gorm:"index;not null"`
+	Sequence  uint64
+	Side      uint8
+	Price     decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Volume    decimal.Decimal `gorm:"type:decimal(36,18)"`
+}
+
+func (v1BookDelta) TableName() string { return "book_delta" }
+
+type v1Candle struct {
+	ID          uint            `gorm:"primary_key"`
+	Time        time.Time       `gorm:"unique_index:idx_market_candle;not null"`
+	MarketRef   uint            `gorm:"unique_index:idx_market_candle;not null"`
+	Interval    string          `gorm:"unique_index:idx_market_candle;size:8;not null"`
+	Open        decimal.Decimal `gorm:"type:decimal(36,18)"`
+	High        decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Low         decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Close       decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Volume      decimal.Decimal `gorm:"type:decimal(36,18)"`
+	QuoteVolume decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Trades      uint
+}
+
+func (v1Candle) TableName() string { return "candles" }
+
+type v1ConsolidatedTicker struct {
+	ID           uint            `gorm:"primary_key"`
+	Time         time.Time       `gorm:"unique_index:idx_symbol_consolidated;not null"`
+	SymRef       uint            `gorm:"unique_index:idx_symbol_consolidated;not null"`
+	Bid          decimal.Decimal `gorm:"type:decimal(36,18)"`
+	BidVolume    decimal.Decimal `gorm:"type:decimal(36,18)"`
+	BidExchanger string          `gorm:"size:64"`
+	Ask          decimal.Decimal `gorm:"type:decimal(36,18)"`
+	AskVolume    decimal.Decimal `gorm:"type:decimal(36,18)"`
+	AskExchanger string          `gorm:"size:64"`
+	VWAP         decimal.Decimal `gorm:"column:vwap;type:decimal(36,18)"`
+	Volume       decimal.Decimal `gorm:"type:decimal(36,18)"`
+}
+
+func (v1ConsolidatedTicker) TableName() string { return "consolidated_tickers" }
+
+type v1VenueQuote struct {
+	ID        uint   `gorm:"primary_key"`
+	TickerRef uint   `gorm:"index;not null"`
+	MarketRef uint   `gorm:"not null"`
+	Exchanger string `gorm:"size:64"`
+	Time      time.Time
+	Bid       decimal.Decimal `gorm:"type:decimal(36,18)"`
+	BidVolume decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Ask       decimal.Decimal `gorm:"type:decimal(36,18)"`
+	AskVolume decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Spread    decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Last      decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Volume    decimal.Decimal `gorm:"type:decimal(36,18)"`
+}
+
+func (v1VenueQuote) TableName() string { return "venue_quotes" }
+
+type v1QualityIssue struct {
+	ID        uint      `gorm:"primary_key"`
+	Time      time.Time `gorm:"index:idx_market_issue;not null"`
+	MarketRef uint      `gorm:"index:idx_market_issue;not null"`
+	Kind      string    `gorm:"size:16"`
+	Rule      string    `gorm:"size:32;index"`
+	Action    string    `gorm:"size:16"`
+	Detail    string
+	Data      string `gorm:"type:text"`
+}
+
+func (v1QualityIssue) TableName() string { return "quality_issues" }
+
+type v1SourceEvent struct {
+	ID        uint      `gorm:"primary_key"`
+	Time      time.Time `gorm:"not null"`
+	Exchanger string    `gorm:"size:64;not null"`
+	MarketRef uint
+	Kind      string `gorm:"size:32"`
+	Detail    string
+}
+
+func (v1SourceEvent) TableName() string { return "source_events" }
+
+type v1BookMetrics struct {
+	ID           uint                `gorm:"primary_key"`
+	Time         time.Time           `gorm:"unique_index:idx_market_bookmetrics;not null"`
+	MarketRef    uint                `gorm:"unique_index:idx_market_bookmetrics;not null"`
+	Mid          decimal.Decimal     `gorm:"type:decimal(36,18)"`
+	Spread       decimal.Decimal     `gorm:"type:decimal(36,18)"`
+	Microprice   decimal.Decimal     `gorm:"type:decimal(36,18)"`
+	Band         decimal.Decimal     `gorm:"type:decimal(36,18)"`
+	BidDepth     decimal.Decimal     `gorm:"type:decimal(36,18)"`
+	AskDepth     decimal.Decimal     `gorm:"type:decimal(36,18)"`
+	Imbalance    decimal.Decimal     `gorm:"type:decimal(36,18)"`
+	Notional     decimal.Decimal     `gorm:"type:decimal(36,18)"`
+	BuySlippage  decimal.NullDecimal `gorm:"type:decimal(36,18)"`
+	SellSlippage decimal.NullDecimal `gorm:"type:decimal(36,18)"`
+}
+
+func (v1BookMetrics) TableName() string { return "book_metrics" }
+
+type v1AlertRule struct {
+	ID        uint            `gorm:"primary_key"`
+	Name      string          `gorm:"size:64"`
+	MarketRef uint            `gorm:"index;not null"`
+	Metric    string          `gorm:"size:16;not null"`
+	Op        string          `gorm:"size:8"`
+	Threshold decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Channel   string          `gorm:"size:16;not null"`
+	Target    string          `gorm:"not null"`
+	Cooldown  int64
+	Enabled   bool
+}
+
+func (v1AlertRule) TableName() string { return "alert_rules" }
+
+type v1Alert struct {
+	ID        uint            `gorm:"primary_key"`
+	Time      time.Time       `gorm:"index:idx_rule_alert;not null"`
+	RuleRef   uint            `gorm:"index:idx_rule_alert;not null"`
+	Value     decimal.Decimal `gorm:"type:decimal(36,18)"`
+	Message   string
+	Delivered bool
+	Error     string
+}
+
+func (v1Alert) TableName() string { return "alerts" }
+
+// v1Tables are the tables of the migration 1 in creation order
+var v1Tables = []interface{}{&v1Exchanger{}, &v1Market{}, &v1Currency{}, &v1CurrencyExchanger{},
+	&v1CommunicationAPI{}, &v1AccessSecret{}, &v1Symbol{},
+	&v1Ticker{}, &v1Trade{}, &v1OrderBook{}, &v1BookLevel{}, &v1BookDelta{}, &v1Candle{},
+	&v1ConsolidatedTicker{}, &v1VenueQuote{}, &v1QualityIssue{}, &v1SourceEvent{},
+	&v1BookMetrics{}, &v1AlertRule{}, &v1Alert{}}
+
+// v1DecimalTables are the tables of the migration 1 whose decimal columns were floating point before
+var v1DecimalTables = []interface{}{&v1Market{}, &v1Ticker{}, &v1Trade{}, &v1BookLevel{}, &v1BookDelta{}, &v1Candle{}}
+
+// createSchemaV1 creates the tables of the migration 1 and their indexes, or adds the missing columns and indexes
+// of the tables created by AutoMigrate before the migrations, converting their order books and decimal columns
+func (d *DataStore) createSchemaV1() error {
+	if err := d.db.AutoMigrate(v1Tables...).Error; err != nil {
+		return err
+	}
+	for _, step := range []func() error{d.migrateOrderBookLevels, d.migrateDecimalColumns, d.addQueryIndexes, d.setupTimescale} {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropSchemaV1 drops the tables of the migration 1, with the views on them on postgres
+func (d *DataStore) dropSchemaV1() error {
+	for k := len(v1Tables) - 1; k >= 0; k-- {
+		s := "DROP TABLE IF EXISTS " + d.db.NewScope(v1Tables[k]).TableName()
+		if d.Dialect == "postgres" {
+			s += " CASCADE"
+		}
+		if err := d.db.Exec(s).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/jinzhu/gorm"
+)
+
+// InsertSourceEvents stores the source events c
+func (d *DataStore) InsertSourceEvents(c []*common.SourceEvent) error {
+	rows := make([]interface{}, len(c))
+	for k, e := range c {
+		rows[k] = e
+	}
+	return d.insertRows(d.db, rows)
+}
+
+// SourceEvents returns the source events of the exchanger in the time range [from, to), in time order
+func (d *DataStore) SourceEvents(exchanger string, from, to time.Time) ([]*common.SourceEvent, error) {
+	c := []*common.SourceEvent{}
+	err := d.db.Where("exchanger = ? and time >= ? and time < ?", exchanger, from.UTC(), to.UTC()).Order("time, id").Find(&c).Error
+	return c, err
+}
+
+// the record kinds of ScanTimes and CountRecords
+const (
+	KindTicker = "ticker"
+	KindTrade  = "trade"
+	KindCandle = "candle"
+)
+
+// times selects the times of the records of kind of the market marketRef in [from, to), interval is of the candles
+func (d *DataStore) times(kind string, marketRef uint, interval string, from, to time.Time) (*gorm.DB, error) {
+	var model interface{}
+	switch kind {
+	case KindTicker:
+		model = &common.Ticker{}
+	case KindTrade:
+		model = &common.Trade{}
+	case KindCandle:
+		model = &common.Candle{}
+	default:
+		return nil, fmt.Errorf("unknown record kind %s", kind)
+	}
+	q := d.db.Model(model).Where("market_ref = ? and time >= ? and time < ?", marketRef, from.UTC(), to.UTC())
+	if kind == KindCandle {
+		q = q.Where(d.db.Dialect().Quote("interval")+" = ?", interval)
+	}
+	return q, nil
+}
+
+// ScanTimes calls f with the times of the records of kind, ticker, trade or candle, of the market marketRef
+// in the time range [from, to), in time order. interval selects the candles.
+func (d *DataStore) ScanTimes(kind string, marketRef uint, interval string, from, to time.Time, f func(time.Time) error) error {
+	q, err := d.times(kind, marketRef, interval, from, to)
+	if err != nil {
+		return err
+	}
+	rows, err := q.Select("time").Order("time").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t time.Time
+		if err = rows.Scan(&t); err != nil {
+			return err
+		}
+		if err = f(t); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// CountRecords returns the number of the records of ScanTimes
+func (d *DataStore) CountRecords(kind string, marketRef uint, interval string, from, to time.Time) (int, error) {
+	q, err := d.times(kind, marketRef, interval, from, to)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	err = q.Count(&n).Error
+	return n, err
+}
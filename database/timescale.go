@@ -0,0 +1,129 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+// hypertables are the tables partitioned by time when TimescaleDB is available.
+// A unique index of a hypertable has to include the time column, unique lists the ones rebuilt for it.
+var hypertables = []struct {
+	table  string
+	unique map[string]string // index name: columns
+}{
+	{"tickers", nil},
+	{"trades", map[string]string{"idx_market_trade": "market_ref, order_id, time"}},
+	{"order_books", nil},
+	{"book_delta", nil},
+}
+
+// ohlcvViews are the continuous aggregates of the trades.
+// Each one is refreshed every schedule for the buckets between start and end before now.
+var ohlcvViews = []struct {
+	name     string
+	bucket   string
+	start    string
+	end      string
+	schedule string
+}{
+	{"trade_ohlcv_1m", "1 minute", "1 hour", "1 minute", "1 minute"},
+	{"trade_ohlcv_1h", "1 hour", "1 day", "1 hour", "30 minutes"},
+	{"trade_ohlcv_1d", "1 day", "3 days", "1 day", "1 hour"},
+}
+
+// setupTimescale turns the market data tables into hypertables and creates the OHLCV views of the trades,
+// on postgres with the timescaledb extension installed. It is a no-op otherwise, and once done.
+func (d *DataStore) setupTimescale() error {
+	if d.Dialect != "postgres" {
+		return nil
+	}
+	var n int
+	if err := d.db.Raw("SELECT count(*) FROM pg_available_extensions WHERE name = 'timescaledb'").Row().Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		log.Println("timescaledb not available, tables are left unpartitioned")
+		return nil
+	}
+	if err := d.db.Exec("CREATE EXTENSION IF NOT EXISTS timescaledb").Error; err != nil {
+		return err
+	}
+	for _, h := range hypertables {
+		if err := d.createHypertable(h.table, h.unique); err != nil {
+			return fmt.Errorf("hypertable %s: %v", h.table, err)
+		}
+	}
+	for _, v := range ohlcvViews {
+		if err := d.createOHLCVView(v.name, v.bucket, v.start, v.end, v.schedule); err != nil {
+			return fmt.Errorf("continuous aggregate %s: %v", v.name, err)
+		}
+	}
+	return nil
+}
+
+// createHypertable partitions table by time, the existing rows are moved into the chunks.
+// The primary key becomes (id, time) and the unique indexes are rebuilt with their new columns.
+func (d *DataStore) createHypertable(table string, unique map[string]string) error {
+	var n int
+	if err := d.db.Raw("SELECT count(*) FROM timescaledb_information.hypertables WHERE hypertable_name = ?", table).Row().Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	tx := d.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	for _, s := range hypertableStatements(table, unique) {
+		if err := tx.Exec(s).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// hypertableStatements returns the statements partitioning table by time, with the unique indexes in name order
+func hypertableStatements(table string, unique map[string]string) []string {
+	stmts := []string{
+		"ALTER TABLE " + table + " DROP CONSTRAINT IF EXISTS " + table + "_pkey",
+		"ALTER TABLE " + table + " ADD PRIMARY KEY (id, time)",
+	}
+	names := make([]string, 0, len(unique))
+	for name := range unique {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stmts = append(stmts, "DROP INDEX IF EXISTS "+name, "CREATE UNIQUE INDEX "+name+" ON "+table+" ("+unique[name]+")")
+	}
+	return append(stmts, "SELECT create_hypertable('"+table+"', 'time', migrate_data => true)")
+}
+
+// createOHLCVView creates the continuous aggregate name of the trades by market in buckets of bucket
+// and its refresh policy. A continuous aggregate can not be created in a transaction.
+func (d *DataStore) createOHLCVView(name, bucket, start, end, schedule string) error {
+	for _, s := range ohlcvViewStatements(name, bucket, start, end, schedule) {
+		if err := d.db.Exec(s).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ohlcvViewStatements returns the statements creating the continuous aggregate name and its refresh policy
+func ohlcvViewStatements(name, bucket, start, end, schedule string) []string {
+	return []string{
+		"CREATE MATERIALIZED VIEW IF NOT EXISTS " + name + " WITH (timescaledb.continuous) AS" +
+			" SELECT market_ref, time_bucket(INTERVAL '" + bucket + "', time) AS bucket," +
+			" first(price, time) AS open, max(price) AS high, min(price) AS low, last(price, time) AS close," +
+			" sum(amount) AS volume, sum(total) AS quote_volume, count(*) AS trades" +
+			" FROM trades GROUP BY market_ref, bucket WITH NO DATA",
+		"SELECT add_continuous_aggregate_policy('" + name + "'," +
+			" start_offset => INTERVAL '" + start + "', end_offset => INTERVAL '" + end + "'," +
+			" schedule_interval => INTERVAL '" + schedule + "', if_not_exists => true)",
+	}
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTimescaleGating(t *testing.T) {
+	// no query on the other dialects, the store of mysql has no connection
+	if err := (&DataStore{Dialect: "mysql"}).setupTimescale(); err != nil {
+		t.Fatal("mysql", err)
+	}
+	ds := openTestStore(t)
+	defer ds.CloseDB()
+	if ds.Dialect != "sqlite3" {
+		t.Skip("timescale tables of", ds.Dialect)
+	}
+	if err := ds.setupTimescale(); err != nil {
+		t.Fatal("sqlite", err)
+	}
+	for _, v := range ohlcvViews {
+		if ds.GetDB().HasTable(v.name) {
+			t.Error("continuous aggregate on sqlite", v.name)
+		}
+	}
+}
+
+func TestHypertables(t *testing.T) {
+	ds := openTestStore(t)
+	defer ds.CloseDB()
+	dialect := ds.GetDB().Dialect()
+	for _, h := range hypertables {
+		if !ds.GetDB().HasTable(h.table) {
+			t.Error("hypertable of no table", h.table)
+			continue
+		}
+		for _, col := range []string{"id", "time"} {
+			if !dialect.HasColumn(h.table, col) {
+				t.Error("hypertable without column", h.table, col)
+			}
+		}
+		for name, cols := range h.unique {
+			for _, col := range strings.Split(cols, ",") {
+				if !dialect.HasColumn(h.table, strings.TrimSpace(col)) {
+					t.Error("unique index of a missing column", h.table, name, col)
+				}
+			}
+		}
+	}
+	for _, col := range []string{"market_ref", "time", "price", "amount", "total"} {
+		if !dialect.HasColumn("trades", col) {
+			t.Error("continuous aggregate of a missing trade column", col)
+		}
+	}
+
+	got := hypertableStatements("trades", map[string]string{"idx_b": "b, time", "idx_a": "a, time"})
+	expected := []string{
+		"ALTER TABLE trades DROP CONSTRAINT IF EXISTS trades_pkey",
+		"ALTER TABLE trades ADD PRIMARY KEY (id, time)",
+		"DROP INDEX IF EXISTS idx_a",
+		"CREATE UNIQUE INDEX idx_a ON trades (a, time)",
+		"DROP INDEX IF EXISTS idx_b",
+		"CREATE UNIQUE INDEX idx_b ON trades (b, time)",
+		"SELECT create_hypertable('trades', 'time', migrate_data => true)",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Error("hypertable statements", got)
+	}
+	got = ohlcvViewStatements("trade_ohlcv_1h", "1 hour", "1 day", "1 hour", "30 minutes")
+	if len(got) != 2 || !strings.HasPrefix(got[0], "CREATE MATERIALIZED VIEW IF NOT EXISTS trade_ohlcv_1h WITH (timescaledb.continuous)") ||
+		!strings.Contains(got[0], "time_bucket(INTERVAL '1 hour', time)") ||
+		got[1] != "SELECT add_continuous_aggregate_policy('trade_ohlcv_1h', start_offset => INTERVAL '1 day',"+
+			" end_offset => INTERVAL '1 hour', schedule_interval => INTERVAL '30 minutes', if_not_exists => true)" {
+		t.Error("continuous aggregate statements", got)
+	}
+}
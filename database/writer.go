@@ -0,0 +1,585 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	DefaultBatchSize     = 1000            // records buffered before a flush
+	DefaultBatchInterval = 2 * time.Second // longest time a record stays in the buffer
+
+	DefaultSnapshotInterval = time.Minute // order books in between are stored as deltas of the last snapshot
+)
+
+// BatchWriter buffers tickers, trades, candles, order books and their deltas and stores them with multi-row inserts.
+// Each flush runs in one transaction, records already stored with the same unique key are kept as they are,
+// except the candles which replace them.
+// A flush is triggered when size records are buffered, every interval, or by Flush and Close.
+// The records added are copied, not modified: they are shared with the other subscribers of the bus.
+//
+// An order book is stored as a full snapshot once per SnapshotInterval for each market,
+// the books received in between are stored as the deltas from the previous one.
+type BatchWriter struct {
+	SnapshotInterval time.Duration // set before the first order book is added
+
+	ds       *DataStore
+	size     int
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending int // rows buffered, an order book counts its levels too
+	tickers []*common.Ticker
+	trades  []*common.Trade
+	candles []*common.Candle
+	books   []*common.OrderBook
+	deltas  []*bookDelta
+	markets map[uint]*bookState // order book state by market id
+	refs    map[string]uint     // ids of the stored markets the records had without id, by exchanger:name
+
+	flushMu sync.Mutex // one flush at a time
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewBatchWriter creates a BatchWriter on d, zero size or interval take the defaults
+func (d *DataStore) NewBatchWriter(size int, interval time.Duration) *BatchWriter {
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	if interval <= 0 {
+		interval = DefaultBatchInterval
+	}
+	w := &BatchWriter{
+		SnapshotInterval: DefaultSnapshotInterval,
+
+		ds:       d,
+		size:     size,
+		interval: interval,
+		markets:  make(map[uint]*bookState),
+		refs:     make(map[string]uint),
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *BatchWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.kick:
+		case <-w.stop:
+			return
+		}
+		if err := w.Flush(); err != nil {
+			log.Println("batch writer flush error:", err)
+		}
+	}
+}
+
+// Close stops the background flushing and writes what is left in the buffer
+func (w *BatchWriter) Close() error {
+	close(w.stop)
+	<-w.done
+	return w.Flush()
+}
+
+// AddTicker buffers a copy of c, the market of c is stored first if it is new
+func (w *BatchWriter) AddTicker(c *common.Ticker) error {
+	ref, err := w.marketRef(c.MarketRef, c.Market)
+	if err != nil {
+		return err
+	}
+	r := *c
+	r.MarketRef = ref
+
+	w.mu.Lock()
+	w.tickers = append(w.tickers, &r)
+	w.added(1)
+	w.mu.Unlock()
+	return nil
+}
+
+// AddTrade buffers a copy of c, the market of c is stored first if it is new
+func (w *BatchWriter) AddTrade(c *common.Trade) error {
+	ref, err := w.marketRef(c.MarketRef, c.Market)
+	if err != nil {
+		return err
+	}
+	r := *c
+	r.MarketRef = ref
+
+	w.mu.Lock()
+	w.trades = append(w.trades, &r)
+	w.added(1)
+	w.mu.Unlock()
+	return nil
+}
+
+// AddCandle buffers a copy of c, the market of c is stored first if it is new
+func (w *BatchWriter) AddCandle(c *common.Candle) error {
+	ref, err := w.marketRef(c.MarketRef, c.Market)
+	if err != nil {
+		return err
+	}
+	r := *c
+	r.MarketRef = ref
+
+	w.mu.Lock()
+	w.candles = append(w.candles, &r)
+	w.added(1)
+	w.mu.Unlock()
+	return nil
+}
+
+// bookState is the last order book written of a market
+type bookState struct {
+	snapshot *common.OrderBook // the snapshot the deltas refer to
+	last     *common.OrderBook
+	sequence uint64
+}
+
+// bookDelta is a buffered delta waiting for the id of its snapshot
+type bookDelta struct {
+	*common.BookDelta
+	snapshot *common.OrderBook
+}
+
+// AddOrderBook buffers a copy of c as a snapshot or as the deltas from the previous book of the market.
+// The market of c is stored first if it is new.
+func (w *BatchWriter) AddOrderBook(ob *common.OrderBook) error {
+	ref, err := w.marketRef(ob.MarketRef, ob.Market)
+	if err != nil {
+		return err
+	}
+	c := new(common.OrderBook)
+	*c = *ob // the levels are only read
+	c.MarketRef = ref
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	st := w.markets[ref]
+	if st == nil {
+		st = &bookState{}
+		w.markets[ref] = st
+	}
+	if st.snapshot == nil || c.Time.Sub(st.snapshot.Time) >= w.SnapshotInterval {
+		st.sequence++
+		c.Sequence = st.sequence
+		st.snapshot, st.last = c, c
+		w.books = append(w.books, c)
+		w.added(1 + len(c.Bids) + len(c.Asks))
+		return nil
+	}
+
+	deltas := common.DiffOrderBook(st.last, c)
+	for _, d := range deltas {
+		st.sequence++
+		d.Sequence = st.sequence
+		w.deltas = append(w.deltas, &bookDelta{BookDelta: d, snapshot: st.snapshot})
+	}
+	c.Sequence = st.sequence
+	st.last = c
+	w.added(len(deltas))
+	return nil
+}
+
+// AddBookDeltas buffers copies of the changes c of the order book of m, from a streaming source, as the deltas
+// of the last snapshot of the market. They are applied to the last book, which is stored as a snapshot instead
+// once per SnapshotInterval. The deltas before the first order book of the market are dropped.
+func (w *BatchWriter) AddBookDeltas(m *common.Market, c []*common.BookDelta) error {
+	if len(c) == 0 {
+		return nil
+	}
+	ref, err := w.marketRef(c[0].MarketRef, m)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	st := w.markets[ref]
+	if st == nil || st.snapshot == nil {
+		return nil
+	}
+	last := st.last.Clone()
+	last.Apply(c)
+	if last.Time.Sub(st.snapshot.Time) >= w.SnapshotInterval {
+		st.sequence++
+		last.ID, last.Sequence = 0, st.sequence
+		st.snapshot, st.last = last, last
+		w.books = append(w.books, last)
+		w.added(1 + len(last.Bids) + len(last.Asks))
+		return nil
+	}
+	for _, d := range c {
+		r := *d
+		r.ID, r.MarketRef = 0, ref
+		st.sequence++
+		r.Sequence = st.sequence
+		w.deltas = append(w.deltas, &bookDelta{BookDelta: &r, snapshot: st.snapshot})
+	}
+	last.Sequence = st.sequence
+	st.last = last
+	w.added(len(c))
+	return nil
+}
+
+// marketRef returns the market id of a record like DataStore.marketRef, a market without id is stored
+// from a copy: the markets of the records are shared too
+func (w *BatchWriter) marketRef(ref uint, m *common.Market) (uint, error) {
+	if ref != 0 || m == nil || m.ID != 0 {
+		return w.ds.marketRef(ref, m)
+	}
+	key := m.Name
+	if m.Exchanger != nil {
+		key = m.Exchanger.Name + ":" + m.Name
+	}
+	w.mu.Lock()
+	id := w.refs[key]
+	w.mu.Unlock()
+	if id != 0 {
+		return id, nil
+	}
+	id, err := w.ds.marketRef(0, copyMarket(m))
+	if err != nil {
+		return 0, err
+	}
+	w.mu.Lock()
+	w.refs[key] = id
+	w.mu.Unlock()
+	return id, nil
+}
+
+// copyMarket copies m with its exchanger and symbol, the ones UpdateMarket sets the ids of
+func copyMarket(m *common.Market) *common.Market {
+	c := *m
+	if m.Exchanger != nil {
+		e := *m.Exchanger
+		c.Exchanger = &e
+	}
+	if m.Symbol != nil {
+		s := *m.Symbol
+		for _, cu := range []**common.Currency{&s.Base, &s.Quote} {
+			if *cu != nil {
+				v := **cu
+				*cu = &v
+			}
+		}
+		c.Symbol = &s
+	}
+	return &c
+}
+
+// lostSnapshot makes the next book of the market of s a snapshot, s has not been stored
+func (w *BatchWriter) lostSnapshot(s *common.OrderBook) {
+	w.mu.Lock()
+	if st := w.markets[s.MarketRef]; st != nil && st.snapshot == s {
+		delete(w.markets, s.MarketRef)
+	}
+	w.mu.Unlock()
+}
+
+// added counts n more buffered rows and wakes up the flushing routine when the buffer is full, w.mu is held
+func (w *BatchWriter) added(n int) {
+	w.pending += n
+	if w.pending >= w.size {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush writes all the buffered records in one transaction.
+// The records of a failed flush are dropped.
+func (w *BatchWriter) Flush() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	tickers, trades, candles, books, deltas := w.tickers, w.trades, w.candles, w.books, w.deltas
+	w.tickers, w.trades, w.candles, w.books, w.deltas = nil, nil, nil, nil, nil
+	w.pending = 0
+	w.mu.Unlock()
+
+	if len(tickers) == 0 && len(trades) == 0 && len(candles) == 0 && len(books) == 0 && len(deltas) == 0 {
+		return nil
+	}
+
+	tx := w.ds.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	err := w.ds.insertRows(tx, tickerRows(tickers))
+	if err == nil {
+		err = w.ds.insertRows(tx, tradeRows(trades))
+	}
+	if err == nil {
+		err = w.ds.upsertCandles(tx, candles)
+	}
+	if err == nil {
+		err = w.ds.insertOrderBooks(tx, books)
+	}
+	if err == nil {
+		err = w.ds.insertRows(tx, w.deltaRows(deltas))
+	}
+	if err == nil {
+		err = tx.Commit().Error
+	} else {
+		tx.Rollback()
+	}
+	if err != nil {
+		for _, b := range books {
+			b.ID = 0
+		}
+		for _, d := range deltas {
+			w.lostSnapshot(d.snapshot) // the next deltas would miss these
+		}
+		log.Printf("batch dropped, %d tickers %d trades %d candles %d order books %d deltas", len(tickers), len(trades), len(candles), len(books), len(deltas))
+	}
+	for _, b := range books {
+		if b.ID == 0 {
+			w.lostSnapshot(b)
+		}
+	}
+	return err
+}
+
+// deltaRows returns the rows of the deltas whose snapshot is stored, the others are dropped
+func (w *BatchWriter) deltaRows(c []*bookDelta) []interface{} {
+	rows := make([]interface{}, 0, len(c))
+	for _, d := range c {
+		if d.snapshot.ID == 0 {
+			continue
+		}
+		d.BookRef = d.snapshot.ID
+		rows = append(rows, d.BookDelta)
+	}
+	return rows
+}
+
+func tickerRows(c []*common.Ticker) []interface{} {
+	rows := make([]interface{}, len(c))
+	for k := range c {
+		rows[k] = c[k]
+	}
+	return rows
+}
+
+func tradeRows(c []*common.Trade) []interface{} {
+	rows := make([]interface{}, len(c))
+	for k := range c {
+		rows[k] = c[k]
+	}
+	return rows
+}
+
+// upsertCandles stores the candles replacing the stored ones of the same market, interval and time:
+// the candle of a bucket still open, or corrected by a late trade, is written again.
+// Of the candles of one key in c the last one is kept.
+func (d *DataStore) upsertCandles(tx *gorm.DB, c []*common.Candle) error {
+	if len(c) == 0 {
+		return nil
+	}
+	type key struct {
+		market   uint
+		interval string
+		time     int64
+	}
+	last := make(map[key]int, len(c))
+	for k, r := range c {
+		last[key{r.MarketRef, r.Interval, r.Time.UnixNano()}] = k
+	}
+	var table string
+	var cols []string
+	values := make([][]interface{}, 0, len(last))
+	for k, r := range c {
+		if last[key{r.MarketRef, r.Interval, r.Time.UnixNano()}] != k {
+			continue
+		}
+		var vals []interface{}
+		table, cols, vals = insertColumns(tx, r)
+		values = append(values, vals)
+	}
+	q := tx.Dialect().Quote
+	return d.insertChunks(tx, table, cols, values, d.onDuplicateUpdate(cols, []string{q("time"), q("market_ref"), q("interval")}))
+}
+
+// insertOrderBooks stores the snapshot rows of books and their levels.
+// A book with the same market and time stored already is skipped and its ID is left zero.
+func (d *DataStore) insertOrderBooks(tx *gorm.DB, books []*common.OrderBook) error {
+	levels := []interface{}{}
+	for _, b := range books {
+		table, cols, vals := insertColumns(tx, b)
+		id, err := d.insertID(tx, table, cols, vals)
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			continue
+		}
+		b.ID = id
+		for _, l := range b.Levels() {
+			levels = append(levels, l)
+		}
+	}
+	return d.insertRows(tx, levels)
+}
+
+// insertID inserts one row and returns its auto increment id, zero when the row exists already
+func (d *DataStore) insertID(tx *gorm.DB, table string, cols []string, vals []interface{}) (uint, error) {
+	if d.Dialect == "postgres" { // lib/pq has no LastInsertId
+		query, args := d.insertSQL(table, cols, [][]interface{}{vals}, d.onDuplicateKey())
+		var id uint
+		err := tx.CommonDB().QueryRow(query+" RETURNING id", args...).Scan(&id)
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return id, err
+	}
+	res, err := d.execInsert(tx, table, cols, [][]interface{}{vals}, d.onDuplicateKey())
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, nil
+	}
+	id, err := res.LastInsertId()
+	return uint(id), err
+}
+
+// insertRows writes rows of one model with multi-row inserts, rows with a duplicated unique key are skipped
+func (d *DataStore) insertRows(tx *gorm.DB, rows []interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	var table string
+	var cols []string
+	values := make([][]interface{}, len(rows))
+	for k, r := range rows {
+		table, cols, values[k] = insertColumns(tx, r)
+	}
+	return d.insertChunks(tx, table, cols, values, d.onDuplicateKey())
+}
+
+// insertChunks splits values into statements within the bind variable limit of the dialect, onDup ends each one
+func (d *DataStore) insertChunks(tx *gorm.DB, table string, cols []string, values [][]interface{}, onDup string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	per := d.maxBindVars() / len(cols)
+	for len(values) > 0 {
+		n := per
+		if n > len(values) {
+			n = len(values)
+		}
+		if _, err := d.execInsert(tx, table, cols, values[:n], onDup); err != nil {
+			return err
+		}
+		values = values[n:]
+	}
+	return nil
+}
+
+// insertColumns returns the quoted table name, column names and values to insert value, a pointer to a model.
+// The auto increment ID and the associations are left out.
+func insertColumns(tx *gorm.DB, value interface{}) (table string, cols []string, vals []interface{}) {
+	scope := tx.NewScope(value)
+	for _, f := range scope.Fields() {
+		if !f.IsNormal || f.IsIgnored || (f.IsPrimaryKey && f.Name == "ID") {
+			continue
+		}
+		cols = append(cols, scope.Quote(f.DBName))
+		v := f.Field.Interface()
+		if t, ok := v.(time.Time); ok {
+			v = t.UTC() // see utcTimes
+		}
+		vals = append(vals, v)
+	}
+	return scope.QuotedTableName(), cols, vals
+}
+
+// execInsert runs a single INSERT statement of all rows
+func (d *DataStore) execInsert(tx *gorm.DB, table string, cols []string, rows [][]interface{}, onDup string) (sql.Result, error) {
+	query, args := d.insertSQL(table, cols, rows, onDup)
+	return tx.CommonDB().Exec(query, args...)
+}
+
+// insertSQL builds the INSERT statement of rows and its arguments, onDup is its clause of the duplicated keys
+func (d *DataStore) insertSQL(table string, cols []string, rows [][]interface{}, onDup string) (string, []interface{}) {
+	var b strings.Builder
+	args := make([]interface{}, 0, len(cols)*len(rows))
+	b.WriteString("INSERT INTO " + table + " (" + strings.Join(cols, ",") + ") VALUES ")
+	for i, r := range rows {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('(')
+		for j := range r {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(d.bindVar(len(args) + j + 1))
+		}
+		b.WriteByte(')')
+		args = append(args, r...)
+	}
+	b.WriteString(onDup)
+	return b.String(), args
+}
+
+// onDuplicateKey returns the insert clause keeping the stored row when the unique key of a new one exists already
+func (d *DataStore) onDuplicateKey() string {
+	if d.Dialect == "mysql" {
+		return " ON DUPLICATE KEY UPDATE id = id"
+	}
+	return " ON CONFLICT DO NOTHING"
+}
+
+// onDuplicateUpdate returns the insert clause replacing the cols of the stored row when the unique key
+// of a new one exists already, key are the quoted columns of the unique key
+func (d *DataStore) onDuplicateUpdate(cols, key []string) string {
+	set := make([]string, 0, len(cols))
+	for _, c := range cols {
+		if d.Dialect == "mysql" {
+			set = append(set, c+" = VALUES("+c+")")
+		} else {
+			set = append(set, c+" = excluded."+c)
+		}
+	}
+	if d.Dialect == "mysql" {
+		return " ON DUPLICATE KEY UPDATE " + strings.Join(set, ",")
+	}
+	return " ON CONFLICT (" + strings.Join(key, ",") + ") DO UPDATE SET " + strings.Join(set, ",")
+}
+
+func (d *DataStore) bindVar(i int) string {
+	if d.Dialect == "postgres" {
+		return "$" + strconv.Itoa(i)
+	}
+	return "?"
+}
+
+// maxBindVars is the number of bind variables a statement may carry
+func (d *DataStore) maxBindVars() int {
+	if d.Dialect == "mysql" || d.Dialect == "postgres" {
+		return 65535
+	}
+	return 999
+}
@@ -0,0 +1,186 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/shopspring/decimal"
+)
+
+func TestBatchWriter(t *testing.T) {
+	ds := openTestStore(t)
+	defer ds.CloseDB()
+
+	for _, c := range Markes {
+		if ds.UpdateMarket(c).Error != nil {
+			t.Fatal("error save market", ds.GetDB().Error)
+		}
+	}
+
+	count := func() (n [4]int) {
+		ds.GetDB().Model(&common.Ticker{}).Count(&n[0])
+		ds.GetDB().Model(&common.Trade{}).Count(&n[1])
+		ds.GetDB().Model(&common.OrderBook{}).Count(&n[2])
+		ds.GetDB().Model(&common.Candle{}).Count(&n[3])
+		return
+	}
+
+	var first [4]int
+	for round := 0; round < 2; round++ {
+		w := ds.NewBatchWriter(3, 0)
+		for _, c := range testTickers {
+			if err := w.AddTicker(c); err != nil {
+				t.Fatal("add ticker", err)
+			}
+		}
+		for _, c := range testTrades {
+			if err := w.AddTrade(c); err != nil {
+				t.Fatal("add trade", err)
+			}
+		}
+		for _, c := range testOrderBooks {
+			if err := w.AddOrderBook(c); err != nil {
+				t.Fatal("add orderbook", err)
+			}
+		}
+		for _, c := range testCandles {
+			if err := w.AddCandle(c); err != nil {
+				t.Fatal("add candle", err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal("flush", err)
+		}
+
+		n := count()
+		if round == 0 {
+			first = n
+			if n[0] < len(testTickers) || n[1] < len(testTrades) || n[2] < len(testOrderBooks) || n[3] < len(testCandles) {
+				t.Fatalf("records missing after flush, %v", n)
+			}
+		} else if n != first {
+			t.Fatalf("duplicated records stored again, %v then %v", first, n)
+		}
+	}
+
+	ob, err := ds.OrderBookAt(testOrderBooks[0].MarketRef, testOrderBooks[0].Time)
+	if err != nil {
+		t.Fatal("order book not found", err)
+	}
+	if len(ob.Bids) != len(testOrderBooks[0].Bids) || len(ob.Asks) != len(testOrderBooks[0].Asks) {
+		t.Fatalf("order book levels not stored, %d bids %d asks", len(ob.Bids), len(ob.Asks))
+	}
+}
+
+func TestOrderBookDeltas(t *testing.T) {
+	ds := openTestStore(t)
+	defer ds.CloseDB()
+
+	m := Markes[1]
+	if ds.UpdateMarket(m).Error != nil {
+		t.Fatal("error save market", ds.GetDB().Error)
+	}
+
+	start := time.Date(2018, 11, 23, 8, 0, 0, 0, time.UTC)
+	books := []*common.OrderBook{}
+	for k := 0; k < 4; k++ {
+		books = append(books, &common.OrderBook{
+			Market: m,
+			Time:   start.Add(time.Duration(k) * 20 * time.Second),
+			Bids:   []*common.PriceVol{{Price: dec("0.0101"), Volume: decimal.New(int64(10+k), 0)}, {Price: dec("0.0100"), Volume: dec("5")}},
+			Asks:   []*common.PriceVol{{Price: dec("0.0102").Add(decimal.New(int64(k), -4)), Volume: dec("7")}},
+		})
+	}
+
+	w := ds.NewBatchWriter(0, 0)
+	w.SnapshotInterval = 50 * time.Second // the snapshots are books 0 and 3
+	for _, c := range books {
+		if err := w.AddOrderBook(c); err != nil {
+			t.Fatal("add orderbook", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("flush", err)
+	}
+
+	for k, c := range books {
+		ob, err := ds.OrderBookAt(m.ID, c.Time.Add(time.Second))
+		if err != nil {
+			t.Fatal("order book not found", err)
+		}
+		if !common.SameLevels(ob.Bids, c.Bids) || !common.SameLevels(ob.Asks, c.Asks) {
+			t.Fatalf("book %d rebuilt wrong, bids %v asks %v", k, ob.Bids, ob.Asks)
+		}
+	}
+	if _, err := ds.OrderBookAt(m.ID, start.Add(-time.Second)); err == nil {
+		t.Fatal("no book should be found before the first snapshot")
+	}
+}
+
+func TestStreamedDeltas(t *testing.T) {
+	ds := openTestStore(t)
+	defer ds.CloseDB()
+	m, other := Markes[1], Markes[0]
+	for _, c := range []*common.Market{m, other} {
+		if ds.UpdateMarket(c).Error != nil {
+			t.Fatal("error save market", ds.GetDB().Error)
+		}
+	}
+
+	start := time.Date(2018, 11, 24, 8, 0, 0, 0, time.UTC)
+	book := &common.OrderBook{Market: m, Time: start,
+		Bids: []*common.PriceVol{{Price: dec("0.0101"), Volume: dec("10")}, {Price: dec("0.0100"), Volume: dec("5")}},
+		Asks: []*common.PriceVol{{Price: dec("0.0102"), Volume: dec("7")}}}
+	deltas := [][]*common.BookDelta{
+		{{Time: start.Add(10 * time.Second), Side: common.BookBid, Price: dec("0.0101"), Volume: dec("12"), Sequence: 1}},
+		{{Time: start.Add(20 * time.Second), Side: common.BookBid, Price: dec("0.0100"), Sequence: 2},
+			{Time: start.Add(20 * time.Second), Side: common.BookAsk, Price: dec("0.0103"), Volume: dec("1"), Sequence: 3}},
+		{{Time: start.Add(70 * time.Second), Side: common.BookAsk, Price: dec("0.0102"), Sequence: 4}}, // a snapshot
+		{{Time: start.Add(80 * time.Second), Side: common.BookBid, Price: dec("0.0099"), Volume: dec("3"), Sequence: 5}},
+	}
+
+	w := ds.NewBatchWriter(0, 0)
+	w.SnapshotInterval = 50 * time.Second
+	// before the first book of its market
+	if err := w.AddBookDeltas(other, []*common.BookDelta{{Time: start, Price: dec("1"), Volume: dec("1")}}); err != nil {
+		t.Fatal("add deltas", err)
+	}
+	if err := w.AddOrderBook(book); err != nil {
+		t.Fatal("add orderbook", err)
+	}
+	expected := []*common.OrderBook{book.Clone()}
+	for _, c := range deltas {
+		if err := w.AddBookDeltas(m, c); err != nil {
+			t.Fatal("add deltas", err)
+		}
+		ob := expected[len(expected)-1].Clone()
+		ob.Apply(c)
+		expected = append(expected, ob)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("flush", err)
+	}
+	if deltas[1][1].MarketRef != 0 || deltas[1][1].Sequence != 3 || deltas[1][1].ID != 0 {
+		t.Fatal("delta modified", deltas[1][1])
+	}
+
+	for k, c := range expected {
+		ob, err := ds.OrderBookAt(m.ID, c.Time.Add(time.Second))
+		if err != nil {
+			t.Fatal("order book not found", err)
+		}
+		if !common.SameLevels(ob.Bids, c.Bids) || !common.SameLevels(ob.Asks, c.Asks) {
+			t.Fatalf("book %d rebuilt wrong, bids %v asks %v", k, ob.Bids, ob.Asks)
+		}
+	}
+	var n int
+	ds.GetDB().Model(&common.OrderBook{}).Where("market_ref = ? and time >= ?", m.ID, start).Count(&n)
+	if n != 2 {
+		t.Fatal("snapshots", n)
+	}
+	ds.GetDB().Model(&common.BookDelta{}).Where("market_ref = ? and time >= ?", other.ID, start).Count(&n)
+	if n != 0 {
+		t.Fatal("deltas without a book stored", n)
+	}
+}
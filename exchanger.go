@@ -3,8 +3,10 @@ package main
 import (
 	"fmt"
 
+	"github.com/exchangedata/database"
 	"github.com/exchangedata/exchanger"
 	"github.com/exchangedata/exchanger/bittrex"
+	"github.com/exchangedata/sink"
 )
 
 var exchangerSupported []string
@@ -26,8 +28,9 @@ func isSupported(s string) bool {
 	return false
 }
 
-// NewExchanger creates a exchanger.Exchanger with the specified name s
-func NewExchanger(s string) (exchanger.ExControl, error) {
+// NewExchanger creates a exchanger.Exchanger with the specified name s,
+// its reference data is stored in ds and its market data written to sinks
+func NewExchanger(s string, ds *database.DataStore, sinks ...sink.Sink) (exchanger.ExControl, error) {
 	if isSupported(s) == false {
 		return nil, fmt.Errorf("not supported exchanger %s", s)
 	}
@@ -35,7 +38,7 @@ func NewExchanger(s string) (exchanger.ExControl, error) {
 	var nex exchanger.ExControl
 	switch s {
 	case "bittrex":
-		nex = bittrex.NewBittrex()
+		nex = bittrex.NewBittrex(ds, sinks...)
 	}
 	return nex, nil
 }
@@ -1,14 +1,19 @@
 package bittrex
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/exchangedata/common"
 	"github.com/exchangedata/database"
+	"github.com/exchangedata/exchanger"
+	"github.com/exchangedata/sink"
 	"github.com/urfave/cli"
 )
 
@@ -72,28 +77,55 @@ const (
 
 	BittrexAuthRate   = 6
 	BittrexUnauthRate = 6
+
+	BittrexFetchInterval = 5 * time.Second // default is to get market data every 5 seconds
+	BittrexFetchWorkers  = 8               // markets fetched at the same time, the client rate limit still applies
 )
 
 // Bittrex struct
 type Bittrex struct {
 	ex     *common.Exchanger
-	ds     *database.DataStore
+	ds     *database.DataStore // reference data: currencies and markets, nil when not stored
+	sink   sink.Sink           // market data
 	client *client
 	logger *log.Logger
 
+	interval time.Duration // period of the market data fetching cycle
+	workers  int
+	stats    *exchanger.FetchStats
+	sources  *exchanger.SourceLog // starts, stops and failures, for the gaps of the data
+
+	streamMu    sync.Mutex
+	streamNames []string             // given to StreamMarkets, resolved into streams once the markets are known
+	streams     map[string]bool      // names of the markets streamed by the websocket
+	running     map[string]chan bool // stop channels of the running streams, nil when the exchanger is stopped
+
 	done chan struct{} // Closed when the receive rountine received error, then the main exchanger communication routine exit
 	// If CloseDone is not closed, the connection should be reconnected...ToDo
 	stop chan struct{} // Signal to close connection and exit. Program exiting...
 }
 
-func NewBittrex() *Bittrex {
+// NewBittrex creates the exchanger writing the market data to sinks.
+// The currencies and markets are stored in ds when it is not nil, ds is opened and migrated by the caller.
+// The sinks belong to the caller as well, they are not closed by Stop.
+func NewBittrex(ds *database.DataStore, sinks ...sink.Sink) *Bittrex {
 	b := &Bittrex{
-		ex: &common.Exchanger{Name: "bittrex"},
+		ex:   &common.Exchanger{Name: "bittrex"},
+		ds:   ds,
+		sink: sink.NewMulti(sinks...),
 	}
 	b.NewLogger()
 	b.done = make(chan struct{})
 	b.stop = make(chan struct{}, 1)
 	b.client = NewClient(API_KEY, API_SECRET)
+	b.interval = BittrexFetchInterval
+	b.workers = BittrexFetchWorkers
+	b.stats = exchanger.NewFetchStats()
+	if ds != nil {
+		b.sources = exchanger.NewSourceLog(b.ex.Name, ds)
+	} else {
+		b.sources = exchanger.NewSourceLog(b.ex.Name, nil)
+	}
 	return b
 }
 
@@ -102,30 +134,23 @@ func (b *Bittrex) NewLogger() {
 }
 
 func (b *Bittrex) Logf(format string, v ...interface{}) {
-	b.logger.Printf(format, v)
+	b.logger.Printf(format, v...)
 }
 
 func (b *Bittrex) Logln(v ...interface{}) {
-	b.logger.Println(v)
+	b.logger.Println(v...)
 }
 
 func (b *Bittrex) Panicf(format string, v ...interface{}) {
-	b.logger.Panicf(format, v)
+	b.logger.Panicf(format, v...)
 }
 
 func (b *Bittrex) Panic(v ...interface{}) {
-	b.logger.Panic(v)
+	b.logger.Panic(v...)
 }
 
 // Setup prepares the basic data for startup and main duty loop
 func (b *Bittrex) Setup() error {
-	var err error
-	b.ds = database.NewDataStore("mysql")
-	if err = b.ds.OpenDB(); err != nil {
-		b.Panic("open db failed")
-	}
-	b.ds.AutoMigrate()
-
 	if currencies, err := b.GetCurrencies(); err != nil {
 		b.Logln("error get currency ", err)
 		return err
@@ -135,7 +160,7 @@ func (b *Bittrex) Setup() error {
 		}
 		for _, c := range currencies {
 			n := &common.Currency{Name: c.CurrencyLong, Abbr: c.Currency} //Exchangers: []*common.Exchanger{b.ex}} // not sure why this panic. ToKnow
-			if b.ds.UpdateCurrency(n).Error != nil {
+			if b.ds != nil && b.ds.UpdateCurrency(n).Error != nil {
 				b.Logln("error update db, currency ", n, b.ds.GetDB().Error)
 				return b.ds.GetDB().Error
 			}
@@ -166,18 +191,23 @@ func (b *Bittrex) Setup() error {
 				Quote: quote,
 			}
 			m := &common.Market{
-				Name:   sym.String(),
-				Symbol: sym,
-				Active: c.IsActive,
+				Name:      sym.String(),
+				Symbol:    sym,
+				Active:    c.IsActive,
+				Exchanger: b.ex,
+				Limitation: common.Limitation{
+					Min: c.MinTradeSize,
+				},
 			}
-			if b.ds.UpdateMarket(m).Error != nil {
+			if b.ds != nil && b.ds.UpdateMarket(m).Error != nil {
 				b.Logln("error update db, market ", c, b.ds.GetDB().Error)
 				return b.ds.GetDB().Error
 			}
+			b.ex.Markets = append(b.ex.Markets, m)
 		}
 	}
 
-	if b.ds.UpdateExchanger(b.ex).Error != nil {
+	if b.ds != nil && b.ds.UpdateExchanger(b.ex).Error != nil {
 		b.Logln("error update db, exchanger ", b.ex, b.ds.GetDB().Error)
 		return b.ds.GetDB().Error
 	}
@@ -189,25 +219,159 @@ func (b *Bittrex) Start(wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	b.Logln("bittrex Started ...")
-	ticker := time.NewTicker(5 * time.Second) // default is to get ticker every 5 seconds
+	b.sources.Event(common.EventStarted, "")
+	defer b.sources.Event(common.EventStopped, "")
+	ticker := time.NewTicker(b.interval)
 	defer ticker.Stop()
+	b.startStreams()
+	defer b.stopStreams()
 
 	for {
 		select {
 		case <-ticker.C: // timely keepAlive processing
 			b.runDataFetcher()
 		case <-b.stop:
-			close(b.done)
+			b.done <- struct{}{}
 			return
 		}
 	}
 }
 
-// Stop ...
+// Stop ends Start, the exchanger can be started again
 func (b *Bittrex) Stop() {
 	b.stop <- struct{}{}
 	<-b.done
-	close(b.stop)
+}
+
+// StreamMarkets sets the markets whose order books are streamed by the websocket before Start, "*" for all of them,
+// see exchanger.Streamer. A name is the market name or the bittrex one, ex: BTC_LTC or BTC-LTC.
+func (b *Bittrex) StreamMarkets(names ...string) {
+	b.streamMu.Lock()
+	defer b.streamMu.Unlock()
+	b.streamNames, b.streams = names, nil
+}
+
+// AddStream streams the order book of the market name, from now on if the exchanger is running
+func (b *Bittrex) AddStream(name string) error {
+	m := b.market(name)
+	if m == nil {
+		return fmt.Errorf("unknown market %s", name)
+	}
+	b.streamMu.Lock()
+	defer b.streamMu.Unlock()
+	b.resolveStreams()
+	b.streams[m.Name] = true
+	if b.running != nil && b.running[m.Name] == nil {
+		b.startStream(m)
+	}
+	return nil
+}
+
+// RemoveStream stops streaming the order book of the market name
+func (b *Bittrex) RemoveStream(name string) error {
+	m := b.market(name)
+	if m == nil {
+		return fmt.Errorf("unknown market %s", name)
+	}
+	b.streamMu.Lock()
+	defer b.streamMu.Unlock()
+	b.resolveStreams()
+	delete(b.streams, m.Name)
+	if stop := b.running[m.Name]; stop != nil {
+		close(stop)
+		delete(b.running, m.Name)
+	}
+	return nil
+}
+
+// Streams returns the names of the streamed markets, sorted
+func (b *Bittrex) Streams() []string {
+	b.streamMu.Lock()
+	defer b.streamMu.Unlock()
+	b.resolveStreams()
+	names := make([]string, 0, len(b.streams))
+	for n := range b.streams {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// market returns the market of the name, nil if unknown
+func (b *Bittrex) market(name string) *common.Market {
+	for _, m := range b.ex.Markets {
+		if strings.EqualFold(name, m.Name) || strings.EqualFold(name, marketName(m)) {
+			return m
+		}
+	}
+	return nil
+}
+
+// resolveStreams turns the names given to StreamMarkets into the streamed markets once they are known
+func (b *Bittrex) resolveStreams() {
+	if b.streams != nil || len(b.ex.Markets) == 0 {
+		return
+	}
+	b.streams = make(map[string]bool)
+	for _, m := range b.ex.Markets {
+		for _, n := range b.streamNames {
+			if n == "*" || strings.EqualFold(n, m.Name) || strings.EqualFold(n, marketName(m)) {
+				b.streams[m.Name] = true
+				break
+			}
+		}
+	}
+}
+
+func (b *Bittrex) startStreams() {
+	b.streamMu.Lock()
+	defer b.streamMu.Unlock()
+	b.resolveStreams()
+	b.running = make(map[string]chan bool)
+	for n := range b.streams {
+		if m := b.market(n); m != nil {
+			b.startStream(m)
+		}
+	}
+}
+
+func (b *Bittrex) stopStreams() {
+	b.streamMu.Lock()
+	defer b.streamMu.Unlock()
+	for _, stop := range b.running {
+		close(stop)
+	}
+	b.running = nil
+}
+
+// startStream runs the stream of m until it is removed or the exchanger stops, streamMu is held
+func (b *Bittrex) startStream(m *common.Market) {
+	stop := make(chan bool)
+	b.running[m.Name] = stop
+	go b.keepStreaming(m, stop)
+}
+
+// keepStreaming streams m until stop, reconnecting after the errors and disconnections
+func (b *Bittrex) keepStreaming(m *common.Market, stop <-chan bool) {
+	const retry = 5 * time.Second
+	for {
+		err := b.StreamMarket(m, stop)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		b.Logln("stream", m.Name, "disconnected:", err)
+		if err == nil {
+			err = errors.New("disconnected")
+		}
+		b.sources.Fail(common.EventDisconnected, m, err)
+		select {
+		case <-stop:
+			return
+		case <-time.After(retry):
+		}
+	}
 }
 
 // Done ...
@@ -215,47 +379,104 @@ func (b *Bittrex) Done() {
 	b.done <- struct{}{}
 }
 
+// runDataFetcher fetches all the markets with a pool of b.workers routines, it returns the first fetch error of the cycle.
+// A cycle taking longer than b.interval is reported, the ticks missed meanwhile are dropped by the time.Ticker.
 func (b *Bittrex) runDataFetcher() (err error) {
-	b.Logln("runDataFetcher ...")
-	for _, m := range b.ex.Markets {
-		name := m.Symbol.String()
-		b.Logln("Get ", name, " data ...")
-		// Get Ticker
-		ticker, err := b.GetTicker(name)
-		if err != nil {
-			b.Logln(err, ticker)
-		}
-		// Get market summary
-		marketSummary, err := b.GetMarketSummary(name)
-		if err != nil {
-			b.Logln(err, marketSummary)
+	start := time.Now()
+	var errMu sync.Mutex
+	keep := func(e error) {
+		errMu.Lock()
+		if err == nil {
+			err = e
 		}
+		errMu.Unlock()
+	}
+	markets := make(chan *common.Market)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < b.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range markets {
+				t := time.Now()
+				err := b.fetchMarket(m)
+				b.stats.Observe(m.Name, time.Since(t), err)
+				if err != nil {
+					keep(err)
+					b.Logln("fetch", m.Name, "error:", err)
+					b.sources.Fail(common.EventFetchFailed, m, err)
+				} else {
+					b.sources.Recover(common.EventFetchFailed, m)
+				}
+			}
+		}()
+	}
+	for _, m := range b.ex.Markets {
+		markets <- m
+	}
+	close(markets)
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	if b.stats.Cycle(elapsed, b.interval) {
+		cycles, overruns := b.stats.Overruns()
+		slowest, l := b.stats.Slowest()
+		b.Logf("fetch cycle of %d markets took %s, longer than the interval %s (%d of %d cycles overran, slowest market %s avg %s)",
+			len(b.ex.Markets), elapsed, b.interval, overruns, cycles, slowest, l.Average())
+	}
+	return
+}
 
-		// Get orders book
-		orderBook, err := b.GetOrderBook(name, "both")
-		if err != nil {
-			b.Logln(err, orderBook)
+// fetchMarket gets the market data of m and writes it to the sink, it returns the first error met
+func (b *Bittrex) fetchMarket(m *common.Market) (err error) {
+	name := marketName(m)
+	keep := func(e error) {
+		if err == nil {
+			err = e
 		}
+	}
 
-		// Market history
-		marketHistory, err := b.GetMarketHistory(name)
-		if err != nil {
-			for _, trade := range marketHistory {
-				b.Logln(err, trade.Timestamp.String(), trade.OrderUuid, trade.OrderType, trade.FillType, trade.Quantity, trade.Price)
-			}
+	// the market summary carries the ticker values as well
+	summaries, e := b.GetMarketSummary(name)
+	keep(e)
+	tickers := make([]*common.Ticker, 0, len(summaries))
+	for _, s := range summaries {
+		t, e := newTicker(m, s)
+		if e != nil {
+			keep(e)
+			continue
 		}
+		tickers = append(tickers, t)
+	}
+	if len(tickers) > 0 {
+		keep(b.sink.WriteTickers(tickers))
+	}
 
-		// Get Distribution
-		distribution, err := b.GetDistribution(name)
-		if err != nil {
-			for _, balance := range distribution.Distribution {
-				b.Logln(balance.BalanceD)
-			}
+	received := time.Now()
+	orderBook, e := b.GetOrderBook(name, "both")
+	if e == nil {
+		e = b.sink.WriteOrderBooks([]*common.OrderBook{newOrderBook(m, orderBook, received)})
+	}
+	keep(e)
+
+	// the latest trades, those stored by the previous cycles are skipped by the gorm sink
+	history, e := b.GetMarketHistory(name)
+	keep(e)
+	if len(history) > 0 {
+		trades := make([]*common.Trade, len(history))
+		for k, h := range history {
+			trades[k] = newTrade(m, h)
 		}
+		keep(b.sink.WriteTrades(trades))
 	}
 	return
 }
 
+// marketName returns the Bittrex market name of m, ex: BTC-LTC
+func marketName(m *common.Market) string {
+	return strings.Replace(m.Symbol.String(), "_", "-", 1)
+}
+
 func (b *Bittrex) GetCurrencyByName(name string) *common.Currency {
 	return b.ex.GetCurrencyByName(name)
 }
@@ -12,6 +12,8 @@ import (
 	"net/http/httputil"
 	"strings"
 	"time"
+
+	"github.com/exchangedata/exchanger"
 )
 
 type client struct {
@@ -20,11 +22,12 @@ type client struct {
 	httpClient  *http.Client
 	httpTimeout time.Duration
 	debug       bool
+	limiter     *exchanger.RateLimiter
 }
 
 // NewClient return a new Bittrex HTTP client
 func NewClient(apiKey, apiSecret string) (c *client) {
-	return &client{apiKey, apiSecret, &http.Client{}, 30 * time.Second, false}
+	return &client{apiKey, apiSecret, &http.Client{}, 30 * time.Second, false, defaultLimiter()}
 }
 
 // NewClientWithCustomHttpConfig returns a new Bittrex HTTP client using the predefined http client
@@ -33,12 +36,17 @@ func NewClientWithCustomHttpConfig(apiKey, apiSecret string, httpClient *http.Cl
 	if timeout <= 0 {
 		timeout = 30 * time.Second
 	}
-	return &client{apiKey, apiSecret, httpClient, timeout, false}
+	return &client{apiKey, apiSecret, httpClient, timeout, false, defaultLimiter()}
 }
 
 // NewClient returns a new Bittrex HTTP client with custom timeout
 func NewClientWithCustomTimeout(apiKey, apiSecret string, timeout time.Duration) (c *client) {
-	return &client{apiKey, apiSecret, &http.Client{}, timeout, false}
+	return &client{apiKey, apiSecret, &http.Client{}, timeout, false, defaultLimiter()}
+}
+
+// defaultLimiter keeps the client under the public API call rate of Bittrex
+func defaultLimiter() *exchanger.RateLimiter {
+	return exchanger.NewRateLimiter(BittrexUnauthRate, time.Second)
 }
 
 func (c client) dumpRequest(r *http.Request) {
@@ -96,6 +104,7 @@ func (c *client) doTimeoutRequest(timer *time.Timer, req *http.Request) (*http.R
 
 // do prepare and process HTTP request to Bittrex API
 func (c *client) do(method string, resource string, payload string, authNeeded bool) (response []byte, err error) {
+	c.limiter.Wait() // the waiting time is not counted into the request timeout
 	connectTimer := time.NewTimer(c.httpTimeout)
 
 	var rawurl string
@@ -0,0 +1,106 @@
+package bittrex
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/shopspring/decimal"
+)
+
+// conversions of the Bittrex responses into the common market data
+
+// newTicker converts the market summary s of m
+func newTicker(m *common.Market, s btMarketSummary) (*common.Ticker, error) {
+	t, err := time.Parse(TIME_FORMAT, s.TimeStamp) // the fraction of second is accepted too
+	if err != nil {
+		return nil, err
+	}
+	return &common.Ticker{
+		Time:          t,
+		Market:        m,
+		High:          s.High,
+		Low:           s.Low,
+		Bid:           s.Bid,
+		Ask:           s.Ask,
+		Last:          s.Last,
+		PreviousClose: s.PrevDay,
+		BaseVolume:    s.BaseVolume,
+		QuoteVolume:   s.Volume,
+	}, nil
+}
+
+// newOrderBook converts the order book ob of m received at t
+func newOrderBook(m *common.Market, ob btOrderBook, t time.Time) *common.OrderBook {
+	c := &common.OrderBook{
+		Time:   t,
+		Market: m,
+		Bids:   make([]*common.PriceVol, 0, len(ob.Buy)),
+		Asks:   make([]*common.PriceVol, 0, len(ob.Sell)),
+	}
+	for _, o := range ob.Buy {
+		c.Bids = append(c.Bids, &common.PriceVol{Price: o.Rate, Volume: o.Quantity})
+	}
+	for _, o := range ob.Sell {
+		c.Asks = append(c.Asks, &common.PriceVol{Price: o.Rate, Volume: o.Quantity})
+	}
+	return c
+}
+
+// newTrade converts the market history entry h of m
+func newTrade(m *common.Market, h btTrade) *common.Trade {
+	return &common.Trade{
+		Time:    h.Timestamp.Time,
+		Market:  m,
+		OrderID: strconv.FormatInt(h.OrderUuid, 10),
+		Type:    strings.ToLower(h.FillType),
+		Side:    strings.ToLower(h.OrderType),
+		Price:   h.Price,
+		Amount:  h.Quantity,
+		Total:   h.Total,
+	}
+}
+
+// order update types of the exchange deltas
+const (
+	updateAdd = iota
+	updateRemove
+	updateChange
+)
+
+// newStateBook converts the initial exchange state st of m received at t into a full order book
+func newStateBook(m *common.Market, st ExchangeState, t time.Time) *common.OrderBook {
+	c := &common.OrderBook{
+		Time:   t,
+		Market: m,
+		Bids:   make([]*common.PriceVol, 0, len(st.Buys)),
+		Asks:   make([]*common.PriceVol, 0, len(st.Sells)),
+	}
+	for _, o := range st.Buys {
+		c.Bids = append(c.Bids, &common.PriceVol{Price: o.Rate, Volume: o.Quantity})
+	}
+	for _, o := range st.Sells {
+		c.Asks = append(c.Asks, &common.PriceVol{Price: o.Rate, Volume: o.Quantity})
+	}
+	return c
+}
+
+// newStateDeltas converts the order updates of the exchange state st of m received at t into book deltas,
+// their Sequence is left to the caller
+func newStateDeltas(m *common.Market, st ExchangeState, t time.Time) []*common.BookDelta {
+	deltas := make([]*common.BookDelta, 0, len(st.Buys)+len(st.Sells))
+	for _, side := range []struct {
+		side    uint8
+		updates []OrderUpdate
+	}{{common.BookBid, st.Buys}, {common.BookAsk, st.Sells}} {
+		for _, u := range side.updates {
+			d := &common.BookDelta{Time: t, MarketRef: m.ID, Side: side.side, Price: u.Rate, Volume: u.Quantity}
+			if u.Type == updateRemove {
+				d.Volume = decimal.Zero
+			}
+			deltas = append(deltas, d)
+		}
+	}
+	return deltas
+}
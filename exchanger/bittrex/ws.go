@@ -3,8 +3,11 @@ package bittrex
 import (
 	"encoding/json"
 	"errors"
+	"sync"
 	"time"
 
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/sink"
 	"github.com/thebotguys/signalr"
 )
 
@@ -52,13 +55,6 @@ func doAsyncTimeout(f func() error, tmFunc func(error), timeout time.Duration) e
 	}
 }
 
-func sendStateAsync(dataCh chan<- ExchangeState, st ExchangeState) {
-	select {
-	case dataCh <- st:
-	default:
-	}
-}
-
 func subForMarket(client *signalr.Client, market string) (json.RawMessage, error) {
 	_, err := client.CallHub(WS_HUB, "SubscribeToExchangeDeltas", market)
 	if err != nil {
@@ -67,7 +63,7 @@ func subForMarket(client *signalr.Client, market string) (json.RawMessage, error
 	return client.CallHub(WS_HUB, "QueryExchangeState", market)
 }
 
-func parseStates(messages []json.RawMessage, dataCh chan<- ExchangeState, market string) {
+func parseStates(messages []json.RawMessage, handle func(ExchangeState), market string) {
 	for _, msg := range messages {
 		var st ExchangeState
 		if err := json.Unmarshal(msg, &st); err != nil {
@@ -76,21 +72,21 @@ func parseStates(messages []json.RawMessage, dataCh chan<- ExchangeState, market
 		if st.MarketName != market {
 			continue
 		}
-		sendStateAsync(dataCh, st)
+		handle(st)
 	}
 }
 
 // SubscribeExchangeUpdate subscribes for updates of the market.
-// Updates will be sent to dataCh.
+// Updates are passed to handle in order, from the websocket goroutine: a slow handler delays the next ones.
 // To stop subscription, send to, or close 'stop'.
-func (b *Bittrex) SubscribeExchangeUpdate(market string, dataCh chan<- ExchangeState, stop <-chan bool) error {
+func (b *Bittrex) SubscribeExchangeUpdate(market string, handle func(ExchangeState), stop <-chan bool) error {
 	const timeout = 5 * time.Second
 	client := signalr.NewWebsocketClient()
 	client.OnClientMethod = func(hub string, method string, messages []json.RawMessage) {
 		if hub != WS_HUB || method != "updateExchangeState" {
 			return
 		}
-		parseStates(messages, dataCh, market)
+		parseStates(messages, handle, market)
 	}
 	err := doAsyncTimeout(func() error {
 		return client.Connect("https", WS_BASE, []string{WS_HUB})
@@ -118,10 +114,46 @@ func (b *Bittrex) SubscribeExchangeUpdate(market string, dataCh chan<- ExchangeS
 	}
 	st.Initial = true
 	st.MarketName = market
-	sendStateAsync(dataCh, st)
+	handle(st)
 	select {
 	case <-stop:
 	case <-client.DisconnectedChannel:
 	}
 	return nil
 }
+
+// StreamMarket writes the order book of m received by the websocket to the sink until stop:
+// the full book first, then its changes to the sinks taking deltas, see sink.DeltaWriter.
+// The fills are left out, they carry no trade id, the trades come from the market history.
+func (b *Bittrex) StreamMarket(m *common.Market, stop <-chan bool) error {
+	deltaW, _ := b.sink.(sink.DeltaWriter)
+	var mu sync.Mutex // the initial state and the updates come from different goroutines
+	var seq uint64
+	var haveBook bool
+	return b.SubscribeExchangeUpdate(marketName(m), func(st ExchangeState) {
+		mu.Lock()
+		defer mu.Unlock()
+		now := time.Now()
+		if st.Initial {
+			ob := newStateBook(m, st, now)
+			ob.Sequence = seq
+			haveBook = true
+			b.sources.Recover(common.EventDisconnected, m)
+			if err := b.sink.WriteOrderBooks([]*common.OrderBook{ob}); err != nil {
+				b.Logln("stream", m.Name, "book error:", err)
+			}
+			return
+		}
+		if !haveBook || deltaW == nil {
+			return
+		}
+		deltas := newStateDeltas(m, st, now)
+		for _, d := range deltas {
+			seq++
+			d.Sequence = seq
+		}
+		if err := deltaW.WriteBookDeltas(m, deltas); err != nil {
+			b.Logln("stream", m.Name, "delta error:", err)
+		}
+	}, stop)
+}
@@ -7,7 +7,7 @@ import (
 )
 
 func TestBittrexSubscribeOrderBook(t *testing.T) {
-	bt := New("", "")
+	bt := NewBittrex(nil)
 	ch := make(chan ExchangeState, 16)
 	errCh := make(chan error)
 	go func() {
@@ -27,7 +27,12 @@ func TestBittrexSubscribeOrderBook(t *testing.T) {
 		}
 	}()
 	go func() {
-		errCh <- bt.SubscribeExchangeUpdate("USDT-BTC", ch, nil)
+		errCh <- bt.SubscribeExchangeUpdate("USDT-BTC", func(st ExchangeState) {
+			select {
+			case ch <- st:
+			default:
+			}
+		}, nil)
 	}()
 	select {
 	case <-time.After(time.Second * 6):
@@ -35,6 +35,16 @@ type ExControl interface {
 	Stop()
 }
 
+// Streamer is implemented by the exchangers able to stream the order books of markets as they change.
+// StreamMarkets is called before Start with the market names, "*" for all of them.
+// AddStream and RemoveStream change the streamed markets after Setup, while the exchanger runs or not.
+type Streamer interface {
+	StreamMarkets(names ...string)
+	AddStream(market string) error
+	RemoveStream(market string) error
+	Streams() []string
+}
+
 func isValidProxy(url url.URL) bool {
 	return len(url.Hostname()) != 0
 }
@@ -0,0 +1,41 @@
+package exchanger
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter spaces out the calls of an exchanger evenly, it is shared by all the routines talking to the server.
+// A nil *RateLimiter never blocks.
+type RateLimiter struct {
+	mu   sync.Mutex
+	gap  time.Duration
+	next time.Time
+}
+
+// NewRateLimiter creates a limiter allowing rate calls per interval, rate <= 0 disables the limit
+func NewRateLimiter(rate int, per time.Duration) *RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &RateLimiter{gap: per / time.Duration(rate)}
+}
+
+// Wait blocks until the caller is allowed to issue the next request
+func (l *RateLimiter) Wait() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.gap)
+	l.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,33 @@
+package exchanger
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(50, time.Second)
+	start := time.Now()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 5; k++ {
+				l.Wait()
+			}
+		}()
+	}
+	wg.Wait()
+	// 20 calls spaced by 20ms, the first one passes at once
+	if d := time.Since(start); d < 19*20*time.Millisecond {
+		t.Fatalf("20 calls passed in %s, faster than the limit", d)
+	}
+
+	var nl *RateLimiter
+	nl.Wait() // nil limiter never blocks
+	if NewRateLimiter(0, time.Second) != nil {
+		t.Fatal("rate 0 should disable the limiter")
+	}
+}
@@ -0,0 +1,158 @@
+package exchanger
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// The status of an exchanger in a Manager
+const (
+	StatusStopped  = "stopped"
+	StatusRunning  = "running"
+	StatusFailed   = "failed"   // its Setup failed, it is set up again by the next Start
+	StatusStopping = "stopping" // waited for by Stop, it can not be started before its end
+)
+
+// Status describes an exchanger of a Manager
+type Status struct {
+	Name    string
+	Status  string
+	Error   string   // of the failed Setup
+	Streams []string // streamed markets of a Streamer
+}
+
+// Manager starts and stops named exchangers, each of them is set up by its first Start
+type Manager struct {
+	mu  sync.Mutex
+	exs map[string]*managed
+}
+
+type managed struct {
+	ex     ExControl
+	setup  bool
+	status string
+	err    error
+	wg     sync.WaitGroup
+	ended  chan struct{} // closed at the end of the running Stop
+}
+
+func NewManager() *Manager {
+	return &Manager{exs: make(map[string]*managed)}
+}
+
+// Add registers a stopped exchanger, replacing an exchanger of the same name fails
+func (m *Manager) Add(name string, ex ExControl) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.exs[name] != nil {
+		return fmt.Errorf("exchanger %s already added", name)
+	}
+	m.exs[name] = &managed{ex: ex, status: StatusStopped}
+	return nil
+}
+
+// Get returns the exchanger of the name, nil if unknown
+func (m *Manager) Get(name string) ExControl {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.exs[name]; e != nil {
+		return e.ex
+	}
+	return nil
+}
+
+// Start sets the exchanger up if needed and runs it, starting a running exchanger does nothing
+func (m *Manager) Start(name string) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.exs[name]
+	if e == nil {
+		return Status{}, fmt.Errorf("unknown exchanger %s", name)
+	}
+	switch e.status {
+	case StatusRunning:
+		return e.describe(name), nil
+	case StatusStopping:
+		return e.describe(name), fmt.Errorf("exchanger %s is stopping", name)
+	}
+	if !e.setup {
+		if err := e.ex.Setup(); err != nil {
+			e.status, e.err = StatusFailed, err
+			return e.describe(name), err
+		}
+		e.setup = true
+	}
+	e.status, e.err = StatusRunning, nil
+	e.wg.Add(1)
+	go e.ex.Start(&e.wg)
+	return e.describe(name), nil
+}
+
+// Stop stops the exchanger and waits for its end, stopping a stopped exchanger does nothing.
+// The other exchangers are managed meanwhile.
+func (m *Manager) Stop(name string) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.exs[name]
+	if e == nil {
+		return Status{}, fmt.Errorf("unknown exchanger %s", name)
+	}
+	switch e.status {
+	case StatusRunning:
+		e.status, e.ended = StatusStopping, make(chan struct{})
+		m.mu.Unlock()
+		e.ex.Stop()
+		e.wg.Wait()
+		m.mu.Lock()
+		e.status = StatusStopped
+		close(e.ended)
+	case StatusStopping:
+		ended := e.ended
+		m.mu.Unlock()
+		<-ended
+		m.mu.Lock()
+	}
+	return e.describe(name), nil
+}
+
+// StopAll stops the running exchangers
+func (m *Manager) StopAll() {
+	for _, s := range m.List() {
+		m.Stop(s.Name)
+	}
+}
+
+// Status returns the status of the exchanger of the name
+func (m *Manager) Status(name string) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.exs[name]
+	if e == nil {
+		return Status{}, fmt.Errorf("unknown exchanger %s", name)
+	}
+	return e.describe(name), nil
+}
+
+// List returns the status of the exchangers sorted by name
+func (m *Manager) List() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Status, 0, len(m.exs))
+	for name, e := range m.exs {
+		list = append(list, e.describe(name))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (e *managed) describe(name string) Status {
+	s := Status{Name: name, Status: e.status}
+	if e.err != nil {
+		s.Error = e.err.Error()
+	}
+	if st, ok := e.ex.(Streamer); ok {
+		s.Streams = st.Streams()
+	}
+	return s
+}
@@ -0,0 +1,99 @@
+package exchanger
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+type fakeEx struct {
+	setupErr error
+	setups   int
+	stop     chan struct{}
+	ending   chan struct{} // waited for by Start after the stop if not nil
+}
+
+func (f *fakeEx) Setup() error {
+	f.setups++
+	return f.setupErr
+}
+
+func (f *fakeEx) Start(wg *sync.WaitGroup) {
+	defer wg.Done()
+	<-f.stop
+	if f.ending != nil {
+		<-f.ending
+	}
+}
+
+func (f *fakeEx) Stop() { f.stop <- struct{}{} }
+
+func TestManager(t *testing.T) {
+	m := NewManager()
+	ok, bad := &fakeEx{stop: make(chan struct{})}, &fakeEx{setupErr: errors.New("no api"), stop: make(chan struct{})}
+	m.Add("ok", ok)
+	m.Add("bad", bad)
+	if m.Add("ok", ok) == nil {
+		t.Fatal("duplicate exchanger added")
+	}
+
+	if s, err := m.Start("ok"); err != nil || s.Status != StatusRunning {
+		t.Fatal("start", s, err)
+	}
+	if s, err := m.Start("bad"); err == nil || s.Status != StatusFailed || s.Error != "no api" {
+		t.Fatal("failed setup", s, err)
+	}
+	if s, err := m.Stop("ok"); err != nil || s.Status != StatusStopped {
+		t.Fatal("stop", s, err)
+	}
+	// restarted without a new setup
+	if s, _ := m.Start("ok"); s.Status != StatusRunning || ok.setups != 1 {
+		t.Fatal("restart", s, ok.setups)
+	}
+	if _, err := m.Start("none"); err == nil {
+		t.Fatal("unknown exchanger started")
+	}
+
+	m.StopAll()
+	list := m.List()
+	if len(list) != 2 || list[0].Name != "bad" || list[1].Status != StatusStopped {
+		t.Fatal("wrong list", list)
+	}
+}
+
+func TestManagerStopping(t *testing.T) {
+	m := NewManager()
+	slow := &fakeEx{stop: make(chan struct{}), ending: make(chan struct{})}
+	m.Add("slow", slow)
+	m.Add("ok", &fakeEx{stop: make(chan struct{})})
+	m.Start("slow")
+
+	stopped := make(chan Status, 2)
+	for k := 0; k < 2; k++ {
+		go func() {
+			s, _ := m.Stop("slow")
+			stopped <- s
+		}()
+	}
+	for {
+		if s, _ := m.Status("slow"); s.Status == StatusStopping {
+			break
+		}
+		runtime.Gosched()
+	}
+	// the manager is not locked while the exchanger ends
+	if s, err := m.Start("ok"); err != nil || s.Status != StatusRunning {
+		t.Fatal("start during a stop", s, err)
+	}
+	if _, err := m.Start("slow"); err == nil {
+		t.Fatal("started while stopping")
+	}
+	close(slow.ending)
+	for k := 0; k < 2; k++ {
+		if s := <-stopped; s.Status != StatusStopped {
+			t.Fatal("stop", s)
+		}
+	}
+	m.StopAll()
+}
@@ -0,0 +1,77 @@
+package exchanger
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/exchangedata/common"
+)
+
+// EventStore keeps the source events, database.DataStore is one
+type EventStore interface {
+	InsertSourceEvents(c []*common.SourceEvent) error
+}
+
+// recoveries are the kinds of the events ending a failure, by the kind of the failure
+var recoveries = map[string]string{
+	common.EventFetchFailed:  common.EventFetchRecovered,
+	common.EventDisconnected: common.EventReconnected,
+}
+
+// SourceLog records the source events of an exchanger: a failure once until the recovery, whatever the retries
+type SourceLog struct {
+	exchanger string
+	store     EventStore
+
+	mu      sync.Mutex
+	failing map[string]bool // by kind:market
+}
+
+// NewSourceLog returns the log of exchanger writing to store, nil to only follow the failures
+func NewSourceLog(exchanger string, store EventStore) *SourceLog {
+	return &SourceLog{exchanger: exchanger, store: store, failing: make(map[string]bool)}
+}
+
+// Event records the event kind of the exchanger, like common.EventStarted
+func (l *SourceLog) Event(kind, detail string) {
+	l.record(kind, nil, detail)
+}
+
+// Fail records the failure kind of the market m, common.EventFetchFailed or common.EventDisconnected,
+// unless it is failing already
+func (l *SourceLog) Fail(kind string, m *common.Market, err error) {
+	key := kind + ":" + m.Name
+	l.mu.Lock()
+	failing := l.failing[key]
+	l.failing[key] = true
+	l.mu.Unlock()
+	if !failing {
+		l.record(kind, m, err.Error())
+	}
+}
+
+// Recover records the end of the failure kind of the market m, if it is failing
+func (l *SourceLog) Recover(kind string, m *common.Market) {
+	key := kind + ":" + m.Name
+	l.mu.Lock()
+	failing := l.failing[key]
+	delete(l.failing, key)
+	l.mu.Unlock()
+	if failing {
+		l.record(recoveries[kind], m, "")
+	}
+}
+
+func (l *SourceLog) record(kind string, m *common.Market, detail string) {
+	if l.store == nil {
+		return
+	}
+	e := &common.SourceEvent{Time: time.Now(), Exchanger: l.exchanger, Kind: kind, Detail: detail}
+	if m != nil {
+		e.MarketRef = m.ID
+	}
+	if err := l.store.InsertSourceEvents([]*common.SourceEvent{e}); err != nil {
+		log.Println("source event not stored:", l.exchanger, kind, err)
+	}
+}
@@ -0,0 +1,43 @@
+package exchanger
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/exchangedata/common"
+)
+
+type eventList struct {
+	events []*common.SourceEvent
+}
+
+func (l *eventList) InsertSourceEvents(c []*common.SourceEvent) error {
+	l.events = append(l.events, c...)
+	return nil
+}
+
+func TestSourceLog(t *testing.T) {
+	store := &eventList{}
+	l := NewSourceLog("bittrex", store)
+	m := &common.Market{ID: 3, Name: "BTC-LTC"}
+	l.Event(common.EventStarted, "")
+	l.Recover(common.EventFetchFailed, m) // not failing, nothing recorded
+	for k := 0; k < 3; k++ {
+		l.Fail(common.EventFetchFailed, m, errors.New("timeout"))
+	}
+	l.Recover(common.EventFetchFailed, m)
+	l.Fail(common.EventFetchFailed, m, errors.New("timeout"))
+
+	kinds := []string{common.EventStarted, common.EventFetchFailed, common.EventFetchRecovered, common.EventFetchFailed}
+	if len(store.events) != len(kinds) {
+		t.Fatal("wrong events", len(store.events))
+	}
+	for k, e := range store.events {
+		if e.Kind != kinds[k] || e.Exchanger != "bittrex" {
+			t.Error("wrong event", k, e.Kind, e.Exchanger)
+		}
+	}
+	if e := store.events[1]; e.MarketRef != 3 || e.Detail != "timeout" {
+		t.Error("wrong failure", e.MarketRef, e.Detail)
+	}
+}
@@ -0,0 +1,97 @@
+package exchanger
+
+import (
+	"sync"
+	"time"
+)
+
+// Latency collects the fetch durations of one market
+type Latency struct {
+	Count  uint64
+	Errors uint64
+	Last   time.Duration
+	Max    time.Duration
+	Total  time.Duration
+}
+
+// Average returns the mean fetch duration
+func (l Latency) Average() time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	return l.Total / time.Duration(l.Count)
+}
+
+// FetchStats records per market fetch latency and the fetch cycles which overran their interval.
+type FetchStats struct {
+	mu       sync.Mutex
+	markets  map[string]*Latency
+	cycles   uint64
+	overruns uint64
+}
+
+func NewFetchStats() *FetchStats {
+	return &FetchStats{markets: make(map[string]*Latency)}
+}
+
+// Observe adds one fetch of market taking d, err is the fetch result
+func (s *FetchStats) Observe(market string, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.markets[market]
+	if l == nil {
+		l = &Latency{}
+		s.markets[market] = l
+	}
+	l.Count++
+	if err != nil {
+		l.Errors++
+	}
+	l.Last = d
+	l.Total += d
+	if d > l.Max {
+		l.Max = d
+	}
+}
+
+// Cycle records a finished fetch cycle and reports whether it took longer than interval
+func (s *FetchStats) Cycle(elapsed, interval time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cycles++
+	if elapsed > interval {
+		s.overruns++
+		return true
+	}
+	return false
+}
+
+// Overruns returns the number of cycles and how many of them overran
+func (s *FetchStats) Overruns() (cycles, overruns uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cycles, s.overruns
+}
+
+// Latency returns a copy of the latency of market
+func (s *FetchStats) Latency(market string) (Latency, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.markets[market]
+	if !ok {
+		return Latency{}, false
+	}
+	return *l, true
+}
+
+// Slowest returns the market with the highest average latency
+func (s *FetchStats) Slowest() (market string, l Latency) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range s.markets {
+		if v.Average() > l.Average() {
+			market, l = k, *v
+		}
+	}
+	return
+}
@@ -0,0 +1,31 @@
+package exchanger
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFetchStats(t *testing.T) {
+	s := NewFetchStats()
+	s.Observe("BTC_LTC", 10*time.Millisecond, nil)
+	s.Observe("BTC_LTC", 30*time.Millisecond, nil)
+	s.Observe("BTC_ETH", 5*time.Millisecond, nil)
+
+	l, ok := s.Latency("BTC_LTC")
+	if !ok || l.Count != 2 || l.Average() != 20*time.Millisecond || l.Max != 30*time.Millisecond {
+		t.Fatalf("wrong latency %+v", l)
+	}
+	if m, _ := s.Slowest(); m != "BTC_LTC" {
+		t.Fatalf("slowest market should be BTC_LTC, got %s", m)
+	}
+
+	if s.Cycle(time.Second, 5*time.Second) {
+		t.Fatal("cycle shorter than interval reported as overrun")
+	}
+	if !s.Cycle(6*time.Second, 5*time.Second) {
+		t.Fatal("cycle longer than interval not reported")
+	}
+	if c, o := s.Overruns(); c != 2 || o != 1 {
+		t.Fatalf("want 2 cycles 1 overrun, got %d %d", c, o)
+	}
+}
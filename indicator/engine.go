@@ -0,0 +1,135 @@
+package indicator
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/exchangedata/candle"
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/database"
+	"github.com/exchangedata/sink"
+)
+
+// CandleStore gives the stored candles preceding the live ones, database.DataStore is one
+type CandleStore interface {
+	Candles(marketRef uint, interval string, from, to time.Time, after *database.Cursor, limit int) ([]*common.Candle, *database.Cursor, error)
+}
+
+// Engine is a sink.Sink computing its indicators over the live candles of each market and interval,
+// it writes their values to out as the candles change. The other records are ignored.
+//
+// With a Store, the series of a market and interval starts with the stored candles before its first live one,
+// its first values are not delayed by the warmup.
+type Engine struct {
+	Store     CandleStore // set before the first candle
+	Revisions int         // candles kept for the corrections, DefaultRevisions if 0
+
+	out  sink.IndicatorWriter
+	inds []Indicator // never updated, the series start with their clones
+
+	mu     sync.Mutex
+	series map[seriesKey][]*Series
+}
+
+type seriesKey struct {
+	market   uint
+	interval string
+}
+
+// NewEngine returns the engine of the new indicators inds writing to out.
+// out belongs to the caller, it is not closed by Close.
+func NewEngine(out sink.IndicatorWriter, inds ...Indicator) *Engine {
+	return &Engine{out: out, inds: inds, series: make(map[seriesKey][]*Series)}
+}
+
+func (e *Engine) WriteTickers(c []*common.Ticker) error       { return nil }
+func (e *Engine) WriteTrades(c []*common.Trade) error         { return nil }
+func (e *Engine) WriteOrderBooks(c []*common.OrderBook) error { return nil }
+func (e *Engine) Close() error                                { return nil }
+
+// WriteCandles updates the series of the candles c and writes the changed values
+func (e *Engine) WriteCandles(c []*common.Candle) error {
+	e.mu.Lock()
+	var values []*common.IndicatorValue
+	for _, cd := range c {
+		ref := cd.MarketRef
+		if ref == 0 && cd.Market != nil {
+			ref = cd.Market.ID
+		}
+		key := seriesKey{market: ref, interval: cd.Interval}
+		series := e.series[key]
+		if series == nil {
+			series = e.start(ref, cd)
+			e.series[key] = series
+		}
+		for _, s := range series {
+			ind := s.Indicator()
+			for _, p := range s.Update(cd) {
+				values = append(values, &common.IndicatorValue{Time: p.Time, Market: cd.Market, Interval: cd.Interval,
+					Indicator: ind.Name(), Fields: ind.Fields(), Values: p.Values})
+			}
+		}
+	}
+	e.mu.Unlock()
+	if len(values) == 0 {
+		return nil
+	}
+	return e.out.WriteIndicators(values)
+}
+
+// start returns the series of the market ref and interval of c, warmed up with the stored candles before c
+func (e *Engine) start(ref uint, c *common.Candle) []*Series {
+	series := make([]*Series, len(e.inds))
+	warmup := 0
+	for k, ind := range e.inds {
+		if ind.Warmup() > warmup {
+			warmup = ind.Warmup()
+		}
+		series[k] = NewSeries(ind.Clone(), e.Revisions)
+	}
+	if e.Store == nil || ref == 0 || warmup == 0 {
+		return series
+	}
+	past, err := History(e.Store, ref, c.Interval, c.Time, warmup)
+	if err != nil {
+		log.Println("indicator history not read:", c.Interval, ref, err)
+		return series
+	}
+	for _, s := range series {
+		for _, p := range past {
+			s.Update(p)
+		}
+	}
+	return series
+}
+
+// History returns the last n stored candles of the market ref and interval before the time before, in time order
+func History(store CandleStore, ref uint, interval string, before time.Time, n int) ([]*common.Candle, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	i, err := candle.ParseInterval(interval)
+	if err != nil {
+		return nil, err
+	}
+	// the range of n candles, wider for the gaps of the markets without trades
+	from := before.Add(-time.Duration(2*n) * i.Duration())
+	var c []*common.Candle
+	var after *database.Cursor
+	for {
+		page, next, err := store.Candles(ref, interval, from, before, after, database.DefaultQueryLimit)
+		if err != nil {
+			return nil, err
+		}
+		c = append(c, page...)
+		if next == nil {
+			break
+		}
+		after = next
+	}
+	if len(c) > n {
+		c = c[len(c)-n:]
+	}
+	return c, nil
+}
@@ -0,0 +1,440 @@
+// Package indicator computes technical indicators over candle series: SMA, EMA, RSI, MACD, Bollinger bands,
+// ATR and VWAP. An Indicator is updated one candle at a time, the same code serves the stored candles,
+// see Compute, and the live ones, see Engine.
+//
+// An indicator is named by its function and parameters, the missing ones take their usual default:
+//
+//	sma(20) ema(20) rsi(14) macd(12,26,9) bb(20,2) atr(14) vwap vwap(20)
+//
+// vwap is reset at midnight UTC, vwap(n) is over the last n candles. The periods are at most MaxPeriod.
+package indicator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/exchangedata/common"
+)
+
+// Indicator is the state of an indicator over a series of candles
+type Indicator interface {
+	Name() string     // like macd(12,26,9)
+	Fields() []string // the names of the values
+	Warmup() int      // the number of candles before the first values
+	// Update adds the candle c, the next of the series, and returns the values after it, nil during the warmup
+	Update(c *common.Candle) []float64
+	Clone() Indicator // a copy of the state
+}
+
+// defaults are the parameters of the indicators by function
+var defaults = map[string][]float64{
+	"sma": {20}, "ema": {20}, "rsi": {14}, "atr": {14}, "macd": {12, 26, 9}, "bb": {20, 2}, "vwap": {0},
+}
+
+// MaxPeriod is the longest period of an indicator, the longer ones would hold or fetch too many candles
+const MaxPeriod = 10000
+
+// Parse returns the indicator named s, like sma(20)
+func Parse(s string) (Indicator, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	name, args := s, []float64{}
+	if k := strings.IndexByte(s, '('); k >= 0 {
+		if !strings.HasSuffix(s, ")") {
+			return nil, errors.New("bad indicator " + s)
+		}
+		name = s[:k]
+		if p := strings.TrimSpace(s[k+1 : len(s)-1]); p != "" {
+			for _, a := range strings.Split(p, ",") {
+				v, err := strconv.ParseFloat(strings.TrimSpace(a), 64)
+				if err != nil || v < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+					return nil, errors.New("bad indicator parameter " + s)
+				}
+				args = append(args, v)
+			}
+		}
+	}
+	def, ok := defaults[name]
+	if !ok {
+		return nil, errors.New("unknown indicator " + name)
+	}
+	if len(args) > len(def) {
+		return nil, errors.New("too many indicator parameters " + s)
+	}
+	p := append(args, def[len(args):]...)
+	for k, v := range p {
+		if name == "bb" && k == 1 { // the width of the band
+			continue
+		}
+		if v != math.Trunc(v) || v > MaxPeriod || (v < 1 && name != "vwap") {
+			return nil, errors.New("bad indicator period " + s)
+		}
+	}
+	n := int(p[0])
+	switch name {
+	case "sma":
+		return NewSMA(n), nil
+	case "ema":
+		return NewEMA(n), nil
+	case "rsi":
+		return NewRSI(n), nil
+	case "atr":
+		return NewATR(n), nil
+	case "macd":
+		if p[0] >= p[1] {
+			return nil, errors.New("macd fast period not below the slow one " + s)
+		}
+		return NewMACD(n, int(p[1]), int(p[2])), nil
+	case "bb":
+		return NewBollinger(n, p[1]), nil
+	}
+	return NewVWAP(n), nil
+}
+
+// ParseList returns the indicators of a comma separated list, like sma(20),macd(12,26,9)
+func ParseList(s string) ([]Indicator, error) {
+	var c []Indicator
+	depth, start := 0, 0
+	for k := 0; k <= len(s); k++ {
+		if k < len(s) {
+			switch s[k] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+			if s[k] != ',' || depth > 0 {
+				continue
+			}
+		}
+		if strings.TrimSpace(s[start:k]) != "" {
+			ind, err := Parse(s[start:k])
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, ind)
+		}
+		start = k + 1
+	}
+	return c, nil
+}
+
+func float(c *common.Candle) (high, low, close float64) {
+	high, _ = c.High.Float64()
+	low, _ = c.Low.Float64()
+	close, _ = c.Close.Float64()
+	return
+}
+
+// window is the last n values of a series and their sum
+type window struct {
+	v    []float64
+	next int
+	full bool
+	sum  float64
+}
+
+func newWindow(n int) window {
+	return window{v: make([]float64, n)}
+}
+
+// add adds x, dropping the oldest value of a full window
+func (w *window) add(x float64) {
+	w.sum += x - w.v[w.next]
+	w.v[w.next] = x
+	w.next++
+	if w.next == len(w.v) {
+		w.next, w.full = 0, true
+		w.sum = 0 // the sum is recomputed once per turn, not to drift
+		for _, v := range w.v {
+			w.sum += v
+		}
+	}
+}
+
+func (w *window) mean() float64 {
+	return w.sum / float64(len(w.v))
+}
+
+func (w window) clone() window {
+	w.v = append([]float64{}, w.v...)
+	return w
+}
+
+// SMA is the simple moving average of the closes
+type SMA struct {
+	w window
+}
+
+func NewSMA(n int) *SMA {
+	return &SMA{w: newWindow(n)}
+}
+
+func (s *SMA) Name() string     { return fmt.Sprintf("sma(%d)", len(s.w.v)) }
+func (s *SMA) Fields() []string { return []string{"sma"} }
+func (s *SMA) Warmup() int      { return len(s.w.v) - 1 }
+func (s *SMA) Clone() Indicator { return &SMA{w: s.w.clone()} }
+
+func (s *SMA) Update(c *common.Candle) []float64 {
+	_, _, close := float(c)
+	s.w.add(close)
+	if !s.w.full {
+		return nil
+	}
+	return []float64{s.w.mean()}
+}
+
+// ema is an exponential moving average started with the simple average of its first n values
+type ema struct {
+	n     int
+	count int
+	value float64
+}
+
+func (e *ema) add(x float64) bool {
+	e.count++
+	switch {
+	case e.count < e.n:
+		e.value += x
+		return false
+	case e.count == e.n:
+		e.value = (e.value + x) / float64(e.n)
+	default:
+		k := 2 / float64(e.n+1)
+		e.value += k * (x - e.value)
+	}
+	return true
+}
+
+// EMA is the exponential moving average of the closes
+type EMA struct {
+	e ema
+}
+
+func NewEMA(n int) *EMA {
+	return &EMA{e: ema{n: n}}
+}
+
+func (e *EMA) Name() string     { return fmt.Sprintf("ema(%d)", e.e.n) }
+func (e *EMA) Fields() []string { return []string{"ema"} }
+func (e *EMA) Warmup() int      { return e.e.n - 1 }
+func (e *EMA) Clone() Indicator { c := *e; return &c }
+
+func (e *EMA) Update(c *common.Candle) []float64 {
+	_, _, close := float(c)
+	if !e.e.add(close) {
+		return nil
+	}
+	return []float64{e.e.value}
+}
+
+// wilder is the smoothed average of Wilder, started with the simple average of its first n values
+type wilder struct {
+	n     int
+	count int
+	value float64
+}
+
+func (w *wilder) add(x float64) bool {
+	w.count++
+	switch {
+	case w.count < w.n:
+		w.value += x
+		return false
+	case w.count == w.n:
+		w.value = (w.value + x) / float64(w.n)
+	default:
+		w.value = (w.value*float64(w.n-1) + x) / float64(w.n)
+	}
+	return true
+}
+
+// RSI is the relative strength index of Wilder of the closes, from 0 to 100
+type RSI struct {
+	gain, loss wilder
+	prev       float64
+	started    bool
+}
+
+func NewRSI(n int) *RSI {
+	return &RSI{gain: wilder{n: n}, loss: wilder{n: n}}
+}
+
+func (r *RSI) Name() string     { return fmt.Sprintf("rsi(%d)", r.gain.n) }
+func (r *RSI) Fields() []string { return []string{"rsi"} }
+func (r *RSI) Warmup() int      { return r.gain.n }
+func (r *RSI) Clone() Indicator { c := *r; return &c }
+
+func (r *RSI) Update(c *common.Candle) []float64 {
+	_, _, close := float(c)
+	if !r.started {
+		r.prev, r.started = close, true
+		return nil
+	}
+	d := close - r.prev
+	r.prev = close
+	r.gain.add(math.Max(d, 0))
+	if !r.loss.add(math.Max(-d, 0)) {
+		return nil
+	}
+	if r.loss.value == 0 {
+		return []float64{100}
+	}
+	return []float64{100 - 100/(1+r.gain.value/r.loss.value)}
+}
+
+// MACD is the difference of a fast and a slow EMA of the closes, its signal EMA and their difference
+type MACD struct {
+	fast, slow, signal ema
+}
+
+func NewMACD(fast, slow, signal int) *MACD {
+	return &MACD{fast: ema{n: fast}, slow: ema{n: slow}, signal: ema{n: signal}}
+}
+
+func (m *MACD) Name() string {
+	return fmt.Sprintf("macd(%d,%d,%d)", m.fast.n, m.slow.n, m.signal.n)
+}
+func (m *MACD) Fields() []string { return []string{"macd", "signal", "histogram"} }
+func (m *MACD) Warmup() int      { return m.slow.n + m.signal.n - 2 }
+func (m *MACD) Clone() Indicator { c := *m; return &c }
+
+func (m *MACD) Update(c *common.Candle) []float64 {
+	_, _, close := float(c)
+	m.fast.add(close)
+	if !m.slow.add(close) {
+		return nil
+	}
+	macd := m.fast.value - m.slow.value
+	if !m.signal.add(macd) {
+		return nil
+	}
+	return []float64{macd, m.signal.value, macd - m.signal.value}
+}
+
+// Bollinger is the band of k standard deviations around the simple moving average of the closes
+type Bollinger struct {
+	w window
+	k float64
+}
+
+func NewBollinger(n int, k float64) *Bollinger {
+	return &Bollinger{w: newWindow(n), k: k}
+}
+
+func (b *Bollinger) Name() string {
+	return fmt.Sprintf("bb(%d,%s)", len(b.w.v), strconv.FormatFloat(b.k, 'f', -1, 64))
+}
+func (b *Bollinger) Fields() []string { return []string{"middle", "upper", "lower"} }
+func (b *Bollinger) Warmup() int      { return len(b.w.v) - 1 }
+func (b *Bollinger) Clone() Indicator { return &Bollinger{w: b.w.clone(), k: b.k} }
+
+func (b *Bollinger) Update(c *common.Candle) []float64 {
+	_, _, close := float(c)
+	b.w.add(close)
+	if !b.w.full {
+		return nil
+	}
+	mean, variance := b.w.mean(), 0.0
+	for _, v := range b.w.v {
+		variance += (v - mean) * (v - mean)
+	}
+	d := b.k * math.Sqrt(variance/float64(len(b.w.v)))
+	return []float64{mean, mean + d, mean - d}
+}
+
+// ATR is the average true range of Wilder
+type ATR struct {
+	tr      wilder
+	prev    float64
+	started bool
+}
+
+func NewATR(n int) *ATR {
+	return &ATR{tr: wilder{n: n}}
+}
+
+func (a *ATR) Name() string     { return fmt.Sprintf("atr(%d)", a.tr.n) }
+func (a *ATR) Fields() []string { return []string{"atr"} }
+func (a *ATR) Warmup() int      { return a.tr.n - 1 }
+func (a *ATR) Clone() Indicator { c := *a; return &c }
+
+func (a *ATR) Update(c *common.Candle) []float64 {
+	high, low, close := float(c)
+	tr := high - low
+	if a.started {
+		tr = math.Max(tr, math.Max(math.Abs(high-a.prev), math.Abs(low-a.prev)))
+	}
+	a.prev, a.started = close, true
+	if !a.tr.add(tr) {
+		return nil
+	}
+	return []float64{a.tr.value}
+}
+
+// VWAP is the average of the typical prices, (high + low + close) / 3, weighted by the volumes,
+// of the day in UTC or of the last n candles
+type VWAP struct {
+	n         int
+	pv, vol   window    // of the last n candles
+	day       time.Time // of the daily sums
+	dpv, dvol float64
+}
+
+// NewVWAP returns the VWAP of the last n candles, of the day if n is 0
+func NewVWAP(n int) *VWAP {
+	v := &VWAP{n: n}
+	if n > 0 {
+		v.pv, v.vol = newWindow(n), newWindow(n)
+	}
+	return v
+}
+
+func (v *VWAP) Name() string {
+	if v.n == 0 {
+		return "vwap"
+	}
+	return fmt.Sprintf("vwap(%d)", v.n)
+}
+func (v *VWAP) Fields() []string { return []string{"vwap"} }
+
+func (v *VWAP) Warmup() int {
+	if v.n == 0 {
+		return 0
+	}
+	return v.n - 1
+}
+
+func (v *VWAP) Clone() Indicator {
+	c := *v
+	if v.n > 0 {
+		c.pv, c.vol = v.pv.clone(), v.vol.clone()
+	}
+	return &c
+}
+
+func (v *VWAP) Update(c *common.Candle) []float64 {
+	high, low, close := float(c)
+	vol, _ := c.Volume.Float64()
+	pv := (high + low + close) / 3 * vol
+	if v.n > 0 {
+		v.pv.add(pv)
+		v.vol.add(vol)
+		if !v.pv.full || v.vol.sum == 0 {
+			return nil
+		}
+		return []float64{v.pv.sum / v.vol.sum}
+	}
+	if day := c.Time.UTC().Truncate(24 * time.Hour); !day.Equal(v.day) {
+		v.day, v.dpv, v.dvol = day, 0, 0
+	}
+	v.dpv += pv
+	v.dvol += vol
+	if v.dvol == 0 {
+		return nil
+	}
+	return []float64{v.dpv / v.dvol}
+}
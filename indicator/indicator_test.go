@@ -0,0 +1,158 @@
+package indicator
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/shopspring/decimal"
+)
+
+var start = time.Date(2018, 11, 26, 0, 0, 0, 0, time.UTC)
+
+// candles returns the 1m candles of the high, low, close and volume quadruples hlcv
+func candles(hlcv ...float64) []*common.Candle {
+	var c []*common.Candle
+	for k := 0; k < len(hlcv); k += 4 {
+		c = append(c, &common.Candle{Time: start.Add(time.Duration(k/4) * time.Minute), Interval: "1m",
+			High: decimal.NewFromFloat(hlcv[k]), Low: decimal.NewFromFloat(hlcv[k+1]),
+			Close: decimal.NewFromFloat(hlcv[k+2]), Volume: decimal.NewFromFloat(hlcv[k+3])})
+	}
+	return c
+}
+
+// closes returns the 1m candles of the closes c
+func closes(c ...float64) []*common.Candle {
+	var hlcv []float64
+	for _, v := range c {
+		hlcv = append(hlcv, v, v, v, 1)
+	}
+	return candles(hlcv...)
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestIndicators(t *testing.T) {
+	for _, tc := range []struct {
+		spec   string
+		c      []*common.Candle
+		first  int // index of the candle of the first values
+		values [][]float64
+	}{
+		{"sma(3)", closes(1, 2, 3, 4, 5), 2, [][]float64{{2}, {3}, {4}}},
+		{"ema(3)", closes(1, 2, 3, 4, 5), 2, [][]float64{{2}, {3}, {4}}},
+		{"rsi(2)", closes(1, 2, 3, 2), 2, [][]float64{{100}, {50}}},
+		{"macd(2,3,2)", closes(1, 2, 3, 4, 5), 3, [][]float64{{0.5, 0.5, 0}, {0.5, 0.5, 0}}},
+		{"bb(3)", closes(1, 2, 3), 2, [][]float64{{2, 2 + 2*math.Sqrt(2.0/3), 2 - 2*math.Sqrt(2.0/3)}}},
+		{"atr(2)", candles(2, 1, 1.5, 1, 3, 2, 2.5, 1, 4, 2, 3, 1), 1, [][]float64{{1.25}, {1.625}}},
+		{"vwap", candles(3, 1, 2, 1, 6, 3, 3, 3), 0, [][]float64{{2}, {(2 + 12) / 4.0}}},
+		{"vwap(1)", candles(3, 1, 2, 1, 6, 3, 3, 3), 0, [][]float64{{2}, {4}}},
+	} {
+		ind, err := Parse(tc.spec)
+		if err != nil {
+			t.Fatal(tc.spec, err)
+		}
+		if ind.Warmup() != tc.first {
+			t.Error(tc.spec, "warmup", ind.Warmup())
+		}
+		p := Compute(ind, tc.c)
+		if len(p) != len(tc.values) || !p[0].Time.Equal(tc.c[tc.first].Time) {
+			t.Error(tc.spec, "wrong points", p)
+			continue
+		}
+		for k, v := range tc.values {
+			for j := range v {
+				if !near(p[k].Values[j], v[j]) {
+					t.Error(tc.spec, "value", k, ind.Fields()[j], p[k].Values[j], "expected", v[j])
+				}
+			}
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	c, err := ParseList("SMA(5), macd, bb(20, 2.5),vwap")
+	if err != nil || len(c) != 4 {
+		t.Fatal("list", c, err)
+	}
+	for k, name := range []string{"sma(5)", "macd(12,26,9)", "bb(20,2.5)", "vwap"} {
+		if c[k].Name() != name {
+			t.Error("name", c[k].Name(), "expected", name)
+		}
+	}
+	for _, bad := range []string{"wma(5)", "sma(0)", "sma(2.5)", "sma(5", "rsi(1,2)", "macd(26,12,9)", "ema(x)",
+		"sma(1e19)", "sma(1e10)", "ema(10001)", "macd(12,26000,9)", "vwap(1e6)", "bb(20,inf)"} {
+		if _, err := Parse(bad); err == nil {
+			t.Error("bad indicator accepted", bad)
+		}
+	}
+	if ind, err := Parse("sma(10000)"); err != nil || ind.Warmup() != MaxPeriod-1 {
+		t.Error("longest period", err)
+	}
+}
+
+func TestSeries(t *testing.T) {
+	c := closes(1, 2, 3, 4)
+	s := NewSeries(NewSMA(2), 2)
+	if p := s.Update(c[0]); p != nil {
+		t.Fatal("value during the warmup", p)
+	}
+	if p := s.Update(c[1]); len(p) != 1 || p[0].Values[0] != 1.5 {
+		t.Fatal("first value", p)
+	}
+	// the open candle changes
+	if p := s.Update(closes(0, 4)[1]); len(p) != 1 || p[0].Values[0] != 2.5 {
+		t.Fatal("changed candle", p)
+	}
+	// the previous candle is corrected, the values after it are computed again
+	if p := s.Update(closes(3)[0]); len(p) != 1 || !p[0].Time.Equal(c[1].Time) || p[0].Values[0] != 3.5 {
+		t.Fatal("corrected candle", p)
+	}
+	s.Update(c[2])
+	s.Update(c[3])
+	if p := s.Update(c[1]); p != nil || s.Dropped() != 1 {
+		t.Fatal("candle older than the kept ones", p, s.Dropped())
+	}
+	// a candle missing from the kept ones is inserted
+	s = NewSeries(NewSMA(2), 0)
+	s.Update(c[0])
+	s.Update(c[2])
+	if p := s.Update(c[1]); len(p) != 2 || p[0].Values[0] != 1.5 || p[1].Values[0] != 2.5 {
+		t.Fatal("inserted candle", p)
+	}
+}
+
+type indicatorSink struct {
+	values []*common.IndicatorValue
+}
+
+func (s *indicatorSink) WriteIndicators(c []*common.IndicatorValue) error {
+	s.values = append(s.values, c...)
+	return nil
+}
+
+func TestEngine(t *testing.T) {
+	out := &indicatorSink{}
+	inds, _ := ParseList("sma(2),rsi(2)")
+	e := NewEngine(out, inds...)
+	m := &common.Market{ID: 1, Name: "USDT-BTC", Exchanger: &common.Exchanger{Name: "bittrex"}}
+	c := closes(1, 2, 3)
+	for _, cd := range c {
+		cd.Market = m
+	}
+	e.WriteCandles(c[:2])
+	if len(out.values) != 1 || out.values[0].Indicator != "sma(2)" || out.values[0].Market != m || out.values[0].Values[0] != 1.5 {
+		t.Fatal("first values", out.values)
+	}
+	e.WriteCandles(c[2:])
+	if len(out.values) != 3 || out.values[2].Indicator != "rsi(2)" || out.values[2].Values[0] != 100 || out.values[2].Fields[0] != "rsi" {
+		t.Fatal("values", out.values)
+	}
+	// the prototypes are not updated
+	if inds[0].Update(c[0]) != nil {
+		t.Fatal("prototype updated")
+	}
+}
@@ -0,0 +1,103 @@
+package indicator
+
+import (
+	"time"
+
+	"github.com/exchangedata/common"
+)
+
+// DefaultRevisions is the number of the last candles of a Series that may still change
+const DefaultRevisions = 16
+
+// Point is the values of an indicator after the candle of Time
+type Point struct {
+	Time   time.Time
+	Values []float64
+}
+
+// Compute returns the values of ind over the candles c in time order, ind is updated
+func Compute(ind Indicator, c []*common.Candle) []Point {
+	var p []Point
+	for _, candle := range c {
+		if v := ind.Update(candle); v != nil {
+			p = append(p, Point{Time: candle.Time, Values: v})
+		}
+	}
+	return p
+}
+
+// Series updates an indicator with the candles of a live series: the open candle changes until it closes,
+// a closed candle is corrected by the trades arriving late. The last candles are kept with the state before them,
+// a candle among them replaces the one of its time, or is inserted, and the values after it are computed again.
+type Series struct {
+	ind     Indicator
+	max     int
+	past    []revision
+	dropped uint64
+}
+
+// revision is a kept candle and the state of the indicator before it
+type revision struct {
+	c      *common.Candle
+	before Indicator
+}
+
+// NewSeries returns the series of ind keeping the last revisions candles, DefaultRevisions if 0
+func NewSeries(ind Indicator, revisions int) *Series {
+	if revisions <= 0 {
+		revisions = DefaultRevisions
+	}
+	return &Series{ind: ind, max: revisions}
+}
+
+// Indicator returns the indicator of s, it must not be updated
+func (s *Series) Indicator() Indicator {
+	return s.ind
+}
+
+// Dropped is the number of candles older than the kept ones, they were ignored
+func (s *Series) Dropped() uint64 {
+	return s.dropped
+}
+
+// Update adds or replaces the candle c and returns the values that changed, in time order
+func (s *Series) Update(c *common.Candle) []Point {
+	k := len(s.past)
+	for k > 0 && !s.past[k-1].c.Time.Before(c.Time) {
+		k--
+	}
+	switch {
+	case k == len(s.past):
+		s.past = append(s.past, revision{c: c, before: s.ind.Clone()})
+		if len(s.past) > s.max {
+			s.past = s.past[1:]
+		}
+		if v := s.ind.Update(c); v != nil {
+			return []Point{{Time: c.Time, Values: v}}
+		}
+		return nil
+	case k == 0 && len(s.past) == s.max && !s.past[0].c.Time.Equal(c.Time):
+		s.dropped++
+		return nil
+	case s.past[k].c.Time.Equal(c.Time):
+		s.past[k].c = c
+	default:
+		s.past = append(s.past, revision{})
+		copy(s.past[k+1:], s.past[k:])
+		s.past[k] = revision{c: c, before: s.past[k+1].before}
+		if len(s.past) > s.max {
+			s.past = s.past[1:]
+			k--
+		}
+	}
+	// the state before the candle k is replayed with the candles from k
+	s.ind = s.past[k].before.Clone()
+	var p []Point
+	for j := k; j < len(s.past); j++ {
+		s.past[j].before = s.ind.Clone()
+		if v := s.ind.Update(s.past[j].c); v != nil {
+			p = append(p, Point{Time: s.past[j].c.Time, Values: v})
+		}
+	}
+	return p
+}
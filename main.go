@@ -2,12 +2,34 @@ package main
 
 import (
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/exchangedata/alert"
+	"github.com/exchangedata/arbitrage"
+	"github.com/exchangedata/bookmetrics"
+	"github.com/exchangedata/bus"
+	"github.com/exchangedata/candle"
 	"github.com/exchangedata/common"
+	"github.com/exchangedata/consolidate"
+	"github.com/exchangedata/database"
 	"github.com/exchangedata/exchanger"
+	"github.com/exchangedata/indicator"
+	"github.com/exchangedata/mq"
+	"github.com/exchangedata/mq/kafkabroker"
+	"github.com/exchangedata/mq/natsbroker"
+	"github.com/exchangedata/rpc"
+	"github.com/exchangedata/sink"
+	"github.com/exchangedata/stream"
+	"github.com/exchangedata/validate"
+	"github.com/shopspring/decimal"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 const ()
@@ -22,27 +44,282 @@ func main() {
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
 
-	exs := []exchanger.ExControl{}
-	wg := &sync.WaitGroup{}
+	dbConf, err := database.LoadConfig("")
+	if err != nil {
+		log.Fatalln("db config failed", err)
+	}
+	ds := dbConf.DataStore()
+	if err := ds.OpenDB(); err != nil {
+		log.Fatalln("open db failed", err)
+	}
+	defer ds.CloseDB()
+	// the pending migrations run under a lock of the database, the other daemons starting wait for them.
+	// EXDATA_DB_MIGRATE=off leaves them to dbman migrate up, the daemon refuses to start before.
+	if os.Getenv("EXDATA_DB_MIGRATE") == "off" {
+		if pending, err := ds.PendingMigrations(); err != nil || len(pending) > 0 {
+			log.Fatalln("db schema not migrated, run dbman migrate up", len(pending), err)
+		}
+	} else if err := ds.Migrate(); err != nil {
+		log.Fatalln("migrate db failed", err)
+	}
+	// the exchangers publish to the bus, each consumer subscribes with its own buffer:
+	// the stores hold the fetchers back rather than lose data, the live events drop the oldest instead
+	events := bus.New()
+	fwg := &sync.WaitGroup{}
+	var subs []*bus.Subscriber
+	consume := func(name string, s sink.Sink, size int, policy bus.Policy) {
+		sub := events.Subscribe(name, size, policy)
+		subs = append(subs, sub)
+		fwg.Add(1)
+		go func() {
+			defer fwg.Done()
+			bus.Forward(sub, s, func(err error) { log.Println(name, "write error:", err) })
+			if err := s.Close(); err != nil {
+				log.Println("error flush market data:", name, err)
+			}
+		}()
+	}
+	consume("db", sink.NewGorm(ds, 0, 0), 1024, bus.Block)
+	if dir := os.Getenv("EXDATA_FILE_DIR"); dir != "" { // raw market data on disk next to the database
+		conf := sink.FileConfig{Dir: dir, Format: sink.FormatJSONL, Compression: sink.CompressGzip, MaxSize: 64 << 20, MaxAge: time.Hour}
+		if os.Getenv("EXDATA_FILE_FORMAT") == sink.FormatParquet {
+			conf.Format, conf.Compression = sink.FormatParquet, sink.CompressNone
+		}
+		files, err := sink.NewFile(conf)
+		if err != nil {
+			log.Fatalln("file sink failed", err)
+		}
+		consume("file", files, 1024, bus.Block)
+	}
+	if url := os.Getenv("EXDATA_NATS_URL"); url != "" { // live events for the downstream services
+		b, err := natsbroker.Connect(url)
+		if err != nil {
+			log.Fatalln("nats connect failed", err)
+		}
+		consume("nats", mq.NewPublisher(b), 4096, bus.DropOldest)
+	}
+	if brokers := os.Getenv("EXDATA_KAFKA_BROKERS"); brokers != "" {
+		consume("kafka", mq.NewPublisher(kafkabroker.New(strings.Split(brokers, ",")...)), 4096, bus.DropOldest)
+	}
+	// the live updates of the websocket and of the gRPC streams
+	var hub *stream.Hub
+	wsAddr, grpcAddr := os.Getenv("EXDATA_WS_ADDR"), os.Getenv("EXDATA_GRPC_ADDR")
+	if wsAddr != "" || grpcAddr != "" {
+		hub = stream.NewHub(events)
+		fwg.Add(1)
+		go func() {
+			defer fwg.Done()
+			hub.Run()
+		}()
+	}
+	if wsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/ws", hub)
+		go func() {
+			log.Println("stream listening on", wsAddr)
+			if err := http.ListenAndServe(wsAddr, mux); err != nil {
+				log.Fatalln("stream server failed", err)
+			}
+		}()
+	}
+
+	// the indicators of EXDATA_INDICATORS over the live candles, published back to the bus:
+	// the engine only takes the candles, it never waits for its own events
+	if specs := os.Getenv("EXDATA_INDICATORS"); specs != "" {
+		inds, err := indicator.ParseList(specs)
+		if err != nil {
+			log.Fatalln("indicators", err)
+		}
+		engine := indicator.NewEngine(bus.NewSink(events), inds...)
+		engine.Store = ds
+		sub := events.Subscribe("indicators", 1024, bus.Block, bus.Candles)
+		subs = append(subs, sub)
+		fwg.Add(1)
+		go func() {
+			defer fwg.Done()
+			bus.Forward(sub, engine, func(err error) { log.Println("indicators write error:", err) })
+		}()
+	}
+
+	// the candles built from the trades of all the exchangers, in the time zone of EXDATA_CANDLE_TZ
+	exSinks := []sink.Sink{bus.NewSink(events)}
+	var candles *candle.Aggregator
+	if s := os.Getenv("EXDATA_CANDLE_INTERVALS"); s != "" {
+		intervals, err := candle.ParseIntervals(s)
+		if err != nil {
+			log.Fatalln("candle intervals", err)
+		}
+		loc, err := time.LoadLocation(os.Getenv("EXDATA_CANDLE_TZ"))
+		if err != nil {
+			log.Fatalln("candle time zone", err)
+		}
+		candles = candle.NewAggregator(bus.NewSink(events), loc, 0, intervals...)
+		if err = candles.Seed(ds); err != nil { // the candles open at the start
+			log.Println("candle seed error:", err)
+		}
+		exSinks = append(exSinks, candles)
+	}
+	// the best bid and offer of the symbols traded on several exchangers
+	var consolidator *consolidate.Consolidator
+	if os.Getenv("EXDATA_CONSOLIDATE") != "" {
+		consolidator = consolidate.NewConsolidator(bus.NewSink(events), 0)
+		exSinks = append(exSinks, consolidator)
+	}
+	// the depth, imbalance and slippage of the order books, EXDATA_BOOK_BAND around the mid price and for orders
+	// of EXDATA_BOOK_NOTIONAL
+	var analyzer *bookmetrics.Analyzer
+	if os.Getenv("EXDATA_BOOK_METRICS") != "" {
+		analyzer = bookmetrics.NewAnalyzer(bus.NewSink(events), 0)
+		var err error
+		if s := os.Getenv("EXDATA_BOOK_BAND"); s != "" {
+			if analyzer.Band, err = decimal.NewFromString(s); err != nil {
+				log.Fatalln("book metrics band", err)
+			}
+		}
+		if s := os.Getenv("EXDATA_BOOK_NOTIONAL"); s != "" {
+			if analyzer.Notional, err = decimal.NewFromString(s); err != nil {
+				log.Fatalln("book metrics notional", err)
+			}
+		}
+		exSinks = append(exSinks, analyzer)
+	}
+	// the arbitrage opportunities in the order books, after the fees of EXDATA_ARB_FEES
+	var detector *arbitrage.Detector
+	if os.Getenv("EXDATA_ARBITRAGE") != "" {
+		detector = arbitrage.NewDetector(bus.NewSink(events), 0)
+		fees, err := arbitrage.ParseFees(os.Getenv("EXDATA_ARB_FEES"))
+		if err != nil {
+			log.Fatalln("arbitrage fees", err)
+		}
+		detector.Fees = fees
+		if s := os.Getenv("EXDATA_ARB_MIN_EDGE"); s != "" {
+			if detector.MinEdge, err = decimal.NewFromString(s); err != nil {
+				log.Fatalln("arbitrage min edge", err)
+			}
+		}
+		exSinks = append(exSinks, detector)
+	}
+
+	// the alert rules of the database against the records, delivered by webhook and by the mail server of EXDATA_SMTP_ADDR.
+	// The webhooks to a private address are refused but those to the hosts of EXDATA_ALERT_WEBHOOK_HOSTS.
+	var alerts *alert.Engine
+	if os.Getenv("EXDATA_ALERTS") != "" {
+		alerts = alert.NewEngine(ds, 0)
+		var hosts []string
+		if s := os.Getenv("EXDATA_ALERT_WEBHOOK_HOSTS"); s != "" {
+			hosts = strings.Split(s, ",")
+		}
+		alerts.Senders[alert.ChannelWebhook] = alert.NewWebhook(10*time.Second, hosts...)
+		if addr := os.Getenv("EXDATA_SMTP_ADDR"); addr != "" {
+			mailer, err := alert.NewSMTP(addr, os.Getenv("EXDATA_SMTP_FROM"), os.Getenv("EXDATA_SMTP_USER"), os.Getenv("EXDATA_SMTP_PASSWORD"))
+			if err != nil {
+				log.Fatalln("alert smtp", err)
+			}
+			alerts.Senders[alert.ChannelEmail] = mailer
+		}
+		exSinks = append(exSinks, alerts)
+	}
+
+	// the records of the exchangers are validated before all the consumers, EXDATA_VALIDATE sets the actions of the rules
+	var validator *validate.Validator
+	if conf := os.Getenv("EXDATA_VALIDATE"); conf != "off" {
+		actions, err := validate.ParseActions(conf)
+		if err != nil {
+			log.Fatalln("validation actions", err)
+		}
+		validator = validate.New(sink.NewMulti(exSinks...), ds)
+		validator.Actions = actions
+		exSinks = []sink.Sink{validator}
+	}
+
+	manager := exchanger.NewManager()
 	for k := range exVar {
-		ex, err := NewExchanger(exVar[k].Name)
+		ex, err := NewExchanger(exVar[k].Name, ds, exSinks...)
 		if err != nil {
 			log.Fatalf("cannot initialize exchanger, configuration error, %s", exVar[k].Name)
 		} else {
 			log.Println("Exchanger ", exVar[k].Name, "initialized")
 		}
-		exs = append(exs, ex)
-		ex.Setup()
-		wg.Add(1)
-		go ex.Start(wg)
+		manager.Add(exVar[k].Name, ex)
+		if s, ok := ex.(exchanger.Streamer); ok && os.Getenv("EXDATA_STREAM_MARKETS") != "" {
+			s.StreamMarkets(strings.Split(os.Getenv("EXDATA_STREAM_MARKETS"), ",")...)
+		}
+		if _, err = manager.Start(exVar[k].Name); err != nil {
+			log.Println("Exchanger", exVar[k].Name, "setup failed:", err)
+		}
+	}
+
+	var rpcServer *grpc.Server
+	if grpcAddr != "" { // queries, live streams and control of the exchangers
+		l, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			log.Fatalln("grpc listen failed", err)
+		}
+		// the Admin service is served with a token only, over TLS with the certificate of EXDATA_GRPC_TLS_CERT
+		var opts []grpc.ServerOption
+		if cert := os.Getenv("EXDATA_GRPC_TLS_CERT"); cert != "" {
+			creds, err := credentials.NewServerTLSFromFile(cert, os.Getenv("EXDATA_GRPC_TLS_KEY"))
+			if err != nil {
+				log.Fatalln("grpc tls", err)
+			}
+			opts = append(opts, grpc.Creds(creds))
+		}
+		adminToken := os.Getenv("EXDATA_ADMIN_TOKEN")
+		if adminToken == "" {
+			log.Println("grpc admin service disabled without EXDATA_ADMIN_TOKEN")
+		}
+		rpcServer = grpc.NewServer(opts...)
+		rpc.Register(rpcServer, ds, hub, manager, adminToken)
+		go func() {
+			log.Println("grpc listening on", grpcAddr)
+			if err := rpcServer.Serve(l); err != nil {
+				log.Fatalln("grpc server failed", err)
+			}
+		}()
 	}
 
 	log.Println("All exchangers have been set, waiting for interrupt to terminate exchangedata...")
 	<-interrupt // exit only when the application is interrupted
 	log.Println("intterupted! Exiting...")
-	for _, ex := range exs {
-		ex.Stop()
+	if rpcServer != nil {
+		rpcServer.Stop()
+	}
+	manager.StopAll()
+	if validator != nil {
+		validator.Close()
+	}
+	if candles != nil {
+		if err := candles.Close(); err != nil {
+			log.Println("candle write error:", err)
+		}
+		if n := candles.Dropped(); n > 0 {
+			log.Println("candles dropped", n, "late trades, rebuild them with dbman candles")
+		}
+	}
+	if consolidator != nil {
+		if err := consolidator.Close(); err != nil {
+			log.Println("consolidated write error:", err)
+		}
+	}
+	if detector != nil {
+		if err := detector.Close(); err != nil {
+			log.Println("arbitrage write error:", err)
+		}
+	}
+	if analyzer != nil {
+		if err := analyzer.Close(); err != nil {
+			log.Println("book metrics write error:", err)
+		}
+	}
+	if alerts != nil {
+		alerts.Close()
+	}
+	events.Close()
+	fwg.Wait()
+	for _, sub := range subs {
+		if n := sub.Dropped(); n > 0 {
+			log.Println("consumer", sub.Name, "dropped", n, "of", sub.Delivered()+n, "events")
+		}
 	}
-	wg.Wait()
 	return
 }
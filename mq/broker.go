@@ -0,0 +1,110 @@
+package mq
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Broker is a message bus the events are published to.
+// A subscription pattern may use the NATS wildcards: * for one token and > for the remaining ones,
+// a broker without wildcard support returns an error for them.
+type Broker interface {
+	Publish(subject string, data []byte) error
+	Subscribe(pattern string, f func(subject string, data []byte)) (Subscription, error)
+	Close() error
+}
+
+type Subscription interface {
+	Unsubscribe() error
+}
+
+// ErrClosed is returned by a closed broker
+var ErrClosed = errors.New("broker closed")
+
+// Local is an in-process Broker, mostly for tests.
+// A message is handed to the subscribers before Publish returns, in the order they subscribed.
+type Local struct {
+	mu     sync.RWMutex
+	subs   []*localSub
+	closed bool
+}
+
+func NewLocal() *Local {
+	return &Local{}
+}
+
+type localSub struct {
+	l       *Local
+	pattern []string
+	f       func(subject string, data []byte)
+}
+
+func (l *Local) Publish(subject string, data []byte) error {
+	l.mu.RLock()
+	if l.closed {
+		l.mu.RUnlock()
+		return ErrClosed
+	}
+	subs := make([]*localSub, 0, len(l.subs))
+	tokens := splitSubject(subject)
+	for _, s := range l.subs {
+		if matchSubject(s.pattern, tokens) {
+			subs = append(subs, s)
+		}
+	}
+	l.mu.RUnlock()
+
+	for _, s := range subs {
+		s.f(subject, data)
+	}
+	return nil
+}
+
+func (l *Local) Subscribe(pattern string, f func(subject string, data []byte)) (Subscription, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, ErrClosed
+	}
+	s := &localSub{l: l, pattern: splitSubject(pattern), f: f}
+	l.subs = append(l.subs, s)
+	return s, nil
+}
+
+func (l *Local) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	l.subs = nil
+	l.mu.Unlock()
+	return nil
+}
+
+func (s *localSub) Unsubscribe() error {
+	s.l.mu.Lock()
+	defer s.l.mu.Unlock()
+	for k, t := range s.l.subs {
+		if t == s {
+			s.l.subs = append(s.l.subs[:k:k], s.l.subs[k+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func splitSubject(s string) []string {
+	return strings.Split(s, ".")
+}
+
+// matchSubject tells if the subject tokens match the pattern tokens
+func matchSubject(pattern, tokens []string) bool {
+	for k, p := range pattern {
+		if p == ">" {
+			return len(tokens) > k
+		}
+		if k >= len(tokens) || (p != "*" && p != tokens[k]) {
+			return false
+		}
+	}
+	return len(pattern) == len(tokens)
+}
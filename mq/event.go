@@ -0,0 +1,276 @@
+// Package mq publishes the normalized market data to a message bus, NATS, Kafka or an in-process broker.
+//
+// An event is published to the subject md.<exchanger>.<market>.<type>, type is one of the Type constants.
+// The payload is an Envelope encoded in JSON, its V is the version of the encoding.
+package mq
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/shopspring/decimal"
+)
+
+// Version is the version of the Envelope encoding, it changes when a field changes meaning or is removed
+const Version = 1
+
+// event types, the last token of the subject
+const (
+	TypeTicker = "ticker"
+	TypeTrade  = "trade"
+	TypeBook   = "book"  // full order book
+	TypeDelta  = "delta" // order book changes after a book
+	TypeCandle = "candle"
+	TypeBBO    = "bbo" // consolidated ticker of a symbol
+	TypeArb    = "opportunity"
+	TypeInd    = "indicator" // technical indicator of the candles of an interval
+	TypeDepth  = "depth"     // order book metrics
+)
+
+// Consolidated is the exchanger of the consolidated tickers, their market is the symbol, like BTC_USDT
+const Consolidated = "consolidated"
+
+// Arbitrage is the exchanger of the arbitrage opportunities, their market is the kind, cross or triangular
+const Arbitrage = "arbitrage"
+
+// Envelope is the message of an event. Seq increases by one for each event of a subject from a publisher,
+// a consumer missing a number has missed an event.
+type Envelope struct {
+	V         int             `json:"v"`
+	Type      string          `json:"type"`
+	Exchanger string          `json:"exchanger"`
+	Market    string          `json:"market"`
+	Time      time.Time       `json:"time"`
+	Seq       uint64          `json:"seq"`
+	Data      json.RawMessage `json:"data"` // one of the *Data types by Type
+}
+
+// Decode decodes the Data of e into v
+func (e *Envelope) Decode(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// Level is a price level, [price, volume]
+type Level [2]decimal.Decimal
+
+type TickerData struct {
+	Last          decimal.Decimal `json:"last"`
+	Bid           decimal.Decimal `json:"bid"`
+	BidVolume     decimal.Decimal `json:"bid_volume"`
+	Ask           decimal.Decimal `json:"ask"`
+	AskVolume     decimal.Decimal `json:"ask_volume"`
+	High          decimal.Decimal `json:"high"`
+	Low           decimal.Decimal `json:"low"`
+	Open          decimal.Decimal `json:"open"`
+	Close         decimal.Decimal `json:"close"`
+	PreviousClose decimal.Decimal `json:"previous_close"`
+	BaseVolume    decimal.Decimal `json:"base_volume"`
+	QuoteVolume   decimal.Decimal `json:"quote_volume"`
+}
+
+type TradeData struct {
+	OrderID string          `json:"order_id"`
+	Type    string          `json:"type"`
+	Side    string          `json:"side"`
+	Price   decimal.Decimal `json:"price"`
+	Amount  decimal.Decimal `json:"amount"`
+	Total   decimal.Decimal `json:"total"`
+}
+
+// BookData is a full order book, the levels from the best price
+type BookData struct {
+	Sequence uint64  `json:"sequence"`
+	Bids     []Level `json:"bids"`
+	Asks     []Level `json:"asks"`
+}
+
+// DeltaData are changed levels of an order book, a zero volume removes the level
+type DeltaData struct {
+	Sequence uint64  `json:"sequence"` // of the last change
+	Bids     []Level `json:"bids"`
+	Asks     []Level `json:"asks"`
+}
+
+type CandleData struct {
+	Interval    string          `json:"interval"`
+	Open        decimal.Decimal `json:"open"`
+	High        decimal.Decimal `json:"high"`
+	Low         decimal.Decimal `json:"low"`
+	Close       decimal.Decimal `json:"close"`
+	Volume      decimal.Decimal `json:"volume"`
+	QuoteVolume decimal.Decimal `json:"quote_volume"`
+	Trades      uint            `json:"trades"`
+}
+
+// ConsolidatedData is the best bid and ask of a symbol across the exchangers, and the volume weighted price
+type ConsolidatedData struct {
+	Bid          decimal.Decimal `json:"bid"`
+	BidVolume    decimal.Decimal `json:"bid_volume"`
+	BidExchanger string          `json:"bid_exchanger"`
+	Ask          decimal.Decimal `json:"ask"`
+	AskVolume    decimal.Decimal `json:"ask_volume"`
+	AskExchanger string          `json:"ask_exchanger"`
+	VWAP         decimal.Decimal `json:"vwap"`
+	Volume       decimal.Decimal `json:"volume"`
+	Venues       []*VenueData    `json:"venues"`
+}
+
+type VenueData struct {
+	Exchanger string          `json:"exchanger"`
+	Time      time.Time       `json:"time"`
+	Bid       decimal.Decimal `json:"bid"`
+	BidVolume decimal.Decimal `json:"bid_volume"`
+	Ask       decimal.Decimal `json:"ask"`
+	AskVolume decimal.Decimal `json:"ask_volume"`
+	Spread    decimal.Decimal `json:"spread"`
+	Last      decimal.Decimal `json:"last"`
+	Volume    decimal.Decimal `json:"volume"`
+}
+
+// OpportunityData is an arbitrage opportunity, the legs in order
+type OpportunityData struct {
+	Currency string          `json:"currency"`
+	Start    decimal.Decimal `json:"start"`
+	Profit   decimal.Decimal `json:"profit"`
+	Edge     decimal.Decimal `json:"edge"`
+	Legs     []*LegData      `json:"legs"`
+}
+
+type LegData struct {
+	Exchanger string          `json:"exchanger"`
+	Market    string          `json:"market"`
+	Side      string          `json:"side"`
+	Price     decimal.Decimal `json:"price"`
+	Amount    decimal.Decimal `json:"amount"`
+	Fee       decimal.Decimal `json:"fee"`
+}
+
+// IndicatorData is the value of a technical indicator after the candle of the envelope time
+type IndicatorData struct {
+	Interval  string             `json:"interval"`
+	Indicator string             `json:"indicator"`
+	Values    map[string]float64 `json:"values"` // by field
+}
+
+// DepthData is the metrics of an order book, the slippages are null when the book is not deep enough
+type DepthData struct {
+	Mid          decimal.Decimal  `json:"mid"`
+	Spread       decimal.Decimal  `json:"spread"`
+	Microprice   decimal.Decimal  `json:"microprice"`
+	Band         decimal.Decimal  `json:"band"`
+	BidDepth     decimal.Decimal  `json:"bid_depth"`
+	AskDepth     decimal.Decimal  `json:"ask_depth"`
+	Imbalance    decimal.Decimal  `json:"imbalance"`
+	Notional     decimal.Decimal  `json:"notional"`
+	BuySlippage  *decimal.Decimal `json:"buy_slippage"`
+	SellSlippage *decimal.Decimal `json:"sell_slippage"`
+}
+
+func NewTickerData(t *common.Ticker) *TickerData {
+	return &TickerData{Last: t.Last, Bid: t.Bid, BidVolume: t.BidVolume, Ask: t.Ask, AskVolume: t.AskVolume,
+		High: t.High, Low: t.Low, Open: t.Open, Close: t.Close, PreviousClose: t.PreviousClose,
+		BaseVolume: t.BaseVolume, QuoteVolume: t.QuoteVolume}
+}
+
+func NewTradeData(t *common.Trade) *TradeData {
+	return &TradeData{OrderID: t.OrderID, Type: t.Type, Side: t.Side, Price: t.Price, Amount: t.Amount, Total: t.Total}
+}
+
+func NewBookData(b *common.OrderBook) *BookData {
+	return &BookData{Sequence: b.Sequence, Bids: levels(b.Bids), Asks: levels(b.Asks)}
+}
+
+// NewDeltaData groups the changes c of an order book by side, Sequence is the largest of c
+func NewDeltaData(c []*common.BookDelta) *DeltaData {
+	d := &DeltaData{Bids: []Level{}, Asks: []Level{}}
+	for _, l := range c {
+		if l.Side == common.BookBid {
+			d.Bids = append(d.Bids, Level{l.Price, l.Volume})
+		} else {
+			d.Asks = append(d.Asks, Level{l.Price, l.Volume})
+		}
+		if l.Sequence > d.Sequence {
+			d.Sequence = l.Sequence
+		}
+	}
+	return d
+}
+
+func NewCandleData(c *common.Candle) *CandleData {
+	return &CandleData{Interval: c.Interval, Open: c.Open, High: c.High, Low: c.Low, Close: c.Close,
+		Volume: c.Volume, QuoteVolume: c.QuoteVolume, Trades: c.Trades}
+}
+
+func NewConsolidatedData(c *common.ConsolidatedTicker) *ConsolidatedData {
+	d := &ConsolidatedData{Bid: c.Bid, BidVolume: c.BidVolume, BidExchanger: c.BidExchanger,
+		Ask: c.Ask, AskVolume: c.AskVolume, AskExchanger: c.AskExchanger, VWAP: c.VWAP, Volume: c.Volume,
+		Venues: make([]*VenueData, len(c.Venues))}
+	for k, v := range c.Venues {
+		d.Venues[k] = &VenueData{Exchanger: v.Exchanger, Time: v.Time.UTC(), Bid: v.Bid, BidVolume: v.BidVolume,
+			Ask: v.Ask, AskVolume: v.AskVolume, Spread: v.Spread, Last: v.Last, Volume: v.Volume}
+	}
+	return d
+}
+
+func NewOpportunityData(o *common.Opportunity) *OpportunityData {
+	d := &OpportunityData{Currency: o.Currency, Start: o.Start, Profit: o.Profit, Edge: o.Edge, Legs: make([]*LegData, len(o.Legs))}
+	for k, l := range o.Legs {
+		ex, market := common.MarketNames(0, l.Market)
+		d.Legs[k] = &LegData{Exchanger: ex, Market: market, Side: l.Side, Price: l.Price, Amount: l.Amount, Fee: l.Fee}
+	}
+	return d
+}
+
+func NewIndicatorData(v *common.IndicatorValue) *IndicatorData {
+	d := &IndicatorData{Interval: v.Interval, Indicator: v.Indicator, Values: make(map[string]float64, len(v.Fields))}
+	for k, f := range v.Fields {
+		d.Values[f] = v.Values[k]
+	}
+	return d
+}
+
+func NewDepthData(m *common.BookMetrics) *DepthData {
+	d := &DepthData{Mid: m.Mid, Spread: m.Spread, Microprice: m.Microprice, Band: m.Band, BidDepth: m.BidDepth,
+		AskDepth: m.AskDepth, Imbalance: m.Imbalance, Notional: m.Notional}
+	if m.BuySlippage.Valid {
+		d.BuySlippage = &m.BuySlippage.Decimal
+	}
+	if m.SellSlippage.Valid {
+		d.SellSlippage = &m.SellSlippage.Decimal
+	}
+	return d
+}
+
+// SymbolName returns the name of the symbol of a consolidated ticker, like BTC_USDT
+func SymbolName(ref uint, s *common.Symbol) string {
+	if s == nil || s.Base == nil || s.Quote == nil {
+		return "symbol-" + strconv.FormatUint(uint64(ref), 10)
+	}
+	return s.String()
+}
+
+func levels(c []*common.PriceVol) []Level {
+	l := make([]Level, len(c))
+	for k, p := range c {
+		l[k] = Level{p.Price, p.Volume}
+	}
+	return l
+}
+
+// Subject returns the subject of the events of type typ of a market.
+// The characters with a meaning in NATS subjects are replaced in the names.
+func Subject(exchanger, market, typ string) string {
+	return "md." + subjectToken(exchanger) + "." + subjectToken(market) + "." + typ
+}
+
+var tokenReplacer = strings.NewReplacer(".", "_", " ", "_", "*", "_", ">", "_")
+
+func subjectToken(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return tokenReplacer.Replace(s)
+}
@@ -0,0 +1,116 @@
+// Package kafkabroker is the Kafka mq.Broker, a subject is a topic
+package kafkabroker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/exchangedata/mq"
+	"github.com/segmentio/kafka-go"
+)
+
+// Broker publishes to the topics named by the subjects, the topics are created by the first message.
+// The messages are written asynchronously in batches, the write errors are logged.
+// Kafka has no wildcard subscription, a subscription is of one topic with the consumer group GroupID.
+type Broker struct {
+	GroupID string // consumer group of the subscriptions, set before subscribing
+
+	brokers []string
+	w       *kafka.Writer
+
+	mu      sync.Mutex
+	readers map[*subscription]struct{}
+}
+
+// New creates a Broker on the Kafka brokers, host:port
+func New(brokers ...string) *Broker {
+	return &Broker{
+		GroupID: "exdata",
+		brokers: brokers,
+		w: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Balancer:               &kafka.Hash{}, // the events of a market stay in one partition
+			AllowAutoTopicCreation: true,
+			BatchTimeout:           10 * time.Millisecond,
+			Async:                  true,
+			Completion: func(messages []kafka.Message, err error) {
+				if err != nil {
+					log.Println("kafka publish of", len(messages), "messages failed:", err)
+				}
+			},
+		},
+		readers: make(map[*subscription]struct{}),
+	}
+}
+
+var _ mq.Broker = (*Broker)(nil)
+
+// Publish queues data to the topic subject, keyed by the subject to keep the order of its events
+func (b *Broker) Publish(subject string, data []byte) error {
+	return b.w.WriteMessages(context.Background(), kafka.Message{Topic: subject, Key: []byte(subject), Value: data})
+}
+
+type subscription struct {
+	b      *Broker
+	r      *kafka.Reader
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (b *Broker) Subscribe(pattern string, f func(subject string, data []byte)) (mq.Subscription, error) {
+	if strings.ContainsAny(pattern, "*>") {
+		return nil, errors.New("kafka subscriptions have no wildcards")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &subscription{
+		b:      b,
+		r:      kafka.NewReader(kafka.ReaderConfig{Brokers: b.brokers, GroupID: b.GroupID, Topic: pattern}),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	b.mu.Lock()
+	b.readers[s] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		defer close(s.done)
+		for {
+			m, err := s.r.ReadMessage(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Println("kafka read of", pattern, "stopped:", err)
+				}
+				return
+			}
+			f(m.Topic, m.Value)
+		}
+	}()
+	return s, nil
+}
+
+func (s *subscription) Unsubscribe() error {
+	s.b.mu.Lock()
+	delete(s.b.readers, s)
+	s.b.mu.Unlock()
+	s.cancel()
+	<-s.done
+	return s.r.Close()
+}
+
+// Close stops the subscriptions and writes the queued messages
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	subs := make([]*subscription, 0, len(b.readers))
+	for s := range b.readers {
+		subs = append(subs, s)
+	}
+	b.mu.Unlock()
+	for _, s := range subs {
+		s.Unsubscribe()
+	}
+	return b.w.Close()
+}
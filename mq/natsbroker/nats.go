@@ -0,0 +1,38 @@
+// Package natsbroker is the NATS mq.Broker
+package natsbroker
+
+import (
+	"github.com/exchangedata/mq"
+	"github.com/nats-io/nats.go"
+)
+
+// Broker publishes to a NATS server, the subjects and wildcards are the NATS ones
+type Broker struct {
+	nc *nats.Conn
+}
+
+// Connect connects to the NATS servers of url, ex: nats://localhost:4222
+func Connect(url string, options ...nats.Option) (*Broker, error) {
+	nc, err := nats.Connect(url, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &Broker{nc: nc}, nil
+}
+
+var _ mq.Broker = (*Broker)(nil)
+
+func (b *Broker) Publish(subject string, data []byte) error {
+	return b.nc.Publish(subject, data)
+}
+
+func (b *Broker) Subscribe(pattern string, f func(subject string, data []byte)) (mq.Subscription, error) {
+	return b.nc.Subscribe(pattern, func(m *nats.Msg) {
+		f(m.Subject, m.Data)
+	})
+}
+
+// Close sends the buffered messages and closes the connection
+func (b *Broker) Close() error {
+	return b.nc.Drain()
+}
@@ -0,0 +1,138 @@
+package mq
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/exchangedata/common"
+)
+
+// Publisher is a sink.Sink publishing the records as events to a Broker.
+// It owns the broker, Close closes it.
+type Publisher struct {
+	b Broker
+
+	mu  sync.Mutex
+	seq map[string]uint64 // last sequence by subject
+}
+
+func NewPublisher(b Broker) *Publisher {
+	return &Publisher{b: b, seq: make(map[string]uint64)}
+}
+
+func (p *Publisher) WriteTickers(c []*common.Ticker) (err error) {
+	for _, t := range c {
+		ex, market := common.MarketNames(t.MarketRef, t.Market)
+		keep(&err, p.publish(ex, market, TypeTicker, t.Time, NewTickerData(t)))
+	}
+	return
+}
+
+func (p *Publisher) WriteTrades(c []*common.Trade) (err error) {
+	for _, t := range c {
+		ex, market := common.MarketNames(t.MarketRef, t.Market)
+		keep(&err, p.publish(ex, market, TypeTrade, t.Time, NewTradeData(t)))
+	}
+	return
+}
+
+func (p *Publisher) WriteOrderBooks(c []*common.OrderBook) (err error) {
+	for _, b := range c {
+		ex, market := common.MarketNames(b.MarketRef, b.Market)
+		keep(&err, p.publish(ex, market, TypeBook, b.Time, NewBookData(b)))
+	}
+	return
+}
+
+// WriteBookDeltas publishes the changes c of the order book of m as one delta event, see sink.DeltaWriter
+func (p *Publisher) WriteBookDeltas(m *common.Market, c []*common.BookDelta) error {
+	if len(c) == 0 {
+		return nil
+	}
+	d := NewDeltaData(c)
+	last := c[0]
+	for _, l := range c {
+		if l.Sequence >= last.Sequence {
+			last = l
+		}
+	}
+	ex, market := common.MarketNames(last.MarketRef, m)
+	return p.publish(ex, market, TypeDelta, last.Time, d)
+}
+
+func (p *Publisher) WriteCandles(c []*common.Candle) (err error) {
+	for _, b := range c {
+		ex, market := common.MarketNames(b.MarketRef, b.Market)
+		keep(&err, p.publish(ex, market, TypeCandle, b.Time, NewCandleData(b)))
+	}
+	return
+}
+
+// WriteConsolidated publishes the consolidated tickers c on the subjects md.consolidated.<symbol>.bbo,
+// see sink.ConsolidatedWriter
+func (p *Publisher) WriteConsolidated(c []*common.ConsolidatedTicker) (err error) {
+	for _, t := range c {
+		keep(&err, p.publish(Consolidated, SymbolName(t.SymRef, t.Symbol), TypeBBO, t.Time, NewConsolidatedData(t)))
+	}
+	return
+}
+
+// WriteOpportunities publishes the arbitrage opportunities c on the subjects md.arbitrage.<kind>.opportunity,
+// see sink.OpportunityWriter
+func (p *Publisher) WriteOpportunities(c []*common.Opportunity) (err error) {
+	for _, o := range c {
+		keep(&err, p.publish(Arbitrage, o.Kind, TypeArb, o.Time, NewOpportunityData(o)))
+	}
+	return
+}
+
+// WriteIndicators publishes the indicator values c on the subjects of their market, see sink.IndicatorWriter
+func (p *Publisher) WriteIndicators(c []*common.IndicatorValue) (err error) {
+	for _, v := range c {
+		ex, market := common.MarketNames(0, v.Market)
+		keep(&err, p.publish(ex, market, TypeInd, v.Time, NewIndicatorData(v)))
+	}
+	return
+}
+
+// WriteBookMetrics publishes the order book metrics c, see sink.BookMetricsWriter
+func (p *Publisher) WriteBookMetrics(c []*common.BookMetrics) (err error) {
+	for _, m := range c {
+		ex, market := common.MarketNames(m.MarketRef, m.Market)
+		keep(&err, p.publish(ex, market, TypeDepth, m.Time, NewDepthData(m)))
+	}
+	return
+}
+
+func (p *Publisher) Close() error {
+	return p.b.Close()
+}
+
+func (p *Publisher) publish(ex, market, typ string, t time.Time, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	subject := Subject(ex, market, typ)
+
+	// the lock keeps the sequence order on the bus
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	seq := p.seq[subject] + 1
+	msg, err := json.Marshal(&Envelope{V: Version, Type: typ, Exchanger: ex, Market: market, Time: t.UTC(), Seq: seq, Data: raw})
+	if err != nil {
+		return err
+	}
+	if err = p.b.Publish(subject, msg); err != nil {
+		return err
+	}
+	p.seq[subject] = seq
+	return nil
+}
+
+func keep(err *error, e error) {
+	if e != nil && *err == nil {
+		*err = e
+	}
+}
@@ -0,0 +1,93 @@
+package mq
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/shopspring/decimal"
+)
+
+var dec = decimal.RequireFromString
+
+func TestMatchSubject(t *testing.T) {
+	for _, c := range []struct {
+		pattern, subject string
+		want             bool
+	}{
+		{"md.bittrex.BTC-LTC.trade", "md.bittrex.BTC-LTC.trade", true},
+		{"md.bittrex.*.trade", "md.bittrex.BTC-LTC.trade", true},
+		{"md.bittrex.*.trade", "md.bittrex.BTC-LTC.book", false},
+		{"md.>", "md.bittrex.BTC-LTC.book", true},
+		{"md.bittrex.>", "md.bittrex", false},
+		{"md.*", "md.bittrex.BTC-LTC.book", false},
+	} {
+		if got := matchSubject(splitSubject(c.pattern), splitSubject(c.subject)); got != c.want {
+			t.Errorf("%s matching %s: %v", c.pattern, c.subject, got)
+		}
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	l := NewLocal()
+	envs := []*Envelope{}
+	subjects := []string{}
+	sub, err := l.Subscribe("md.bittrex.>", func(subject string, data []byte) {
+		e := &Envelope{}
+		if err := json.Unmarshal(data, e); err != nil {
+			t.Fatal("bad envelope", err)
+		}
+		subjects = append(subjects, subject)
+		envs = append(envs, e)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &common.Market{ID: 3, Name: "BTC-LTC", Exchanger: &common.Exchanger{Name: "bittrex"}}
+	other := &common.Market{Name: "BTC-LTC", Exchanger: &common.Exchanger{Name: "poloniex"}}
+	at := time.Date(2018, 11, 22, 3, 4, 5, 0, time.UTC)
+	p := NewPublisher(l)
+	p.WriteTrades([]*common.Trade{
+		{Time: at, Market: m, OrderID: "1", Price: dec("0.0123"), Amount: dec("2")},
+		{Time: at, Market: other, OrderID: "2"},
+		{Time: at, Market: m, OrderID: "3", Price: dec("0.0124"), Amount: dec("1")},
+	})
+	p.WriteOrderBooks([]*common.OrderBook{{Time: at, Market: m,
+		Bids: []*common.PriceVol{{Price: dec("0.0122"), Volume: dec("5")}}}})
+	p.WriteBookDeltas(m, []*common.BookDelta{
+		{Time: at, MarketRef: m.ID, Sequence: 1, Side: common.BookBid, Price: dec("0.0122"), Volume: decimal.Zero},
+		{Time: at, MarketRef: m.ID, Sequence: 2, Side: common.BookAsk, Price: dec("0.0125"), Volume: dec("4")},
+	})
+
+	if len(envs) != 4 {
+		t.Fatalf("%d events received, 4 expected", len(envs))
+	}
+	if subjects[0] != "md.bittrex.BTC-LTC.trade" || subjects[2] != "md.bittrex.BTC-LTC.book" || subjects[3] != "md.bittrex.BTC-LTC.delta" {
+		t.Fatal("wrong subjects", subjects)
+	}
+	if envs[0].V != Version || envs[0].Seq != 1 || envs[1].Seq != 2 || envs[2].Seq != 1 {
+		t.Fatalf("wrong version or sequences, %+v", envs[:3])
+	}
+	trade := &TradeData{}
+	if err = envs[1].Decode(trade); err != nil || trade.OrderID != "3" || !trade.Price.Equal(dec("0.0124")) {
+		t.Fatal("trade not decoded", trade, err)
+	}
+	delta := &DeltaData{}
+	if err = envs[3].Decode(delta); err != nil || delta.Sequence != 2 || len(delta.Bids) != 1 || !delta.Bids[0][1].IsZero() || len(delta.Asks) != 1 {
+		t.Fatal("delta not decoded", delta, err)
+	}
+
+	sub.Unsubscribe()
+	p.WriteTrades([]*common.Trade{{Time: at, Market: m, OrderID: "4"}})
+	if len(envs) != 4 {
+		t.Fatal("event received after unsubscribe")
+	}
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if p.WriteTrades([]*common.Trade{{Time: at, Market: m}}) != ErrClosed {
+		t.Fatal("publish to a closed broker should fail")
+	}
+}
@@ -0,0 +1,116 @@
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"github.com/exchangedata/exchanger"
+	"github.com/exchangedata/rpc/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Admin is the Admin service of the exchangers of a Manager. The calls need its token in the metadata
+// as "authorization: Bearer <token>", they fail with Unauthenticated otherwise.
+type Admin struct {
+	pb.UnimplementedAdminServer
+
+	m     *exchanger.Manager
+	token string
+}
+
+// NewAdmin returns the Admin service of m for the clients of token, all the calls are refused if it is empty
+func NewAdmin(m *exchanger.Manager, token string) *Admin {
+	return &Admin{m: m, token: token}
+}
+
+// authorize checks the token of the call
+func (a *Admin) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if a.token != "" && strings.HasPrefix(auth, "Bearer ") &&
+			subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(a.token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "bad admin token")
+}
+
+func (a *Admin) ListExchangers(ctx context.Context, req *pb.ListExchangersRequest) (*pb.ListExchangerStatusResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	list := a.m.List()
+	r := &pb.ListExchangerStatusResponse{Exchangers: make([]*pb.ExchangerStatus, len(list))}
+	for k, s := range list {
+		r.Exchangers[k] = newStatus(s)
+	}
+	return r, nil
+}
+
+// StartExchanger fails with FailedPrecondition when the setup of the exchanger fails
+func (a *Admin) StartExchanger(ctx context.Context, req *pb.ExchangerRequest) (*pb.ExchangerStatus, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if a.m.Get(req.Name) == nil {
+		return nil, status.Error(codes.NotFound, "unknown exchanger "+req.Name)
+	}
+	s, err := a.m.Start(req.Name)
+	if err != nil && s.Status == exchanger.StatusStopping {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, "setup failed: "+err.Error())
+	}
+	return newStatus(s), nil
+}
+
+func (a *Admin) StopExchanger(ctx context.Context, req *pb.ExchangerRequest) (*pb.ExchangerStatus, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	s, err := a.m.Stop(req.Name)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return newStatus(s), nil
+}
+
+func (a *Admin) AddMarket(ctx context.Context, req *pb.MarketSubscriptionRequest) (*pb.ExchangerStatus, error) {
+	return a.subscription(ctx, req, exchanger.Streamer.AddStream)
+}
+
+func (a *Admin) RemoveMarket(ctx context.Context, req *pb.MarketSubscriptionRequest) (*pb.ExchangerStatus, error) {
+	return a.subscription(ctx, req, exchanger.Streamer.RemoveStream)
+}
+
+// subscription changes the streamed markets of the exchanger of req with change
+func (a *Admin) subscription(ctx context.Context, req *pb.MarketSubscriptionRequest, change func(exchanger.Streamer, string) error) (*pb.ExchangerStatus, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	ex := a.m.Get(req.Exchanger)
+	if ex == nil {
+		return nil, status.Error(codes.NotFound, "unknown exchanger "+req.Exchanger)
+	}
+	st, ok := ex.(exchanger.Streamer)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "exchanger "+req.Exchanger+" does not stream markets")
+	}
+	if err := change(st, req.Market); err != nil {
+		// the markets are known once the exchanger is set up
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	s, err := a.m.Status(req.Exchanger)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return newStatus(s), nil
+}
+
+func newStatus(s exchanger.Status) *pb.ExchangerStatus {
+	return &pb.ExchangerStatus{Name: s.Name, Status: s.Status, Error: s.Error, Streams: s.Streams}
+}
@@ -0,0 +1,67 @@
+package rpc
+
+import (
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/mq"
+	"github.com/exchangedata/rpc/pb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// the messages of the records and of the live envelopes, the decimals are strings
+
+func newMarket(m *common.Market) *pb.Market {
+	ex, name := marketNames(m)
+	v := &pb.Market{Id: uint32(m.ID), Exchanger: ex, Name: name, Active: m.Active, Precision: uint32(m.Precision),
+		MinAmount: m.Limitation.Min.String(), MaxAmount: m.Limitation.Max.String(), MinStep: m.MinStep.String()}
+	if m.Symbol != nil && m.Symbol.Base != nil && m.Symbol.Quote != nil {
+		v.Base, v.Quote = m.Symbol.Base.Abbr, m.Symbol.Quote.Abbr
+	}
+	return v
+}
+
+func newTicker(ex, market string, t time.Time, d *mq.TickerData) *pb.Ticker {
+	return &pb.Ticker{Time: timestamppb.New(t), Exchanger: ex, Market: market,
+		Last: d.Last.String(), Bid: d.Bid.String(), BidVolume: d.BidVolume.String(), Ask: d.Ask.String(), AskVolume: d.AskVolume.String(),
+		High: d.High.String(), Low: d.Low.String(), Open: d.Open.String(), Close: d.Close.String(), PreviousClose: d.PreviousClose.String(),
+		BaseVolume: d.BaseVolume.String(), QuoteVolume: d.QuoteVolume.String()}
+}
+
+func newTrade(ex, market string, t time.Time, d *mq.TradeData) *pb.Trade {
+	return &pb.Trade{Time: timestamppb.New(t), Exchanger: ex, Market: market, OrderId: d.OrderID,
+		Type: d.Type, Side: d.Side, Price: d.Price.String(), Amount: d.Amount.String(), Total: d.Total.String()}
+}
+
+func newCandle(ex, market string, t time.Time, d *mq.CandleData) *pb.Candle {
+	return &pb.Candle{Time: timestamppb.New(t), Exchanger: ex, Market: market, Interval: d.Interval,
+		Open: d.Open.String(), High: d.High.String(), Low: d.Low.String(), Close: d.Close.String(),
+		Volume: d.Volume.String(), QuoteVolume: d.QuoteVolume.String(), Trades: uint32(d.Trades)}
+}
+
+// newOrderBook is a book or delta, depth levels a side if depth is positive
+func newOrderBook(ex, market string, t time.Time, kind pb.OrderBook_Kind, seq uint64, bids, asks []mq.Level, depth int) *pb.OrderBook {
+	return &pb.OrderBook{Time: timestamppb.New(t), Exchanger: ex, Market: market, Kind: kind, Sequence: seq,
+		Bids: levels(bids, depth), Asks: levels(asks, depth)}
+}
+
+func levels(c []mq.Level, depth int) []*pb.Level {
+	if depth > 0 && len(c) > depth {
+		c = c[:depth]
+	}
+	l := make([]*pb.Level, len(c))
+	for k, p := range c {
+		l[k] = &pb.Level{Price: p[0].String(), Volume: p[1].String()}
+	}
+	return l
+}
+
+func marketNames(m *common.Market) (ex, market string) {
+	if m == nil {
+		return
+	}
+	if m.Exchanger != nil {
+		ex = m.Exchanger.Name
+	}
+	return ex, m.Name
+}
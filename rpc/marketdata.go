@@ -0,0 +1,307 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/exchangedata/candle"
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/database"
+	"github.com/exchangedata/mq"
+	"github.com/exchangedata/rpc/pb"
+	"github.com/exchangedata/stream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MaxLimit is the largest page size a client may ask for
+const MaxLimit = database.DefaultQueryLimit
+
+// DefaultRange is the time range of the queries without from
+const DefaultRange = 24 * time.Hour
+
+// MarketData is the MarketData service of the data of ds, the live updates come from hub
+type MarketData struct {
+	pb.UnimplementedMarketDataServer
+
+	ds  *database.DataStore
+	hub *stream.Hub
+}
+
+// NewMarketData returns the service, the streams fail with Unavailable if hub is nil
+func NewMarketData(ds *database.DataStore, hub *stream.Hub) *MarketData {
+	return &MarketData{ds: ds, hub: hub}
+}
+
+func (s *MarketData) ListExchangers(ctx context.Context, req *pb.ListExchangersRequest) (*pb.ListExchangersResponse, error) {
+	c, err := s.ds.Exchangers()
+	if err != nil {
+		return nil, queryError(err)
+	}
+	r := &pb.ListExchangersResponse{Exchangers: make([]*pb.Exchanger, len(c))}
+	for k, e := range c {
+		r.Exchangers[k] = &pb.Exchanger{Id: uint32(e.ID), Name: e.Name, Info: e.Info}
+	}
+	return r, nil
+}
+
+func (s *MarketData) ListMarkets(ctx context.Context, req *pb.ListMarketsRequest) (*pb.ListMarketsResponse, error) {
+	c, err := s.ds.Markets(database.MarketFilter{Exchanger: req.Exchanger, Currency: req.Currency,
+		Base: req.Base, Quote: req.Quote, Active: req.Active})
+	if err != nil {
+		return nil, queryError(err)
+	}
+	r := &pb.ListMarketsResponse{Markets: make([]*pb.Market, len(c))}
+	for k, m := range c {
+		r.Markets[k] = newMarket(m)
+	}
+	return r, nil
+}
+
+func (s *MarketData) GetLatestTicker(ctx context.Context, req *pb.GetLatestTickerRequest) (*pb.Ticker, error) {
+	m, err := s.market(req.Market)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.ds.LatestTicker(m.ID)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	ex, name := marketNames(m)
+	return newTicker(ex, name, t.Time, mq.NewTickerData(t)), nil
+}
+
+func (s *MarketData) ListTickers(ctx context.Context, req *pb.RangeRequest) (*pb.ListTickersResponse, error) {
+	m, p, err := s.page(req)
+	if err != nil {
+		return nil, err
+	}
+	c, next, err := s.ds.Tickers(m.ID, p.from, p.to, p.after, p.limit)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	ex, name := marketNames(m)
+	r := &pb.ListTickersResponse{Tickers: make([]*pb.Ticker, len(c)), NextCursor: cursor(next)}
+	for k, t := range c {
+		r.Tickers[k] = newTicker(ex, name, t.Time, mq.NewTickerData(t))
+	}
+	return r, nil
+}
+
+func (s *MarketData) ListTrades(ctx context.Context, req *pb.RangeRequest) (*pb.ListTradesResponse, error) {
+	m, p, err := s.page(req)
+	if err != nil {
+		return nil, err
+	}
+	c, next, err := s.ds.Trades(m.ID, p.from, p.to, p.after, p.limit)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	ex, name := marketNames(m)
+	r := &pb.ListTradesResponse{Trades: make([]*pb.Trade, len(c)), NextCursor: cursor(next)}
+	for k, t := range c {
+		r.Trades[k] = newTrade(ex, name, t.Time, mq.NewTradeData(t))
+	}
+	return r, nil
+}
+
+func (s *MarketData) ListCandles(ctx context.Context, req *pb.RangeRequest) (*pb.ListCandlesResponse, error) {
+	interval := req.Interval
+	if interval == "" {
+		interval = "1m"
+	}
+	if _, err := candle.ParseInterval(interval); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "bad interval: "+interval)
+	}
+	m, p, err := s.page(req)
+	if err != nil {
+		return nil, err
+	}
+	c, next, err := s.ds.Candles(m.ID, interval, p.from, p.to, p.after, p.limit)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	ex, name := marketNames(m)
+	r := &pb.ListCandlesResponse{Candles: make([]*pb.Candle, len(c)), NextCursor: cursor(next)}
+	for k, t := range c {
+		r.Candles[k] = newCandle(ex, name, t.Time, mq.NewCandleData(t))
+	}
+	return r, nil
+}
+
+func (s *MarketData) GetOrderBook(ctx context.Context, req *pb.GetOrderBookRequest) (*pb.OrderBook, error) {
+	m, err := s.market(req.Market)
+	if err != nil {
+		return nil, err
+	}
+	at := time.Now()
+	if req.At != nil {
+		at = req.At.AsTime()
+	}
+	ob, err := s.ds.OrderBookAt(m.ID, at)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	ex, name := marketNames(m)
+	d := mq.NewBookData(ob)
+	return newOrderBook(ex, name, ob.Time, pb.OrderBook_SNAPSHOT, ob.Sequence, d.Bids, d.Asks, int(req.Depth)), nil
+}
+
+func (s *MarketData) StreamTickers(req *pb.StreamRequest, srv pb.MarketData_StreamTickersServer) error {
+	return s.stream(req, mq.TypeTicker, srv, func(e *mq.Envelope) error {
+		var d mq.TickerData
+		if err := e.Decode(&d); err != nil {
+			return err
+		}
+		t := newTicker(e.Exchanger, e.Market, e.Time, &d)
+		t.Seq = e.Seq
+		return srv.Send(t)
+	})
+}
+
+func (s *MarketData) StreamTrades(req *pb.StreamRequest, srv pb.MarketData_StreamTradesServer) error {
+	return s.stream(req, mq.TypeTrade, srv, func(e *mq.Envelope) error {
+		var d mq.TradeData
+		if err := e.Decode(&d); err != nil {
+			return err
+		}
+		t := newTrade(e.Exchanger, e.Market, e.Time, &d)
+		t.Seq = e.Seq
+		return srv.Send(t)
+	})
+}
+
+func (s *MarketData) StreamCandles(req *pb.StreamRequest, srv pb.MarketData_StreamCandlesServer) error {
+	return s.stream(req, mq.TypeCandle, srv, func(e *mq.Envelope) error {
+		var d mq.CandleData
+		if err := e.Decode(&d); err != nil {
+			return err
+		}
+		c := newCandle(e.Exchanger, e.Market, e.Time, &d)
+		c.Seq = e.Seq
+		return srv.Send(c)
+	})
+}
+
+func (s *MarketData) StreamOrderBooks(req *pb.StreamRequest, srv pb.MarketData_StreamOrderBooksServer) error {
+	return s.stream(req, mq.TypeBook, srv, func(e *mq.Envelope) error {
+		kind := pb.OrderBook_SNAPSHOT
+		if e.Type == mq.TypeDelta {
+			kind = pb.OrderBook_DELTA
+		}
+		var d mq.BookData // the fields of DeltaData
+		if err := e.Decode(&d); err != nil {
+			return err
+		}
+		return srv.Send(newOrderBook(e.Exchanger, e.Market, e.Time, kind, e.Seq, d.Bids, d.Asks, 0))
+	})
+}
+
+// stream sends the updates of type typ of the markets of req with send, until the client leaves.
+// A client too slow is dropped by the hub, the stream ends with ResourceExhausted.
+func (s *MarketData) stream(req *pb.StreamRequest, typ string, srv grpc.ServerStream, send func(*mq.Envelope) error) error {
+	if s.hub == nil {
+		return status.Error(codes.Unavailable, "no live updates")
+	}
+	ex, market := req.Exchanger, req.Market
+	if ex == "" {
+		ex = "*"
+	}
+	if market == "" {
+		market = "*"
+	}
+	if strings.Contains(ex, ":") || strings.Contains(market, ":") {
+		return status.Error(codes.InvalidArgument, "bad exchanger or market")
+	}
+	c, cancel, err := s.hub.Subscribe(ex + ":" + market + ":" + typ)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer cancel()
+	ctx := srv.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-c:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "updates not read in time")
+			}
+			e := &mq.Envelope{}
+			if err := json.Unmarshal(msg, e); err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// market finds the market of ref, of its id or of its name on its exchanger
+func (s *MarketData) market(ref *pb.MarketRef) (*common.Market, error) {
+	if ref == nil || (ref.Id == 0 && ref.Name == "") {
+		return nil, status.Error(codes.InvalidArgument, "market missing")
+	}
+	f := database.MarketFilter{Name: ref.Name, Exchanger: ref.Exchanger}
+	if ref.Id != 0 {
+		f = database.MarketFilter{ID: uint(ref.Id)}
+	}
+	c, err := s.ds.Markets(f)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	switch {
+	case len(c) == 0:
+		return nil, status.Error(codes.NotFound, "unknown market "+ref.Name)
+	case len(c) > 1:
+		return nil, status.Error(codes.InvalidArgument, "market "+ref.Name+" on several exchangers, set the exchanger")
+	}
+	return c[0], nil
+}
+
+type page struct {
+	from, to time.Time
+	after    *database.Cursor
+	limit    int
+}
+
+// page reads the market, range and page of req
+func (s *MarketData) page(req *pb.RangeRequest) (*common.Market, *page, error) {
+	p := &page{to: time.Now(), limit: MaxLimit}
+	if req.To != nil {
+		p.to = req.To.AsTime()
+	}
+	p.from = p.to.Add(-DefaultRange)
+	if req.From != nil {
+		p.from = req.From.AsTime()
+	}
+	if !p.from.Before(p.to) {
+		return nil, nil, status.Error(codes.InvalidArgument, "from not before to")
+	}
+	if req.Cursor != "" {
+		var err error
+		if p.after, err = database.ParseCursor(req.Cursor); err != nil {
+			return nil, nil, status.Error(codes.InvalidArgument, "bad cursor: "+req.Cursor)
+		}
+	}
+	if req.Limit > MaxLimit {
+		return nil, nil, status.Error(codes.InvalidArgument, "bad limit, 1 to "+strconv.Itoa(MaxLimit))
+	}
+	if req.Limit > 0 {
+		p.limit = int(req.Limit)
+	}
+	m, err := s.market(req.Market)
+	return m, p, err
+}
+
+func cursor(next *database.Cursor) string {
+	if next == nil {
+		return ""
+	}
+	return next.String()
+}
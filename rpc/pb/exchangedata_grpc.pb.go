@@ -0,0 +1,836 @@
+// The gRPC API of exchangedata: the queries of the stored market data, the live updates
+// and the control of the exchangers of the daemon.
+//
+// The Go code in rpc/pb is generated by go generate ./rpc, with protoc-gen-go and protoc-gen-go-grpc.
+// Python: python -m grpc_tools.protoc -I rpc --python_out=. --grpc_python_out=. rpc/exchangedata.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: exchangedata.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	MarketData_ListExchangers_FullMethodName   = "/exchangedata.v1.MarketData/ListExchangers"
+	MarketData_ListMarkets_FullMethodName      = "/exchangedata.v1.MarketData/ListMarkets"
+	MarketData_GetLatestTicker_FullMethodName  = "/exchangedata.v1.MarketData/GetLatestTicker"
+	MarketData_ListTickers_FullMethodName      = "/exchangedata.v1.MarketData/ListTickers"
+	MarketData_ListTrades_FullMethodName       = "/exchangedata.v1.MarketData/ListTrades"
+	MarketData_ListCandles_FullMethodName      = "/exchangedata.v1.MarketData/ListCandles"
+	MarketData_GetOrderBook_FullMethodName     = "/exchangedata.v1.MarketData/GetOrderBook"
+	MarketData_StreamTickers_FullMethodName    = "/exchangedata.v1.MarketData/StreamTickers"
+	MarketData_StreamTrades_FullMethodName     = "/exchangedata.v1.MarketData/StreamTrades"
+	MarketData_StreamCandles_FullMethodName    = "/exchangedata.v1.MarketData/StreamCandles"
+	MarketData_StreamOrderBooks_FullMethodName = "/exchangedata.v1.MarketData/StreamOrderBooks"
+)
+
+// MarketDataClient is the client API for MarketData service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MarketDataClient interface {
+	ListExchangers(ctx context.Context, in *ListExchangersRequest, opts ...grpc.CallOption) (*ListExchangersResponse, error)
+	ListMarkets(ctx context.Context, in *ListMarketsRequest, opts ...grpc.CallOption) (*ListMarketsResponse, error)
+	GetLatestTicker(ctx context.Context, in *GetLatestTickerRequest, opts ...grpc.CallOption) (*Ticker, error)
+	ListTickers(ctx context.Context, in *RangeRequest, opts ...grpc.CallOption) (*ListTickersResponse, error)
+	ListTrades(ctx context.Context, in *RangeRequest, opts ...grpc.CallOption) (*ListTradesResponse, error)
+	ListCandles(ctx context.Context, in *RangeRequest, opts ...grpc.CallOption) (*ListCandlesResponse, error)
+	GetOrderBook(ctx context.Context, in *GetOrderBookRequest, opts ...grpc.CallOption) (*OrderBook, error)
+	StreamTickers(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (MarketData_StreamTickersClient, error)
+	StreamTrades(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (MarketData_StreamTradesClient, error)
+	StreamCandles(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (MarketData_StreamCandlesClient, error)
+	// StreamOrderBooks sends the current book of each market then its deltas, numbered without gaps
+	StreamOrderBooks(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (MarketData_StreamOrderBooksClient, error)
+}
+
+type marketDataClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMarketDataClient(cc grpc.ClientConnInterface) MarketDataClient {
+	return &marketDataClient{cc}
+}
+
+func (c *marketDataClient) ListExchangers(ctx context.Context, in *ListExchangersRequest, opts ...grpc.CallOption) (*ListExchangersResponse, error) {
+	out := new(ListExchangersResponse)
+	err := c.cc.Invoke(ctx, MarketData_ListExchangers_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *marketDataClient) ListMarkets(ctx context.Context, in *ListMarketsRequest, opts ...grpc.CallOption) (*ListMarketsResponse, error) {
+	out := new(ListMarketsResponse)
+	err := c.cc.Invoke(ctx, MarketData_ListMarkets_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *marketDataClient) GetLatestTicker(ctx context.Context, in *GetLatestTickerRequest, opts ...grpc.CallOption) (*Ticker, error) {
+	out := new(Ticker)
+	err := c.cc.Invoke(ctx, MarketData_GetLatestTicker_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *marketDataClient) ListTickers(ctx context.Context, in *RangeRequest, opts ...grpc.CallOption) (*ListTickersResponse, error) {
+	out := new(ListTickersResponse)
+	err := c.cc.Invoke(ctx, MarketData_ListTickers_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *marketDataClient) ListTrades(ctx context.Context, in *RangeRequest, opts ...grpc.CallOption) (*ListTradesResponse, error) {
+	out := new(ListTradesResponse)
+	err := c.cc.Invoke(ctx, MarketData_ListTrades_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *marketDataClient) ListCandles(ctx context.Context, in *RangeRequest, opts ...grpc.CallOption) (*ListCandlesResponse, error) {
+	out := new(ListCandlesResponse)
+	err := c.cc.Invoke(ctx, MarketData_ListCandles_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *marketDataClient) GetOrderBook(ctx context.Context, in *GetOrderBookRequest, opts ...grpc.CallOption) (*OrderBook, error) {
+	out := new(OrderBook)
+	err := c.cc.Invoke(ctx, MarketData_GetOrderBook_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *marketDataClient) StreamTickers(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (MarketData_StreamTickersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MarketData_ServiceDesc.Streams[0], MarketData_StreamTickers_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &marketDataStreamTickersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MarketData_StreamTickersClient interface {
+	Recv() (*Ticker, error)
+	grpc.ClientStream
+}
+
+type marketDataStreamTickersClient struct {
+	grpc.ClientStream
+}
+
+func (x *marketDataStreamTickersClient) Recv() (*Ticker, error) {
+	m := new(Ticker)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *marketDataClient) StreamTrades(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (MarketData_StreamTradesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MarketData_ServiceDesc.Streams[1], MarketData_StreamTrades_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &marketDataStreamTradesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MarketData_StreamTradesClient interface {
+	Recv() (*Trade, error)
+	grpc.ClientStream
+}
+
+type marketDataStreamTradesClient struct {
+	grpc.ClientStream
+}
+
+func (x *marketDataStreamTradesClient) Recv() (*Trade, error) {
+	m := new(Trade)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *marketDataClient) StreamCandles(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (MarketData_StreamCandlesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MarketData_ServiceDesc.Streams[2], MarketData_StreamCandles_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &marketDataStreamCandlesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MarketData_StreamCandlesClient interface {
+	Recv() (*Candle, error)
+	grpc.ClientStream
+}
+
+type marketDataStreamCandlesClient struct {
+	grpc.ClientStream
+}
+
+func (x *marketDataStreamCandlesClient) Recv() (*Candle, error) {
+	m := new(Candle)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *marketDataClient) StreamOrderBooks(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (MarketData_StreamOrderBooksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MarketData_ServiceDesc.Streams[3], MarketData_StreamOrderBooks_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &marketDataStreamOrderBooksClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MarketData_StreamOrderBooksClient interface {
+	Recv() (*OrderBook, error)
+	grpc.ClientStream
+}
+
+type marketDataStreamOrderBooksClient struct {
+	grpc.ClientStream
+}
+
+func (x *marketDataStreamOrderBooksClient) Recv() (*OrderBook, error) {
+	m := new(OrderBook)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// MarketDataServer is the server API for MarketData service.
+// All implementations must embed UnimplementedMarketDataServer
+// for forward compatibility
+type MarketDataServer interface {
+	ListExchangers(context.Context, *ListExchangersRequest) (*ListExchangersResponse, error)
+	ListMarkets(context.Context, *ListMarketsRequest) (*ListMarketsResponse, error)
+	GetLatestTicker(context.Context, *GetLatestTickerRequest) (*Ticker, error)
+	ListTickers(context.Context, *RangeRequest) (*ListTickersResponse, error)
+	ListTrades(context.Context, *RangeRequest) (*ListTradesResponse, error)
+	ListCandles(context.Context, *RangeRequest) (*ListCandlesResponse, error)
+	GetOrderBook(context.Context, *GetOrderBookRequest) (*OrderBook, error)
+	StreamTickers(*StreamRequest, MarketData_StreamTickersServer) error
+	StreamTrades(*StreamRequest, MarketData_StreamTradesServer) error
+	StreamCandles(*StreamRequest, MarketData_StreamCandlesServer) error
+	// StreamOrderBooks sends the current book of each market then its deltas, numbered without gaps
+	StreamOrderBooks(*StreamRequest, MarketData_StreamOrderBooksServer) error
+	mustEmbedUnimplementedMarketDataServer()
+}
+
+// UnimplementedMarketDataServer must be embedded to have forward compatible implementations.
+type UnimplementedMarketDataServer struct {
+}
+
+func (UnimplementedMarketDataServer) ListExchangers(context.Context, *ListExchangersRequest) (*ListExchangersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListExchangers not implemented")
+}
+func (UnimplementedMarketDataServer) ListMarkets(context.Context, *ListMarketsRequest) (*ListMarketsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMarkets not implemented")
+}
+func (UnimplementedMarketDataServer) GetLatestTicker(context.Context, *GetLatestTickerRequest) (*Ticker, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLatestTicker not implemented")
+}
+func (UnimplementedMarketDataServer) ListTickers(context.Context, *RangeRequest) (*ListTickersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTickers not implemented")
+}
+func (UnimplementedMarketDataServer) ListTrades(context.Context, *RangeRequest) (*ListTradesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTrades not implemented")
+}
+func (UnimplementedMarketDataServer) ListCandles(context.Context, *RangeRequest) (*ListCandlesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCandles not implemented")
+}
+func (UnimplementedMarketDataServer) GetOrderBook(context.Context, *GetOrderBookRequest) (*OrderBook, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetOrderBook not implemented")
+}
+func (UnimplementedMarketDataServer) StreamTickers(*StreamRequest, MarketData_StreamTickersServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamTickers not implemented")
+}
+func (UnimplementedMarketDataServer) StreamTrades(*StreamRequest, MarketData_StreamTradesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamTrades not implemented")
+}
+func (UnimplementedMarketDataServer) StreamCandles(*StreamRequest, MarketData_StreamCandlesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamCandles not implemented")
+}
+func (UnimplementedMarketDataServer) StreamOrderBooks(*StreamRequest, MarketData_StreamOrderBooksServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamOrderBooks not implemented")
+}
+func (UnimplementedMarketDataServer) mustEmbedUnimplementedMarketDataServer() {}
+
+// UnsafeMarketDataServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MarketDataServer will
+// result in compilation errors.
+type UnsafeMarketDataServer interface {
+	mustEmbedUnimplementedMarketDataServer()
+}
+
+func RegisterMarketDataServer(s grpc.ServiceRegistrar, srv MarketDataServer) {
+	s.RegisterService(&MarketData_ServiceDesc, srv)
+}
+
+func _MarketData_ListExchangers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListExchangersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MarketDataServer).ListExchangers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MarketData_ListExchangers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MarketDataServer).ListExchangers(ctx, req.(*ListExchangersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MarketData_ListMarkets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMarketsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MarketDataServer).ListMarkets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MarketData_ListMarkets_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MarketDataServer).ListMarkets(ctx, req.(*ListMarketsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MarketData_GetLatestTicker_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLatestTickerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MarketDataServer).GetLatestTicker(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MarketData_GetLatestTicker_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MarketDataServer).GetLatestTicker(ctx, req.(*GetLatestTickerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MarketData_ListTickers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MarketDataServer).ListTickers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MarketData_ListTickers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MarketDataServer).ListTickers(ctx, req.(*RangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MarketData_ListTrades_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MarketDataServer).ListTrades(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MarketData_ListTrades_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MarketDataServer).ListTrades(ctx, req.(*RangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MarketData_ListCandles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MarketDataServer).ListCandles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MarketData_ListCandles_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MarketDataServer).ListCandles(ctx, req.(*RangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MarketData_GetOrderBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetOrderBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MarketDataServer).GetOrderBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MarketData_GetOrderBook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MarketDataServer).GetOrderBook(ctx, req.(*GetOrderBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MarketData_StreamTickers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MarketDataServer).StreamTickers(m, &marketDataStreamTickersServer{stream})
+}
+
+type MarketData_StreamTickersServer interface {
+	Send(*Ticker) error
+	grpc.ServerStream
+}
+
+type marketDataStreamTickersServer struct {
+	grpc.ServerStream
+}
+
+func (x *marketDataStreamTickersServer) Send(m *Ticker) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MarketData_StreamTrades_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MarketDataServer).StreamTrades(m, &marketDataStreamTradesServer{stream})
+}
+
+type MarketData_StreamTradesServer interface {
+	Send(*Trade) error
+	grpc.ServerStream
+}
+
+type marketDataStreamTradesServer struct {
+	grpc.ServerStream
+}
+
+func (x *marketDataStreamTradesServer) Send(m *Trade) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MarketData_StreamCandles_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MarketDataServer).StreamCandles(m, &marketDataStreamCandlesServer{stream})
+}
+
+type MarketData_StreamCandlesServer interface {
+	Send(*Candle) error
+	grpc.ServerStream
+}
+
+type marketDataStreamCandlesServer struct {
+	grpc.ServerStream
+}
+
+func (x *marketDataStreamCandlesServer) Send(m *Candle) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MarketData_StreamOrderBooks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MarketDataServer).StreamOrderBooks(m, &marketDataStreamOrderBooksServer{stream})
+}
+
+type MarketData_StreamOrderBooksServer interface {
+	Send(*OrderBook) error
+	grpc.ServerStream
+}
+
+type marketDataStreamOrderBooksServer struct {
+	grpc.ServerStream
+}
+
+func (x *marketDataStreamOrderBooksServer) Send(m *OrderBook) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// MarketData_ServiceDesc is the grpc.ServiceDesc for MarketData service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MarketData_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "exchangedata.v1.MarketData",
+	HandlerType: (*MarketDataServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListExchangers",
+			Handler:    _MarketData_ListExchangers_Handler,
+		},
+		{
+			MethodName: "ListMarkets",
+			Handler:    _MarketData_ListMarkets_Handler,
+		},
+		{
+			MethodName: "GetLatestTicker",
+			Handler:    _MarketData_GetLatestTicker_Handler,
+		},
+		{
+			MethodName: "ListTickers",
+			Handler:    _MarketData_ListTickers_Handler,
+		},
+		{
+			MethodName: "ListTrades",
+			Handler:    _MarketData_ListTrades_Handler,
+		},
+		{
+			MethodName: "ListCandles",
+			Handler:    _MarketData_ListCandles_Handler,
+		},
+		{
+			MethodName: "GetOrderBook",
+			Handler:    _MarketData_GetOrderBook_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamTickers",
+			Handler:       _MarketData_StreamTickers_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamTrades",
+			Handler:       _MarketData_StreamTrades_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamCandles",
+			Handler:       _MarketData_StreamCandles_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamOrderBooks",
+			Handler:       _MarketData_StreamOrderBooks_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "exchangedata.proto",
+}
+
+const (
+	Admin_ListExchangers_FullMethodName = "/exchangedata.v1.Admin/ListExchangers"
+	Admin_StartExchanger_FullMethodName = "/exchangedata.v1.Admin/StartExchanger"
+	Admin_StopExchanger_FullMethodName  = "/exchangedata.v1.Admin/StopExchanger"
+	Admin_AddMarket_FullMethodName      = "/exchangedata.v1.Admin/AddMarket"
+	Admin_RemoveMarket_FullMethodName   = "/exchangedata.v1.Admin/RemoveMarket"
+)
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminClient interface {
+	ListExchangers(ctx context.Context, in *ListExchangersRequest, opts ...grpc.CallOption) (*ListExchangerStatusResponse, error)
+	StartExchanger(ctx context.Context, in *ExchangerRequest, opts ...grpc.CallOption) (*ExchangerStatus, error)
+	StopExchanger(ctx context.Context, in *ExchangerRequest, opts ...grpc.CallOption) (*ExchangerStatus, error)
+	// AddMarket streams the order book of a market, RemoveMarket stops it
+	AddMarket(ctx context.Context, in *MarketSubscriptionRequest, opts ...grpc.CallOption) (*ExchangerStatus, error)
+	RemoveMarket(ctx context.Context, in *MarketSubscriptionRequest, opts ...grpc.CallOption) (*ExchangerStatus, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) ListExchangers(ctx context.Context, in *ListExchangersRequest, opts ...grpc.CallOption) (*ListExchangerStatusResponse, error) {
+	out := new(ListExchangerStatusResponse)
+	err := c.cc.Invoke(ctx, Admin_ListExchangers_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) StartExchanger(ctx context.Context, in *ExchangerRequest, opts ...grpc.CallOption) (*ExchangerStatus, error) {
+	out := new(ExchangerStatus)
+	err := c.cc.Invoke(ctx, Admin_StartExchanger_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) StopExchanger(ctx context.Context, in *ExchangerRequest, opts ...grpc.CallOption) (*ExchangerStatus, error) {
+	out := new(ExchangerStatus)
+	err := c.cc.Invoke(ctx, Admin_StopExchanger_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) AddMarket(ctx context.Context, in *MarketSubscriptionRequest, opts ...grpc.CallOption) (*ExchangerStatus, error) {
+	out := new(ExchangerStatus)
+	err := c.cc.Invoke(ctx, Admin_AddMarket_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) RemoveMarket(ctx context.Context, in *MarketSubscriptionRequest, opts ...grpc.CallOption) (*ExchangerStatus, error) {
+	out := new(ExchangerStatus)
+	err := c.cc.Invoke(ctx, Admin_RemoveMarket_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility
+type AdminServer interface {
+	ListExchangers(context.Context, *ListExchangersRequest) (*ListExchangerStatusResponse, error)
+	StartExchanger(context.Context, *ExchangerRequest) (*ExchangerStatus, error)
+	StopExchanger(context.Context, *ExchangerRequest) (*ExchangerStatus, error)
+	// AddMarket streams the order book of a market, RemoveMarket stops it
+	AddMarket(context.Context, *MarketSubscriptionRequest) (*ExchangerStatus, error)
+	RemoveMarket(context.Context, *MarketSubscriptionRequest) (*ExchangerStatus, error)
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedAdminServer struct {
+}
+
+func (UnimplementedAdminServer) ListExchangers(context.Context, *ListExchangersRequest) (*ListExchangerStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListExchangers not implemented")
+}
+func (UnimplementedAdminServer) StartExchanger(context.Context, *ExchangerRequest) (*ExchangerStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartExchanger not implemented")
+}
+func (UnimplementedAdminServer) StopExchanger(context.Context, *ExchangerRequest) (*ExchangerStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StopExchanger not implemented")
+}
+func (UnimplementedAdminServer) AddMarket(context.Context, *MarketSubscriptionRequest) (*ExchangerStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddMarket not implemented")
+}
+func (UnimplementedAdminServer) RemoveMarket(context.Context, *MarketSubscriptionRequest) (*ExchangerStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveMarket not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_ListExchangers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListExchangersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListExchangers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListExchangers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListExchangers(ctx, req.(*ListExchangersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_StartExchanger_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExchangerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).StartExchanger(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_StartExchanger_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).StartExchanger(ctx, req.(*ExchangerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_StopExchanger_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExchangerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).StopExchanger(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_StopExchanger_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).StopExchanger(ctx, req.(*ExchangerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_AddMarket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MarketSubscriptionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).AddMarket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_AddMarket_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).AddMarket(ctx, req.(*MarketSubscriptionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_RemoveMarket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MarketSubscriptionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).RemoveMarket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_RemoveMarket_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).RemoveMarket(ctx, req.(*MarketSubscriptionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "exchangedata.v1.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListExchangers",
+			Handler:    _Admin_ListExchangers_Handler,
+		},
+		{
+			MethodName: "StartExchanger",
+			Handler:    _Admin_StartExchanger_Handler,
+		},
+		{
+			MethodName: "StopExchanger",
+			Handler:    _Admin_StopExchanger_Handler,
+		},
+		{
+			MethodName: "AddMarket",
+			Handler:    _Admin_AddMarket_Handler,
+		},
+		{
+			MethodName: "RemoveMarket",
+			Handler:    _Admin_RemoveMarket_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "exchangedata.proto",
+}
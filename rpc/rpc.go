@@ -0,0 +1,42 @@
+// Package rpc serves the gRPC API of exchangedata.proto: the MarketData service queries the stored market data
+// and streams the live updates of the stream hub, the Admin service controls the exchangers of the daemon.
+//
+// A market is named by its id, or by its name and exchanger, the name alone when it is on one exchanger.
+// The errors are the gRPC codes InvalidArgument, NotFound, FailedPrecondition or Internal, the database errors
+// are logged and hidden from the client.
+package rpc
+
+//go:generate protoc -I . --go_out=pb --go_opt=paths=source_relative --go-grpc_out=pb --go-grpc_opt=paths=source_relative exchangedata.proto
+
+import (
+	"log"
+
+	"github.com/exchangedata/database"
+	"github.com/exchangedata/exchanger"
+	"github.com/exchangedata/rpc/pb"
+	"github.com/exchangedata/stream"
+	"github.com/jinzhu/gorm"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Register adds the services to s: MarketData if ds is not nil, without the streams if hub is nil,
+// Admin if m is not nil and adminToken, its token, is not empty
+func Register(s *grpc.Server, ds *database.DataStore, hub *stream.Hub, m *exchanger.Manager, adminToken string) {
+	if ds != nil {
+		pb.RegisterMarketDataServer(s, NewMarketData(ds, hub))
+	}
+	if m != nil && adminToken != "" {
+		pb.RegisterAdminServer(s, NewAdmin(m, adminToken))
+	}
+}
+
+// queryError turns a database error into a gRPC status
+func queryError(err error) error {
+	if gorm.IsRecordNotFoundError(err) {
+		return status.Error(codes.NotFound, "no data")
+	}
+	log.Println("rpc query error:", err)
+	return status.Error(codes.Internal, "internal error")
+}
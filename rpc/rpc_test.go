@@ -0,0 +1,210 @@
+package rpc
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exchangedata/bus"
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/database"
+	"github.com/exchangedata/exchanger"
+	"github.com/exchangedata/rpc/pb"
+	"github.com/exchangedata/stream"
+	"github.com/shopspring/decimal"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var dec = decimal.RequireFromString
+
+var start = time.Date(2018, 11, 26, 0, 0, 0, 0, time.UTC)
+
+// openTestDB is a sqlite database with the DOGE-BTC market of bittrex, a ticker and a trade a minute for 5 minutes
+func openTestDB(t *testing.T) (*database.DataStore, *common.Market, func()) {
+	dir, err := ioutil.TempDir("", "edrpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := database.NewDataStore("sqlite3")
+	ds.Name = filepath.Join(dir, "test.db")
+	if err = ds.OpenDB(); err != nil {
+		t.Fatal("open db", err)
+	}
+	if err = ds.Migrate(); err != nil {
+		t.Fatal("migrate db", err)
+	}
+	m := &common.Market{Name: "DOGE-BTC", Exchanger: &common.Exchanger{Name: "bittrex"},
+		Symbol: &common.Symbol{Base: &common.Currency{Name: "dogecoin", Abbr: "DOGE"}, Quote: &common.Currency{Name: "bitcoin", Abbr: "BTC"}}}
+	if err = ds.UpdateMarket(m).Error; err != nil {
+		t.Fatal("save market", err)
+	}
+	w := ds.NewBatchWriter(0, 0)
+	for k := 0; k < 5; k++ {
+		at := start.Add(time.Duration(k) * time.Minute)
+		w.AddTicker(&common.Ticker{Time: at, Market: m, Last: dec(strconv.Itoa(k + 1))})
+		w.AddTrade(&common.Trade{Time: at, Market: m, OrderID: strconv.Itoa(k), Side: "buy", Price: dec("1"), Amount: dec("2")})
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal("flush", err)
+	}
+	return ds, m, func() {
+		ds.CloseDB()
+		os.RemoveAll(dir)
+	}
+}
+
+// serve runs the services on a local port and returns a connection to them
+const testAdminToken = "s3cret"
+
+func serve(t *testing.T, ds *database.DataStore, hub *stream.Hub, m *exchanger.Manager) (*grpc.ClientConn, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	Register(s, ds, hub, m, testAdminToken)
+	go s.Serve(l)
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal("dial", err)
+	}
+	return conn, func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func TestMarketData(t *testing.T) {
+	ds, m, closeDB := openTestDB(t)
+	defer closeDB()
+	b := bus.New()
+	hub := stream.NewHub(b)
+	go hub.Run()
+	defer b.Close()
+	conn, stop := serve(t, ds, hub, nil)
+	defer stop()
+	c := pb.NewMarketDataClient(conn)
+	ctx := context.Background()
+
+	markets, err := c.ListMarkets(ctx, &pb.ListMarketsRequest{Base: "doge"})
+	if err != nil || len(markets.Markets) != 1 || markets.Markets[0].Exchanger != "bittrex" || markets.Markets[0].Quote != "BTC" {
+		t.Fatal("markets", markets, err)
+	}
+	ref := &pb.MarketRef{Exchanger: "bittrex", Name: "DOGE-BTC"}
+	if tk, err := c.GetLatestTicker(ctx, &pb.GetLatestTickerRequest{Market: ref}); err != nil || tk.Last != "5" {
+		t.Fatal("latest ticker", tk, err)
+	}
+
+	// two pages of trades
+	req := &pb.RangeRequest{Market: ref, From: timestamppb.New(start), To: timestamppb.New(start.Add(time.Hour)), Limit: 3}
+	first, err := c.ListTrades(ctx, req)
+	if err != nil || len(first.Trades) != 3 || first.NextCursor == "" {
+		t.Fatal("first page", first, err)
+	}
+	req.Cursor = first.NextCursor
+	if next, err := c.ListTrades(ctx, req); err != nil || len(next.Trades) != 2 || next.NextCursor != "" || next.Trades[0].OrderId != "3" {
+		t.Fatal("last page", next, err)
+	}
+
+	if _, err = c.GetLatestTicker(ctx, &pb.GetLatestTickerRequest{Market: &pb.MarketRef{Name: "NONE"}}); status.Code(err) != codes.NotFound {
+		t.Fatal("unknown market", err)
+	}
+	if _, err = c.ListCandles(ctx, &pb.RangeRequest{Market: ref, Interval: "1x"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatal("bad interval", err)
+	}
+
+	// the live books: the snapshot then the changes
+	books, err := c.StreamOrderBooks(ctx, &pb.StreamRequest{Exchanger: "bittrex"})
+	if err != nil {
+		t.Fatal("stream", err)
+	}
+	src := bus.NewSink(b)
+	// the stream may be subscribed before, between or after the two books
+	for _, bid := range []string{"99", "100"} {
+		src.WriteOrderBooks([]*common.OrderBook{{Time: time.Now(), Market: m,
+			Bids: []*common.PriceVol{{Price: dec(bid), Volume: dec("1")}}}})
+	}
+	ob, err := books.Recv()
+	if err != nil || ob.Market != "DOGE-BTC" {
+		t.Fatal("book", ob, err)
+	}
+	if ob.Kind == pb.OrderBook_SNAPSHOT && ob.Sequence == 1 {
+		if ob, err = books.Recv(); err != nil || ob.Kind != pb.OrderBook_DELTA || ob.Sequence != 2 || len(ob.Bids) != 2 {
+			t.Fatal("delta", ob, err)
+		}
+	} else if ob.Kind != pb.OrderBook_SNAPSHOT || ob.Sequence != 2 || ob.Bids[0].Price != "100" {
+		t.Fatal("late snapshot", ob)
+	}
+}
+
+type fakeStreamer struct {
+	stop    chan struct{}
+	streams []string
+}
+
+func (f *fakeStreamer) Setup() error { return nil }
+func (f *fakeStreamer) Start(wg *sync.WaitGroup) {
+	defer wg.Done()
+	<-f.stop
+}
+func (f *fakeStreamer) Stop()                         { f.stop <- struct{}{} }
+func (f *fakeStreamer) StreamMarkets(names ...string) { f.streams = names }
+func (f *fakeStreamer) AddStream(market string) error {
+	f.streams = append(f.streams, market)
+	return nil
+}
+func (f *fakeStreamer) RemoveStream(market string) error { f.streams = nil; return nil }
+func (f *fakeStreamer) Streams() []string                { return f.streams }
+
+func TestAdmin(t *testing.T) {
+	m := exchanger.NewManager()
+	m.Add("bittrex", &fakeStreamer{stop: make(chan struct{})})
+	conn, stop := serve(t, nil, nil, m)
+	defer stop()
+	c := pb.NewAdminClient(conn)
+	for _, auth := range []string{"", "Bearer wrong", testAdminToken} {
+		ctx := context.Background()
+		if auth != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", auth)
+		}
+		if _, err := c.StopExchanger(ctx, &pb.ExchangerRequest{Name: "bittrex"}); status.Code(err) != codes.Unauthenticated {
+			t.Fatal("admin call with the authorization", auth, err)
+		}
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+testAdminToken)
+
+	if s, err := c.StartExchanger(ctx, &pb.ExchangerRequest{Name: "bittrex"}); err != nil || s.Status != exchanger.StatusRunning {
+		t.Fatal("start", s, err)
+	}
+	if s, err := c.AddMarket(ctx, &pb.MarketSubscriptionRequest{Exchanger: "bittrex", Market: "BTC-LTC"}); err != nil || len(s.Streams) != 1 {
+		t.Fatal("add market", s, err)
+	}
+	if s, err := c.StopExchanger(ctx, &pb.ExchangerRequest{Name: "bittrex"}); err != nil || s.Status != exchanger.StatusStopped {
+		t.Fatal("stop", s, err)
+	}
+	list, err := c.ListExchangers(ctx, &pb.ListExchangersRequest{})
+	if err != nil || len(list.Exchangers) != 1 || list.Exchangers[0].Streams[0] != "BTC-LTC" {
+		t.Fatal("list", list, err)
+	}
+	if _, err = c.StartExchanger(ctx, &pb.ExchangerRequest{Name: "none"}); status.Code(err) != codes.NotFound {
+		t.Fatal("unknown exchanger", err)
+	}
+
+	// no Admin service without a token
+	s := grpc.NewServer()
+	Register(s, nil, nil, m, "")
+	if _, ok := s.GetServiceInfo()["exchangedata.v1.Admin"]; ok {
+		t.Fatal("admin service without a token")
+	}
+}
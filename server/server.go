@@ -0,0 +1,760 @@
+// Package server serves the market data stored by the database package as JSON over HTTP.
+//
+//	GET /exchangers
+//	GET /markets?exchanger=&currency=&base=&quote=&active=
+//	GET /tickers?exchanger=                     the latest ticker of each market
+//	GET /tickers/{market}?from=&to=&cursor=&limit=  the latest ticker without from and to
+//	GET /trades/{market}?from=&to=&cursor=&limit=
+//	GET /orderbook/{market}?at=&depth=
+//	GET /bookmetrics/{market}?from=&to=&cursor=&limit=  depth, imbalance, microprice and slippages of the books
+//	GET /candles?market=&exchanger=&interval=&from=&to=&cursor=&limit=
+//	GET /indicators?market=&exchanger=&interval=&indicators=&from=&to=&cursor=&limit=  indicators of the candles,
+//	    like indicators=sma(20),rsi(14),macd(12,26,9), the values by indicator and field after each candle
+//	GET /issues?market=&exchanger=&rule=&from=&to=&cursor=&limit=  the validation issues of a market
+//	GET /issues/counts?market=&exchanger=&from=&to=    the number of issues by rule and action, of all the markets without market
+//	GET /completeness?market=&exchanger=&from=&to=&tickers=&trades=&candles=&tz=  the gaps and completeness by day
+//	    tickers and trades are the longest durations between two records, 0 not to check them, candles the intervals
+//	GET /alerts/rules                           the alert rules, see the alert package
+//	POST /alerts/rules                          a new rule, the JSON of a rule without id
+//	GET, PUT, DELETE /alerts/rules/{id}
+//	GET /alerts?rule=&from=&to=&cursor=&limit=  the alerts of a rule
+//
+// The POST, PUT and DELETE requests need the Token of the server as "Authorization: Bearer <token>",
+// they are all refused without a token. The CORS headers only allow the GET requests.
+// A market is its id, its name with the exchanger parameter, or exchanger:name.
+// The times are RFC3339, a date or unix seconds. The range is the last day before to, now by default.
+// The lists are {"data": [...], "next": cursor}, the next page is requested with the cursor parameter,
+// there is no next cursor after the last page. The errors are {"error": message}.
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/exchangedata/alert"
+	"github.com/exchangedata/candle"
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/completeness"
+	"github.com/exchangedata/database"
+	"github.com/exchangedata/indicator"
+	"github.com/jinzhu/gorm"
+)
+
+// MaxLimit is the largest page size a client may ask for
+const MaxLimit = database.DefaultQueryLimit
+
+// DefaultRange is the time range of the queries without from
+const DefaultRange = 24 * time.Hour
+
+// Server is the http.Handler of the API
+type Server struct {
+	AllowOrigin string // value of Access-Control-Allow-Origin, no CORS header if empty
+	Token       string // bearer token of the POST, PUT and DELETE requests, refused if empty
+
+	ds    *database.DataStore
+	mux   *http.ServeMux
+	write *http.ServeMux // the handlers of POST, PUT and DELETE
+}
+
+// New returns a server of the data of ds, without CORS headers until AllowOrigin is set
+func New(ds *database.DataStore) *Server {
+	s := &Server{ds: ds, mux: http.NewServeMux(), write: http.NewServeMux()}
+	s.mux.HandleFunc("/exchangers", s.exchangers)
+	s.mux.HandleFunc("/markets", s.markets)
+	s.mux.HandleFunc("/tickers", s.latestTickers)
+	s.mux.HandleFunc("/tickers/", s.tickers)
+	s.mux.HandleFunc("/trades/", s.trades)
+	s.mux.HandleFunc("/orderbook/", s.orderBook)
+	s.mux.HandleFunc("/bookmetrics/", s.bookMetrics)
+	s.mux.HandleFunc("/candles", s.candles)
+	s.mux.HandleFunc("/indicators", s.indicators)
+	s.mux.HandleFunc("/issues", s.issues)
+	s.mux.HandleFunc("/issues/counts", s.issueCounts)
+	s.mux.HandleFunc("/completeness", s.completeness)
+	s.mux.HandleFunc("/alerts", s.alerts)
+	for _, mux := range []*http.ServeMux{s.mux, s.write} {
+		mux.HandleFunc("/alerts/rules", s.alertRules)
+		mux.HandleFunc("/alerts/rules/", s.alertRule)
+	}
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.AllowOrigin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", s.AllowOrigin)
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	}
+	switch r.Method {
+	case http.MethodOptions: // preflight
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet, http.MethodHead:
+		s.mux.ServeHTTP(w, r)
+	case http.MethodPost, http.MethodPut, http.MethodDelete:
+		if _, pattern := s.write.Handler(r); pattern != "" {
+			if !s.authorized(r) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+				return
+			}
+			s.write.ServeHTTP(w, r)
+			return
+		}
+		fallthrough
+	default:
+		w.Header().Set("Allow", "GET, OPTIONS")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// authorized tells if r has the bearer token of the server
+func (s *Server) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if s.Token == "" || !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(s.Token)) == 1
+}
+
+// Handle adds the handler h of pattern to the API, for the services sharing the server
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// HandleFunc adds the handler function of pattern to the API, see Handle
+func (s *Server) HandleFunc(pattern string, f http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, f)
+}
+
+// badRequest is an error of the client parameters
+type badRequest struct {
+	error
+}
+
+// notFound is a record missing
+type notFound struct {
+	error
+}
+
+type list struct {
+	Data interface{} `json:"data"`
+	Next string      `json:"next,omitempty"`
+}
+
+func (s *Server) exchangers(w http.ResponseWriter, r *http.Request) {
+	c, err := s.ds.Exchangers()
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	v := make([]*exchangerView, len(c))
+	for k, e := range c {
+		v[k] = &exchangerView{ID: e.ID, Name: e.Name, Info: e.Info}
+	}
+	writeJSON(w, &list{Data: v})
+}
+
+func (s *Server) markets(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := database.MarketFilter{Exchanger: q.Get("exchanger"), Currency: q.Get("currency"), Base: q.Get("base"), Quote: q.Get("quote")}
+	if a := q.Get("active"); a != "" {
+		var err error
+		if f.Active, err = strconv.ParseBool(a); err != nil {
+			writeError(w, 0, badRequest{errors.New("bad active: " + a)})
+			return
+		}
+	}
+	c, err := s.ds.Markets(f)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	v := make([]*marketView, len(c))
+	for k, m := range c {
+		v[k] = newMarketView(m)
+	}
+	writeJSON(w, &list{Data: v})
+}
+
+func (s *Server) latestTickers(w http.ResponseWriter, r *http.Request) {
+	var refs []uint
+	if ex := r.URL.Query().Get("exchanger"); ex != "" {
+		markets, err := s.ds.MarketsByExchanger(ex)
+		if err != nil {
+			writeError(w, 0, err)
+			return
+		}
+		if len(markets) == 0 {
+			writeJSON(w, &list{Data: []*tickerView{}})
+			return
+		}
+		for _, m := range markets {
+			refs = append(refs, m.ID)
+		}
+	}
+	c, err := s.ds.LatestTickers(refs...)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	v := make([]*tickerView, len(c))
+	for k, t := range c {
+		v[k] = newTickerView(t)
+	}
+	writeJSON(w, &list{Data: v})
+}
+
+func (s *Server) tickers(w http.ResponseWriter, r *http.Request) {
+	m, err := s.pathMarket(r, "/tickers/")
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("from") == "" && q.Get("to") == "" && q.Get("cursor") == "" {
+		t, err := s.ds.LatestTicker(m.ID)
+		if err != nil {
+			writeError(w, 0, err)
+			return
+		}
+		writeJSON(w, &list{Data: []*tickerView{newTickerView(t)}})
+		return
+	}
+	p, err := parsePage(r)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	c, next, err := s.ds.Tickers(m.ID, p.from, p.to, p.after, p.limit)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	v := make([]*tickerView, len(c))
+	for k, t := range c {
+		v[k] = newTickerView(t)
+	}
+	writeJSON(w, newList(v, next))
+}
+
+func (s *Server) trades(w http.ResponseWriter, r *http.Request) {
+	m, err := s.pathMarket(r, "/trades/")
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	p, err := parsePage(r)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	c, next, err := s.ds.Trades(m.ID, p.from, p.to, p.after, p.limit)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	v := make([]*tradeView, len(c))
+	for k, t := range c {
+		v[k] = newTradeView(t)
+	}
+	writeJSON(w, newList(v, next))
+}
+
+func (s *Server) orderBook(w http.ResponseWriter, r *http.Request) {
+	m, err := s.pathMarket(r, "/orderbook/")
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	q := r.URL.Query()
+	at := time.Now()
+	if a := q.Get("at"); a != "" {
+		if at, err = parseTime(a); err != nil {
+			writeError(w, 0, badRequest{errors.New("bad at: " + a)})
+			return
+		}
+	}
+	depth, err := intParam(q.Get("depth"), 0)
+	if err != nil || depth < 0 {
+		writeError(w, 0, badRequest{errors.New("bad depth: " + q.Get("depth"))})
+		return
+	}
+	ob, err := s.ds.OrderBookAt(m.ID, at)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	writeJSON(w, &list{Data: newBookView(m, ob, depth)})
+}
+
+func (s *Server) bookMetrics(w http.ResponseWriter, r *http.Request) {
+	m, err := s.pathMarket(r, "/bookmetrics/")
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	p, err := parsePage(r)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	c, next, err := s.ds.BookMetrics(m.ID, p.from, p.to, p.after, p.limit)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	v := make([]*bookMetricsView, len(c))
+	for k, b := range c {
+		v[k] = newBookMetricsView(b)
+	}
+	writeJSON(w, newList(v, next))
+}
+
+func (s *Server) candles(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("market") == "" {
+		writeError(w, 0, badRequest{errors.New("market missing")})
+		return
+	}
+	m, err := s.market(q.Get("market"), q.Get("exchanger"))
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	interval := q.Get("interval")
+	if interval == "" {
+		interval = "1m"
+	}
+	if _, err := candle.ParseInterval(interval); err != nil {
+		writeError(w, 0, badRequest{errors.New("bad interval: " + interval)})
+		return
+	}
+	p, err := parsePage(r)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	c, next, err := s.ds.Candles(m.ID, interval, p.from, p.to, p.after, p.limit)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	v := make([]*candleView, len(c))
+	for k, t := range c {
+		v[k] = newCandleView(t)
+	}
+	writeJSON(w, newList(v, next))
+}
+
+// indicators computes the indicators over a page of candles, after the stored candles of their warmup
+func (s *Server) indicators(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("market") == "" {
+		writeError(w, 0, badRequest{errors.New("market missing")})
+		return
+	}
+	inds, err := indicator.ParseList(q.Get("indicators"))
+	if err != nil || len(inds) == 0 {
+		writeError(w, 0, badRequest{errors.New("bad indicators: " + q.Get("indicators"))})
+		return
+	}
+	m, err := s.market(q.Get("market"), q.Get("exchanger"))
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	interval := q.Get("interval")
+	if interval == "" {
+		interval = "1m"
+	}
+	if _, err := candle.ParseInterval(interval); err != nil {
+		writeError(w, 0, badRequest{errors.New("bad interval: " + interval)})
+		return
+	}
+	p, err := parsePage(r)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	c, next, err := s.ds.Candles(m.ID, interval, p.from, p.to, p.after, p.limit)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	v := make([]*indicatorView, len(c))
+	for k, cd := range c {
+		v[k] = &indicatorView{Time: cd.Time.UTC(), Values: make(map[string]map[string]float64)}
+	}
+	if len(c) > 0 {
+		for _, ind := range inds {
+			past, err := indicator.History(s.ds, m.ID, interval, c[0].Time, ind.Warmup())
+			if err != nil {
+				writeError(w, 0, err)
+				return
+			}
+			indicator.Compute(ind, past)
+			k := 0
+			for _, pt := range indicator.Compute(ind, c) {
+				for !v[k].Time.Equal(pt.Time.UTC()) {
+					k++
+				}
+				v[k].Values[ind.Name()] = fieldValues(ind.Fields(), pt.Values)
+			}
+		}
+	}
+	writeJSON(w, newList(v, next))
+}
+
+func (s *Server) issues(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("market") == "" {
+		writeError(w, 0, badRequest{errors.New("market missing")})
+		return
+	}
+	m, err := s.market(q.Get("market"), q.Get("exchanger"))
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	p, err := parsePage(r)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	c, next, err := s.ds.Issues(m.ID, q.Get("rule"), p.from, p.to, p.after, p.limit)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	v := make([]*issueView, len(c))
+	for k, i := range c {
+		v[k] = newIssueView(i)
+	}
+	writeJSON(w, newList(v, next))
+}
+
+func (s *Server) issueCounts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var ref uint
+	if q.Get("market") != "" {
+		m, err := s.market(q.Get("market"), q.Get("exchanger"))
+		if err != nil {
+			writeError(w, 0, err)
+			return
+		}
+		ref = m.ID
+	}
+	p, err := parsePage(r)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	c, err := s.ds.IssueCounts(ref, p.from, p.to)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	v := make([]*issueCountView, len(c))
+	for k, i := range c {
+		v[k] = &issueCountView{Rule: i.Rule, Action: i.Action, Count: i.Count}
+	}
+	writeJSON(w, &list{Data: v})
+}
+
+func (s *Server) completeness(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	p, err := parsePage(r)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	o := completeness.DefaultOptions(p.from, p.to)
+	o.Exchanger = q.Get("exchanger")
+	if q.Get("market") != "" {
+		m, err := s.market(q.Get("market"), q.Get("exchanger"))
+		if err != nil {
+			writeError(w, 0, err)
+			return
+		}
+		o.Exchanger, o.Market = m.Exchanger.Name, m.Name
+	}
+	for name, d := range map[string]*time.Duration{"tickers": &o.Tickers, "trades": &o.Trades} {
+		if v := q.Get(name); v != "" {
+			if *d, err = time.ParseDuration(v); err != nil || *d < 0 {
+				writeError(w, 0, badRequest{errors.New("bad " + name + ": " + v)})
+				return
+			}
+		}
+	}
+	if v := q.Get("candles"); v != "" {
+		if o.Candles, err = candle.ParseIntervals(v); err != nil {
+			writeError(w, 0, badRequest{errors.New("bad candles: " + v)})
+			return
+		}
+	}
+	if v := q.Get("tz"); v != "" {
+		if o.Loc, err = time.LoadLocation(v); err != nil {
+			writeError(w, 0, badRequest{errors.New("bad tz: " + v)})
+			return
+		}
+	}
+	c, err := completeness.Scan(s.ds, o)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	writeJSON(w, newCompletenessView(c))
+}
+
+// maxBody is the size limit of the request bodies
+const maxBody = 1 << 20
+
+func (s *Server) alertRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		rule, err := s.readAlertRule(w, r)
+		if err != nil {
+			writeError(w, 0, err)
+			return
+		}
+		if err = s.ds.SaveAlertRule(rule); err != nil {
+			writeError(w, 0, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newAlertRuleView(rule))
+		return
+	}
+	c, err := s.ds.AlertRules(false)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	v := make([]*alertRuleView, len(c))
+	for k, rule := range c {
+		v[k] = newAlertRuleView(rule)
+	}
+	writeJSON(w, &list{Data: v})
+}
+
+func (s *Server) alertRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/alerts/rules/"), 10, 64)
+	if err != nil {
+		writeError(w, 0, notFound{errors.New("no rule id in path")})
+		return
+	}
+	old, err := s.ds.AlertRule(uint(id))
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	switch r.Method {
+	case http.MethodDelete:
+		if err = s.ds.DeleteAlertRule(old.ID); err != nil {
+			writeError(w, 0, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPut:
+		rule, err := s.readAlertRule(w, r)
+		if err != nil {
+			writeError(w, 0, err)
+			return
+		}
+		rule.ID = old.ID
+		if err = s.ds.SaveAlertRule(rule); err != nil {
+			writeError(w, 0, err)
+			return
+		}
+		old = rule
+	case http.MethodPost:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	writeJSON(w, newAlertRuleView(old))
+}
+
+// readAlertRule decodes the rule of the request body and checks it
+func (s *Server) readAlertRule(w http.ResponseWriter, r *http.Request) (*common.AlertRule, error) {
+	in := &alertRuleInput{}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(in); err != nil {
+		return nil, badRequest{errors.New("bad rule: " + err.Error())}
+	}
+	if in.Market == "" {
+		return nil, badRequest{errors.New("market missing")}
+	}
+	m, err := s.market(in.Market, in.Exchanger)
+	if err != nil {
+		return nil, err
+	}
+	rule := &common.AlertRule{Name: in.Name, MarketRef: m.ID, Market: m, Metric: in.Metric, Op: in.Op,
+		Threshold: in.Threshold, Channel: in.Channel, Target: in.Target, Cooldown: in.Cooldown, Enabled: true}
+	if in.Enabled != nil {
+		rule.Enabled = *in.Enabled
+	}
+	if err = alert.CheckRule(rule); err != nil {
+		return nil, badRequest{err}
+	}
+	return rule, nil
+}
+
+func (s *Server) alerts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	id, err := strconv.ParseUint(q.Get("rule"), 10, 64)
+	if err != nil {
+		writeError(w, 0, badRequest{errors.New("bad rule: " + q.Get("rule"))})
+		return
+	}
+	p, err := parsePage(r)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	c, next, err := s.ds.Alerts(uint(id), p.from, p.to, p.after, p.limit)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+	v := make([]*alertView, len(c))
+	for k, a := range c {
+		v[k] = &alertView{Time: a.Time.UTC(), Rule: a.RuleRef, Value: a.Value, Message: a.Message,
+			Delivered: a.Delivered, Error: a.Error}
+	}
+	writeJSON(w, newList(v, next))
+}
+
+// pathMarket returns the market named by the path after prefix
+func (s *Server) pathMarket(r *http.Request, prefix string) (*common.Market, error) {
+	name := strings.TrimPrefix(r.URL.Path, prefix)
+	if name == "" || strings.Contains(name, "/") {
+		return nil, notFound{errors.New("no market in path")}
+	}
+	return s.market(name, r.URL.Query().Get("exchanger"))
+}
+
+// market finds the market of the id, of the name on exchanger, or of exchanger:name
+func (s *Server) market(name, exchanger string) (*common.Market, error) {
+	f := database.MarketFilter{Name: name, Exchanger: exchanger}
+	if k := strings.IndexByte(name, ':'); k >= 0 {
+		f.Exchanger, f.Name = name[:k], name[k+1:]
+	} else if id, err := strconv.ParseUint(name, 10, 64); err == nil {
+		f = database.MarketFilter{ID: uint(id)}
+	}
+	c, err := s.ds.Markets(f)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(c) == 0:
+		return nil, notFound{errors.New("unknown market " + name)}
+	case len(c) > 1:
+		return nil, badRequest{errors.New("market " + name + " on several exchangers, set the exchanger")}
+	}
+	return c[0], nil
+}
+
+type page struct {
+	from, to time.Time
+	after    *database.Cursor
+	limit    int
+}
+
+// parsePage reads the range and page parameters of r
+func parsePage(r *http.Request) (*page, error) {
+	q := r.URL.Query()
+	p := &page{to: time.Now()}
+	var err error
+	if t := q.Get("to"); t != "" {
+		if p.to, err = parseTime(t); err != nil {
+			return nil, badRequest{errors.New("bad to: " + t)}
+		}
+	}
+	p.from = p.to.Add(-DefaultRange)
+	if f := q.Get("from"); f != "" {
+		if p.from, err = parseTime(f); err != nil {
+			return nil, badRequest{errors.New("bad from: " + f)}
+		}
+	}
+	if !p.from.Before(p.to) {
+		return nil, badRequest{errors.New("from not before to")}
+	}
+	if c := q.Get("cursor"); c != "" {
+		if p.after, err = database.ParseCursor(c); err != nil {
+			return nil, badRequest{errors.New("bad cursor: " + c)}
+		}
+	}
+	p.limit, err = intParam(q.Get("limit"), MaxLimit)
+	if err != nil || p.limit <= 0 || p.limit > MaxLimit {
+		return nil, badRequest{errors.New("bad limit, 1 to " + strconv.Itoa(MaxLimit))}
+	}
+	return p, nil
+}
+
+// parseTime parses an RFC3339 time, a date in UTC or unix seconds
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
+
+func intParam(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func newList(data interface{}, next *database.Cursor) *list {
+	l := &list{Data: data}
+	if next != nil {
+		l.Next = next.String()
+	}
+	return l
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("server write error:", err)
+	}
+}
+
+// writeError writes err with status, or the status of its kind if status is 0.
+// The database errors are logged and hidden from the client.
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status == 0 {
+		switch err.(type) {
+		case badRequest:
+			status = http.StatusBadRequest
+		case notFound:
+			status = http.StatusNotFound
+		default:
+			if gorm.IsRecordNotFoundError(err) {
+				status, err = http.StatusNotFound, errors.New("no data")
+			} else {
+				log.Println("server query error:", err)
+				status, err = http.StatusInternalServerError, errors.New("internal error")
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/database"
+	"github.com/shopspring/decimal"
+)
+
+var dec = decimal.RequireFromString
+
+var start = time.Date(2018, 11, 26, 0, 0, 0, 0, time.UTC)
+
+// openTestServer serves a sqlite database with the DOGE-BTC market on two exchangers,
+// tickers, trades, candles and order books on the bittrex one from start, one a minute for 5 minutes
+func openTestServer(t *testing.T) (*Server, *database.DataStore, func()) {
+	dir, err := ioutil.TempDir("", "edserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := database.NewDataStore("sqlite3")
+	ds.Name = filepath.Join(dir, "test.db")
+	if err = ds.OpenDB(); err != nil {
+		t.Fatal("open db", err)
+	}
+	if err = ds.Migrate(); err != nil {
+		t.Fatal("migrate db", err)
+	}
+
+	btc := &common.Currency{Name: "bitcoin", Abbr: "BTC"}
+	doge := &common.Currency{Name: "dogecoin", Abbr: "DOGE"}
+	var markets []*common.Market
+	for _, ex := range []string{"bittrex", "poloniex"} {
+		m := &common.Market{Name: "DOGE-BTC", Symbol: &common.Symbol{Base: doge, Quote: btc}, Exchanger: &common.Exchanger{Name: ex}}
+		if err = ds.UpdateMarket(m).Error; err != nil {
+			t.Fatal("save market", err)
+		}
+		markets = append(markets, m)
+	}
+	m := markets[0]
+	w := ds.NewBatchWriter(0, 0)
+	w.SnapshotInterval = 0
+	for k := 0; k < 5; k++ {
+		at := start.Add(time.Duration(k) * time.Minute)
+		price := dec(strconv.Itoa(k + 1))
+		w.AddTicker(&common.Ticker{Time: at, Market: m, Last: price})
+		w.AddTrade(&common.Trade{Time: at, Market: m, OrderID: strconv.Itoa(k), Side: "buy", Price: price, Amount: dec("2")})
+		w.AddCandle(&common.Candle{Time: at, Market: m, Interval: "1m", Open: price, High: price, Low: price, Close: price, Trades: 1})
+		w.AddOrderBook(&common.OrderBook{Time: at, Market: m,
+			Bids: []*common.PriceVol{{Price: price, Volume: dec("1")}, {Price: dec("0.5"), Volume: dec("3")}},
+			Asks: []*common.PriceVol{{Price: price.Add(dec("1")), Volume: dec("1")}}})
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal("flush", err)
+	}
+	s := New(ds)
+	s.Token = testToken
+	return s, ds, func() {
+		ds.CloseDB()
+		os.RemoveAll(dir)
+	}
+}
+
+func get(t *testing.T, s http.Handler, url string, status int, out interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+	if rec.Code != status {
+		t.Fatalf("%s: status %d, %d expected, %s", url, rec.Code, status, rec.Body)
+	}
+	if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Fatalf("%s: CORS header %s by default", url, origin)
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+	}
+}
+
+const testToken = "s3cret"
+
+// send requests url with method, the JSON body and the token of the server, see get
+func send(t *testing.T, s http.Handler, method, url, body string, status int, out interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	s.ServeHTTP(rec, r)
+	if rec.Code != status {
+		t.Fatalf("%s %s: status %d, %d expected, %s", method, url, rec.Code, status, rec.Body)
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	s, ds, closeDB := openTestServer(t)
+	defer closeDB()
+
+	var exchangers struct{ Data []exchangerView }
+	get(t, s, "/exchangers", 200, &exchangers)
+	if len(exchangers.Data) != 2 {
+		t.Fatal("wrong exchangers", exchangers.Data)
+	}
+
+	var markets struct{ Data []marketView }
+	get(t, s, "/markets?currency=doge&exchanger=Bittrex", 200, &markets)
+	if len(markets.Data) != 1 || markets.Data[0].Base != "DOGE" || markets.Data[0].Exchanger != "bittrex" {
+		t.Fatal("wrong markets", markets.Data)
+	}
+	get(t, s, "/markets?active=maybe", 400, nil)
+
+	var tickers struct{ Data []tickerView }
+	get(t, s, "/tickers/bittrex:DOGE-BTC", 200, &tickers)
+	if len(tickers.Data) != 1 || !tickers.Data[0].Last.Equal(dec("5")) {
+		t.Fatal("wrong latest ticker", tickers.Data)
+	}
+	get(t, s, "/tickers?exchanger=bittrex", 200, &tickers)
+	if len(tickers.Data) != 1 || tickers.Data[0].Market != "DOGE-BTC" {
+		t.Fatal("wrong latest tickers", tickers.Data)
+	}
+	get(t, s, "/tickers/DOGE-BTC?exchanger=bittrex&from=2018-11-26&to=2018-11-26T00:03:00Z", 200, &tickers)
+	if len(tickers.Data) != 3 {
+		t.Fatal("wrong tickers in range", tickers.Data)
+	}
+	get(t, s, "/tickers/DOGE-BTC", 400, nil)          // on both exchangers
+	get(t, s, "/tickers/poloniex:DOGE-BTC", 404, nil) // no ticker
+	get(t, s, "/tickers/nowhere:DOGE-BTC", 404, nil)
+
+	// pages of 2 trades
+	var ids []string
+	url := "/trades/" + strconv.Itoa(int(markets.Data[0].ID)) + "?from=2018-11-26&to=1543276800&limit=2"
+	for pages := 0; ; pages++ {
+		var trades struct {
+			Data []tradeView
+			Next string
+		}
+		get(t, s, url, 200, &trades)
+		for _, tr := range trades.Data {
+			ids = append(ids, tr.OrderID)
+		}
+		if trades.Next == "" {
+			break
+		}
+		if pages > 3 {
+			t.Fatal("no last page")
+		}
+		url = "/trades/bittrex:DOGE-BTC?from=2018-11-26&to=1543276800&limit=2&cursor=" + trades.Next
+	}
+	if len(ids) != 5 || ids[0] != "0" || ids[4] != "4" {
+		t.Fatal("wrong trades", ids)
+	}
+	for _, bad := range []string{"limit=0", "limit=5000", "from=yesterday", "from=2018-11-27&to=2018-11-26", "cursor=x"} {
+		get(t, s, "/trades/bittrex:DOGE-BTC?"+bad, 400, nil)
+	}
+
+	var book struct{ Data bookView }
+	get(t, s, "/orderbook/bittrex:DOGE-BTC?at=2018-11-26T00:02:30Z&depth=1", 200, &book)
+	if !book.Data.Time.Equal(start.Add(2*time.Minute)) || len(book.Data.Bids) != 1 || !book.Data.Bids[0][0].Equal(dec("3")) {
+		t.Fatal("wrong order book", book.Data)
+	}
+	get(t, s, "/orderbook/bittrex:DOGE-BTC?at=2018-11-25", 404, nil)
+
+	var candles struct{ Data []candleView }
+	get(t, s, "/candles?market=bittrex:DOGE-BTC&from=2018-11-26&to=2018-11-27", 200, &candles)
+	if len(candles.Data) != 5 || candles.Data[4].Trades != 1 || !candles.Data[4].Close.Equal(dec("5")) {
+		t.Fatal("wrong candles", candles.Data)
+	}
+	get(t, s, "/candles?market=bittrex:DOGE-BTC&interval=5x", 400, nil)
+	get(t, s, "/candles", 400, nil)
+
+	c, err := ds.Markets(database.MarketFilter{Exchanger: "bittrex"})
+	if err != nil || len(c) != 1 {
+		t.Fatal("market", c, err)
+	}
+	m := c[0]
+	if err := ds.InsertIssues([]*common.QualityIssue{
+		{Time: start, Market: m, Kind: "ticker", Rule: "crossed_book", Action: "quarantine", Data: `{"bid":"2","ask":"1"}`},
+		{Time: start, Market: m, Kind: "trade", Rule: "outlier", Action: "annotate"},
+		{Time: start.Add(time.Minute), Market: m, Kind: "trade", Rule: "outlier", Action: "annotate"},
+	}); err != nil {
+		t.Fatal("insert issues", err)
+	}
+	var issues struct{ Data []issueView }
+	get(t, s, "/issues?market=bittrex:DOGE-BTC&rule=crossed_book&from=2018-11-26&to=2018-11-27", 200, &issues)
+	if len(issues.Data) != 1 || issues.Data[0].Action != "quarantine" || string(issues.Data[0].Data) != `{"bid":"2","ask":"1"}` {
+		t.Fatal("wrong issues", issues.Data)
+	}
+	var counts struct{ Data []issueCountView }
+	get(t, s, "/issues/counts?from=2018-11-26&to=2018-11-27", 200, &counts)
+	if len(counts.Data) != 2 || counts.Data[1].Rule != "outlier" || counts.Data[1].Count != 2 {
+		t.Fatal("wrong issue counts", counts.Data)
+	}
+	err = ds.InsertBookMetrics([]*common.BookMetrics{{Time: start, Market: m, Mid: dec("1.5"), Imbalance: dec("0.25"),
+		SellSlippage: decimal.NullDecimal{Decimal: dec("0.01"), Valid: true}}})
+	if err != nil {
+		t.Fatal("insert book metrics", err)
+	}
+	var metrics struct{ Data []bookMetricsView }
+	get(t, s, "/bookmetrics/bittrex:DOGE-BTC?from=2018-11-26&to=2018-11-27", 200, &metrics)
+	if len(metrics.Data) != 1 || !metrics.Data[0].Imbalance.Equal(dec("0.25")) || metrics.Data[0].BuySlippage != nil ||
+		metrics.Data[0].SellSlippage == nil || !metrics.Data[0].SellSlippage.Equal(dec("0.01")) {
+		t.Fatal("wrong book metrics", metrics.Data)
+	}
+
+	var inds struct {
+		Data []indicatorView
+		Next string
+	}
+	get(t, s, "/indicators?market=bittrex:DOGE-BTC&indicators=sma(2),rsi(2)&from=2018-11-26&to=2018-11-27&limit=3", 200, &inds)
+	if len(inds.Data) != 3 || len(inds.Data[0].Values) != 0 || inds.Data[1].Values["sma(2)"]["sma"] != 1.5 ||
+		inds.Data[2].Values["rsi(2)"]["rsi"] != 100 || inds.Next == "" {
+		t.Fatal("wrong indicators", inds.Data)
+	}
+	// the next page starts after the candles of the warmup
+	get(t, s, "/indicators?market=bittrex:DOGE-BTC&indicators=sma(2)&from=2018-11-26&to=2018-11-27&cursor="+inds.Next, 200, &inds)
+	if len(inds.Data) != 2 || inds.Data[0].Values["sma(2)"]["sma"] != 3.5 {
+		t.Fatal("wrong indicators page", inds.Data)
+	}
+	get(t, s, "/indicators?market=bittrex:DOGE-BTC&indicators=wma(2)", 400, nil)
+	get(t, s, "/indicators?market=bittrex:DOGE-BTC&indicators=sma(1e19)", 400, nil)
+	var report completenessView
+	get(t, s, "/completeness?market=bittrex:DOGE-BTC&from=2018-11-26&to=2018-11-27&trades=0&candles=1m", 200, &report)
+	if len(report.Days) != 2 || report.Days[0].Kind != "ticker" || report.Days[1].Records != 5 || len(report.Gaps) != 2 {
+		t.Fatal("wrong completeness", report.Days, len(report.Gaps))
+	}
+	get(t, s, "/completeness?tickers=-1m", 400, nil)
+
+	var rule alertRuleView
+	send(t, s, "POST", "/alerts/rules", `{"market": "bittrex:DOGE-BTC", "metric": "price", "op": "above", "threshold": "0.5",
+		"channel": "webhook", "target": "https://example.com/hook", "cooldown": 600}`, 201, &rule)
+	if rule.ID == 0 || rule.Exchanger != "bittrex" || !rule.Threshold.Equal(dec("0.5")) || !rule.Enabled {
+		t.Fatal("wrong rule", rule)
+	}
+	send(t, s, "POST", "/alerts/rules", `{"market": "DOGE-BTC", "metric": "price", "op": "above", "channel": "webhook",
+		"target": "https://example.com/hook"}`, 400, nil)
+	send(t, s, "POST", "/alerts/rules", `{"market": "bittrex:DOGE-BTC", "metric": "volume", "op": "above", "channel": "webhook",
+		"target": "https://example.com/hook"}`, 400, nil)
+	send(t, s, "POST", "/alerts/rules", `{"market": "bittrex:DOGE-BTC", "metric": "spread", "op": "above", "channel": "webhook",
+		"target": "http://169.254.169.254/latest/meta-data"}`, 400, nil)
+	for _, token := range []string{"", "Bearer wrong", testToken} {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("DELETE", "/alerts/rules/"+strconv.Itoa(int(rule.ID)), nil)
+		r.Header.Set("Authorization", token)
+		s.ServeHTTP(rec, r)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatal("deleted without the token", token, rec.Code)
+		}
+	}
+	ruleURL := "/alerts/rules/" + strconv.Itoa(int(rule.ID))
+	send(t, s, "PUT", ruleURL, `{"market": "DOGE-BTC", "exchanger": "poloniex", "metric": "no_data", "threshold": 300,
+		"channel": "email", "target": "ops@example.com", "enabled": false}`, 200, &rule)
+	if rule.Exchanger != "poloniex" || rule.Op != "above" || rule.Enabled {
+		t.Fatal("wrong replaced rule", rule)
+	}
+	var rules struct{ Data []alertRuleView }
+	get(t, s, "/alerts/rules", 200, &rules)
+	if len(rules.Data) != 1 || rules.Data[0].Channel != "email" {
+		t.Fatal("wrong rules", rules.Data)
+	}
+	if err := ds.InsertAlert(&common.Alert{Time: start, RuleRef: rule.ID, Value: dec("301"), Message: "no data", Delivered: true}); err != nil {
+		t.Fatal(err)
+	}
+	var alerts struct{ Data []alertView }
+	get(t, s, "/alerts?rule="+strconv.Itoa(int(rule.ID))+"&from=2018-11-26&to=2018-11-27", 200, &alerts)
+	if len(alerts.Data) != 1 || !alerts.Data[0].Value.Equal(dec("301")) || !alerts.Data[0].Delivered {
+		t.Fatal("wrong alerts", alerts.Data)
+	}
+	send(t, s, "POST", ruleURL, "{}", 405, nil)
+	send(t, s, "DELETE", ruleURL, "", 204, nil)
+	get(t, s, ruleURL, 404, nil)
+	send(t, s, "DELETE", ruleURL, "", 404, nil)
+
+	s.AllowOrigin = "https://example.com"
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("OPTIONS", "/trades/1", nil))
+	if methods := rec.Header().Get("Access-Control-Allow-Methods"); rec.Code != http.StatusNoContent ||
+		rec.Header().Get("Access-Control-Allow-Origin") != s.AllowOrigin ||
+		methods == "" || strings.Contains(methods, "POST") || strings.Contains(methods, "DELETE") {
+		t.Fatal("wrong preflight", rec.Code, methods)
+	}
+	s.AllowOrigin = ""
+	s.Token = "" // writes disabled
+	send(t, s, "DELETE", ruleURL, "", 401, nil)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("POST", "/markets", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatal("POST allowed", rec.Code)
+	}
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/completeness"
+	"github.com/shopspring/decimal"
+)
+
+// the JSON views of the records, a market is named by its exchanger and market names.
+// The decimals are strings to keep them exact.
+
+type exchangerView struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Info string `json:"info,omitempty"`
+}
+
+type marketView struct {
+	ID        uint            `json:"id"`
+	Exchanger string          `json:"exchanger"`
+	Name      string          `json:"name"`
+	Base      string          `json:"base"`
+	Quote     string          `json:"quote"`
+	Active    bool            `json:"active"`
+	Precision uint            `json:"precision"`
+	MinAmount decimal.Decimal `json:"min_amount"`
+	MaxAmount decimal.Decimal `json:"max_amount"`
+	MinStep   decimal.Decimal `json:"min_step"`
+}
+
+func newMarketView(m *common.Market) *marketView {
+	v := &marketView{ID: m.ID, Name: m.Name, Active: m.Active, Precision: m.Precision,
+		MinAmount: m.Limitation.Min, MaxAmount: m.Limitation.Max, MinStep: m.MinStep}
+	if m.Exchanger != nil {
+		v.Exchanger = m.Exchanger.Name
+	}
+	if m.Symbol != nil && m.Symbol.Base != nil && m.Symbol.Quote != nil {
+		v.Base, v.Quote = m.Symbol.Base.Abbr, m.Symbol.Quote.Abbr
+	}
+	return v
+}
+
+type tickerView struct {
+	Time          time.Time       `json:"time"`
+	Exchanger     string          `json:"exchanger"`
+	Market        string          `json:"market"`
+	Last          decimal.Decimal `json:"last"`
+	Bid           decimal.Decimal `json:"bid"`
+	BidVolume     decimal.Decimal `json:"bid_volume"`
+	Ask           decimal.Decimal `json:"ask"`
+	AskVolume     decimal.Decimal `json:"ask_volume"`
+	High          decimal.Decimal `json:"high"`
+	Low           decimal.Decimal `json:"low"`
+	Open          decimal.Decimal `json:"open"`
+	Close         decimal.Decimal `json:"close"`
+	PreviousClose decimal.Decimal `json:"previous_close"`
+	Change        decimal.Decimal `json:"change"`
+	Percentage    decimal.Decimal `json:"percentage"`
+	BaseVolume    decimal.Decimal `json:"base_volume"`
+	QuoteVolume   decimal.Decimal `json:"quote_volume"`
+}
+
+func newTickerView(c *common.Ticker) *tickerView {
+	ex, market := marketNames(c.Market)
+	return &tickerView{Time: c.Time.UTC(), Exchanger: ex, Market: market,
+		Last: c.Last, Bid: c.Bid, BidVolume: c.BidVolume, Ask: c.Ask, AskVolume: c.AskVolume,
+		High: c.High, Low: c.Low, Open: c.Open, Close: c.Close, PreviousClose: c.PreviousClose,
+		Change: c.Change, Percentage: c.Percentage, BaseVolume: c.BaseVolume, QuoteVolume: c.QuoteVolume}
+}
+
+type tradeView struct {
+	Time      time.Time       `json:"time"`
+	Exchanger string          `json:"exchanger"`
+	Market    string          `json:"market"`
+	OrderID   string          `json:"order_id"`
+	Type      string          `json:"type,omitempty"`
+	Side      string          `json:"side"`
+	Price     decimal.Decimal `json:"price"`
+	Amount    decimal.Decimal `json:"amount"`
+	Total     decimal.Decimal `json:"total"`
+}
+
+func newTradeView(c *common.Trade) *tradeView {
+	ex, market := marketNames(c.Market)
+	return &tradeView{Time: c.Time.UTC(), Exchanger: ex, Market: market, OrderID: c.OrderID,
+		Type: c.Type, Side: c.Side, Price: c.Price, Amount: c.Amount, Total: c.Total}
+}
+
+// level is a [price, volume] pair
+type level [2]decimal.Decimal
+
+type bookView struct {
+	Time      time.Time `json:"time"`
+	Exchanger string    `json:"exchanger"`
+	Market    string    `json:"market"`
+	Sequence  uint64    `json:"sequence"`
+	Bids      []level   `json:"bids"`
+	Asks      []level   `json:"asks"`
+}
+
+// newBookView is the order book ob of the market m, depth levels a side if depth is positive
+func newBookView(m *common.Market, ob *common.OrderBook, depth int) *bookView {
+	ex, market := marketNames(m)
+	side := func(c []*common.PriceVol) []level {
+		if depth > 0 && len(c) > depth {
+			c = c[:depth]
+		}
+		l := make([]level, len(c))
+		for k, p := range c {
+			l[k] = level{p.Price, p.Volume}
+		}
+		return l
+	}
+	return &bookView{Time: ob.Time.UTC(), Exchanger: ex, Market: market, Sequence: ob.Sequence,
+		Bids: side(ob.Bids), Asks: side(ob.Asks)}
+}
+
+type candleView struct {
+	Time        time.Time       `json:"time"`
+	Exchanger   string          `json:"exchanger"`
+	Market      string          `json:"market"`
+	Interval    string          `json:"interval"`
+	Open        decimal.Decimal `json:"open"`
+	High        decimal.Decimal `json:"high"`
+	Low         decimal.Decimal `json:"low"`
+	Close       decimal.Decimal `json:"close"`
+	Volume      decimal.Decimal `json:"volume"`
+	QuoteVolume decimal.Decimal `json:"quote_volume"`
+	Trades      uint            `json:"trades"`
+}
+
+func newCandleView(c *common.Candle) *candleView {
+	ex, market := marketNames(c.Market)
+	return &candleView{Time: c.Time.UTC(), Exchanger: ex, Market: market, Interval: c.Interval,
+		Open: c.Open, High: c.High, Low: c.Low, Close: c.Close, Volume: c.Volume, QuoteVolume: c.QuoteVolume, Trades: c.Trades}
+}
+
+type issueView struct {
+	Time      time.Time       `json:"time"`
+	Exchanger string          `json:"exchanger"`
+	Market    string          `json:"market"`
+	Kind      string          `json:"kind"`
+	Rule      string          `json:"rule"`
+	Action    string          `json:"action"`
+	Detail    string          `json:"detail"`
+	Data      json.RawMessage `json:"data,omitempty"` // the quarantined record
+}
+
+func newIssueView(c *common.QualityIssue) *issueView {
+	ex, market := marketNames(c.Market)
+	v := &issueView{Time: c.Time.UTC(), Exchanger: ex, Market: market, Kind: c.Kind, Rule: c.Rule, Action: c.Action, Detail: c.Detail}
+	if c.Data != "" {
+		v.Data = json.RawMessage(c.Data)
+	}
+	return v
+}
+
+type bookMetricsView struct {
+	Time         time.Time        `json:"time"`
+	Exchanger    string           `json:"exchanger"`
+	Market       string           `json:"market"`
+	Mid          decimal.Decimal  `json:"mid"`
+	Spread       decimal.Decimal  `json:"spread"`
+	Microprice   decimal.Decimal  `json:"microprice"`
+	Band         decimal.Decimal  `json:"band"`
+	BidDepth     decimal.Decimal  `json:"bid_depth"`
+	AskDepth     decimal.Decimal  `json:"ask_depth"`
+	Imbalance    decimal.Decimal  `json:"imbalance"`
+	Notional     decimal.Decimal  `json:"notional"`
+	BuySlippage  *decimal.Decimal `json:"buy_slippage"` // null when the book is not deep enough
+	SellSlippage *decimal.Decimal `json:"sell_slippage"`
+}
+
+func newBookMetricsView(c *common.BookMetrics) *bookMetricsView {
+	ex, market := marketNames(c.Market)
+	v := &bookMetricsView{Time: c.Time.UTC(), Exchanger: ex, Market: market, Mid: c.Mid, Spread: c.Spread,
+		Microprice: c.Microprice, Band: c.Band, BidDepth: c.BidDepth, AskDepth: c.AskDepth, Imbalance: c.Imbalance,
+		Notional: c.Notional}
+	if c.BuySlippage.Valid {
+		v.BuySlippage = &c.BuySlippage.Decimal
+	}
+	if c.SellSlippage.Valid {
+		v.SellSlippage = &c.SellSlippage.Decimal
+	}
+	return v
+}
+
+type indicatorView struct {
+	Time   time.Time                     `json:"time"`
+	Values map[string]map[string]float64 `json:"values"` // by indicator and field, the indicators after their warmup
+}
+
+func fieldValues(fields []string, values []float64) map[string]float64 {
+	v := make(map[string]float64, len(fields))
+	for k, f := range fields {
+		v[f] = values[k]
+	}
+	return v
+}
+
+type alertRuleView struct {
+	ID        uint            `json:"id"`
+	Name      string          `json:"name,omitempty"`
+	Exchanger string          `json:"exchanger"`
+	Market    string          `json:"market"`
+	Metric    string          `json:"metric"`
+	Op        string          `json:"op"`
+	Threshold decimal.Decimal `json:"threshold"`
+	Channel   string          `json:"channel"`
+	Target    string          `json:"target"`
+	Cooldown  int64           `json:"cooldown"` // seconds
+	Enabled   bool            `json:"enabled"`
+}
+
+func newAlertRuleView(r *common.AlertRule) *alertRuleView {
+	ex, market := marketNames(r.Market)
+	return &alertRuleView{ID: r.ID, Name: r.Name, Exchanger: ex, Market: market, Metric: r.Metric, Op: r.Op,
+		Threshold: r.Threshold, Channel: r.Channel, Target: r.Target, Cooldown: r.Cooldown, Enabled: r.Enabled}
+}
+
+// alertRuleInput is the body of the requests creating or replacing a rule, the market is named like
+// in the query parameters. A rule is enabled unless enabled is false.
+type alertRuleInput struct {
+	Name      string          `json:"name"`
+	Exchanger string          `json:"exchanger"`
+	Market    string          `json:"market"`
+	Metric    string          `json:"metric"`
+	Op        string          `json:"op"`
+	Threshold decimal.Decimal `json:"threshold"`
+	Channel   string          `json:"channel"`
+	Target    string          `json:"target"`
+	Cooldown  int64           `json:"cooldown"`
+	Enabled   *bool           `json:"enabled"`
+}
+
+type alertView struct {
+	Time      time.Time       `json:"time"`
+	Rule      uint            `json:"rule"`
+	Value     decimal.Decimal `json:"value"`
+	Message   string          `json:"message"`
+	Delivered bool            `json:"delivered"`
+	Error     string          `json:"error,omitempty"`
+}
+
+type issueCountView struct {
+	Rule   string `json:"rule"`
+	Action string `json:"action"`
+	Count  int    `json:"count"`
+}
+
+type completenessView struct {
+	From time.Time  `json:"from"`
+	To   time.Time  `json:"to"`
+	Days []*dayView `json:"days"`
+	Gaps []*gapView `json:"gaps"`
+}
+
+type dayView struct {
+	Exchanger    string  `json:"exchanger"`
+	Market       string  `json:"market"`
+	Kind         string  `json:"kind"`
+	Day          string  `json:"day"`
+	Records      int     `json:"records"`
+	Gaps         int     `json:"gaps"`
+	Missing      float64 `json:"missing"` // seconds
+	Completeness float64 `json:"completeness"`
+}
+
+type gapView struct {
+	Exchanger string    `json:"exchanger"`
+	Market    string    `json:"market"`
+	Kind      string    `json:"kind"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Causes    []string  `json:"causes"`
+	Fillable  bool      `json:"fillable"`
+}
+
+func newCompletenessView(r *completeness.Report) *completenessView {
+	v := &completenessView{From: r.From.UTC(), To: r.To.UTC(), Days: make([]*dayView, len(r.Days)), Gaps: make([]*gapView, len(r.Gaps))}
+	for k, d := range r.Days {
+		v.Days[k] = &dayView{Exchanger: d.Exchanger, Market: d.Market, Kind: d.Kind, Day: d.Day.Format("2006-01-02"),
+			Records: d.Records, Gaps: d.Gaps, Missing: d.Missing.Seconds(), Completeness: d.Completeness}
+	}
+	for k, g := range r.Gaps {
+		v.Gaps[k] = &gapView{Exchanger: g.Exchanger, Market: g.Market, Kind: g.Kind, From: g.From.UTC(), To: g.To.UTC(),
+			Causes: g.Causes, Fillable: g.Fillable}
+	}
+	return v
+}
+
+func marketNames(m *common.Market) (ex, market string) {
+	if m == nil {
+		return
+	}
+	if m.Exchanger != nil {
+		ex = m.Exchanger.Name
+	}
+	return ex, m.Name
+}
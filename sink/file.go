@@ -0,0 +1,353 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/klauspost/compress/zstd"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// file formats and compressions of FileConfig
+const (
+	FormatJSONL   = "jsonl"
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+
+	CompressNone = ""
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+
+	DefaultRowGroupSize = 8 << 20 // bytes of a parquet row group, a file keeps one in memory while it is written
+)
+
+// FileConfig configures a File sink. A parquet File keeps up to RowGroupSize in memory for each open file,
+// one by exchanger, market, kind and date: with a few hundred markets, row groups of tens of MB take gigabytes.
+type FileConfig struct {
+	Dir          string        // root of the exchanger/market/date partitions
+	Format       string        // FormatJSONL, FormatCSV or FormatParquet
+	Compression  string        // CompressNone, CompressGzip or CompressZstd, the column codec for parquet, snappy if none
+	MaxSize      int64         // bytes written to a file before it is rotated, 0 for no limit
+	MaxAge       time.Duration // time a file is written before it is rotated, 0 for no limit
+	RowGroupSize int64         // parquet only, 0 for DefaultRowGroupSize
+}
+
+// File writes the records as JSON Lines, CSV or Parquet files under Dir/exchanger/market/date,
+// one file for each kind of record: tickers, trades, orderbooks and candles.
+// The date is the UTC day of the record time, a partition keeps one file open for each date it gets records of,
+// the file of a date is closed once the records are two days later. Files are only appended,
+// a rotated file is never reopened, a new one named kind-HHMMSS-n is created instead.
+//
+// In CSV and parquet an order book is written as one row per price level.
+// A parquet file is only readable once closed, by a rotation or Close, and its size only grows
+// when a row group is written.
+type File struct {
+	conf FileConfig
+
+	mu    sync.Mutex
+	files map[string]*partFile // by exchanger/market/kind/date
+}
+
+// NewFile creates a File sink with conf, Dir is created if missing
+func NewFile(conf FileConfig) (*File, error) {
+	switch conf.Format {
+	case FormatJSONL, FormatCSV, FormatParquet:
+	default:
+		return nil, fmt.Errorf("not supported file format %s", conf.Format)
+	}
+	switch conf.Compression {
+	case CompressNone, CompressGzip, CompressZstd:
+	default:
+		return nil, fmt.Errorf("not supported file compression %s", conf.Compression)
+	}
+	if conf.RowGroupSize <= 0 {
+		conf.RowGroupSize = DefaultRowGroupSize
+	}
+	if err := os.MkdirAll(conf.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return &File{conf: conf, files: make(map[string]*partFile)}, nil
+}
+
+// partFile is the file a partition is written to
+type partFile struct {
+	date    string
+	opened  time.Time
+	written int64 // bytes before compression
+
+	f   *os.File
+	buf *bufio.Writer
+	z   io.WriteCloser // compressor, nil when not compressed
+	out io.Writer
+	csv *csv.Writer   // encodes the rows to row
+	row *bytes.Buffer // CSV rows of a record, written at once so their size is counted
+	pq  *writer.ParquetWriter
+}
+
+func (p *partFile) Write(b []byte) (int, error) {
+	n, err := p.out.Write(b)
+	p.written += int64(n)
+	return n, err
+}
+
+func (p *partFile) close() error {
+	var err error
+	if p.pq != nil {
+		err = p.pq.WriteStop()
+	}
+	if p.z != nil {
+		if e := p.z.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if e := p.buf.Flush(); e != nil && err == nil {
+		err = e
+	}
+	if e := p.f.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// record is one record of a kind, ready to encode
+type record interface {
+	partition() (exchanger, market string, t time.Time)
+	rows() [][]string           // CSV rows
+	parquetRows() []interface{} // rows of the parquet schema of the kind
+}
+
+// kind describes the files of a kind of record
+type kind struct {
+	name   string
+	header []string    // CSV header
+	schema interface{} // parquet row
+}
+
+var (
+	tickerKind = &kind{"tickers", tickerHeader, new(tickerRow)}
+	tradeKind  = &kind{"trades", tradeHeader, new(tradeRow)}
+	bookKind   = &kind{"orderbooks", bookHeader, new(bookRow)}
+	candleKind = &kind{"candles", candleHeader, new(candleRow)}
+)
+
+func (s *File) WriteTickers(c []*common.Ticker) error {
+	recs := make([]record, len(c))
+	for k, t := range c {
+		recs[k] = newTickerRecord(t)
+	}
+	return s.write(tickerKind, recs)
+}
+
+func (s *File) WriteTrades(c []*common.Trade) error {
+	recs := make([]record, len(c))
+	for k, t := range c {
+		recs[k] = newTradeRecord(t)
+	}
+	return s.write(tradeKind, recs)
+}
+
+func (s *File) WriteOrderBooks(c []*common.OrderBook) error {
+	recs := make([]record, len(c))
+	for k, b := range c {
+		recs[k] = newBookRecord(b)
+	}
+	return s.write(bookKind, recs)
+}
+
+func (s *File) WriteCandles(c []*common.Candle) error {
+	recs := make([]record, len(c))
+	for k, b := range c {
+		recs[k] = newCandleRecord(b)
+	}
+	return s.write(candleKind, recs)
+}
+
+// Close closes all the files
+func (s *File) Close() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, p := range s.files {
+		if e := p.close(); e != nil && err == nil {
+			err = e
+		}
+		delete(s.files, k)
+	}
+	return
+}
+
+func (s *File) write(k *kind, recs []record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range recs {
+		ex, market, t := r.partition()
+		p, err := s.file(ex, market, k, t)
+		if err != nil {
+			return err
+		}
+		if p.pq != nil {
+			for _, row := range r.parquetRows() {
+				if err = p.pq.Write(row); err != nil {
+					break
+				}
+			}
+		} else if p.csv != nil {
+			err = p.writeRows(r.rows())
+		} else {
+			var b []byte
+			if b, err = json.Marshal(r); err == nil {
+				_, err = p.Write(append(b, '\n'))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRows encodes rows as CSV and writes them to the file
+func (p *partFile) writeRows(rows [][]string) error {
+	p.row.Reset()
+	if err := p.csv.WriteAll(rows); err != nil {
+		return err
+	}
+	_, err := p.Write(p.row.Bytes())
+	return err
+}
+
+// file returns the file of the partition and date of a record at t, rotating the current one when it is full or too old
+func (s *File) file(ex, market string, k *kind, t time.Time) (*partFile, error) {
+	part := ex + "/" + market + "/" + k.name + "/"
+	date := t.UTC().Format("2006-01-02")
+	key := part + date
+	now := time.Now()
+
+	p := s.files[key]
+	if p != nil && ((s.conf.MaxSize > 0 && p.written >= s.conf.MaxSize) ||
+		(s.conf.MaxAge > 0 && now.Sub(p.opened) >= s.conf.MaxAge)) {
+		delete(s.files, key)
+		if err := p.close(); err != nil {
+			return nil, err
+		}
+		p = nil
+	}
+	if p != nil {
+		return p, nil
+	}
+	if err := s.closeBefore(part, t.UTC().AddDate(0, 0, -1).Format("2006-01-02"), now); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(s.conf.Dir, pathName(ex), pathName(market), date)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := s.create(dir, k.name, now)
+	if err != nil {
+		return nil, err
+	}
+	p = &partFile{date: date, opened: now, f: f, buf: bufio.NewWriterSize(f, 64<<10)}
+	p.out = p.buf
+	if s.conf.Format == FormatParquet {
+		if p.pq, err = s.parquetWriter(p, k.schema); err != nil {
+			f.Close()
+			return nil, err
+		}
+		s.files[key] = p
+		return p, nil
+	}
+	switch s.conf.Compression {
+	case CompressGzip:
+		p.z = gzip.NewWriter(p.buf)
+	case CompressZstd:
+		if p.z, err = zstd.NewWriter(p.buf); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if p.z != nil {
+		p.out = p.z
+	}
+	if s.conf.Format == FormatCSV {
+		p.row = new(bytes.Buffer)
+		p.csv = csv.NewWriter(p.row)
+		if err := p.writeRows([][]string{k.header}); err != nil {
+			p.close()
+			return nil, err
+		}
+	}
+	s.files[key] = p
+	return p, nil
+}
+
+// closeBefore closes the files of the partition part dated before date and the ones older than MaxAge,
+// a partition of late records keeps no more than the files of two days open
+func (s *File) closeBefore(part, date string, now time.Time) (err error) {
+	for key, p := range s.files {
+		if (strings.HasPrefix(key, part) && p.date < date) || (s.conf.MaxAge > 0 && now.Sub(p.opened) >= s.conf.MaxAge) {
+			delete(s.files, key)
+			if e := p.close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return
+}
+
+// create creates a new file of kind in dir, never an existing one
+func (s *File) create(dir, kind string, now time.Time) (*os.File, error) {
+	ext := "." + s.conf.Format
+	if s.conf.Format != FormatParquet { // parquet columns are compressed inside
+		switch s.conf.Compression {
+		case CompressGzip:
+			ext += ".gz"
+		case CompressZstd:
+			ext += ".zst"
+		}
+	}
+	base := kind + "-" + now.UTC().Format("150405")
+	for n := 0; ; n++ {
+		f, err := os.OpenFile(filepath.Join(dir, base+"-"+strconv.Itoa(n)+ext), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, err
+	}
+}
+
+// parquetWriter creates the parquet writer of p with the row type schema
+func (s *File) parquetWriter(p *partFile, schema interface{}) (*writer.ParquetWriter, error) {
+	pw, err := writer.NewParquetWriterFromWriter(p, schema, 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.RowGroupSize = s.conf.RowGroupSize
+	switch s.conf.Compression {
+	case CompressGzip:
+		pw.CompressionType = parquet.CompressionCodec_GZIP
+	case CompressZstd:
+		pw.CompressionType = parquet.CompressionCodec_ZSTD
+	default:
+		pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	}
+	return pw, nil
+}
+
+// pathName makes name usable as a directory name
+func pathName(name string) string {
+	return strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(name)
+}
@@ -0,0 +1,223 @@
+package sink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/klauspost/compress/zstd"
+	"github.com/shopspring/decimal"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/types"
+)
+
+var testMarket = &common.Market{Name: "BTC-LTC", Exchanger: &common.Exchanger{Name: "bittrex"}}
+
+// testTrades returns n trades a minute apart from 23:50 UTC, crossing midnight after 10
+func testTrades(n int) []*common.Trade {
+	start := time.Date(2018, 11, 22, 23, 50, 0, 0, time.UTC)
+	c := make([]*common.Trade, n)
+	for k := range c {
+		c[k] = &common.Trade{Time: start.Add(time.Duration(k) * time.Minute), OrderID: "order", Side: "buy",
+			Price: decimal.RequireFromString("0.01203001"), Amount: decimal.New(int64(k), 0), Market: testMarket}
+	}
+	return c
+}
+
+// readLines returns the lines of all the files under dir by the name of their date directory
+func readLines(t *testing.T, dir string) map[string][]string {
+	lines := make(map[string][]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		var r io.Reader = f
+		switch {
+		case strings.HasSuffix(path, ".gz"):
+			if r, err = gzip.NewReader(f); err != nil {
+				return err
+			}
+		case strings.HasSuffix(path, ".zst"):
+			d, err := zstd.NewReader(f)
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+			r = d
+		}
+		date := filepath.Base(filepath.Dir(path))
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			lines[date] = append(lines[date], s.Text())
+		}
+		return s.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func TestFileSink(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "exdata-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, conf := range []FileConfig{
+		{Format: FormatJSONL, Compression: CompressZstd},
+		{Format: FormatCSV, Compression: CompressGzip, MaxSize: 300},
+		{Format: FormatCSV},
+	} {
+		conf.Dir = filepath.Join(tmp, conf.Format+conf.Compression)
+		s, err := NewFile(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = s.WriteTrades(testTrades(15)); err != nil {
+			t.Fatal("write trades", err)
+		}
+		if err = s.Close(); err != nil {
+			t.Fatal("close", err)
+		}
+
+		files, _ := filepath.Glob(filepath.Join(conf.Dir, "bittrex", "BTC-LTC", "2018-11-22", "trades-*"))
+		if conf.MaxSize > 0 && len(files) < 2 {
+			t.Fatalf("%s %s: files not rotated by size", conf.Format, conf.Compression)
+		}
+
+		lines := readLines(t, conf.Dir)
+		first, second := lines["2018-11-22"], lines["2018-11-23"]
+		if conf.Format == FormatCSV { // each file starts with the header
+			first, second = dropHeaders(first), dropHeaders(second)
+		}
+		if len(first) != 10 || len(second) != 5 {
+			t.Fatalf("%s %s: trades not partitioned by date, %d and %d", conf.Format, conf.Compression, len(first), len(second))
+		}
+		if !strings.Contains(first[0], "0.01203001") {
+			t.Fatalf("%s %s: price not exact, %s", conf.Format, conf.Compression, first[0])
+		}
+	}
+}
+
+func TestFileDates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exdata-dates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFile(FileConfig{Dir: dir, Format: FormatCSV})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trades := testTrades(15)
+	for k := 0; k < 5; k++ { // records of both days in turn
+		if err = s.WriteTrades([]*common.Trade{trades[k], trades[10+k]}); err != nil {
+			t.Fatal("write trades", err)
+		}
+	}
+	if len(s.files) != 2 {
+		t.Fatal("files of the dates not kept open", len(s.files))
+	}
+	size := len(strings.Join(tradeHeader, ",")) + 1
+	for _, c := range trades[10:] {
+		size += len(strings.Join(newTradeRecord(c).rows()[0], ",")) + 1
+	}
+	if p := s.files["bittrex/BTC-LTC/trades/2018-11-23"]; p == nil || p.written != int64(size) {
+		t.Fatal("written size not counted", p, size)
+	}
+	late := *trades[0]
+	late.Time = late.Time.AddDate(0, 0, 2)
+	if err = s.WriteTrades([]*common.Trade{&late}); err != nil {
+		t.Fatal("write trades", err)
+	}
+	if len(s.files) != 2 || s.files["bittrex/BTC-LTC/trades/2018-11-22"] != nil {
+		t.Fatal("file of an old date not closed", len(s.files))
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal("close", err)
+	}
+
+	for _, date := range []string{"2018-11-22", "2018-11-23", "2018-11-24"} {
+		files, _ := filepath.Glob(filepath.Join(dir, "bittrex", "BTC-LTC", date, "trades-*"))
+		if len(files) != 1 {
+			t.Fatal("files rotated on a date switch", date, files)
+		}
+	}
+	lines := readLines(t, dir)
+	if len(dropHeaders(lines["2018-11-22"])) != 5 || len(dropHeaders(lines["2018-11-23"])) != 5 || len(dropHeaders(lines["2018-11-24"])) != 1 {
+		t.Fatal("trades not partitioned by date", lines)
+	}
+}
+
+func dropHeaders(lines []string) []string {
+	rows := []string{}
+	for _, l := range lines {
+		if l != strings.Join(tradeHeader, ",") {
+			rows = append(rows, l)
+		}
+	}
+	return rows
+}
+
+func TestParquetFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exdata-parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFile(FileConfig{Dir: dir, Format: FormatParquet, Compression: CompressZstd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.WriteTrades(testTrades(15)); err != nil {
+		t.Fatal("write trades", err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal("close", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "bittrex", "BTC-LTC", "2018-11-22", "trades-*.parquet"))
+	if len(files) != 1 {
+		t.Fatal("parquet file not written", files)
+	}
+	f, err := local.NewLocalFileReader(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := reader.NewParquetReader(f, new(tradeRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.ReadStop()
+	rows := make([]tradeRow, r.GetNumRows())
+	if err = r.Read(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 10 {
+		t.Fatalf("%d rows of the day, 10 expected", len(rows))
+	}
+	if p := types.DECIMAL_BYTE_ARRAY_ToString([]byte(rows[3].Price), 36, 18); !decimal.RequireFromString(p).Equal(decimal.RequireFromString("0.01203001")) {
+		t.Fatal("price not exact", p)
+	}
+	if rows[3].Market != "BTC-LTC" || parquetTime(testTrades(4)[3].Time) != rows[3].Time {
+		t.Fatalf("row not stored, %+v", rows[3])
+	}
+}
@@ -0,0 +1,81 @@
+package sink
+
+import (
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/database"
+)
+
+// Gorm stores the records in a DataStore through a database.BatchWriter
+type Gorm struct {
+	ds *database.DataStore
+	w  *database.BatchWriter
+}
+
+// NewGorm creates a Gorm sink on ds, zero size or interval take the BatchWriter defaults.
+// The DataStore stays open when the sink is closed.
+func NewGorm(ds *database.DataStore, size int, interval time.Duration) *Gorm {
+	return &Gorm{ds: ds, w: ds.NewBatchWriter(size, interval)}
+}
+
+// Writer returns the BatchWriter of the sink
+func (g *Gorm) Writer() *database.BatchWriter {
+	return g.w
+}
+
+func (g *Gorm) WriteTickers(c []*common.Ticker) (err error) {
+	for _, t := range c {
+		if e := g.w.AddTicker(t); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (g *Gorm) WriteTrades(c []*common.Trade) (err error) {
+	for _, t := range c {
+		if e := g.w.AddTrade(t); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (g *Gorm) WriteOrderBooks(c []*common.OrderBook) (err error) {
+	for _, b := range c {
+		if e := g.w.AddOrderBook(b); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (g *Gorm) WriteCandles(c []*common.Candle) (err error) {
+	for _, b := range c {
+		if e := g.w.AddCandle(b); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// WriteBookDeltas stores the changes c of the order book of m through the BatchWriter, see sink.DeltaWriter
+func (g *Gorm) WriteBookDeltas(m *common.Market, c []*common.BookDelta) error {
+	return g.w.AddBookDeltas(m, c)
+}
+
+// WriteConsolidated stores c at once, not batched, see sink.ConsolidatedWriter
+func (g *Gorm) WriteConsolidated(c []*common.ConsolidatedTicker) error {
+	return g.ds.InsertConsolidated(c)
+}
+
+// WriteBookMetrics stores c at once, not batched, see sink.BookMetricsWriter
+func (g *Gorm) WriteBookMetrics(c []*common.BookMetrics) error {
+	return g.ds.InsertBookMetrics(c)
+}
+
+// Close flushes the buffered records
+func (g *Gorm) Close() error {
+	return g.w.Close()
+}
@@ -0,0 +1,120 @@
+package sink
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/xitongsys/parquet-go/types"
+)
+
+// The parquet rows follow the common structs, the decimals keep the scale and precision of the database
+// columns as DECIMAL(36,18) in 16 bytes, the times are UTC timestamps in microseconds.
+// The exchanger and market names are dictionary encoded.
+
+// decimalScale is the scale of database.DecimalType
+const decimalScale = 18
+
+// parquetDecimal returns d as the unscaled big endian two's complement of the DECIMAL columns,
+// the digits beyond decimalScale are truncated
+func parquetDecimal(d decimal.Decimal) string {
+	return types.StrIntToBinary(d.Shift(decimalScale).BigInt().String(), "BigEndian", 16, true)
+}
+
+func parquetTime(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Microsecond)
+}
+
+type tickerRow struct {
+	Time          int64  `parquet:"name=time, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+	Exchanger     string `parquet:"name=exchanger, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Market        string `parquet:"name=market, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Last          string `parquet:"name=last, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	Bid           string `parquet:"name=bid, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	BidVolume     string `parquet:"name=bid_volume, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	Ask           string `parquet:"name=ask, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	AskVolume     string `parquet:"name=ask_volume, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	High          string `parquet:"name=high, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	Low           string `parquet:"name=low, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	Open          string `parquet:"name=open, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	Close         string `parquet:"name=close, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	PreviousClose string `parquet:"name=previous_close, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	Change        string `parquet:"name=change, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	Percentage    string `parquet:"name=percentage, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	Average       string `parquet:"name=average, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	BaseVolume    string `parquet:"name=base_volume, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	QuoteVolume   string `parquet:"name=quote_volume, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+}
+
+func (r *tickerRecord) parquetRows() []interface{} {
+	return []interface{}{&tickerRow{Time: parquetTime(r.Time), Exchanger: r.Exchanger, Market: r.Market,
+		Last: parquetDecimal(r.Last), Bid: parquetDecimal(r.Bid), BidVolume: parquetDecimal(r.BidVolume),
+		Ask: parquetDecimal(r.Ask), AskVolume: parquetDecimal(r.AskVolume), High: parquetDecimal(r.High), Low: parquetDecimal(r.Low),
+		Open: parquetDecimal(r.Open), Close: parquetDecimal(r.Close), PreviousClose: parquetDecimal(r.PreviousClose),
+		Change: parquetDecimal(r.Change), Percentage: parquetDecimal(r.Percentage), Average: parquetDecimal(r.Average),
+		BaseVolume: parquetDecimal(r.BaseVolume), QuoteVolume: parquetDecimal(r.QuoteVolume)}}
+}
+
+type tradeRow struct {
+	Time      int64  `parquet:"name=time, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+	Exchanger string `parquet:"name=exchanger, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Market    string `parquet:"name=market, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	OrderID   string `parquet:"name=order_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Type      string `parquet:"name=type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Side      string `parquet:"name=side, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Price     string `parquet:"name=price, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	Amount    string `parquet:"name=amount, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	Total     string `parquet:"name=total, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+}
+
+func (r *tradeRecord) parquetRows() []interface{} {
+	return []interface{}{&tradeRow{Time: parquetTime(r.Time), Exchanger: r.Exchanger, Market: r.Market,
+		OrderID: r.OrderID, Type: r.Type, Side: r.Side,
+		Price: parquetDecimal(r.Price), Amount: parquetDecimal(r.Amount), Total: parquetDecimal(r.Total)}}
+}
+
+// bookRow is one price level of an order book snapshot
+type bookRow struct {
+	Time      int64  `parquet:"name=time, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+	Exchanger string `parquet:"name=exchanger, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Market    string `parquet:"name=market, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Sequence  int64  `parquet:"name=sequence, type=INT64, convertedtype=UINT_64"`
+	Side      string `parquet:"name=side, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Level     int32  `parquet:"name=level, type=INT32, convertedtype=UINT_32"`
+	Price     string `parquet:"name=price, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	Volume    string `parquet:"name=volume, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+}
+
+func (r *bookRecord) parquetRows() []interface{} {
+	rows := make([]interface{}, 0, len(r.Bids)+len(r.Asks))
+	t := parquetTime(r.Time)
+	for _, side := range []struct {
+		name   string
+		levels [][2]decimal.Decimal
+	}{{"bid", r.Bids}, {"ask", r.Asks}} {
+		for k, l := range side.levels {
+			rows = append(rows, &bookRow{Time: t, Exchanger: r.Exchanger, Market: r.Market, Sequence: int64(r.Sequence),
+				Side: side.name, Level: int32(k), Price: parquetDecimal(l[0]), Volume: parquetDecimal(l[1])})
+		}
+	}
+	return rows
+}
+
+type candleRow struct {
+	Time        int64  `parquet:"name=time, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+	Exchanger   string `parquet:"name=exchanger, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Market      string `parquet:"name=market, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Interval    string `parquet:"name=interval, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Open        string `parquet:"name=open, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	High        string `parquet:"name=high, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	Low         string `parquet:"name=low, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	Close       string `parquet:"name=close, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	Volume      string `parquet:"name=volume, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	QuoteVolume string `parquet:"name=quote_volume, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, scale=18, precision=36, length=16"`
+	Trades      int64  `parquet:"name=trades, type=INT64, convertedtype=UINT_64"`
+}
+
+func (r *candleRecord) parquetRows() []interface{} {
+	return []interface{}{&candleRow{Time: parquetTime(r.Time), Exchanger: r.Exchanger, Market: r.Market, Interval: r.Interval,
+		Open: parquetDecimal(r.Open), High: parquetDecimal(r.High), Low: parquetDecimal(r.Low), Close: parquetDecimal(r.Close),
+		Volume: parquetDecimal(r.Volume), QuoteVolume: parquetDecimal(r.QuoteVolume), Trades: int64(r.Trades)}}
+}
@@ -0,0 +1,163 @@
+package sink
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/shopspring/decimal"
+)
+
+// the flat records of the file sink, a market is named by its exchanger and market names.
+// The decimals are written as strings to keep them exact.
+
+var tickerHeader = []string{"time", "exchanger", "market", "last", "bid", "bid_volume", "ask", "ask_volume",
+	"high", "low", "open", "close", "previous_close", "change", "percentage", "average", "base_volume", "quote_volume"}
+
+type tickerRecord struct {
+	Time          time.Time       `json:"time"`
+	Exchanger     string          `json:"exchanger"`
+	Market        string          `json:"market"`
+	Last          decimal.Decimal `json:"last"`
+	Bid           decimal.Decimal `json:"bid"`
+	BidVolume     decimal.Decimal `json:"bid_volume"`
+	Ask           decimal.Decimal `json:"ask"`
+	AskVolume     decimal.Decimal `json:"ask_volume"`
+	High          decimal.Decimal `json:"high"`
+	Low           decimal.Decimal `json:"low"`
+	Open          decimal.Decimal `json:"open"`
+	Close         decimal.Decimal `json:"close"`
+	PreviousClose decimal.Decimal `json:"previous_close"`
+	Change        decimal.Decimal `json:"change"`
+	Percentage    decimal.Decimal `json:"percentage"`
+	Average       decimal.Decimal `json:"average"`
+	BaseVolume    decimal.Decimal `json:"base_volume"`
+	QuoteVolume   decimal.Decimal `json:"quote_volume"`
+}
+
+func newTickerRecord(c *common.Ticker) *tickerRecord {
+	ex, market := common.MarketNames(c.MarketRef, c.Market)
+	return &tickerRecord{Time: c.Time.UTC(), Exchanger: ex, Market: market,
+		Last: c.Last, Bid: c.Bid, BidVolume: c.BidVolume, Ask: c.Ask, AskVolume: c.AskVolume,
+		High: c.High, Low: c.Low, Open: c.Open, Close: c.Close, PreviousClose: c.PreviousClose,
+		Change: c.Change, Percentage: c.Percentage, Average: c.Average,
+		BaseVolume: c.BaseVolume, QuoteVolume: c.QuoteVolume}
+}
+
+func (r *tickerRecord) partition() (string, string, time.Time) {
+	return r.Exchanger, r.Market, r.Time
+}
+
+func (r *tickerRecord) rows() [][]string {
+	return [][]string{{formatTime(r.Time), r.Exchanger, r.Market, r.Last.String(), r.Bid.String(), r.BidVolume.String(),
+		r.Ask.String(), r.AskVolume.String(), r.High.String(), r.Low.String(), r.Open.String(), r.Close.String(),
+		r.PreviousClose.String(), r.Change.String(), r.Percentage.String(), r.Average.String(),
+		r.BaseVolume.String(), r.QuoteVolume.String()}}
+}
+
+var tradeHeader = []string{"time", "exchanger", "market", "order_id", "type", "side", "price", "amount", "total"}
+
+type tradeRecord struct {
+	Time      time.Time       `json:"time"`
+	Exchanger string          `json:"exchanger"`
+	Market    string          `json:"market"`
+	OrderID   string          `json:"order_id"`
+	Type      string          `json:"type"`
+	Side      string          `json:"side"`
+	Price     decimal.Decimal `json:"price"`
+	Amount    decimal.Decimal `json:"amount"`
+	Total     decimal.Decimal `json:"total"`
+}
+
+func newTradeRecord(c *common.Trade) *tradeRecord {
+	ex, market := common.MarketNames(c.MarketRef, c.Market)
+	return &tradeRecord{Time: c.Time.UTC(), Exchanger: ex, Market: market, OrderID: c.OrderID,
+		Type: c.Type, Side: c.Side, Price: c.Price, Amount: c.Amount, Total: c.Total}
+}
+
+func (r *tradeRecord) partition() (string, string, time.Time) {
+	return r.Exchanger, r.Market, r.Time
+}
+
+func (r *tradeRecord) rows() [][]string {
+	return [][]string{{formatTime(r.Time), r.Exchanger, r.Market, r.OrderID, r.Type, r.Side,
+		r.Price.String(), r.Amount.String(), r.Total.String()}}
+}
+
+var bookHeader = []string{"time", "exchanger", "market", "sequence", "side", "level", "price", "volume"}
+
+// bookRecord is a whole order book, the levels are [price, volume] pairs from the best price
+type bookRecord struct {
+	Time      time.Time            `json:"time"`
+	Exchanger string               `json:"exchanger"`
+	Market    string               `json:"market"`
+	Sequence  uint64               `json:"sequence"`
+	Bids      [][2]decimal.Decimal `json:"bids"`
+	Asks      [][2]decimal.Decimal `json:"asks"`
+}
+
+func newBookRecord(c *common.OrderBook) *bookRecord {
+	ex, market := common.MarketNames(c.MarketRef, c.Market)
+	r := &bookRecord{Time: c.Time.UTC(), Exchanger: ex, Market: market, Sequence: c.Sequence,
+		Bids: make([][2]decimal.Decimal, len(c.Bids)), Asks: make([][2]decimal.Decimal, len(c.Asks))}
+	for k, l := range c.Bids {
+		r.Bids[k] = [2]decimal.Decimal{l.Price, l.Volume}
+	}
+	for k, l := range c.Asks {
+		r.Asks[k] = [2]decimal.Decimal{l.Price, l.Volume}
+	}
+	return r
+}
+
+func (r *bookRecord) partition() (string, string, time.Time) {
+	return r.Exchanger, r.Market, r.Time
+}
+
+func (r *bookRecord) rows() [][]string {
+	rows := make([][]string, 0, len(r.Bids)+len(r.Asks))
+	t, seq := formatTime(r.Time), strconv.FormatUint(r.Sequence, 10)
+	for _, side := range []struct {
+		name   string
+		levels [][2]decimal.Decimal
+	}{{"bid", r.Bids}, {"ask", r.Asks}} {
+		for k, l := range side.levels {
+			rows = append(rows, []string{t, r.Exchanger, r.Market, seq, side.name, strconv.Itoa(k), l[0].String(), l[1].String()})
+		}
+	}
+	return rows
+}
+
+var candleHeader = []string{"time", "exchanger", "market", "interval", "open", "high", "low", "close", "volume", "quote_volume", "trades"}
+
+type candleRecord struct {
+	Time        time.Time       `json:"time"`
+	Exchanger   string          `json:"exchanger"`
+	Market      string          `json:"market"`
+	Interval    string          `json:"interval"`
+	Open        decimal.Decimal `json:"open"`
+	High        decimal.Decimal `json:"high"`
+	Low         decimal.Decimal `json:"low"`
+	Close       decimal.Decimal `json:"close"`
+	Volume      decimal.Decimal `json:"volume"`
+	QuoteVolume decimal.Decimal `json:"quote_volume"`
+	Trades      uint            `json:"trades"`
+}
+
+func newCandleRecord(c *common.Candle) *candleRecord {
+	ex, market := common.MarketNames(c.MarketRef, c.Market)
+	return &candleRecord{Time: c.Time.UTC(), Exchanger: ex, Market: market, Interval: c.Interval,
+		Open: c.Open, High: c.High, Low: c.Low, Close: c.Close, Volume: c.Volume, QuoteVolume: c.QuoteVolume, Trades: c.Trades}
+}
+
+func (r *candleRecord) partition() (string, string, time.Time) {
+	return r.Exchanger, r.Market, r.Time
+}
+
+func (r *candleRecord) rows() [][]string {
+	return [][]string{{formatTime(r.Time), r.Exchanger, r.Market, r.Interval, r.Open.String(), r.High.String(), r.Low.String(),
+		r.Close.String(), r.Volume.String(), r.QuoteVolume.String(), strconv.FormatUint(uint64(r.Trades), 10)}}
+}
+
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,134 @@
+// Package sink defines where the market data received from the exchangers goes.
+package sink
+
+import (
+	"github.com/exchangedata/common"
+)
+
+// Sink stores or forwards market data. The records refer to their market with Market or MarketRef.
+// A sink may buffer the records, Close writes what is left and releases the sink.
+type Sink interface {
+	WriteTickers(c []*common.Ticker) error
+	WriteTrades(c []*common.Trade) error
+	WriteOrderBooks(c []*common.OrderBook) error
+	WriteCandles(c []*common.Candle) error
+	Close() error
+}
+
+// DeltaWriter is implemented by the sinks taking the order book changes of streaming sources as they come.
+// The deltas are of the market m, in Sequence order.
+type DeltaWriter interface {
+	WriteBookDeltas(m *common.Market, c []*common.BookDelta) error
+}
+
+// ConsolidatedWriter is implemented by the sinks taking the consolidated tickers of the symbols traded
+// on several exchangers
+type ConsolidatedWriter interface {
+	WriteConsolidated(c []*common.ConsolidatedTicker) error
+}
+
+// OpportunityWriter is implemented by the sinks taking the arbitrage opportunities found in the order books
+type OpportunityWriter interface {
+	WriteOpportunities(c []*common.Opportunity) error
+}
+
+// IndicatorWriter is implemented by the sinks taking the technical indicators computed over the live candles
+type IndicatorWriter interface {
+	WriteIndicators(c []*common.IndicatorValue) error
+}
+
+// BookMetricsWriter is implemented by the sinks taking the metrics of the order books
+type BookMetricsWriter interface {
+	WriteBookMetrics(c []*common.BookMetrics) error
+}
+
+// Multi writes the same records to all of its sinks.
+// A failing sink does not stop the others, the first error is returned.
+type Multi []Sink
+
+// NewMulti returns a Sink writing to all sinks, the sink itself when there is only one
+func NewMulti(sinks ...Sink) Sink {
+	if len(sinks) == 1 {
+		return sinks[0]
+	}
+	return Multi(sinks)
+}
+
+func (m Multi) each(f func(s Sink) error) (err error) {
+	for _, s := range m {
+		if e := f(s); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (m Multi) WriteTickers(c []*common.Ticker) error {
+	return m.each(func(s Sink) error { return s.WriteTickers(c) })
+}
+
+func (m Multi) WriteTrades(c []*common.Trade) error {
+	return m.each(func(s Sink) error { return s.WriteTrades(c) })
+}
+
+func (m Multi) WriteOrderBooks(c []*common.OrderBook) error {
+	return m.each(func(s Sink) error { return s.WriteOrderBooks(c) })
+}
+
+func (m Multi) WriteCandles(c []*common.Candle) error {
+	return m.each(func(s Sink) error { return s.WriteCandles(c) })
+}
+
+// WriteBookDeltas writes c to the sinks implementing DeltaWriter
+func (m Multi) WriteBookDeltas(market *common.Market, c []*common.BookDelta) error {
+	return m.each(func(s Sink) error {
+		if d, ok := s.(DeltaWriter); ok {
+			return d.WriteBookDeltas(market, c)
+		}
+		return nil
+	})
+}
+
+// WriteConsolidated writes c to the sinks implementing ConsolidatedWriter
+func (m Multi) WriteConsolidated(c []*common.ConsolidatedTicker) error {
+	return m.each(func(s Sink) error {
+		if w, ok := s.(ConsolidatedWriter); ok {
+			return w.WriteConsolidated(c)
+		}
+		return nil
+	})
+}
+
+// WriteOpportunities writes c to the sinks implementing OpportunityWriter
+func (m Multi) WriteOpportunities(c []*common.Opportunity) error {
+	return m.each(func(s Sink) error {
+		if w, ok := s.(OpportunityWriter); ok {
+			return w.WriteOpportunities(c)
+		}
+		return nil
+	})
+}
+
+// WriteIndicators writes c to the sinks implementing IndicatorWriter
+func (m Multi) WriteIndicators(c []*common.IndicatorValue) error {
+	return m.each(func(s Sink) error {
+		if w, ok := s.(IndicatorWriter); ok {
+			return w.WriteIndicators(c)
+		}
+		return nil
+	})
+}
+
+// WriteBookMetrics writes c to the sinks implementing BookMetricsWriter
+func (m Multi) WriteBookMetrics(c []*common.BookMetrics) error {
+	return m.each(func(s Sink) error {
+		if w, ok := s.(BookMetricsWriter); ok {
+			return w.WriteBookMetrics(c)
+		}
+		return nil
+	})
+}
+
+func (m Multi) Close() error {
+	return m.each(func(s Sink) error { return s.Close() })
+}
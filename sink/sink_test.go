@@ -0,0 +1,64 @@
+package sink
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/exchangedata/common"
+)
+
+// countSink counts the records written, it fails every write when err is set
+type countSink struct {
+	tickers, trades, books, candles int
+	closed                          bool
+	err                             error
+}
+
+func (s *countSink) WriteTickers(c []*common.Ticker) error {
+	s.tickers += len(c)
+	return s.err
+}
+
+func (s *countSink) WriteTrades(c []*common.Trade) error {
+	s.trades += len(c)
+	return s.err
+}
+
+func (s *countSink) WriteOrderBooks(c []*common.OrderBook) error {
+	s.books += len(c)
+	return s.err
+}
+
+func (s *countSink) WriteCandles(c []*common.Candle) error {
+	s.candles += len(c)
+	return s.err
+}
+
+func (s *countSink) Close() error {
+	s.closed = true
+	return s.err
+}
+
+func TestMulti(t *testing.T) {
+	failing := &countSink{err: errors.New("sink down")}
+	good := &countSink{}
+	s := NewMulti(failing, good)
+
+	if err := s.WriteTickers([]*common.Ticker{{}, {}}); err != failing.err {
+		t.Fatal("error of the failing sink expected, got", err)
+	}
+	s.WriteTrades([]*common.Trade{{}})
+	s.WriteOrderBooks([]*common.OrderBook{{}})
+	s.WriteCandles([]*common.Candle{{}, {}, {}})
+	s.Close()
+
+	for _, c := range []*countSink{failing, good} {
+		if c.tickers != 2 || c.trades != 1 || c.books != 1 || c.candles != 3 || !c.closed {
+			t.Fatalf("records not written to every sink, %+v", c)
+		}
+	}
+
+	if NewMulti(good) != Sink(good) {
+		t.Fatal("a single sink should not be wrapped")
+	}
+}
@@ -0,0 +1,110 @@
+package stream
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// client is a websocket connection, or an in-process subscriber without conn. subs is guarded by the hub lock
+type client struct {
+	conn *websocket.Conn
+	addr string      // for the logs
+	send chan []byte // closed by the hub when the client is dropped
+	subs map[string]bool
+}
+
+type request struct {
+	Op       string   `json:"op"`
+	Channels []string `json:"channels"`
+}
+
+// subscribed tells if a subscription of c matches the channel ch
+func (c *client) subscribed(ch string) bool {
+	for p := range c.subs {
+		if matchChannel(p, ch) {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribedOther tells if a subscription of c other than pattern matches the channel ch
+func (c *client) subscribedOther(ch, pattern string) bool {
+	for p := range c.subs {
+		if p != pattern && matchChannel(p, ch) {
+			return true
+		}
+	}
+	return false
+}
+
+// readLoop handles the requests of c until its connection fails
+func (h *Hub) readLoop(c *client) {
+	defer func() {
+		h.mu.Lock()
+		h.drop(c)
+		h.mu.Unlock()
+	}()
+	c.conn.SetReadLimit(maxRequest)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req request
+		if err = json.Unmarshal(data, &req); err != nil {
+			h.replyError(c, "bad request: "+err.Error())
+			continue
+		}
+		switch req.Op {
+		case "subscribe":
+			if err = h.subscribe(c, req.Channels); err != nil {
+				h.replyError(c, err.Error())
+			}
+		case "unsubscribe":
+			h.unsubscribe(c, req.Channels)
+		default:
+			h.replyError(c, "unknown op "+req.Op+", subscribe or unsubscribe expected")
+		}
+	}
+}
+
+func (h *Hub) replyError(c *client, msg string) {
+	data, _ := json.Marshal(&replyMsg{Type: "error", Error: msg})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.send(c, data)
+}
+
+// writeLoop writes the messages queued for c and pings it, it closes the connection when the hub drops c
+func (c *client) writeLoop() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,352 @@
+// Package stream pushes the market events of the bus to websocket clients.
+//
+// A client subscribes to channels exchanger:market:type, type is ticker, trade, book, depth, candle or indicator,
+// * matches any exchanger, market or type. The consolidated tickers of a symbol are on consolidated:BTC_USDT:bbo,
+// the arbitrage opportunities on arbitrage:cross:opportunity and arbitrage:triangular:opportunity:
+//
+//	{"op": "subscribe", "channels": ["bittrex:USDT-BTC:book", "bittrex:*:trade"]}
+//	{"op": "unsubscribe", "channels": ["bittrex:*:trade"]}
+//
+// The replies are {"type": "subscribed"|"unsubscribed", "channels": [...]} or {"type": "error", "error": message}.
+// The updates are mq.Envelope messages. The book channel starts with the current book, type book,
+// then sends its changes, type delta, numbered by Seq without gaps. A client too slow to keep up is disconnected,
+// it gets a new snapshot when it subscribes again.
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/exchangedata/bus"
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/mq"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// SendBuffer is the number of messages waiting for a client before it is disconnected
+	SendBuffer = 256
+
+	writeWait  = 10 * time.Second
+	pingPeriod = 30 * time.Second
+	pongWait   = pingPeriod * 2
+	maxRequest = 4096
+)
+
+// Hub follows the events of a bus and sends them to the subscribed clients, it is the http.Handler of the websocket
+type Hub struct {
+	Upgrader websocket.Upgrader
+
+	sub *bus.Subscriber
+
+	mu      sync.Mutex // events and subscriptions are handled one at a time, a snapshot is never overtaken by its deltas
+	clients map[*client]bool
+	books   map[string]*bookState // by exchanger:market
+	seq     map[string]uint64     // last sequence by channel
+	closed  bool                  // Run returned, the new clients are closed at once
+}
+
+// bookState is the current book of a market as seen by the clients, at sequence seq
+type bookState struct {
+	ob  *common.OrderBook
+	seq uint64
+}
+
+// NewHub subscribes a hub to the events of b, Run delivers them
+func NewHub(b *bus.Bus) *Hub {
+	return &Hub{
+		// the events are not changed by the clients and the API is read only, any origin is accepted
+		Upgrader: websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
+		// the books are rebuilt from the events, none may be lost: publishing waits for the hub
+		sub:     b.Subscribe("stream", 1024, bus.Block),
+		clients: make(map[*client]bool),
+		books:   make(map[string]*bookState),
+		seq:     make(map[string]uint64),
+	}
+}
+
+// Run sends the events to the clients until the bus is closed, then disconnects them
+func (h *Hub) Run() {
+	for e := range h.sub.C {
+		h.handle(e)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for c := range h.clients {
+		h.drop(c)
+	}
+}
+
+func (h *Hub) handle(e *bus.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch e.Topic {
+	case bus.Tickers:
+		for _, t := range e.Tickers {
+			ex, market := common.MarketNames(t.MarketRef, t.Market)
+			h.publish(ex, market, mq.TypeTicker, t.Time, 0, mq.NewTickerData(t))
+		}
+	case bus.Trades:
+		for _, t := range e.Trades {
+			ex, market := common.MarketNames(t.MarketRef, t.Market)
+			h.publish(ex, market, mq.TypeTrade, t.Time, 0, mq.NewTradeData(t))
+		}
+	case bus.Candles:
+		for _, c := range e.Candles {
+			ex, market := common.MarketNames(c.MarketRef, c.Market)
+			h.publish(ex, market, mq.TypeCandle, c.Time, 0, mq.NewCandleData(c))
+		}
+	case bus.Consolidated:
+		for _, c := range e.Consolidated {
+			h.publish(mq.Consolidated, mq.SymbolName(c.SymRef, c.Symbol), mq.TypeBBO, c.Time, 0, mq.NewConsolidatedData(c))
+		}
+	case bus.Opportunities:
+		for _, o := range e.Opportunities {
+			h.publish(mq.Arbitrage, o.Kind, mq.TypeArb, o.Time, 0, mq.NewOpportunityData(o))
+		}
+	case bus.Indicators:
+		for _, v := range e.Indicators {
+			ex, market := common.MarketNames(0, v.Market)
+			h.publish(ex, market, mq.TypeInd, v.Time, 0, mq.NewIndicatorData(v))
+		}
+	case bus.BookMetrics:
+		for _, m := range e.BookMetrics {
+			ex, market := common.MarketNames(m.MarketRef, m.Market)
+			h.publish(ex, market, mq.TypeDepth, m.Time, 0, mq.NewDepthData(m))
+		}
+	case bus.OrderBooks:
+		for _, ob := range e.OrderBooks {
+			h.book(ob)
+		}
+	case bus.BookDeltas:
+		if len(e.Deltas) == 0 {
+			return
+		}
+		ex, market := common.MarketNames(e.Deltas[0].MarketRef, e.Market)
+		st := h.books[ex+":"+market]
+		if st == nil { // no book to change yet
+			return
+		}
+		st.ob.Apply(e.Deltas)
+		st.ob.Time = e.Deltas[len(e.Deltas)-1].Time
+		h.delta(ex, market, st, e.Deltas)
+	}
+}
+
+// book sends the first book of a market, the changes from the current one after
+func (h *Hub) book(ob *common.OrderBook) {
+	ex, market := common.MarketNames(ob.MarketRef, ob.Market)
+	key := ex + ":" + market
+	st := h.books[key]
+	if st == nil {
+		st = &bookState{ob: ob.Clone(), seq: 1}
+		h.books[key] = st
+		h.publish(ex, market, mq.TypeBook, ob.Time, st.seq, h.snapshot(st))
+		return
+	}
+	deltas := common.DiffOrderBook(st.ob, ob)
+	st.ob = ob.Clone()
+	if len(deltas) > 0 {
+		h.delta(ex, market, st, deltas)
+	}
+}
+
+func (h *Hub) delta(ex, market string, st *bookState, deltas []*common.BookDelta) {
+	st.seq++
+	d := mq.NewDeltaData(deltas)
+	d.Sequence = st.seq
+	h.publish(ex, market, mq.TypeDelta, st.ob.Time, st.seq, d)
+}
+
+func (h *Hub) snapshot(st *bookState) *mq.BookData {
+	d := mq.NewBookData(st.ob)
+	d.Sequence = st.seq
+	return d
+}
+
+// publish sends the event to the clients of its channel, the sequence of the channel is used if seq is 0
+func (h *Hub) publish(ex, market, typ string, t time.Time, seq uint64, data interface{}) {
+	ch := channelName(ex, market, typ)
+	if seq == 0 {
+		seq = h.seq[ch] + 1
+		h.seq[ch] = seq
+	}
+	var msg []byte
+	for c := range h.clients {
+		if !c.subscribed(ch) {
+			continue
+		}
+		if msg == nil {
+			var err error
+			if msg, err = envelope(ex, market, typ, t, seq, data); err != nil {
+				log.Println("stream encode error:", err)
+				return
+			}
+		}
+		h.send(c, msg)
+	}
+}
+
+// send queues msg for c, a client with a full queue is disconnected
+func (h *Hub) send(c *client, msg []byte) {
+	if !h.clients[c] {
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+		log.Println("stream client", c.addr, "too slow, disconnected")
+		h.drop(c)
+	}
+}
+
+func (h *Hub) drop(c *client) {
+	if h.clients[c] {
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+// subscribe adds the channels to c and sends the current books of the new book channels
+func (h *Hub) subscribe(c *client, channels []string) error {
+	for _, ch := range channels {
+		if err := validChannel(ch); err != nil {
+			return err
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.clients[c] {
+		return errors.New("client closed")
+	}
+	h.send(c, reply("subscribed", channels))
+	for _, ch := range channels {
+		if c.subs[ch] {
+			continue
+		}
+		c.subs[ch] = true
+		for key, st := range h.books {
+			k := strings.IndexByte(key, ':')
+			ex, market := key[:k], key[k+1:]
+			book := channelName(ex, market, mq.TypeBook)
+			if !matchChannel(ch, book) || c.subscribedOther(book, ch) {
+				continue
+			}
+			msg, err := envelope(ex, market, mq.TypeBook, st.ob.Time, st.seq, h.snapshot(st))
+			if err != nil {
+				return err
+			}
+			h.send(c, msg)
+		}
+	}
+	return nil
+}
+
+func (h *Hub) unsubscribe(c *client, channels []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ch := range channels {
+		delete(c.subs, ch)
+	}
+	h.send(c, reply("unsubscribed", channels))
+}
+
+// ServeHTTP upgrades the request to a websocket and serves the subscriptions of the client
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader replied
+	}
+	c := h.add(conn, conn.RemoteAddr().String())
+	go c.writeLoop()
+	h.readLoop(c)
+}
+
+// Subscribe adds an in-process client of the channels, it receives the messages a websocket client would get,
+// without the replies. The channel is closed by cancel, by the end of Run, or when the client is too slow.
+func (h *Hub) Subscribe(channels ...string) (<-chan []byte, func(), error) {
+	c := h.add(nil, "local")
+	cancel := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.drop(c)
+	}
+	if err := h.subscribe(c, channels); err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	<-c.send // the subscribed reply, queued before the snapshots
+	return c.send, cancel, nil
+}
+
+func (h *Hub) add(conn *websocket.Conn, addr string) *client {
+	c := &client{conn: conn, addr: addr, send: make(chan []byte, SendBuffer), subs: make(map[string]bool)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(c.send)
+	} else {
+		h.clients[c] = true
+	}
+	return c
+}
+
+// channelName is exchanger:market:type, the deltas are sent on the book channel
+func channelName(ex, market, typ string) string {
+	if typ == mq.TypeDelta {
+		typ = mq.TypeBook
+	}
+	return ex + ":" + market + ":" + typ
+}
+
+func validChannel(ch string) error {
+	parts := strings.Split(ch, ":")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return errors.New("bad channel " + ch + ", exchanger:market:type expected")
+	}
+	switch parts[2] {
+	case mq.TypeTicker, mq.TypeTrade, mq.TypeBook, mq.TypeCandle, mq.TypeBBO, mq.TypeArb, mq.TypeInd, mq.TypeDepth, "*":
+		return nil
+	}
+	return errors.New("bad channel type " + parts[2] + ", ticker, trade, book, candle, depth, bbo, opportunity or indicator expected")
+}
+
+// matchChannel tells if the channel ch matches the subscription pattern, * matching any part.
+// The names are compared ignoring the case.
+func matchChannel(pattern, ch string) bool {
+	p, c := strings.Split(pattern, ":"), strings.Split(ch, ":")
+	if len(p) != 3 || len(c) != 3 {
+		return false
+	}
+	for k := range p {
+		if p[k] != "*" && !strings.EqualFold(p[k], c[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+func envelope(ex, market, typ string, t time.Time, seq uint64, data interface{}) ([]byte, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&mq.Envelope{V: mq.Version, Type: typ, Exchanger: ex, Market: market, Time: t.UTC(), Seq: seq, Data: raw})
+}
+
+type replyMsg struct {
+	Type     string   `json:"type"`
+	Channels []string `json:"channels,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+func reply(typ string, channels []string) []byte {
+	msg, _ := json.Marshal(&replyMsg{Type: typ, Channels: channels})
+	return msg
+}
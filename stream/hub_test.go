@@ -0,0 +1,137 @@
+package stream
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exchangedata/bus"
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/mq"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+)
+
+var dec = decimal.RequireFromString
+
+var testMarket = &common.Market{ID: 1, Name: "USDT-BTC", Exchanger: &common.Exchanger{Name: "bittrex"}}
+
+func testBook(bid string) *common.OrderBook {
+	return &common.OrderBook{Time: time.Now(), Market: testMarket,
+		Bids: []*common.PriceVol{{Price: dec(bid), Volume: dec("1")}},
+		Asks: []*common.PriceVol{{Price: dec("101"), Volume: dec("2")}}}
+}
+
+func dial(t *testing.T, url string, channels ...string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	if err != nil {
+		t.Fatal("dial", err)
+	}
+	if err = conn.WriteJSON(&request{Op: "subscribe", Channels: channels}); err != nil {
+		t.Fatal("subscribe", err)
+	}
+	var r replyMsg
+	if err = conn.ReadJSON(&r); err != nil || r.Type != "subscribed" {
+		t.Fatal("subscribe reply", r, err)
+	}
+	return conn
+}
+
+func read(t *testing.T, conn *websocket.Conn) *mq.Envelope {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	e := &mq.Envelope{}
+	if err := conn.ReadJSON(e); err != nil {
+		t.Fatal("read", err)
+	}
+	return e
+}
+
+func TestHub(t *testing.T) {
+	b := bus.New()
+	h := NewHub(b)
+	done := make(chan bool)
+	go func() {
+		h.Run()
+		done <- true
+	}()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	src := bus.NewSink(b)
+
+	first := dial(t, srv.URL, "bittrex:USDT-BTC:book", "*:*:trade")
+	defer first.Close()
+
+	src.WriteOrderBooks([]*common.OrderBook{testBook("99")})
+	e := read(t, first)
+	var book mq.BookData
+	if e.Type != mq.TypeBook || e.Seq != 1 || e.Decode(&book) != nil || !book.Bids[0][0].Equal(dec("99")) {
+		t.Fatal("wrong snapshot", e)
+	}
+
+	// a polled book is sent as its changes, a streamed delta as is
+	src.WriteOrderBooks([]*common.OrderBook{testBook("100")})
+	src.WriteBookDeltas(testMarket, []*common.BookDelta{{MarketRef: 1, Side: common.BookAsk, Price: dec("102"), Volume: dec("3")}})
+	src.WriteTrades([]*common.Trade{{Market: testMarket, OrderID: "1", Price: dec("100")}})
+	for seq := uint64(2); seq <= 3; seq++ {
+		var d mq.DeltaData
+		if e = read(t, first); e.Type != mq.TypeDelta || e.Seq != seq || e.Decode(&d) != nil {
+			t.Fatal("wrong delta", e)
+		}
+		if seq == 2 && (len(d.Bids) != 2 || len(d.Asks) != 0) {
+			t.Fatal("wrong book changes", d)
+		}
+	}
+	if e = read(t, first); e.Type != mq.TypeTrade || e.Market != "USDT-BTC" || e.Seq != 1 {
+		t.Fatal("wrong trade", e)
+	}
+
+	// a late client starts from the current book
+	second := dial(t, srv.URL, "bittrex:*:book")
+	defer second.Close()
+	if e = read(t, second); e.Type != mq.TypeBook || e.Seq != 3 || e.Decode(&book) != nil || len(book.Asks) != 2 || !book.Bids[0][0].Equal(dec("100")) {
+		t.Fatal("wrong late snapshot", e, book)
+	}
+
+	second.WriteJSON(&request{Op: "subscribe", Channels: []string{"bittrex:USDT-BTC"}})
+	var r replyMsg
+	if second.ReadJSON(&r); r.Type != "error" {
+		t.Fatal("bad channel accepted", r)
+	}
+	second.WriteJSON(&request{Op: "unsubscribe", Channels: []string{"bittrex:*:book"}})
+	if second.ReadJSON(&r); r.Type != "unsubscribed" {
+		t.Fatal("wrong unsubscribe reply", r)
+	}
+	src.WriteOrderBooks([]*common.OrderBook{testBook("98")})
+	if e = read(t, first); e.Type != mq.TypeDelta || e.Seq != 4 {
+		t.Fatal("wrong delta", e)
+	}
+
+	b.Close()
+	<-done
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := second.ReadMessage(); err == nil {
+		t.Fatal("unsubscribed client got a message")
+	} else if _, ok := err.(*websocket.CloseError); !ok {
+		t.Fatal("client not closed", err)
+	}
+}
+
+func TestMatchChannel(t *testing.T) {
+	for _, c := range []struct {
+		pattern, ch string
+		want        bool
+	}{
+		{"bittrex:USDT-BTC:book", "bittrex:USDT-BTC:book", true},
+		{"Bittrex:usdt-btc:book", "bittrex:USDT-BTC:book", true},
+		{"*:*:*", "bittrex:USDT-BTC:trade", true},
+		{"bittrex:*:trade", "bittrex:USDT-BTC:book", false},
+		{"bittrex:USDT-BTC", "bittrex:USDT-BTC:book", false},
+	} {
+		if matchChannel(c.pattern, c.ch) != c.want {
+			t.Error(c.pattern, c.ch, "!", c.want)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+// Package validate checks the market data of the exchangers before it is stored or published.
+//
+// Each record goes through the rules of its kind, a record breaking a rule gets the Action of the rule:
+// it is rejected, quarantined, kept only as a common.QualityIssue, or annotated, passed on with its issue stored.
+// The tickers and order books of a market must be in time order, the trades of the fetch cycles come unordered.
+package validate
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/mq"
+	"github.com/exchangedata/sink"
+	"github.com/shopspring/decimal"
+)
+
+// the rules
+const (
+	RuleBadPrice     = "bad_price"     // a zero or negative price, a negative volume
+	RuleCrossedBook  = "crossed_book"  // a bid at or above the ask
+	RuleFutureTime   = "future_time"   // a time ahead of the clock by more than MaxSkew
+	RuleNonMonotonic = "non_monotonic" // a ticker or book older than the previous one of its market
+	RuleOutlier      = "outlier"       // a price Sigmas standard deviations away from the last prices of its market
+	RuleOutsideBook  = "outside_book"  // a trade price out of the last book of its market by more than BookMargin
+)
+
+// Rules are all the rules
+var Rules = []string{RuleBadPrice, RuleCrossedBook, RuleFutureTime, RuleNonMonotonic, RuleOutlier, RuleOutsideBook}
+
+// the actions, from the strongest
+const (
+	ActionReject     = "reject"
+	ActionQuarantine = "quarantine"
+	ActionAnnotate   = "annotate"
+	ActionIgnore     = "ignore" // the rule is not checked
+)
+
+var strength = map[string]int{ActionReject: 3, ActionQuarantine: 2, ActionAnnotate: 1, ActionIgnore: 0}
+
+// DefaultActions are the actions of the rules, the suspect records are quarantined or annotated
+// and only the invalid ones rejected
+var DefaultActions = map[string]string{
+	RuleBadPrice:     ActionReject,
+	RuleCrossedBook:  ActionQuarantine,
+	RuleFutureTime:   ActionQuarantine,
+	RuleNonMonotonic: ActionReject,
+	RuleOutlier:      ActionAnnotate,
+	RuleOutsideBook:  ActionAnnotate,
+}
+
+// the kinds of the records of the issues
+const (
+	KindTicker = "ticker"
+	KindTrade  = "trade"
+	KindBook   = "book"
+)
+
+// IssueStore keeps the issues, database.DataStore is one
+type IssueStore interface {
+	InsertIssues(c []*common.QualityIssue) error
+}
+
+// Validator is a sink.Sink passing the records of the exchangers to next after the rules.
+// The deltas and candles are passed as they come. next and store belong to the caller.
+type Validator struct {
+	Actions    map[string]string // by rule, DefaultActions for the others, set before the first record
+	MaxSkew    time.Duration
+	Window     int     // number of the last prices of a market in the outlier stats
+	Sigmas     float64 // distance of an outlier to the mean of the window
+	BookMargin float64 // relative to the book prices
+	MaxBookAge time.Duration
+
+	next  sink.Sink
+	store IssueStore
+
+	mu      sync.Mutex
+	markets map[string]*market // by exchanger:market
+	counts  map[string]uint64  // by rule
+}
+
+// market is what the rules know of a market
+type market struct {
+	ticker, book time.Time // of the last ones passed
+	ob           *common.OrderBook
+	prices       []float64 // ring of the last prices of the records passed
+	next         int
+	outliers     int // refused in a row
+}
+
+// New returns a validator writing the valid records to next and the issues to store, only logged if nil
+func New(next sink.Sink, store IssueStore) *Validator {
+	return &Validator{
+		Actions:    make(map[string]string),
+		MaxSkew:    time.Minute,
+		Window:     100,
+		Sigmas:     8,
+		BookMargin: 0.01,
+		MaxBookAge: time.Minute,
+		next:       next,
+		store:      store,
+		markets:    make(map[string]*market),
+		counts:     make(map[string]uint64),
+	}
+}
+
+// ParseActions parses the actions of rules, like outlier=quarantine,outside_book=ignore
+func ParseActions(s string) (map[string]string, error) {
+	actions := make(map[string]string)
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad action %s, rule=action expected", a)
+		}
+		rule, action := strings.TrimSpace(kv[0]), strings.ToLower(strings.TrimSpace(kv[1]))
+		if _, ok := DefaultActions[rule]; !ok {
+			return nil, fmt.Errorf("unknown rule %s", rule)
+		}
+		if _, ok := strength[action]; !ok {
+			return nil, fmt.Errorf("unknown action %s of %s", action, rule)
+		}
+		actions[rule] = action
+	}
+	return actions, nil
+}
+
+// Counts returns the number of the records which broke each rule
+func (v *Validator) Counts() map[string]uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c := make(map[string]uint64, len(v.counts))
+	for rule, n := range v.counts {
+		c[rule] = n
+	}
+	return c
+}
+
+func (v *Validator) action(rule string) string {
+	if a, ok := v.Actions[rule]; ok {
+		return a
+	}
+	return DefaultActions[rule]
+}
+
+// finding is a broken rule
+type finding struct {
+	rule, detail string
+}
+
+// check collects the findings of a record
+type check struct {
+	v        *Validator
+	findings []finding
+}
+
+func (c *check) fail(rule, format string, args ...interface{}) {
+	if c.v.action(rule) != ActionIgnore {
+		c.findings = append(c.findings, finding{rule, fmt.Sprintf(format, args...)})
+	}
+}
+
+// judge counts the findings of a record and adds their issues to issues, it reports whether the record goes on
+func (v *Validator) judge(issues *[]*common.QualityIssue, kind string, m *common.Market, ref uint, at time.Time,
+	data interface{}, findings []finding) bool {
+	if len(findings) == 0 {
+		return true
+	}
+	action := ActionAnnotate
+	for _, f := range findings {
+		v.counts[f.rule]++
+		if a := v.action(f.rule); strength[a] > strength[action] {
+			action = a
+		}
+	}
+	var raw string
+	if action == ActionQuarantine {
+		if b, err := json.Marshal(data); err == nil {
+			raw = string(b)
+		}
+	}
+	ex, name := common.MarketNames(ref, m)
+	for _, f := range findings {
+		log.Println("validation", action, kind, ex+":"+name, at.Format(time.RFC3339Nano), f.rule+":", f.detail)
+		*issues = append(*issues, &common.QualityIssue{Time: at, MarketRef: ref, Market: m, Kind: kind,
+			Rule: f.rule, Action: action, Detail: f.detail, Data: raw})
+	}
+	return action == ActionAnnotate
+}
+
+func (v *Validator) market(ref uint, m *common.Market) *market {
+	ex, name := common.MarketNames(ref, m)
+	st := v.markets[ex+":"+name]
+	if st == nil {
+		st = &market{}
+		v.markets[ex+":"+name] = st
+	}
+	return st
+}
+
+// keep stores the issues, their failure does not hold the records back
+func (v *Validator) keep(issues []*common.QualityIssue) {
+	if v.store == nil || len(issues) == 0 {
+		return
+	}
+	if err := v.store.InsertIssues(issues); err != nil {
+		log.Println("validation issues not stored:", err)
+	}
+}
+
+func (v *Validator) WriteTickers(c []*common.Ticker) error {
+	now := time.Now()
+	var issues []*common.QualityIssue
+	passed := make([]*common.Ticker, 0, len(c))
+	v.mu.Lock()
+	for _, t := range c {
+		st := v.market(t.MarketRef, t.Market)
+		ch := &check{v: v}
+		if !t.Last.IsPositive() || t.Bid.IsNegative() || t.Ask.IsNegative() {
+			ch.fail(RuleBadPrice, "last %s bid %s ask %s", t.Last, t.Bid, t.Ask)
+		}
+		if t.Bid.IsPositive() && t.Ask.IsPositive() && !t.Bid.LessThan(t.Ask) {
+			ch.fail(RuleCrossedBook, "bid %s ask %s", t.Bid, t.Ask)
+		}
+		v.checkTime(ch, t.Time, now)
+		if t.Time.Before(st.ticker) {
+			ch.fail(RuleNonMonotonic, "after %s", st.ticker.Format(time.RFC3339Nano))
+		}
+		v.checkOutlier(ch, st, t.Last)
+		ok := v.judge(&issues, KindTicker, t.Market, t.MarketRef, t.Time, mq.NewTickerData(t), ch.findings)
+		v.keepPrice(ch, st, t.Last, ok)
+		if ok {
+			st.ticker = t.Time
+			passed = append(passed, t)
+		}
+	}
+	v.mu.Unlock()
+	v.keep(issues)
+	if len(passed) == 0 {
+		return nil
+	}
+	return v.next.WriteTickers(passed)
+}
+
+func (v *Validator) WriteTrades(c []*common.Trade) error {
+	now := time.Now()
+	var issues []*common.QualityIssue
+	passed := make([]*common.Trade, 0, len(c))
+	v.mu.Lock()
+	for _, t := range c {
+		st := v.market(t.MarketRef, t.Market)
+		ch := &check{v: v}
+		if !t.Price.IsPositive() || !t.Amount.IsPositive() {
+			ch.fail(RuleBadPrice, "price %s amount %s", t.Price, t.Amount)
+		}
+		v.checkTime(ch, t.Time, now)
+		v.checkOutlier(ch, st, t.Price)
+		if ob := st.ob; ob != nil && t.Price.IsPositive() && absDuration(t.Time.Sub(ob.Time)) <= v.MaxBookAge {
+			margin := decimal.NewFromFloat(v.BookMargin)
+			if len(ob.Bids) > 0 && t.Price.LessThan(ob.Bids[0].Price.Mul(one.Sub(margin))) {
+				ch.fail(RuleOutsideBook, "price %s under the bid %s", t.Price, ob.Bids[0].Price)
+			} else if len(ob.Asks) > 0 && t.Price.GreaterThan(ob.Asks[0].Price.Mul(one.Add(margin))) {
+				ch.fail(RuleOutsideBook, "price %s over the ask %s", t.Price, ob.Asks[0].Price)
+			}
+		}
+		ok := v.judge(&issues, KindTrade, t.Market, t.MarketRef, t.Time, mq.NewTradeData(t), ch.findings)
+		v.keepPrice(ch, st, t.Price, ok)
+		if ok {
+			passed = append(passed, t)
+		}
+	}
+	v.mu.Unlock()
+	v.keep(issues)
+	if len(passed) == 0 {
+		return nil
+	}
+	return v.next.WriteTrades(passed)
+}
+
+func (v *Validator) WriteOrderBooks(c []*common.OrderBook) error {
+	now := time.Now()
+	var issues []*common.QualityIssue
+	passed := make([]*common.OrderBook, 0, len(c))
+	v.mu.Lock()
+	for _, ob := range c {
+		st := v.market(ob.MarketRef, ob.Market)
+		ch := &check{v: v}
+		for _, side := range [][]*common.PriceVol{ob.Bids, ob.Asks} {
+			for _, l := range side {
+				if !l.Price.IsPositive() || l.Volume.IsNegative() {
+					ch.fail(RuleBadPrice, "level %s %s", l.Price, l.Volume)
+					break
+				}
+			}
+		}
+		if len(ob.Bids) > 0 && len(ob.Asks) > 0 && !ob.Bids[0].Price.LessThan(ob.Asks[0].Price) {
+			ch.fail(RuleCrossedBook, "bid %s ask %s", ob.Bids[0].Price, ob.Asks[0].Price)
+		}
+		v.checkTime(ch, ob.Time, now)
+		if ob.Time.Before(st.book) {
+			ch.fail(RuleNonMonotonic, "after %s", st.book.Format(time.RFC3339Nano))
+		}
+		if v.judge(&issues, KindBook, ob.Market, ob.MarketRef, ob.Time, mq.NewBookData(ob), ch.findings) {
+			st.book, st.ob = ob.Time, ob.Clone()
+			passed = append(passed, ob)
+		}
+	}
+	v.mu.Unlock()
+	v.keep(issues)
+	if len(passed) == 0 {
+		return nil
+	}
+	return v.next.WriteOrderBooks(passed)
+}
+
+// WriteBookDeltas passes the changes c of the order book of m to next, see sink.DeltaWriter
+func (v *Validator) WriteBookDeltas(m *common.Market, c []*common.BookDelta) error {
+	if len(c) == 0 {
+		return nil
+	}
+	v.mu.Lock()
+	if st := v.market(c[0].MarketRef, m); st.ob != nil {
+		st.ob.Apply(c)
+		st.book = st.ob.Time
+	}
+	v.mu.Unlock()
+	if d, ok := v.next.(sink.DeltaWriter); ok {
+		return d.WriteBookDeltas(m, c)
+	}
+	return nil
+}
+
+func (v *Validator) WriteCandles(c []*common.Candle) error {
+	return v.next.WriteCandles(c)
+}
+
+// Close logs the counts of the rules, next is not closed
+func (v *Validator) Close() error {
+	counts := v.Counts()
+	rules := make([]string, 0, len(counts))
+	for rule := range counts {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		log.Println("validation", rule, counts[rule], "records,", v.action(rule))
+	}
+	return nil
+}
+
+var one = decimal.New(1, 0)
+
+func (v *Validator) checkTime(ch *check, at, now time.Time) {
+	if at.Sub(now) > v.MaxSkew {
+		ch.fail(RuleFutureTime, "%s ahead of the clock", at.Sub(now))
+	}
+}
+
+// checkOutlier checks price against the window of the market
+func (v *Validator) checkOutlier(ch *check, st *market, price decimal.Decimal) {
+	if !price.IsPositive() || v.Window <= 0 {
+		return
+	}
+	p, _ := price.Float64()
+	if len(st.prices) >= v.Window/2 && len(st.prices) > 1 {
+		var sum, sq float64
+		for _, x := range st.prices {
+			sum += x
+		}
+		mean := sum / float64(len(st.prices))
+		for _, x := range st.prices {
+			sq += (x - mean) * (x - mean)
+		}
+		// a flat window would make any tick an outlier
+		std := math.Max(math.Sqrt(sq/float64(len(st.prices)-1)), mean*0.001)
+		if math.Abs(p-mean) > v.Sigmas*std {
+			ch.fail(RuleOutlier, "price %s, mean %g std %g of the last %d", price, mean, std, len(st.prices))
+		}
+	}
+}
+
+// keepPrice adds price to the window of the market if its record passed, a refused outlier would shift the window
+// towards itself. After Window outliers refused in a row the window is restarted: the market moved to other prices.
+func (v *Validator) keepPrice(ch *check, st *market, price decimal.Decimal, passed bool) {
+	if !price.IsPositive() || v.Window <= 0 {
+		return
+	}
+	if !passed {
+		for _, f := range ch.findings {
+			if f.rule == RuleOutlier {
+				if st.outliers++; st.outliers >= v.Window {
+					st.prices, st.next, st.outliers = st.prices[:0], 0, 0
+				}
+				break
+			}
+		}
+		return
+	}
+	st.outliers = 0
+	p, _ := price.Float64()
+	if len(st.prices) < v.Window {
+		st.prices = append(st.prices, p)
+	} else {
+		st.prices[st.next] = p
+		st.next = (st.next + 1) % v.Window
+	}
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
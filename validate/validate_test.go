@@ -0,0 +1,171 @@
+package validate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/exchangedata/common"
+	"github.com/exchangedata/database"
+	"github.com/shopspring/decimal"
+)
+
+var dec = decimal.RequireFromString
+
+type recordSink struct {
+	tickers []*common.Ticker
+	trades  []*common.Trade
+	books   []*common.OrderBook
+}
+
+func (s *recordSink) WriteTickers(c []*common.Ticker) error {
+	s.tickers = append(s.tickers, c...)
+	return nil
+}
+func (s *recordSink) WriteTrades(c []*common.Trade) error {
+	s.trades = append(s.trades, c...)
+	return nil
+}
+func (s *recordSink) WriteOrderBooks(c []*common.OrderBook) error {
+	s.books = append(s.books, c...)
+	return nil
+}
+func (s *recordSink) WriteCandles(c []*common.Candle) error { return nil }
+func (s *recordSink) Close() error                          { return nil }
+
+func levels(pv ...string) []*common.PriceVol {
+	l := []*common.PriceVol{}
+	for k := 0; k < len(pv); k += 2 {
+		l = append(l, &common.PriceVol{Price: dec(pv[k]), Volume: dec(pv[k+1])})
+	}
+	return l
+}
+
+func TestValidator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edvalidate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ds := database.NewDataStore("sqlite3")
+	ds.Name = filepath.Join(dir, "test.db")
+	if err = ds.OpenDB(); err != nil {
+		t.Fatal("open db", err)
+	}
+	defer ds.CloseDB()
+	if err = ds.Migrate(); err != nil {
+		t.Fatal("migrate db", err)
+	}
+
+	out := &recordSink{}
+	v := New(out, ds)
+	v.Window = 10
+	m := &common.Market{Name: "USDT-BTC", Exchanger: &common.Exchanger{Name: "bittrex"}}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	at := func(sec int) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+
+	v.WriteTickers([]*common.Ticker{
+		{Time: at(10), Market: m, Last: dec("100"), Bid: dec("99"), Ask: dec("101")},
+		{Time: at(5), Market: m, Last: dec("100"), Bid: dec("99"), Ask: dec("101")},                     // non monotonic, rejected
+		{Time: at(20), Market: m, Last: dec("100"), Bid: dec("101"), Ask: dec("99")},                    // crossed, quarantined
+		{Time: at(30), Market: m, Last: dec("0"), Bid: dec("99"), Ask: dec("101")},                      // bad price, rejected
+		{Time: time.Now().Add(time.Hour), Market: m, Last: dec("100"), Bid: dec("99"), Ask: dec("101")}, // future
+	})
+	if len(out.tickers) != 1 || !out.tickers[0].Time.Equal(at(10)) {
+		t.Fatal("wrong tickers passed", out.tickers)
+	}
+
+	v.WriteOrderBooks([]*common.OrderBook{{Time: at(40), Market: m, Bids: levels("99", "1"), Asks: levels("101", "1")}})
+	trades := []*common.Trade{}
+	for k := 0; k < 10; k++ {
+		trades = append(trades, &common.Trade{Time: at(41 + k), Market: m, OrderID: strconv.Itoa(k),
+			Price: dec("100").Add(decimal.New(int64(k%3), -1)), Amount: dec("1")})
+	}
+	// out of the book and far from the last prices, annotated and passed
+	trades = append(trades, &common.Trade{Time: at(60), Market: m, OrderID: "out", Price: dec("150"), Amount: dec("1")})
+	v.WriteTrades(trades)
+	if len(out.trades) != 11 {
+		t.Fatal("wrong trades passed", len(out.trades))
+	}
+	if len(out.books) != 1 {
+		t.Fatal("book not passed")
+	}
+
+	counts := v.Counts()
+	for rule, n := range map[string]uint64{RuleNonMonotonic: 1, RuleCrossedBook: 1, RuleBadPrice: 1, RuleFutureTime: 1,
+		RuleOutlier: 1, RuleOutsideBook: 1} {
+		if counts[rule] != n {
+			t.Error("count of", rule, counts[rule], "expected", n)
+		}
+	}
+
+	c, err := ds.IssueCounts(0, start, time.Now().Add(2*time.Hour))
+	if err != nil || len(c) != 6 {
+		t.Fatal("issue counts", c, err)
+	}
+	issues, _, err := ds.Issues(m.ID, RuleCrossedBook, start, time.Now(), nil, 10)
+	if err != nil || len(issues) != 1 || issues[0].Action != ActionQuarantine || issues[0].Data == "" || issues[0].Kind != KindTicker {
+		t.Fatal("quarantined ticker", issues, err)
+	}
+	issues, _, err = ds.Issues(m.ID, RuleOutsideBook, start, time.Now(), nil, 10)
+	if err != nil || len(issues) != 1 || issues[0].Action != ActionAnnotate || issues[0].Data != "" {
+		t.Fatal("annotated trade", issues, err)
+	}
+}
+
+func TestParseActions(t *testing.T) {
+	a, err := ParseActions("outlier=quarantine, outside_book=IGNORE")
+	if err != nil || len(a) != 2 || a[RuleOutsideBook] != ActionIgnore {
+		t.Fatal("actions", a, err)
+	}
+	for _, bad := range []string{"outlier", "unknown=reject", "outlier=drop"} {
+		if _, err := ParseActions(bad); err == nil {
+			t.Error("bad actions accepted", bad)
+		}
+	}
+
+	// an ignored rule is not checked
+	out := &recordSink{}
+	v := New(out, nil)
+	v.Actions = a
+	m := &common.Market{Name: "USDT-BTC", Exchanger: &common.Exchanger{Name: "bittrex"}}
+	v.WriteOrderBooks([]*common.OrderBook{{Time: time.Now(), Market: m, Bids: levels("99", "1"), Asks: levels("101", "1")}})
+	v.WriteTrades([]*common.Trade{{Time: time.Now(), Market: m, OrderID: "1", Price: dec("120"), Amount: dec("1")}})
+	if len(out.trades) != 1 || v.Counts()[RuleOutsideBook] != 0 {
+		t.Fatal("ignored rule checked", v.Counts())
+	}
+}
+
+func TestOutlierWindow(t *testing.T) {
+	out := &recordSink{}
+	v := New(out, nil)
+	v.Window = 10
+	v.Actions[RuleOutlier] = ActionReject
+	m := &common.Market{Name: "USDT-BTC", Exchanger: &common.Exchanger{Name: "bittrex"}}
+	start := time.Now().Add(-time.Hour)
+	n := 0
+	write := func(price string, count int) {
+		for k := 0; k < count; k++ {
+			n++
+			v.WriteTrades([]*common.Trade{{Time: start.Add(time.Duration(n) * time.Second), Market: m, OrderID: strconv.Itoa(n),
+				Price: dec(price), Amount: dec("1")}})
+		}
+	}
+	write("100", 5)
+	write("100.2", 5)
+	// the refused prices stay out of the window, they would make the next ones pass
+	write("1000", 9)
+	write("100.1", 1)
+	if len(out.trades) != 11 || v.Counts()[RuleOutlier] != 9 {
+		t.Fatal("outliers passed", len(out.trades), v.Counts())
+	}
+	// the market moved, the window restarts after Window outliers in a row
+	write("1000", 10)
+	write("1000.5", 1)
+	if len(out.trades) != 12 || !out.trades[11].Price.Equal(dec("1000.5")) {
+		t.Fatal("window not restarted", len(out.trades))
+	}
+}